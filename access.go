@@ -0,0 +1,131 @@
+package lager
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// AccessLogHandler() wraps an http.Handler so that an access log line is
+// written [at the Acc level, like from GcpLogAccess()] after the handler
+// returns, including the response status and how many bytes of the body
+// were written:
+//
+//      http.Handle("/", lager.AccessLogHandler(mux))
+//
+//      ["2021-06-09 13:10:07.0447Z", "ACCESS", "Response sent",
+//          {"httpRequest":{"requestMethod":"GET", ..., "status":200,
+//          "responseSize":5120, "latency":"0.0312s", ...}}]
+//
+// If the client disconnected before the response was finished (so the
+// request's Context was canceled before the handler returned or a write
+// of the response failed after the Context was done), then the line
+// instead says so and includes how many bytes had been written, so such
+// requests can be told apart from server-side failures:
+//
+//      ["2021-06-09 13:10:07.0447Z", "ACCESS", "Client aborted request",
+//          {"client_aborted":true, "bytes_written":1024},
+//          {"httpRequest":{...}}]
+//
+// When the response has a Content-Encoding header (such as "gzip"), it is
+// logged as "content_encoding" and the bytes written are logged as
+// "compressed_size".  If the compression was done by a handler wrapped by
+// AccessLogHandler(), then that handler can call SetUncompressedSize() so
+// "original_size" is also logged.  The X-Cache and Age response headers,
+// if present, are logged as "cache" and "age" (in seconds), which helps
+// when analyzing caching behavior from origin logs:
+//
+//      ["2021-06-09 13:10:07.0447Z", "ACCESS", "Response sent",
+//          {"content_encoding":"gzip", "original_size":20480,
+//          "compressed_size":5120, "cache":"HIT", "age":37},
+//          {"httpRequest":{...}}]
+//
+// A handler that never sets a status is logged as having responded 200.
+// If the handler panics, then the line says "Handler panicked" and
+// includes the "panic" value [see PanicValue()] and the status is logged
+// as 500 (if the handler did not set one) before the panic continues.
+//
+// The http.ResponseWriter passed to the handler still supports Flush() and
+// Hijack() if the original one did, and has an Unwrap() method so an
+// http.ResponseController can reach the original.
+//
+func AccessLogHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, rawSize: -1}
+		defer func() {
+			p := recover()
+			rec.logAccess(req, start, p)
+			if nil != p {
+				panic(p)
+			}
+		}()
+		h.ServeHTTP(rec, req)
+	})
+}
+
+// Writes the access log line for a request [see AccessLogHandler()].  'p'
+// is the value that the handler panicked with, if any.
+func (sr *statusRecorder) logAccess(
+	req *http.Request, start time.Time, p interface{},
+) {
+	done := req.Context().Err()
+	aborted := context.Canceled == done || nil != sr.failure && nil != done
+	if 0 == sr.status && nil != p {
+		sr.status = http.StatusInternalServerError
+	} else if 0 == sr.status {
+		sr.status = http.StatusOK
+	}
+	log := GcpLogAccess(req, GcpFakeResponse(sr.status, sr.size, ""), &start)
+	var pairs []interface{}
+	if aborted {
+		pairs = append(pairs, "client_aborted", true, "bytes_written", sr.size)
+	}
+	pairs = append(pairs, sr.encodingPairs(sr.Header())...)
+	if nil != p {
+		log.MMap("Handler panicked", append(pairs, "panic", PanicValue(p))...)
+	} else if aborted {
+		log.MMap("Client aborted request", pairs...)
+	} else {
+		log.MMap("Response sent", pairs...)
+	}
+}
+
+// Returns the pairs describing the compression and caching of a response
+// [see AccessLogHandler()].
+func (sr *statusRecorder) encodingPairs(hdr http.Header) []interface{} {
+	var pairs []interface{}
+	if enc := hdr.Get("Content-Encoding"); "" != enc {
+		pairs = append(pairs, "content_encoding", enc)
+		if 0 <= sr.rawSize {
+			pairs = append(pairs, "original_size", sr.rawSize)
+		}
+		pairs = append(pairs, "compressed_size", sr.size)
+	}
+	if cache := hdr.Get("X-Cache"); "" != cache {
+		pairs = append(pairs, "cache", cache)
+	}
+	if age := hdr.Get("Age"); "" != age {
+		if n, err := strconv.Atoi(age); nil == err {
+			pairs = append(pairs, "age", n)
+		} else {
+			pairs = append(pairs, "age", age)
+		}
+	}
+	return pairs
+}
+
+// SetUncompressedSize() lets a handler (wrapped by AccessLogHandler())
+// that compresses its response report how many bytes the response was
+// before compression, so it can be logged as "original_size".  Pass in the
+// http.ResponseWriter that the handler was given.  It does nothing if 'w'
+// is not from AccessLogHandler():
+//
+//      lager.SetUncompressedSize(w, int64(len(body)))
+//
+func SetUncompressedSize(w http.ResponseWriter, size int64) {
+	if rec, ok := w.(*statusRecorder); ok {
+		rec.rawSize = size
+	}
+}
@@ -0,0 +1,207 @@
+package lager
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The most distinct values of the accounting key that are tracked; lines
+// with other values are counted under "(other)".
+const maxAccounted = 10000
+
+// Volume is how much was logged for one value of the accounting key [see
+// SetVolumeAccounting()].
+//
+type Volume struct {
+	Lines uint64
+	Bytes uint64
+}
+
+// Tracks log volume per value of a key [see SetVolumeAccounting()].
+type accountant struct {
+	key    string
+	top    int
+	mu     sync.Mutex
+	since  time.Time
+	recent map[string]*Volume // Since the last report.
+	total  map[string]*Volume // Since accounting was enabled.
+}
+
+// SetVolumeAccounting() tracks how many log lines and bytes are written for
+// each value of 'key' (such as "tenant" or "endpoint"), as found in the
+// pairs passed to Map() or MMap() or among the context pairs.  Lines that
+// do not include 'key' are counted under the value "".  This lets log
+// costs be attributed and noisy tenants be caught.
+//
+// Every 'every', if any lines were written, a Note line is logged giving
+// the totals and the 'top' values that logged the most bytes during that
+// interval (the report itself is not counted):
+//
+//      defer lager.SetVolumeAccounting("tenant", 3, time.Minute)()
+//
+//      ["2021-06-09 13:10:07.0447Z", "NOTE", "Log volume",
+//          {"key":"tenant", "interval":"60.000s", "lines":5210,
+//          "bytes":1293817, "top":[
+//          {"value":"acme", "lines":4100, "bytes":1025002},
+//          {"value":"", "lines":802, "bytes":188874},
+//          {"value":"initech", "lines":211, "bytes":61220}]}]
+//
+// An 'every' of 0 (or less) means no such lines are logged, so the counts
+// are only available via LogVolume().  A 'top' of 0 (or less) means 10.
+// Up to 10,000 distinct values are tracked; lines with other values are
+// counted under "(other)".  Values that are not strings are converted via
+// fmt.Sprint().  Lines from AppendEntry() are not counted.  Passing a 'key'
+// of "" turns accounting off.  It returns a function that restores the
+// prior setting (and stops the reports).
+//
+func SetVolumeAccounting(key string, top int, every time.Duration) func() {
+	var a *accountant
+	if "" != key {
+		if top <= 0 {
+			top = 10
+		}
+		a = &accountant{
+			key: key, top: top, since: time.Now(),
+			recent: map[string]*Volume{}, total: map[string]*Volume{},
+		}
+	}
+	var prior *accountant
+	updateGlobals(func(g *globals) {
+		prior = g.account
+		g.account = a
+	})
+	stop := make(chan struct{})
+	if nil != a && 0 < every {
+		go a.report(every, stop)
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			updateGlobals(func(g *globals) {
+				g.account = prior
+			})
+		})
+	}
+}
+
+// LogVolume() returns how many log lines and bytes have been written for
+// each value of the accounting key since SetVolumeAccounting() was called
+// ('nil' if accounting is off).
+//
+func LogVolume() map[string]Volume {
+	return getGlobals().account.volumes()
+}
+
+// Returns a copy of the total volumes ('nil' if 'a' is 'nil').
+func (a *accountant) volumes() map[string]Volume {
+	if nil == a {
+		return nil
+	}
+	defer AutoLock(&a.mu)()
+	vols := make(map[string]Volume, len(a.total))
+	for val, v := range a.total {
+		vols[val] = *v
+	}
+	return vols
+}
+
+// Notes the value of the accounting key for a log line about to be
+// written.  'pairs' is the list of key/value pairs passed to Map() or
+// MMap() ('nil' for List() and MList()).  Returns the logger to use.
+func (l *logger) account(pairs []interface{}) *logger {
+	if nil == l || nil == l.g.account {
+		return l
+	}
+	val, found := pairValue(pairs, l.g.account.key)
+	if !found {
+		val = l.ctxPairs().Get(l.g.account.key)
+	}
+	cp := *l
+	switch x := val.(type) {
+	case nil:
+		cp.acctVal = ""
+	case string:
+		cp.acctVal = x
+	default:
+		cp.acctVal = fmt.Sprint(x)
+	}
+	return &cp
+}
+
+// Counts a log line of 'size' bytes for 'val'.
+func (a *accountant) add(val string, size int) {
+	defer AutoLock(&a.mu)()
+	for _, m := range []map[string]*Volume{a.recent, a.total} {
+		v := m[val]
+		if nil == v && maxAccounted <= len(m) {
+			v = m["(other)"]
+			if nil == v {
+				v = &Volume{}
+				m["(other)"] = v
+			}
+		} else if nil == v {
+			v = &Volume{}
+			m[val] = v
+		}
+		v.Lines++
+		v.Bytes += uint64(size)
+	}
+}
+
+// Periodically logs the volume since the prior report, until 'stop' is
+// closed.
+func (a *accountant) report(every time.Duration, stop chan struct{}) {
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+		}
+		a.mu.Lock()
+		now := time.Now()
+		recent, since := a.recent, a.since
+		a.recent, a.since = map[string]*Volume{}, now
+		a.mu.Unlock()
+		if 0 == len(recent) {
+			continue
+		}
+		vals := make([]string, 0, len(recent))
+		var sum Volume
+		for val, v := range recent {
+			vals = append(vals, val)
+			sum.Lines += v.Lines
+			sum.Bytes += v.Bytes
+		}
+		sort.Slice(vals, func(i, j int) bool {
+			vi, vj := recent[vals[i]], recent[vals[j]]
+			if vi.Bytes != vj.Bytes {
+				return vj.Bytes < vi.Bytes
+			}
+			return vals[i] < vals[j]
+		})
+		if a.top < len(vals) {
+			vals = vals[:a.top]
+		}
+		top := make(AList, 0, len(vals))
+		for _, val := range vals {
+			top = append(top, Map("value", val,
+				"lines", recent[val].Lines, "bytes", recent[val].Bytes))
+		}
+		l, ok := getGlobals().lagers[int(lNote)].(*logger)
+		if !ok {
+			continue
+		}
+		g := *l.g
+		g.account = nil // So the report itself is not counted.
+		rep := *l
+		rep.g = &g
+		rep.MMap("Log volume", "key", a.key,
+			"interval", fmt.Sprintf("%.3fs", now.Sub(since).Seconds()),
+			"lines", sum.Lines, "bytes", sum.Bytes, "top", top)
+	}
+}
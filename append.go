@@ -0,0 +1,74 @@
+package lager
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// AppendEntry() appends the log line that would be written by
+//
+//      lager.Level(level[0]).MMap(msg, pairs...)
+//
+// to 'dst' and returns the result (much like the append() built-in) rather
+// than writing it.  This lets frameworks that do their own I/O (such as
+// servers that own their output buffers or custom batchers) use Lager's
+// encoding:
+//
+//      buf = lager.AppendEntry(buf[:0], "Acc", "Request", "path", path)
+//
+// 'level' is a log level name (like "Warn" or "WARN") or just its first
+// letter from "PEFWNAITDOG"; anything else calls panic().  If that level
+// is not enabled (or the line is dropped by a level rule, filter, or
+// sampling), then 'dst' is returned unchanged.  The line is formatted as
+// configured [such as via Keys() or UseConsoleFormat()] but Panic and Exit
+// lines do not call panic() nor os.Exit(), and triggers [see AddTrigger()]
+// are not counted.
+//
+func AppendEntry(
+	dst []byte, level, msg string, pairs ...interface{},
+) []byte {
+	return appendEntry(getGlobals(), dst, level, msg, pairs)
+}
+
+// AppendEntry() is like lager.AppendEntry() but uses the Logger's
+// configuration.
+//
+func (lg *Logger) AppendEntry(
+	dst []byte, level, msg string, pairs ...interface{},
+) []byte {
+	return appendEntry(lg.globals(), dst, level, msg, pairs)
+}
+
+func appendEntry(
+	g *globals, dst []byte, level, msg string, pairs []interface{},
+) []byte {
+	lev, ok := nLevels, false
+	if "" != level {
+		lev, ok = letterLevel(level[0])
+	}
+	if !ok {
+		panic(fmt.Sprintf(
+			"AppendEntry() needs a level from \"PEFWNAITDOG\" not %q", level))
+	}
+	l, ok := g.lagers[int(lev)].(*logger)
+	if !ok {
+		return dst
+	}
+	if l = l.prepare(msg, pairs, pairs); nil == l {
+		return dst
+	}
+	out := bytes.NewBuffer(dst)
+	b := bufPool.Get().(*buffer)
+	b.g = l.g
+	b.msg = msg
+	b.w = l.bind(b, out, false)
+	b.private = true
+	l.head(b)
+	l.mmap(b, msg, pairs)
+	l.tail(b)
+	b.unlock()
+	b.w, b.private, b.failed = nil, false, nil
+	b.resetEncs()
+	bufPool.Put(b)
+	return out.Bytes()
+}
@@ -0,0 +1,354 @@
+package lager
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Queues writes of log lines to be done by a background goroutine.
+type asyncOutput struct {
+	queue    chan asyncItem
+	interval time.Duration
+	mu       sync.RWMutex // Held (exclusively) to stop the goroutine.
+	stopped  bool
+
+	// The slowest write since the last health report [see
+	// WarnWhenBacklogged()].
+	slowMu   sync.Mutex
+	slowOut  io.Writer
+	slowTook time.Duration
+}
+
+// One queued write or, if 'done' is not 'nil', a request to write out
+// everything queued so far and then close 'done'.
+type asyncItem struct {
+	w    io.Writer
+	key  interface{} // The pending batch to add to [see outputKey()].
+	g    *globals    // The configuration the line was logged with.
+	data []byte
+	done chan struct{}
+	stop bool
+}
+
+// The io.Writer used for log lines when async mode is enabled.
+type asyncWriter struct {
+	a   *asyncOutput
+	w   io.Writer
+	key interface{}
+	g   *globals
+}
+
+// A destination with log lines waiting to be written to it.  Lines for a
+// net.Conn are kept separately so they can be written with one writev().
+type asyncPending struct {
+	w    io.Writer
+	g    *globals // The configuration of the latest line.
+	buf  bytes.Buffer
+	bufs net.Buffers
+}
+
+// Write out pending lines once this many bytes are waiting.
+const asyncMaxPending = 64 * 1024
+
+// SetAsync() enables (or, if 'queueLen' is 0, disables) asynchronous
+// output.  In async mode, log lines are queued and then written by a
+// background goroutine so that log I/O is taken off of hot request paths.
+// Queued lines are written out at least every 'interval' (default 100ms) or
+// sooner if a lot of data is waiting.  The queue holds up to 'queueLen'
+// writes; if it fills up, logging blocks until there is room again.
+//
+// Call Flush() to wait for queued lines to be written, such as before the
+// program exits.  Panic and Exit log lines [and lines logged with a Context
+// from FlushOn()] wait for all queued lines to be written.
+//
+// It returns a function that restores the prior setting:
+//
+//      defer lager.SetAsync(4096, 50*time.Millisecond)()
+//
+// Disabling async mode waits for any queued lines to be written.
+//
+func SetAsync(queueLen int, interval time.Duration) func() {
+	var a *asyncOutput
+	if 0 < queueLen {
+		if interval <= 0 {
+			interval = 100 * time.Millisecond
+		}
+		a = newAsyncOutput(queueLen, interval)
+	}
+	var prior *asyncOutput
+	updateGlobals(func(g *globals) {
+		prior = g.async
+		g.async = a
+	})
+	prior.stop()
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.async = prior
+		})
+		a.stop()
+	}
+}
+
+// Starts a background goroutine that writes out queued lines.
+func newAsyncOutput(queueLen int, interval time.Duration) *asyncOutput {
+	a := &asyncOutput{
+		queue: make(chan asyncItem, queueLen), interval: interval,
+	}
+	go a.run()
+	return a
+}
+
+// Flush() writes any repeated line being held [see SetDedupWindow()] and
+// then waits until all log lines that were queued in async mode [see
+// SetAsync() and SetCoalescing()] before it was called have been written.
+// Then each output [see SetOutput(), SetLevelOutput(), and
+// SetModuleRoutes()] that has a 'Flush() error' method gets flushed (the
+// first error from that is returned).  If 'ctx' is done before the queued
+// lines are written, then ctx.Err() is returned.  'ctx' can be 'nil'.
+//
+func Flush(ctx Ctx) error {
+	return getGlobals().flush(ctx)
+}
+
+// Writes any held repeat, waits for lines queued using 'g' to be written,
+// then flushes each of its outputs [see Flush()].
+func (g *globals) flush(ctx Ctx) error {
+	if nil == ctx {
+		ctx = context.Background()
+	}
+	if nil != g.dedup {
+		g.dedup.flush()
+	}
+	for _, a := range []*asyncOutput{g.async, g.coalesce} {
+		if nil == a {
+			continue
+		}
+		if done := a.flush(); nil != done {
+			select {
+			case <-done:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	var first error
+	outs := append([]io.Writer{g.dest}, g.levDest[:]...)
+	for _, r := range g.modRoutes {
+		outs = append(outs, r.Output)
+	}
+	for _, w := range g.modOutputs {
+		outs = append(outs, w)
+	}
+	for i, w := range outs {
+		f, ok := w.(flusher)
+		for _, prev := range outs[:i] {
+			ok = ok && !sameWriter(w, prev)
+		}
+		if ok {
+			if err := f.Flush(); nil != err && nil == first {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// Returns the io.Writer to use for a log line going to 'w' using the
+// configuration 'g'.  If 'drop' is not 'nil', the line can be dropped if the
+// queue is full [see DropWhenBehind()].
+func (a *asyncOutput) writer(
+	g *globals, w io.Writer, drop *droppedLine,
+) io.Writer {
+	aw := asyncWriter{a: a, w: w, key: outputKey(w), g: g}
+	if nil != drop {
+		return &dropWriter{asyncWriter: aw, line: drop}
+	}
+	return aw
+}
+
+// Queues a copy of the data to be written (or writes it directly if async
+// mode is no longer in effect).
+func (aw asyncWriter) Write(data []byte) (int, error) {
+	defer aw.a.mu.RUnlock()
+	aw.a.mu.RLock()
+	if aw.a.stopped {
+		return aw.w.Write(data)
+	}
+	aw.a.queue <- asyncItem{
+		w: aw.w, key: aw.key, g: aw.g, data: append([]byte(nil), data...)}
+	return len(data), nil
+}
+
+// Queues a copy of the data unless the queue is full, then returns whether
+// it was queued (or written directly, if async mode is no longer in effect).
+func (aw asyncWriter) tryWrite(data []byte) (bool, error) {
+	defer aw.a.mu.RUnlock()
+	aw.a.mu.RLock()
+	if aw.a.stopped {
+		_, err := aw.w.Write(data)
+		return true, err
+	}
+	it := asyncItem{
+		w: aw.w, key: aw.key, g: aw.g, data: append([]byte(nil), data...)}
+	select {
+	case aw.a.queue <- it:
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// Waits for all queued lines to be written, then flushes the destination.
+func (aw asyncWriter) Flush() error {
+	if done := aw.a.flush(); nil != done {
+		<-done
+	}
+	if f, ok := aw.w.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Queues a request to write out all queued lines.  Returns a channel that
+// gets closed when that is done (or 'nil' if async mode was stopped).
+func (a *asyncOutput) flush() chan struct{} {
+	defer a.mu.RUnlock()
+	a.mu.RLock()
+	if a.stopped {
+		return nil
+	}
+	done := make(chan struct{})
+	a.queue <- asyncItem{done: done}
+	return done
+}
+
+// Writes out any queued lines and stops the background goroutine.  Any
+// later writes are done directly.
+func (a *asyncOutput) stop() {
+	if nil == a {
+		return
+	}
+	a.mu.Lock()
+	if a.stopped {
+		a.mu.Unlock()
+		return
+	}
+	a.stopped = true
+	done := make(chan struct{})
+	a.queue <- asyncItem{done: done, stop: true}
+	a.mu.Unlock()
+	<-done
+}
+
+// The background goroutine that writes out queued lines.
+func (a *asyncOutput) run() {
+	pending := make([]*asyncPending, 0, 2) // In order of first line.
+	byKey := make(map[interface{}]*asyncPending, 2)
+	size := 0
+	writeAll := func() {
+		for _, p := range pending {
+			start := time.Now()
+			p.write()
+			a.noteWrite(p.w, time.Since(start))
+		}
+		pending = pending[:0]
+		for k := range byKey {
+			delete(byKey, k)
+		}
+		size = 0
+	}
+	tick := time.NewTicker(a.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			writeAll()
+		case it := <-a.queue:
+			if nil != it.done {
+				writeAll()
+				close(it.done)
+				if it.stop {
+					return
+				}
+				continue
+			}
+			p := byKey[it.key]
+			if nil == p {
+				p = &asyncPending{w: it.w}
+				byKey[it.key] = p
+				pending = append(pending, p)
+			}
+			p.g = it.g
+			if _, ok := it.w.(net.Conn); ok {
+				p.bufs = append(p.bufs, it.data)
+			} else {
+				p.buf.Write(it.data)
+			}
+			if size += len(it.data); asyncMaxPending <= size {
+				writeAll()
+			}
+		}
+	}
+}
+
+// Records how long a write to 'w' took, if it is the slowest so far.
+func (a *asyncOutput) noteWrite(w io.Writer, took time.Duration) {
+	defer AutoLock(&a.slowMu)()
+	if a.slowTook < took {
+		a.slowOut, a.slowTook = w, took
+	}
+}
+
+// Returns the slowest output (and how long the write took) since the last
+// call, or 'nil' if nothing was written.
+func (a *asyncOutput) takeSlowest() (io.Writer, time.Duration) {
+	defer AutoLock(&a.slowMu)()
+	w, took := a.slowOut, a.slowTook
+	a.slowOut, a.slowTook = nil, 0
+	return w, took
+}
+
+// Writes queued lines to their destination, using the fallback output and
+// counting the failure if that fails [see SetFallbackOutput()].
+func (p *asyncPending) write() {
+	var err error
+	g := p.g
+	lines := append(net.Buffers(nil), p.bufs...) // WriteTo() consumes p.bufs
+	if nil != p.bufs {
+		err = g.writeBuffers(p.w, &p.bufs)
+	} else {
+		_, err = g.write(p.w, p.buf.Bytes())
+	}
+	if nil != err {
+		if nil != g.fallback && nil != lines {
+			g.fallback.Write(bytes.Join(lines, nil))
+		} else if nil != g.fallback {
+			g.fallback.Write(p.buf.Bytes())
+		}
+		g.outputFailed(err)
+	}
+}
+
+// Returns the key for the pending batch that lines written to 'w' join.
+// Each output is queued on its own [see (*logger).queued()], so 'w' is the
+// io.Writer that was configured (such as via SetOutput()) and lines for it
+// get written together.  A 'w' of a type that cannot be used as a map key
+// gets a key of its own so its lines are still written in order.
+func outputKey(w io.Writer) interface{} {
+	if t := reflect.TypeOf(w); nil != t && t.Comparable() {
+		return w
+	}
+	return new(byte)
+}
+
+// Returns whether two io.Writers are the same (without panicking when they
+// are of a type that cannot be compared).
+func sameWriter(a, b io.Writer) bool {
+	t := reflect.TypeOf(a)
+	return t == reflect.TypeOf(b) && nil != t && t.Comparable() && a == b
+}
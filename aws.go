@@ -0,0 +1,148 @@
+package lager
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The environment variable that AWS Lambda sets to the X-Ray trace header
+// for the current invocation.
+const awsTraceEnv = "_X_AMZN_TRACE_ID"
+
+// RunningInAws() tells Lager to log messages in a format that works best
+// when running in AWS with logs going to CloudWatch Logs (such as from a
+// Lambda function or an ECS task).  Each line is a JSON map whose fields
+// CloudWatch Logs Insights discovers automatically, using the same field
+// names and level names as Lambda's own JSON log format.
+//
+// In particular, RunningInAws() is similar to running:
+//
+//      if "" == os.Getenv("LAGER_KEYS") {
+//          // LAGER_KEYS has precedence over LAGER_AWS.
+//          lager.Keys("timestamp", "level", "message", "data", "", "module")
+//      }
+//      lager.SetLevelNotation(lager.AwsLevelName)
+//
+// So pairs from Contexts are logged in-line where Insights queries can
+// use them directly.  Also, ContextAddTrace() uses the keys
+// "xray_trace_id" and "xray_span_id" [see AwsContextAddTrace()].  It undoes
+// RunningInGcp(), RunningInEcs(), and RunningInAzure().  It returns a
+// function that restores the prior settings:
+//
+//      defer lager.RunningInAws()()
+//
+// Setting LAGER_AWS in the environment has the same effect as calling
+// RunningInAws() when the program starts.  The "aws" format can also be
+// selected via FlagSet().
+//
+func RunningInAws() func() {
+	var keys *keyStrs
+	var levDesc func(string) string
+	var inEcs, inGcp, inAws, inAzure bool
+	updateGlobals(func(g *globals) {
+		keys, levDesc = g.keys, g.levDesc
+		inEcs, inGcp, inAws, inAzure = g.inEcs, g.inGcp, g.inAws, g.inAzure
+		setRunningInAws(true)(g)
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.keys, g.levDesc = keys, levDesc
+			g.inEcs, g.inGcp, g.inAws, g.inAzure = inEcs, inGcp, inAws, inAzure
+		})
+	}
+}
+
+// How AWS options are set safely.
+func setRunningInAws(enabled bool) func(*globals) {
+	return func(g *globals) {
+		g.inAws = enabled
+		if enabled {
+			g.inGcp, g.inEcs, g.inAzure = false, false, false
+			if "" == os.Getenv("LAGER_KEYS") {
+				g.keys = &keyStrs{
+					when: "timestamp", lev: "level", msg: "message",
+					args: "data", mod: "module", ctx: "",
+				}
+			}
+			g.levDesc = AwsLevelName
+		} else {
+			g.levDesc = identLevelNotation
+		}
+	}
+}
+
+// AwsLevelName takes a Lager level name (only the first letter matters and
+// it must be upper case) and returns the corresponding level name used by
+// AWS Lambda's JSON log format (which Lambda uses when filtering by log
+// level).  Levels are mapped as:
+//      Panic, Exit - "FATAL"
+//      Fail - "ERROR"
+//      Warn - "WARN"
+//      Note, Access, Info - "INFO"
+//      Trace - "TRACE"
+//      Debug, Obj, Guts - "DEBUG"
+//      If an invalid level name is passed: "INFO"
+//
+func AwsLevelName(lev string) string {
+	switch lev[0] {
+	case 'P', 'E':
+		return "FATAL"
+	case 'F':
+		return "ERROR"
+	case 'W':
+		return "WARN"
+	case 'T':
+		return "TRACE"
+	case 'D', 'O', 'G':
+		return "DEBUG"
+	}
+	return "INFO"
+}
+
+// AwsTraceSource() parses an X-Ray trace header [like the value of the
+// "X-Amzn-Trace-Id" HTTP header], such as:
+//
+//      Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8
+//
+// and returns a TraceSource for it [see ContextAddTrace()].  It returns
+// 'nil' if the header does not contain a trace ID and a parent (span) ID.
+//
+func AwsTraceSource(header string) TraceSource {
+	ids := traceIDs{}
+	for _, part := range strings.Split(header, ";") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if 2 != len(kv) {
+			continue
+		}
+		switch kv[0] {
+		case "Root":
+			ids.trace = kv[1]
+		case "Parent":
+			ids.span, _ = strconv.ParseUint(kv[1], 16, 64)
+		}
+	}
+	if "" == ids.trace || 0 == ids.span {
+		return nil
+	}
+	return ids
+}
+
+// AwsContextAddTrace() adds pairs for the X-Ray trace of the current AWS
+// Lambda invocation (from the _X_AMZN_TRACE_ID environment variable) to
+// 'ctx' [via ContextAddTrace()] so log lines can be correlated with the
+// trace.  Call it at the start of each invocation:
+//
+//      func handler(ctx context.Context, ev events.SQSEvent) error {
+//          ctx = lager.AwsContextAddTrace(ctx)
+//
+// If the variable is not set (or not valid), then 'ctx' is returned
+// unchanged.
+//
+func AwsContextAddTrace(ctx Ctx) Ctx {
+	src := AwsTraceSource(os.Getenv(awsTraceEnv))
+	if nil == src {
+		return ctx
+	}
+	return ContextAddTrace(ctx, src)
+}
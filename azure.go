@@ -0,0 +1,146 @@
+package lager
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// RunningInAzure() tells Lager to log messages in a format that works best
+// when running in Azure with logs going to Azure Monitor (such as from App
+// Service, Container Apps, or AKS with Container Insights).  Each line is
+// a JSON map using the field names and severity level names of
+// Application Insights trace telemetry.
+//
+// In particular, RunningInAzure() is similar to running:
+//
+//      if "" == os.Getenv("LAGER_KEYS") {
+//          // LAGER_KEYS has precedence over LAGER_AZURE.
+//          lager.Keys("time", "severityLevel", "message", "data",
+//              "customDimensions", "category")
+//      }
+//      lager.SetLevelNotation(lager.AzureLevelName)
+//
+// So pairs from Contexts are logged under "customDimensions" while other
+// pairs are logged in-line.  Also, ContextAddTrace() uses the keys
+// "operation_Id" and "operation_ParentId" [see AzureContextAddTrace()].
+// It undoes RunningInGcp(), RunningInEcs(), and RunningInAws().  It
+// returns a function that restores the prior settings:
+//
+//      defer lager.RunningInAzure()()
+//
+// Setting LAGER_AZURE in the environment has the same effect as calling
+// RunningInAzure() when the program starts.  The "azure" format can also
+// be selected via FlagSet().
+//
+func RunningInAzure() func() {
+	var keys *keyStrs
+	var levDesc func(string) string
+	var inEcs, inGcp, inAws, inAzure bool
+	updateGlobals(func(g *globals) {
+		keys, levDesc = g.keys, g.levDesc
+		inEcs, inGcp, inAws, inAzure = g.inEcs, g.inGcp, g.inAws, g.inAzure
+		setRunningInAzure(true)(g)
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.keys, g.levDesc = keys, levDesc
+			g.inEcs, g.inGcp, g.inAws, g.inAzure = inEcs, inGcp, inAws, inAzure
+		})
+	}
+}
+
+// How Azure options are set safely.
+func setRunningInAzure(enabled bool) func(*globals) {
+	return func(g *globals) {
+		g.inAzure = enabled
+		if enabled {
+			g.inGcp, g.inEcs, g.inAws = false, false, false
+			if "" == os.Getenv("LAGER_KEYS") {
+				g.keys = &keyStrs{
+					when: "time", lev: "severityLevel", msg: "message",
+					args: "data", mod: "category", ctx: "customDimensions",
+				}
+			}
+			g.levDesc = AzureLevelName
+		} else {
+			g.levDesc = identLevelNotation
+		}
+	}
+}
+
+// AzureLevelName takes a Lager level name (only the first letter matters
+// and it must be upper case) and returns the corresponding Application
+// Insights severity level name.  Levels are mapped as:
+//      Panic, Exit - "Critical"
+//      Fail - "Error"
+//      Warn - "Warning"
+//      Note, Access, Info - "Information"
+//      Trace, Debug, Obj, Guts - "Verbose"
+//      If an invalid level name is passed: "Information"
+//
+func AzureLevelName(lev string) string {
+	switch lev[0] {
+	case 'P', 'E':
+		return "Critical"
+	case 'F':
+		return "Error"
+	case 'W':
+		return "Warning"
+	case 'T', 'D', 'O', 'G':
+		return "Verbose"
+	}
+	return "Information"
+}
+
+// AzureTraceSource() returns a TraceSource [see ContextAddTrace()] for the
+// operation that an incoming request is part of, based on the headers
+// that Azure services and Application Insights SDKs send.  The W3C
+// "traceparent" header is used, if present:
+//
+//      traceparent: 00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01
+//
+// Otherwise, the "Request-Id" header of the older HTTP correlation
+// protocol is used, if it has the W3C-compatible form:
+//
+//      Request-Id: |0af7651916cd43dd8448eb211c80319c.b7ad6b7169203331.
+//
+// It returns 'nil' if neither header holds an operation ID and a parent
+// (span) ID.
+//
+func AzureTraceSource(h http.Header) TraceSource {
+	var trace, span string
+	if tp := strings.Split(h.Get("traceparent"), "-"); 4 == len(tp) {
+		trace, span = tp[1], tp[2]
+	} else if rid := h.Get("Request-Id"); strings.HasPrefix(rid, "|") {
+		parts := strings.Split(strings.TrimPrefix(rid, "|"), ".")
+		if 2 <= len(parts) {
+			trace, span = parts[0], parts[1]
+		}
+	}
+	id, _ := strconv.ParseUint(span, 16, 64)
+	if 32 != len(trace) || 16 != len(span) || 0 == id {
+		return nil
+	}
+	return traceIDs{trace: trace, span: id}
+}
+
+// AzureContextAddTrace() adds pairs for the Application Insights operation
+// that 'req' is part of [see AzureTraceSource()] to 'ctx' [via
+// ContextAddTrace()] so log lines can be correlated with the request
+// telemetry:
+//
+//      func handler(w http.ResponseWriter, req *http.Request) {
+//          ctx := lager.AzureContextAddTrace(req.Context(), req)
+//
+// If 'req' has no usable correlation headers, then 'ctx' is returned
+// unchanged.
+//
+func AzureContextAddTrace(ctx Ctx, req *http.Request) Ctx {
+	src := AzureTraceSource(req.Header)
+	if nil == src {
+		return ctx
+	}
+	return ContextAddTrace(ctx, src)
+}
@@ -0,0 +1,345 @@
+/*
+Package batch collects the log lines that Lager writes to an io.Writer
+and hands them, in batches, to a function that ships them elsewhere,
+retrying failures.  It is used to implement Lager's network sinks and can
+be used to write others.
+*/
+package batch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options controls how lines are batched and how failures are retried.
+// Zero values are replaced with the defaults listed.
+//
+type Options struct {
+	// The most lines to send in one batch (default 500).
+	MaxLines int
+
+	// The most bytes of lines to send in one batch (default 1MiB).  A batch
+	// with a single line can be larger.
+	MaxBytes int
+
+	// How long a line can wait before its batch is sent (default 1s).
+	Interval time.Duration
+
+	// How many times to retry sending a batch (default 3).  Use -1 to
+	// never retry.
+	Retries int
+
+	// The delay before the first retry, doubled for each subsequent retry
+	// (default 250ms).
+	Backoff time.Duration
+
+	// Called when a batch is dropped because sending it failed (and no
+	// retries remain).  By default, the error is written to os.Stderr.
+	OnError func(error)
+}
+
+// Sender ships one batch of lines (each without the trailing newline).  It
+// should return an error created via Permanent() if retrying the same
+// batch can never succeed.
+//
+type Sender func(lines [][]byte) error
+
+// Writer is an io.Writer suitable for passing to lager.SetOutput().  Each
+// complete line written to it is queued to be sent by a Sender.
+//
+type Writer struct {
+	send Sender
+	opts Options
+
+	mu     sync.Mutex
+	part   []byte   // Partial line (not yet ended with a newline).
+	lines  [][]byte // Complete lines not yet queued.
+	size   int      // Total bytes in 'lines'.
+	closed bool
+
+	queue chan job
+	stop  chan bool
+	done  chan bool
+}
+
+// A batch to be sent and, optionally, a channel to report completion to.
+type job struct {
+	lines [][]byte
+	done  chan error
+}
+
+type permanent struct{ error }
+
+func (p permanent) Unwrap() error { return p.error }
+
+// ErrClosed is returned when writing to a Writer after Close().
+var ErrClosed = errors.New("batch.Writer already closed")
+
+// Permanent() wraps an error to indicate that retrying will not help.
+func Permanent(err error) error {
+	return permanent{err}
+}
+
+// New() returns a Writer that uses 'send' to ship batches of lines and
+// starts the goroutines that do so.  Call Close() when done with it.
+//
+func New(send Sender, opts Options) *Writer {
+	if opts.MaxLines <= 0 {
+		opts.MaxLines = 500
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = 1024 * 1024
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if 0 == opts.Retries {
+		opts.Retries = 3
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 250 * time.Millisecond
+	}
+	if nil == opts.OnError {
+		opts.OnError = func(err error) {
+			fmt.Fprintf(os.Stderr, "Dropped batch of log lines: %v\n", err)
+		}
+	}
+	w := &Writer{
+		send:  send,
+		opts:  opts,
+		queue: make(chan job, 8),
+		stop:  make(chan bool),
+		done:  make(chan bool),
+	}
+	go w.deliver()
+	go w.tick()
+	return w
+}
+
+// Write() appends bytes to the pending lines.  It only blocks if several
+// batches are already waiting to be sent.
+//
+func (w *Writer) Write(p []byte) (int, error) {
+	defer lock(&w.mu)()
+	if w.closed {
+		return 0, ErrClosed
+	}
+	n := len(p)
+	for 0 < len(p) {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.part = append(w.part, p...)
+			break
+		}
+		line := make([]byte, len(w.part)+i)
+		copy(line, w.part)
+		copy(line[len(w.part):], p[:i])
+		w.part = w.part[:0]
+		p = p[i+1:]
+		w.lines = append(w.lines, line)
+		w.size += len(line)
+		if w.opts.MaxLines <= len(w.lines) || w.opts.MaxBytes <= w.size {
+			w.enqueue(nil)
+		}
+	}
+	return n, nil
+}
+
+// Flush() sends all complete lines written so far and waits for that to
+// finish.  It returns the error from the final attempt to send them.
+//
+func (w *Writer) Flush() error {
+	done := make(chan error, 1)
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrClosed
+	}
+	w.enqueue(done)
+	w.mu.Unlock()
+	return <-done
+}
+
+// Close() sends any remaining lines (including a final partial line) and
+// stops the Writer's goroutines.
+//
+func (w *Writer) Close() error {
+	done := make(chan error, 1)
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrClosed
+	}
+	if 0 < len(w.part) {
+		w.lines = append(w.lines, w.part)
+		w.part = nil
+	}
+	w.enqueue(done)
+	w.closed = true
+	close(w.stop)
+	close(w.queue)
+	w.mu.Unlock()
+	err := <-done
+	<-w.done
+	return err
+}
+
+// Queues the pending lines to be sent.  Must be called with 'mu' locked
+// so that batches are queued in order.
+func (w *Writer) enqueue(done chan error) {
+	if 0 == len(w.lines) && nil == done {
+		return
+	}
+	w.queue <- job{lines: w.lines, done: done}
+	w.lines, w.size = nil, 0
+}
+
+// Sends each queued batch, in order.
+func (w *Writer) deliver() {
+	defer close(w.done)
+	for j := range w.queue {
+		var err error
+		if 0 < len(j.lines) {
+			err = w.attempt(j.lines)
+		}
+		if nil != j.done {
+			j.done <- err
+		}
+	}
+}
+
+// Tries to send one batch, retrying as configured.
+func (w *Writer) attempt(lines [][]byte) error {
+	delay := w.opts.Backoff
+	err := w.send(lines)
+	for try := 0; nil != err && try < w.opts.Retries; try++ {
+		if errors.As(err, new(permanent)) {
+			break
+		}
+		time.Sleep(delay)
+		delay *= 2
+		err = w.send(lines)
+	}
+	if nil != err {
+		w.opts.OnError(fmt.Errorf("%d lines: %w", len(lines), err))
+	}
+	return err
+}
+
+// Queues pending lines once per interval.
+func (w *Writer) tick() {
+	t := time.NewTicker(w.opts.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			w.mu.Lock()
+			if !w.closed {
+				w.enqueue(nil)
+			}
+			w.mu.Unlock()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// The keys that Lager uses for the timestamp and for the log level when
+// writing JSON maps [see lager.Keys(), lager.RunningInGcp(), etc.].
+var (
+	timeKeys  = []string{"time", "timestamp", "@timestamp", "ts_epoch_ms"}
+	levelKeys = []string{"level", "severity", "severityLevel", "log.level"}
+)
+
+// LineTime() extracts the timestamp from a Lager log line.  For a JSON
+// list, it is the first value.  For a JSON map [see lager.Keys()], it is
+// the first value whose key is "time", "timestamp", "@timestamp", or
+// "ts_epoch_ms" (else the first value).  The timestamp can
+// be a string or a number of milliseconds since the Unix epoch [see
+// lager.SetEpochTimestamp()].
+//
+func LineTime(line []byte) (time.Time, bool) {
+	v, _ := lineValue(line, timeKeys, 0)
+	switch x := v.(type) {
+	case string:
+		t, err := time.Parse("2006-01-02T15:04:05.999999999Z",
+			strings.Replace(x, " ", "T", 1))
+		return t, nil == err
+	case json.Number:
+		ms, err := x.Int64()
+		return time.Unix(0, ms*int64(time.Millisecond)).UTC(), nil == err
+	}
+	return time.Time{}, false
+}
+
+// LineLevel() extracts the log level from a Lager log line.  For a JSON
+// list, it is the second value.  For a JSON map, it is the value for 'key'
+// or, if 'key' is "", the first value whose key is "level", "severity",
+// "severityLevel", or "log.level" (else the second value).
+// A numeric level [see lager.SetSeverityNumbers()] is returned in base 10.
+//
+func LineLevel(line []byte, key string) (string, bool) {
+	keys := levelKeys
+	if "" != key {
+		keys = []string{key}
+	}
+	v, ok := lineValue(line, keys, 1)
+	if !ok || nil == v {
+		return "", false
+	}
+	return fmt.Sprint(v), true
+}
+
+// Returns a value from a JSON log line:  for a JSON map, the first value
+// whose key is one of 'keys', otherwise the value at position 'pos'.
+func lineValue(line []byte, keys []string, pos int) (interface{}, bool) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	tok, err := dec.Token()
+	if nil != err {
+		return nil, false
+	}
+	isMap := json.Delim('{') == tok
+	if !isMap && json.Delim('[') != tok {
+		return nil, false
+	}
+	var at interface{}
+	found := false
+	for i := 0; dec.More(); i++ {
+		key := ""
+		if isMap {
+			tok, err := dec.Token()
+			if nil != err {
+				break
+			}
+			key, _ = tok.(string)
+		}
+		var v interface{}
+		if nil != dec.Decode(&v) {
+			break
+		}
+		if i == pos {
+			at, found = v, true
+			if !isMap {
+				break
+			}
+		}
+		for _, k := range keys {
+			if isMap && k == key {
+				return v, true
+			}
+		}
+	}
+	return at, found
+}
+
+func lock(mu *sync.Mutex) func() {
+	mu.Lock()
+	return mu.Unlock
+}
@@ -0,0 +1,102 @@
+package lager
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// The key GCP Cloud Logging uses for the labels of a structured log entry.
+const gcpLabelsKey = "logging.googleapis.com/labels"
+
+// SetBillingLabels() sets static labels (such as a team and a cost center)
+// that are added to every log line so that log sinks can route (and bill)
+// logs by them.  The labels are logged as a map under the "billing" key:
+//
+//      defer lager.SetBillingLabels(map[string]string{
+//          "team": "payments", "cost-center": "cc-1234",
+//      })()
+//
+//      ["2021-06-09 13:10:07.0447Z", "INFO", "Charged card",
+//          {"billing":{"cost-center":"cc-1234", "team":"payments"}}]
+//
+// When running in GCP [see RunningInGcp()], the labels are instead logged
+// under the "logging.googleapis.com/labels" key, which Cloud Logging turns
+// into the labels of the log entry (where log sinks can filter on them).
+// The labels are logged in order of their names.  Passing an empty map
+// removes the labels.  It returns a function that restores the prior
+// labels.
+//
+// Setting LAGER_BILLING_LABELS in the environment to a comma-separated
+// list of {name}={value} pairs, like "team=payments,cost-center=cc-1234",
+// has the same effect as calling SetBillingLabels() when the program
+// starts.
+//
+func SetBillingLabels(labels map[string]string) func() {
+	var prior AMap
+	updateGlobals(func(g *globals) {
+		prior = g.billing
+		g.billing = billingLabels(labels)
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.billing = prior
+		})
+	}
+}
+
+// Converts billing labels to an AMap sorted by name ('nil' if empty).
+func billingLabels(labels map[string]string) AMap {
+	if 0 == len(labels) {
+		return nil
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]interface{}, 0, 2*len(names))
+	for _, name := range names {
+		pairs = append(pairs, name, labels[name])
+	}
+	return AMap(nil).AddPairs(pairs...)
+}
+
+// Sets the initial billing labels from LAGER_BILLING_LABELS.
+func envBillingLabels(g *globals) {
+	env := os.Getenv("LAGER_BILLING_LABELS")
+	if "" == env {
+		return
+	}
+	labels := map[string]string{}
+	for _, part := range strings.Split(env, ",") {
+		eq := strings.Index(part, "=")
+		name := ""
+		if 0 < eq {
+			name = strings.TrimSpace(part[:eq])
+		}
+		if "" == name {
+			initFatal(g, "Invalid LAGER_BILLING_LABELS",
+				"error", fmt.Errorf("expected {name}={value} not %q", part),
+				"got", env)
+			return
+		}
+		labels[name] = strings.TrimSpace(part[eq+1:])
+	}
+	g.billing = billingLabels(labels)
+}
+
+// Writes the billing labels [see SetBillingLabels()] as part of the end of
+// a log line.
+func (l *logger) writeBilling(b *buffer) {
+	key := "billing"
+	if l.g.inGcp {
+		key = gcpLabelsKey
+	}
+	if nil == l.g.keys {
+		b.scalar(RawMap{key, l.g.billing})
+	} else {
+		b.pair(key, l.g.billing)
+	}
+}
@@ -0,0 +1,233 @@
+package lager
+
+import (
+	"math"
+)
+
+// Encodes values as CBOR (RFC 8949).
+type cborEncoder struct{}
+
+// Encodes values as MessagePack.
+type msgpackEncoder struct{}
+
+// UseCborFormat() causes each log line to be written as one CBOR (RFC 8949)
+// data item rather than as a line of JSON, for high-volume internal
+// pipelines where the consumer decodes CBOR.  Lists become CBOR arrays and
+// maps become CBOR maps (with their keys in the same order).  No newlines
+// are written as CBOR data items delimit themselves.  Each value is
+// encoded directly from what was logged, as the line is composed, so no
+// JSON gets composed nor parsed [see UseEncoder()].  It returns a function
+// that restores the prior setting:
+//
+//      defer lager.UseCborFormat()()
+//
+func UseCborFormat() func() {
+	return UseEncoder(cborEncoder{})
+}
+
+// UseMsgpackFormat() is like UseCborFormat() but writes each log line as
+// one MessagePack object.
+//
+//      defer lager.UseMsgpackFormat()()
+//
+func UseMsgpackFormat() func() {
+	return UseEncoder(msgpackEncoder{})
+}
+
+// Append unsigned integers in big-endian byte order.
+func appendBig16(out []byte, n uint16) []byte {
+	return appendBig(out, 2, uint64(n))
+}
+
+func appendBig32(out []byte, n uint32) []byte {
+	return appendBig(out, 4, uint64(n))
+}
+
+func appendBig64(out []byte, n uint64) []byte {
+	return appendBig(out, 8, n)
+}
+
+func appendBig(out []byte, size int, n uint64) []byte {
+	for shift := 8 * (size - 1); 0 <= shift; shift -= 8 {
+		out = append(out, byte(n>>uint(shift)))
+	}
+	return out
+}
+
+// Inserts the 'head' of a list or map (holding its count, which is only
+// known once it is closed) in front of its elements at out[start:].
+func insertHead(out []byte, start int, head []byte) []byte {
+	out = append(out, head...)
+	copy(out[start+len(head):], out[start:len(out)-len(head)])
+	copy(out[start:], head)
+	return out
+}
+
+// Appends a CBOR head: the major type and an argument.
+func cborHead(out []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(out, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(out, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return appendBig16(append(out, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		return appendBig32(append(out, major|26), uint32(n))
+	}
+	return appendBig64(append(out, major|27), n)
+}
+
+func (cborEncoder) OpenList(out []byte) []byte { return out }
+func (cborEncoder) OpenMap(out []byte) []byte  { return out }
+
+func (cborEncoder) CloseList(out []byte, start, n int) []byte {
+	var head [9]byte
+	return insertHead(out, start, cborHead(head[:0], 4, uint64(n)))
+}
+
+func (cborEncoder) CloseMap(out []byte, start, n int) []byte {
+	var head [9]byte
+	return insertHead(out, start, cborHead(head[:0], 5, uint64(n)))
+}
+
+func (cborEncoder) Next(out []byte, _ int) []byte { return out }
+func (cborEncoder) EndLine(out []byte) []byte     { return out }
+func (cborEncoder) Key(out []byte, key string) []byte {
+	return append(cborEncoder{}.strHead(out, len(key)), key...)
+}
+
+func (cborEncoder) Scalar(out []byte, v interface{}) []byte {
+	switch x := v.(type) {
+	case nil:
+		return append(out, 0xF6)
+	case bool:
+		if x {
+			return append(out, 0xF5)
+		}
+		return append(out, 0xF4)
+	case int64:
+		return cborEncoder{}.scalarInt(out, x)
+	case uint64:
+		return cborHead(out, 0, x)
+	case float64:
+		return cborEncoder{}.scalarFloat(out, x, 64)
+	}
+	s := S(v)
+	return append(cborEncoder{}.strHead(out, len(s)), s...)
+}
+
+func (cborEncoder) strHead(out []byte, n int) []byte {
+	return cborHead(out, 3, uint64(n))
+}
+
+func (cborEncoder) strTail(out []byte) []byte { return out }
+
+func (cborEncoder) strBody(out []byte, s string) []byte {
+	return append(out, s...)
+}
+
+func (cborEncoder) scalarInt(out []byte, v int64) []byte {
+	if v < 0 {
+		return cborHead(out, 1, uint64(-(v + 1)))
+	}
+	return cborHead(out, 0, uint64(v))
+}
+
+func (cborEncoder) scalarFloat(out []byte, v float64, bits int) []byte {
+	v = exactFloat(v, bits)
+	return appendBig64(append(out, 0xFB), math.Float64bits(v))
+}
+
+// The MessagePack type bytes for a kind of value with a length: 'fix' (for
+// lengths below 'max'), then ones followed by a 1- (if not 0), 2-, or 4-byte
+// length.
+type msgpackKind struct {
+	fix          byte
+	max          int
+	b8, b16, b32 byte
+}
+
+var (
+	msgpackStr   = msgpackKind{0xA0, 32, 0xD9, 0xDA, 0xDB}
+	msgpackArray = msgpackKind{0x90, 16, 0, 0xDC, 0xDD}
+	msgpackMap   = msgpackKind{0x80, 16, 0, 0xDE, 0xDF}
+)
+
+// Appends a MessagePack type byte and length.
+func msgpackHead(out []byte, n int, k msgpackKind) []byte {
+	switch {
+	case n < k.max:
+		return append(out, k.fix|byte(n))
+	case 0 != k.b8 && n <= math.MaxUint8:
+		return append(out, k.b8, byte(n))
+	case n <= math.MaxUint16:
+		return appendBig16(append(out, k.b16), uint16(n))
+	}
+	return appendBig32(append(out, k.b32), uint32(n))
+}
+
+func (msgpackEncoder) OpenList(out []byte) []byte { return out }
+func (msgpackEncoder) OpenMap(out []byte) []byte  { return out }
+
+func (msgpackEncoder) CloseList(out []byte, start, n int) []byte {
+	var head [5]byte
+	return insertHead(out, start, msgpackHead(head[:0], n, msgpackArray))
+}
+
+func (msgpackEncoder) CloseMap(out []byte, start, n int) []byte {
+	var head [5]byte
+	return insertHead(out, start, msgpackHead(head[:0], n, msgpackMap))
+}
+
+func (msgpackEncoder) Next(out []byte, _ int) []byte { return out }
+func (msgpackEncoder) EndLine(out []byte) []byte     { return out }
+func (msgpackEncoder) Key(out []byte, key string) []byte {
+	return append(msgpackEncoder{}.strHead(out, len(key)), key...)
+}
+
+func (msgpackEncoder) Scalar(out []byte, v interface{}) []byte {
+	switch x := v.(type) {
+	case nil:
+		return append(out, 0xC0)
+	case bool:
+		if x {
+			return append(out, 0xC3)
+		}
+		return append(out, 0xC2)
+	case int64:
+		return msgpackEncoder{}.scalarInt(out, x)
+	case uint64:
+		return appendBig64(append(out, 0xCF), x)
+	case float64:
+		return msgpackEncoder{}.scalarFloat(out, x, 64)
+	}
+	s := S(v)
+	return append(msgpackEncoder{}.strHead(out, len(s)), s...)
+}
+
+func (msgpackEncoder) strHead(out []byte, n int) []byte {
+	return msgpackHead(out, n, msgpackStr)
+}
+
+func (msgpackEncoder) strTail(out []byte) []byte { return out }
+
+func (msgpackEncoder) strBody(out []byte, s string) []byte {
+	return append(out, s...)
+}
+
+func (msgpackEncoder) scalarInt(out []byte, v int64) []byte {
+	switch {
+	case 0 <= v && v < 128, -32 <= v && v < 0:
+		return append(out, byte(v))
+	case math.MinInt32 <= v && v <= math.MaxInt32:
+		return appendBig32(append(out, 0xD2), uint32(v))
+	}
+	return appendBig64(append(out, 0xD3), uint64(v))
+}
+
+func (msgpackEncoder) scalarFloat(out []byte, v float64, bits int) []byte {
+	v = exactFloat(v, bits)
+	return appendBig64(append(out, 0xCB), math.Float64bits(v))
+}
@@ -0,0 +1,118 @@
+package lager
+
+import (
+	"bytes"
+)
+
+// Key/value pairs bound to a Lager via WithPairs(), along with their
+// encoding.
+type boundPairs struct {
+	kvp  AMap   // With the values from any func() or Valuer values.
+	json []byte // Like `"k":"v", "k2":2` (without the enclosing braces).
+}
+
+// See the Lager interface for documentation.
+func (l *logger) WithPairs(pairs ...interface{}) Lager {
+	if 0 == len(pairs) {
+		return l
+	}
+	all := flatPairs(nil, pairs)
+	if nil != l.bound {
+		all = l.bound.kvp.Merge(all)
+	}
+	if nil == all || 0 == len(all.keys) {
+		return l
+	}
+	all = calledPairs(all)
+	out := new(bytes.Buffer)
+	b := bufPool.Get().(*buffer)
+	b.g = l.g
+	b.w = out
+	b.private = true
+	b.open("{")
+	b.pairs(all)
+	b.close("}")
+	b.unlock()
+	b.w, b.private, b.failed = nil, false, nil
+	bufPool.Put(b)
+	data := out.Bytes()
+	cp := *l
+	cp.bound = &boundPairs{kvp: all, json: data[1 : len(data)-1]}
+	return &cp
+}
+
+// Returns a copy of 'kvp' with each func() or Valuer value replaced by
+// the value it returns, so each is only called once.
+func calledPairs(kvp AMap) AMap {
+	cp := &KVPairs{
+		keys: append([]string(nil), kvp.keys...),
+		vals: make([]interface{}, len(kvp.vals)),
+	}
+	for i, v := range kvp.vals {
+		switch f := v.(type) {
+		case func() interface{}:
+			v = f()
+		case Valuer:
+			v = f.LagerValue()
+		}
+		cp.vals[i] = v
+	}
+	return cp
+}
+
+// Returns the context pairs of the logger, including any bound via
+// WithPairs().
+func (l *logger) ctxPairs() AMap {
+	if nil == l.bound {
+		return l.kvp
+	}
+	return l.kvp.Merge(l.bound.kvp)
+}
+
+// Returns whether the logger has any context pairs to log.
+func (l *logger) hasCtxPairs() bool {
+	return nil != l.bound || nil != l.g.globalPairs ||
+		nil != l.g.hostPairs || nil != l.kvp && 0 < len(l.kvp.keys)
+}
+
+// Writes the context pairs as part of the end of a log line.
+func (l *logger) writeCtxPairs(b *buffer) {
+	if nil == l.bound {
+		if nil == l.g.keys {
+			b.scalar(l.kvp)
+		} else if "" == l.g.keys.ctx {
+			b.pairs(l.kvp)
+		} else {
+			b.pair(l.g.keys.ctx, l.kvp)
+		}
+		return
+	}
+	if nil != l.g.keys && "" == l.g.keys.ctx {
+		l.writeBound(b)
+		return
+	}
+	if nil != l.g.keys {
+		b.key(l.g.keys.ctx)
+	}
+	b.open("{")
+	l.writeBound(b)
+	b.close("}")
+}
+
+// Writes the context pairs (other than those replaced by bound pairs)
+// followed by the bound pairs.
+func (l *logger) writeBound(b *buffer) {
+	if nil != l.kvp {
+	pairs:
+		for i, k := range l.kvp.keys {
+			for _, bk := range l.bound.kvp.keys {
+				if bk == k {
+					continue pairs
+				}
+			}
+			b.pair(k, l.kvp.vals[i])
+		}
+	}
+	n := len(l.bound.kvp.keys)
+	b.preEncoded(l.bound.json, n, func() { b.pairs(l.bound.kvp) })
+}
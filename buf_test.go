@@ -5,7 +5,9 @@ import (
 	"context"
 	"io"
 	"os"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/Unity-Technologies/go-tutl-internal"
 )
@@ -17,86 +19,51 @@ func TestEscape(t *testing.T) {
 	b.g = getGlobals()
 	out := &bytes.Buffer{}
 	b.w = out
+	esc1 := func(r rune) string { return string(appendEscapedRune(nil, r)) }
+	esc := func(s string) string { return string(appendEscaped(nil, s)) }
+	quoted := func(v interface{}) string {
+		b.scalar(v)
+		defer func() { b.json.out = b.json.out[0:0] }()
+		return string(b.json.out)
+	}
 
-	b.escape1Rune('"')
-	u.Is(`\"`, b.buf, `esc1 "`)
-	b.buf = b.buf[0:0]
-	b.escape1Rune('\\')
-	u.Is(`\\`, b.buf, `esc1 \`)
-	b.buf = b.buf[0:0]
-	b.escape1Rune('\b')
-	u.Is(`\b`, b.buf, `esc1 \b`)
-	b.buf = b.buf[0:0]
-	b.escape1Rune('\f')
-	u.Is(`\f`, b.buf, `esc1 \f`)
-	b.buf = b.buf[0:0]
-	b.escape1Rune('\n')
-	u.Is(`\n`, b.buf, `esc1 \n`)
-	b.buf = b.buf[0:0]
-	b.escape1Rune('\r')
-	u.Is(`\r`, b.buf, `esc1 \r`)
-	b.buf = b.buf[0:0]
-	b.escape1Rune('\t')
-	u.Is(`\t`, b.buf, `esc1 \t`)
-	b.buf = b.buf[0:0]
-	b.escape1Rune('\x00')
-	u.Is("\\u0000", b.buf, `esc1 \x00`)
-	b.buf = b.buf[0:0]
-	b.escape1Rune('\x01')
-	u.Is("\\u0001", b.buf, `esc1 \x01`)
-	b.buf = b.buf[0:0]
-	b.escape1Rune(0xF234)
-	u.Is("\\uF234", b.buf, `esc1 0xF234`)
-	b.buf = b.buf[0:0]
-
-	b.escape("\x01")
-	u.Is(`\u0001`, b.buf, `s:\x01`)
-	b.buf = b.buf[0:0]
-	b.escapeBytes([]byte{'\x01'})
-	u.Is(`\u0001`, b.buf, `b:\x01`)
-	b.buf = b.buf[0:0]
-
-	b.escape("\u009e")
-	u.Is(`\u009E`, b.buf, `s:\u009e`)
-	b.buf = b.buf[0:0]
-	b.escapeBytes([]byte("\u009e"))
-	u.Is(`\u009E`, b.buf, `b:\u009e`)
-	b.buf = b.buf[0:0]
+	u.Is(`\"`, esc1('"'), `esc1 "`)
+	u.Is(`\\`, esc1('\\'), `esc1 \`)
+	u.Is(`\b`, esc1('\b'), `esc1 \b`)
+	u.Is(`\f`, esc1('\f'), `esc1 \f`)
+	u.Is(`\n`, esc1('\n'), `esc1 \n`)
+	u.Is(`\r`, esc1('\r'), `esc1 \r`)
+	u.Is(`\t`, esc1('\t'), `esc1 \t`)
+	u.Is("\\u0000", esc1('\x00'), `esc1 \x00`)
+	u.Is("\\u0001", esc1('\x01'), `esc1 \x01`)
+	u.Is("\\uF234", esc1(0xF234), `esc1 0xF234`)
+
+	u.Is(`\u0001`, esc("\x01"), `s:\x01`)
+	u.Is(`"\u0001"`, quoted([]byte{'\x01'}), `b:\x01`)
+
+	u.Is(`\u009E`, esc("\u009e"), `s:\u009e`)
+	u.Is(`"\u009E"`, quoted([]byte("\u009e")), `b:\u009e`)
 
 	chess := "\U0001FA52\U0001FA01"
-	b.escape(chess)
-	u.Is(`\uD83E\uDE52\uD83E\uDE01`, b.buf, "s:chess")
-	b.buf = b.buf[0:0]
-	b.escapeBytes([]byte(chess))
-	u.Is(`\uD83E\uDE52\uD83E\uDE01`, b.buf, "b:chess")
-	b.buf = b.buf[0:0]
-
-	b.escape("\x01 \x9A")
-	u.Is(`\u0001 «x9A»`, b.buf, `s:\x01 \x9A`)
-	b.buf = b.buf[0:0]
-	b.escapeBytes([]byte("\x01 \x9A"))
-	u.Is(`\u0001 «x9A»`, b.buf, `b:\x01 \x9A`)
-	b.buf = b.buf[0:0]
-
-	b.escape("\x01 \"\x9A\xBC\" «»")
-	u.Is(`\u0001 \"«x9ABC»\" «»`, b.buf, `s:\x01 "\x9A\xBC" «»`)
-	b.buf = b.buf[0:0]
-	b.escapeBytes([]byte("\x01 \"\x9A\xBC\" «»"))
-	u.Is(`\u0001 \"«x9ABC»\" «»`, b.buf, `b:\x01 "\x9A\xBC" «»`)
-	b.buf = b.buf[0:0]
-
-	b.int(10, 4)
-	u.Is("0010", b.buf, "int(10,4)")
-	b.buf = b.buf[0:0]
-
-	b.scalar(nLevels)
-	u.Is(`"11"`, b.buf, "nLevels goes to 11")
-	b.buf = b.buf[0:0]
+	u.Is(`\uD83E\uDE52\uD83E\uDE01`, esc(chess), "s:chess")
+	u.Is(`"\uD83E\uDE52\uD83E\uDE01"`, quoted([]byte(chess)), "b:chess")
+
+	u.Is(`\u0001 «x9A»`, esc("\x01 \x9A"), `s:\x01 \x9A`)
+	u.Is(`"\u0001 «x9A»"`, quoted([]byte("\x01 \x9A")), `b:\x01 \x9A`)
+
+	u.Is(`\u0001 \"«x9ABC»\" «»`, esc("\x01 \"\x9A\xBC\" «»"),
+		`s:\x01 "\x9A\xBC" «»`)
+	u.Is(`"\u0001 \"«x9ABC»\" «»"`, quoted([]byte("\x01 \"\x9A\xBC\" «»")),
+		`b:\x01 "\x9A\xBC" «»`)
+
+	u.Is("0010", string(appendDigits(nil, 10, 4)), "appendDigits(10,4)")
+
+	u.Is(`"11"`, quoted(nLevels), "nLevels goes to 11")
 
 	b.w = io.Discard
-	b.buf = b.buf[0 : 16*1024-10]
+	b.json.out = b.json.out[0 : 16*1024-10]
 	b.scalar(1.0 / 3.0)
-	u.Like(b.buf, "b.scalar() lock works", "^0[.]3+$")
+	u.Like(b.json.out, "b.scalar() lock works", "^0[.]3+$")
 	b.unlock()
 
 	u.Like(
@@ -115,9 +82,24 @@ func TestInit(t *testing.T) {
 	defer os.Unsetenv("LAGER_LEVELS")
 	defer os.Unsetenv("LAGER_KEYS")
 	defer os.Unsetenv("LAGER_GCP")
+	defer os.Unsetenv("LAGER_LEVEL_RULES")
+	defer os.Unsetenv("LAGER_SPLIT_STDERR")
+	defer os.Unsetenv("LAGER_MUTE")
+	defer os.Unsetenv("LAGER_MIRROR_STDERR")
+	defer os.Unsetenv("LAGER_CONSOLE_MODULES")
+	defer os.Unsetenv("LAGER_MAP_KEYS")
+	defer os.Unsetenv("LAGER_CONSOLE")
+	defer os.Unsetenv("LAGER_LOGFMT")
 	os.Setenv("LAGER_LEVELS", "Fail Wait Note Acc Trace Obj")
+	os.Setenv("LAGER_LEVEL_RULES", "F>I:context canceled")
 	os.Setenv("LAGER_KEYS", "time,sev,msg,data,,mod")
 	os.Setenv("LAGER_GCP", "1")
+	os.Setenv("LAGER_SPLIT_STDERR", "1")
+	os.Setenv("LAGER_MUTE", "1")
+	os.Setenv("LAGER_MIRROR_STDERR", "PEF")
+	os.Setenv("LAGER_CONSOLE_MODULES", "only:api,db")
+	os.Setenv("LAGER_MAP_KEYS", "strings")
+	os.Setenv("LAGER_CONSOLE", "color")
 	firstInit()
 	defer SetOutput(log)()
 
@@ -130,6 +112,145 @@ func TestInit(t *testing.T) {
 	u.Is("", g.keys.ctx, "ctx key")
 	u.Is("mod", g.keys.mod, "mod key")
 	u.Is(true, g.inGcp, "inGcp")
+	u.Is(1, len(g.levRules), "level rules")
+	g.levRules = nil
+	u.Is(os.Stderr, g.levDest[int(lWarn)], "split stderr warn")
+	u.Is(nil, g.levDest[int(lNote)], "split stderr note")
+	os.Unsetenv("LAGER_LEVEL_RULES")
+	u.Is(true, Muted(), "LAGER_MUTE")
+	u.Is(true, g.mirror[int(lFail)], "LAGER_MIRROR_STDERR fail")
+	u.Is(false, g.mirror[int(lWarn)], "LAGER_MIRROR_STDERR warn")
+	os.Unsetenv("LAGER_MIRROR_STDERR")
+	u.Is(false, g.conMods.hides("db"), "LAGER_CONSOLE_MODULES db")
+	u.Is(true, g.conMods.hides("web"), "LAGER_CONSOLE_MODULES web")
+	os.Unsetenv("LAGER_CONSOLE_MODULES")
+	u.Is(MapKeysAsStrings, g.mapKeys, "LAGER_MAP_KEYS")
+	os.Unsetenv("LAGER_MAP_KEYS")
+	u.Is(true, nil != g.format && g.format.color, "LAGER_CONSOLE")
+	os.Unsetenv("LAGER_CONSOLE")
+	os.Setenv("LAGER_LOGFMT", "1")
+	envLogfmt(g)
+	u.Is(true, g.format.logfmt, "LAGER_LOGFMT")
+	os.Unsetenv("LAGER_LOGFMT")
+	os.Setenv("LAGER_SEVERITY_NUMBERS", "otel,sevnum")
+	envSeverityNumbers(g)
+	u.Is(13, g.sevNum("WARN"), "LAGER_SEVERITY_NUMBERS mapper")
+	u.Is("sevnum", g.sevKey, "LAGER_SEVERITY_NUMBERS key")
+	os.Unsetenv("LAGER_SEVERITY_NUMBERS")
+	g.sevNum, g.sevKey = nil, ""
+	os.Setenv("LAGER_FLAT_JSON", "time=@t,_file=")
+	envFlatJSON(g)
+	u.Is("@t", g.format.flat["time"], "LAGER_FLAT_JSON rename")
+	u.Is(true, "" == g.format.flat["_file"], "LAGER_FLAT_JSON omit")
+	os.Unsetenv("LAGER_FLAT_JSON")
+	os.Setenv("LAGER_SYSLOG", "16,billing")
+	envSyslog(g)
+	u.Is(16, g.format.syslog.facility, "LAGER_SYSLOG facility")
+	u.Is("billing", g.format.syslog.app, "LAGER_SYSLOG app")
+	u.Is("lager@32473", g.format.syslog.sdID, "LAGER_SYSLOG sdID")
+	os.Unsetenv("LAGER_SYSLOG")
+	os.Setenv("LAGER_LEVELS", "FW, store/*=Debug")
+	os.Setenv("LAGER_MODULE_LEVELS", "grpc=Warn")
+	envModTree(g)
+	levels, _ := g.modTree.levels("store/blob")
+	u.Is("FWNAITD", levels, "LAGER_LEVELS module rule")
+	levels, _ = g.modTree.levels("grpc")
+	u.Is("FW", levels, "LAGER_MODULE_LEVELS rule")
+	levels, _ = splitLevels(os.Getenv("LAGER_LEVELS"))
+	u.Is("FW", levels, "LAGER_LEVELS global part")
+	os.Unsetenv("LAGER_MODULE_LEVELS")
+	g.modTree = nil
+	os.Setenv("LAGER_SAMPLING", "TD=100, I=10, F=5")
+	envSampling(g)
+	u.Is(uint32(100), g.sample[int(lDebug)], "LAGER_SAMPLING debug")
+	u.Is(uint32(10), g.sample[int(lInfo)], "LAGER_SAMPLING info")
+	u.Is(uint32(0), g.sample[int(lFail)], "LAGER_SAMPLING never fail")
+	os.Unsetenv("LAGER_SAMPLING")
+	g.sample = [int(nLevels)]uint32{}
+	os.Setenv("LAGER_DEDUP_WINDOW", "5s")
+	envDedupWindow(g)
+	u.Is(5*time.Second, g.dedup.window, "LAGER_DEDUP_WINDOW")
+	os.Unsetenv("LAGER_DEDUP_WINDOW")
+	g.dedup = nil
+	os.Setenv("LAGER_FLIGHT_RECORDER", "TD,50")
+	envFlightRecorder(g)
+	u.Is(true, g.flight.levels[int(lDebug)], "LAGER_FLIGHT_RECORDER debug")
+	u.Is(false, g.flight.levels[int(lInfo)], "LAGER_FLIGHT_RECORDER info")
+	u.Is(50, cap(g.flight.held), "LAGER_FLIGHT_RECORDER size")
+	os.Unsetenv("LAGER_FLIGHT_RECORDER")
+	g.flight = nil
+	os.Setenv("LAGER_LEVEL_FLOOR", "W")
+	envLevelFloor(g)
+	u.Is(int32(lWarn), atomic.LoadInt32(&_floor), "LAGER_LEVEL_FLOOR")
+	os.Unsetenv("LAGER_LEVEL_FLOOR")
+	atomic.StoreInt32(&_floor, 0)
+	os.Setenv("LAGER_MAX_LINE_SIZE", "65536")
+	envMaxLineSize(g)
+	u.Is(65536, g.maxSize, "LAGER_MAX_LINE_SIZE")
+	os.Unsetenv("LAGER_MAX_LINE_SIZE")
+	g.maxSize = 0
+	os.Setenv("LAGER_SEQ", "0.3.1:1200")
+	envSequence(g)
+	u.Is("0.3.1", g.seq.proc, "LAGER_SEQ proc")
+	u.Is(uint64(1200), g.seq.next, "LAGER_SEQ start")
+	os.Unsetenv("LAGER_SEQ")
+	g.seq = nil
+	os.Setenv("LAGER_BILLING_LABELS", "team=payments, cost-center=cc-1")
+	envBillingLabels(g)
+	u.Is("payments", g.billing.Get("team"), "LAGER_BILLING_LABELS team")
+	u.Is("cc-1", g.billing.Get("cost-center"), "LAGER_BILLING_LABELS cc")
+	os.Unsetenv("LAGER_BILLING_LABELS")
+	g.billing = nil
+	os.Setenv("LAGER_LEVEL_RETENTION", "PEFW=1y, DOG=7d")
+	envLevelRetention(g)
+	u.Is("1y", g.retention[int(lWarn)], "LAGER_LEVEL_RETENTION W")
+	u.Is("7d", g.retention[int(lDebug)], "LAGER_LEVEL_RETENTION D")
+	u.Is("", g.retention[int(lInfo)], "LAGER_LEVEL_RETENTION I")
+	os.Unsetenv("LAGER_LEVEL_RETENTION")
+	g.retention = [int(nLevels)]string{}
+	os.Setenv("LAGER_EPOCH_TIMESTAMP", "ts")
+	envEpochTimestamp(g)
+	u.Is("ts", g.epochKey, "LAGER_EPOCH_TIMESTAMP key")
+	u.Is(false, g.epochOnly, "LAGER_EPOCH_TIMESTAMP alongside")
+	os.Setenv("LAGER_EPOCH_TIMESTAMP", "only")
+	envEpochTimestamp(g)
+	u.Is("ts_epoch_ms", g.epochKey, "LAGER_EPOCH_TIMESTAMP=only key")
+	u.Is(true, g.epochOnly, "LAGER_EPOCH_TIMESTAMP=only")
+	os.Unsetenv("LAGER_EPOCH_TIMESTAMP")
+	g.epochKey, g.epochOnly = "", false
+	os.Setenv("LAGER_GLOBAL_PAIRS", "service=api, env=prod")
+	envGlobalPairs(g)
+	u.Is("api", g.globalPairs.Get("service"), "LAGER_GLOBAL_PAIRS service")
+	u.Is("prod", g.globalPairs.Get("env"), "LAGER_GLOBAL_PAIRS env")
+	os.Unsetenv("LAGER_GLOBAL_PAIRS")
+	g.globalPairs = nil
+	os.Setenv("LAGER_HOST_PAIRS", "1")
+	envHostPairs(g)
+	u.Is(os.Getpid(), g.hostPairs.Get("pid"), "LAGER_HOST_PAIRS pid")
+	os.Unsetenv("LAGER_HOST_PAIRS")
+	g.hostPairs = nil
+	os.Setenv("LAGER_CALLER_LEVELS", "PF")
+	envCallerLevels(g)
+	u.Is(true, g.callerLevels[int(lFail)], "LAGER_CALLER_LEVELS F")
+	u.Is(false, g.callerLevels[int(lWarn)], "LAGER_CALLER_LEVELS W")
+	u.Is("caller", g.callerKey, "LAGER_CALLER_LEVELS key")
+	os.Unsetenv("LAGER_CALLER_LEVELS")
+	g.callerLevels, g.callerKey = [int(nLevels)]bool{}, ""
+	os.Setenv("LAGER_GCP_LATENCY", "object")
+	envGcpLatency(g)
+	u.Is("object", g.gcpLatency, "LAGER_GCP_LATENCY")
+	os.Unsetenv("LAGER_GCP_LATENCY")
+	g.gcpLatency = ""
+	Unmute()
+	os.Unsetenv("LAGER_MUTE")
+	os.Unsetenv("LAGER_SPLIT_STDERR")
+	updateGlobals(func(g *globals) {
+		g.levDest = [int(nLevels)]io.Writer{}
+		g.mirror = [int(nLevels)]bool{}
+		g.conMods = consoleModules{}
+		g.mapKeys = MapKeysAsPairs
+		g.format = nil
+	})
 
 	u.Is(nil, u.GetPanic(func() {
 		defer ExitViaPanic()(func(x *int) { *x = -1 })
@@ -162,3 +283,91 @@ func TestInit(t *testing.T) {
 
 	defer updateGlobals(setRunningInGcp(false))
 }
+
+func TestParseChrony(t *testing.T) {
+	u := tutl.New(t)
+	off, synced, err := parseChrony("A29FC87B,ntp1.example.com,3," +
+		"1623244207.044,-0.250000000,-0.000001,0.000012,-1.234,0.012," +
+		"0.050,0.010,0.020,64.0,Normal\n")
+	u.Is(nil, err, "parse")
+	u.Is(-250*time.Millisecond, off, "offset")
+	u.Is(true, synced, "synced")
+	_, synced, _ = parseChrony(
+		"0,,0,0,0,0,0,0,0,0,0,0,0,Not synchronised")
+	u.Is(false, synced, "not synced")
+	_, _, err = parseChrony("506 Cannot talk to daemon")
+	u.Like(err, "bad output", "*unexpected chronyc output")
+}
+
+func TestClockRegression(t *testing.T) {
+	u := tutl.New(t)
+	Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer SetOutput(out)()
+	defer SetLevels("FWN")()
+
+	start := time.Date(2021, 6, 9, 13, 10, 7, 0, time.UTC)
+	steps := []time.Duration{0, time.Second, -1500 * time.Millisecond}
+	readings := func(correct bool) func() {
+		restore := DetectClockRegression(correct)
+		i := 0
+		getGlobals().clockGuard.clock = func() time.Time {
+			d := steps[len(steps)-1]
+			if i < len(steps) {
+				d = steps[i]
+			}
+			i++
+			return start.Add(d)
+		}
+		return restore
+	}
+
+	restore := readings(false)
+	Warn().List("a")
+	Warn().List("b")
+	u.Like(out.String(), "no regression yet", "!Clock went backwards")
+	out.Reset()
+	Warn().List("c")
+	u.Like(out.String(), "regression noted",
+		`*"2021-06-09 13:10:05.5000Z", "WARN", "c"]`,
+		`*"NOTE", "Clock went backwards",`+
+			` {"delta":"-2.5s", "corrected":false}]`)
+	restore()
+	out.Reset()
+
+	restore = readings(true)
+	defer restore()
+	Warn().List("a")
+	Warn().List("b")
+	out.Reset()
+	Warn().List("c")
+	u.Like(out.String(), "corrected",
+		`*"2021-06-09 13:10:08.0000Z", "WARN", "c"]`,
+		`*"NOTE", "Clock went backwards",`+
+			` {"delta":"-2.5s", "corrected":true}]`)
+	out.Reset()
+	Warn().List("d")
+	u.Like(out.String(), "reported once", "!Clock went backwards",
+		`*"2021-06-09 13:10:08.0000Z", "WARN", "d"]`)
+}
+
+func TestContainerID(t *testing.T) {
+	u := tutl.New(t)
+	id := "3f2a9c81d0e4b5a6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0"
+	u.Is("3f2a9c81d0e4", containerID(
+		"0::/system.slice/docker-"+id+".scope\n"), "systemd docker")
+	u.Is("3f2a9c81d0e4", containerID("12:pids:/kubepods/burstable/"+
+		"pod1b2c-3d4e/"+id+"\n11:cpu:/kubepods\n"), "kubepods")
+	u.Is("", containerID("0::/user.slice/user-1000.slice\n"), "none")
+	u.Is("", containerID("0::/"+id[:63]+"\n"), "too short")
+}
+
+func TestDurationString(t *testing.T) {
+	u := tutl.New(t)
+	u.Is("1s", durationString(time.Second), "whole")
+	u.Is("1.270s", durationString(1270*time.Millisecond), "millis")
+	u.Is("0.000120s", durationString(120*time.Microsecond), "micros")
+	u.Is("2.000000005s", durationString(2*time.Second+5), "nanos")
+	u.Is("-1.500s", durationString(-1500*time.Millisecond), "negative")
+	u.Is("0s", durationString(0), "zero")
+}
@@ -0,0 +1,108 @@
+package lager
+
+import (
+	"os"
+	"runtime"
+	"strings"
+)
+
+// The default key for the call site [see SetCallerLevels()].
+const callerKey = "caller"
+
+// The prefix of the names of functions in this package, like
+// "github.com/Unity-Technologies/go-lager-internal.".
+var _lagerPkg = lagerPkg()
+
+func lagerPkg() string {
+	pc, _, _, _ := runtime.Caller(0)
+	return strings.TrimSuffix(runtime.FuncForPC(pc).Name(), "lagerPkg")
+}
+
+// SetCallerLevels() adds the call site of each log line of the given levels
+// (letters from "PEFWNAITDOG") as a map under 'key' (default "caller"):
+//
+//      defer lager.SetCallerLevels("PEF", "")()
+//
+//      ["2021-06-09 13:10:07.0447Z", "FAIL", "Query failed",
+//          {"caller":{"file":"app/db/query.go", "line":87,
+//          "func":"Lookup"}}]
+//
+// The call site is the first stack frame outside of this package, so it
+// is the line that called List(), MMap(), etc. even when that was called
+// via a helper within Lager [unlike WithCaller(), no depth is needed].
+// The "func" is just the function's name and the "file" keeps the number
+// of path parts set via SetPathParts().  Since finding the call site is
+// relatively expensive, enable it only for levels where it is worth it.
+//
+// Calling SetCallerLevels() replaces the prior levels; "" turns it off.
+// It returns a function that restores the prior setting.  Setting
+// LAGER_CALLER_LEVELS in the environment to a string of level letters
+// has the same effect as calling SetCallerLevels() with those levels when
+// the program starts.
+//
+func SetCallerLevels(levels, key string) func() {
+	if "" == key {
+		key = callerKey
+	}
+	var priorLevels [int(nLevels)]bool
+	var priorKey string
+	updateGlobals(func(g *globals) {
+		priorLevels, priorKey = g.callerLevels, g.callerKey
+		setCallerLevels(levels, key)(g)
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.callerLevels, g.callerKey = priorLevels, priorKey
+		})
+	}
+}
+
+// How the levels that log their call site are updated safely.
+func setCallerLevels(levels, key string) func(*globals) {
+	return func(g *globals) {
+		g.callerLevels = [int(nLevels)]bool{}
+		for _, c := range []byte(levels) {
+			if lev, ok := letterLevel(c); ok {
+				g.callerLevels[int(lev)] = true
+			}
+		}
+		g.callerKey = key
+	}
+}
+
+// Sets the initial levels that log their call site from
+// LAGER_CALLER_LEVELS.
+func envCallerLevels(g *globals) {
+	if levels := os.Getenv("LAGER_CALLER_LEVELS"); "" != levels {
+		setCallerLevels(levels, callerKey)(g)
+	}
+}
+
+// Returns the file, line, and function name of the first stack frame that
+// is not in this package (or a 'line' of 0 if there is none).
+func callSite(pathparts int) (file string, line int, funcname string) {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, _lagerPkg) {
+			return trimFrame(frame, pathparts)
+		} else if !more {
+			return
+		}
+	}
+}
+
+// Returns a copy of the logger with the call site added to its context
+// pairs [see SetCallerLevels()].
+func (l *logger) withCallSite() *logger {
+	file, line, fn := callSite(l.g.pathParts)
+	if 0 == line {
+		return l
+	}
+	cp := *l
+	cp.kvp = cp.kvp.AddPairs(
+		l.g.callerKey, Map("file", file, "line", line, "func", fn))
+	return &cp
+}
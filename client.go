@@ -0,0 +1,401 @@
+package lager
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// An http.RoundTripper that logs each outbound request.
+type clientTransport struct {
+	next     http.RoundTripper
+	bodyMax  int                 // Capture up to this much of error bodies.
+	redactor func(string) string // Applied to captured error bodies.
+}
+
+// A ClientOption changes how ClientTransport() logs requests.
+type ClientOption func(*clientTransport)
+
+// The pattern RedactSecrets() matches.  The key is matched as a JSON key
+// or as a form or query parameter name, or is "Bearer" or "Basic".
+var secretsRegexp = regexp.MustCompile(`(?i)(` +
+	`"[^"]*(?:password|passwd|secret|token|api[-_]?key|authorization|` +
+	`credential)[^"]*"\s*:\s*)("(?:[^"\\]|\\.)*"|[^,}\s]+)` +
+	`|((?:^|[?&\s])[^=&\s]*(?:password|passwd|secret|token|api[-_]?key|` +
+	`credential)[^=&\s]*=)([^&\s]*)` +
+	`|\b((?:Bearer|Basic) +)([-._~+/=a-z0-9]+)`)
+
+// The Context key for the clientTimings added by WithClientTrace().
+type clientTraceKey struct{}
+
+// The times of the phases of one outbound request, recorded via httptrace.
+type clientTimings struct {
+	mu            sync.Mutex
+	ctx           Ctx // If not nil, log milestones at Debug level.
+	start         time.Time
+	dnsStart      time.Time
+	dnsDone       time.Time
+	connStart     time.Time
+	connDone      time.Time
+	tlsStart      time.Time
+	tlsDone       time.Time
+	firstByte     time.Time
+	reused        bool
+	informational []int
+}
+
+// ClientTransport() returns an http.RoundTripper that writes a client
+// "access log" entry [see GcpLogAccess()] for each outbound request that
+// it passes on to 'next' (or to http.DefaultTransport if 'next' is 'nil'):
+//
+//      client := &http.Client{Transport: lager.ClientTransport(nil)}
+//
+// The entry uses the message "Received response" and, in addition to the
+// "httpRequest" block, includes what is needed to understand a slow call:
+//
+//      "timings"       How long each phase took, such as {"dns":"0.0012s",
+//                      "connect":"0.0104s", "tls":"0.0311s", "ttfb":
+//                      "0.2170s"}, omitting phases that did not happen
+//                      (such as when a pooled connection was reused).
+//      "reused"        'true' if a pooled connection was reused.
+//      "informational" The status codes of any 1xx responses received
+//                      before the final response, like [103].
+//      "location"      Where a 3xx response redirects to.
+//      "redirects"     How many redirects led to this request, if any.
+//      "originalUrl"   The URL requested before the first redirect.
+//
+// An http.Client follows redirects by making a new request for each hop,
+// so each hop gets its own entry and the entry for the final URL reports
+// how many redirect hops preceded it.
+//
+// If the request fails, then a Warn entry with the message "Request
+// failed", the "error", and the "error.kind" [see ErrorKind()] is written
+// instead.
+//
+// Pass in options, such as CaptureErrorBody(), to log more.
+//
+func ClientTransport(
+	next http.RoundTripper, opts ...ClientOption,
+) http.RoundTripper {
+	if nil == next {
+		next = http.DefaultTransport
+	}
+	ct := clientTransport{next: next}
+	for _, opt := range opts {
+		opt(&ct)
+	}
+	return ct
+}
+
+// CaptureErrorBody() returns a ClientOption that adds the first 'maxBytes'
+// bytes of the body of each non-2xx response to the entry logged for it,
+// under the key "responseBody", since the error message from the upstream
+// service is often only found there:
+//
+//      client := &http.Client{Transport: lager.ClientTransport(
+//          nil, lager.CaptureErrorBody(512, nil))}
+//
+// Only bodies with a textual Content-Type (text/*, JSON, XML, or form
+// data) are captured.  'redact' is applied to the captured text before it
+// is logged; if it is 'nil', then RedactSecrets() is used.  The captured
+// bytes are still returned when the caller reads the response body.
+//
+func CaptureErrorBody(maxBytes int, redact func(string) string) ClientOption {
+	if nil == redact {
+		redact = RedactSecrets
+	}
+	return func(ct *clientTransport) {
+		ct.bodyMax, ct.redactor = maxBytes, redact
+	}
+}
+
+// RedactSecrets() returns 's' with the likely secrets in it replaced with
+// "[REDACTED]".  It looks for values of JSON keys and of form or query
+// parameters whose names contain "password", "secret", "token", "api_key",
+// "authorization", or "credential" (ignoring case), and for "Bearer" and
+// "Basic" credentials:
+//
+//      {"error":"denied", "token":"[REDACTED]"}
+//      user=kim&password=[REDACTED]
+//      Authorization: Bearer [REDACTED]
+//
+func RedactSecrets(s string) string {
+	return secretsRegexp.ReplaceAllStringFunc(s, func(m string) string {
+		sub := secretsRegexp.FindStringSubmatch(m)
+		for i := 1; i < len(sub); i += 2 {
+			if "" == sub[i] {
+				continue
+			} else if strings.HasPrefix(sub[i+1], `"`) {
+				return sub[i] + `"[REDACTED]"`
+			}
+			return sub[i] + "[REDACTED]"
+		}
+		return m
+	})
+}
+
+func (ct clientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	timings := &clientTimings{start: start}
+	treq := req.WithContext(
+		httptrace.WithClientTrace(req.Context(), timings.clientTrace()))
+	resp, err := ct.next.RoundTrip(treq)
+
+	pairs := timings.pairs()
+	n, orig := 0, req
+	for r := req.Response; nil != r && nil != r.Request; {
+		n++
+		orig = r.Request
+		r = orig.Response
+	}
+	if 0 < n {
+		pairs = append(pairs,
+			"redirects", n, "originalUrl", RequestUrl(orig).String())
+	}
+	if nil != err {
+		ctx := AddPairs(req.Context(),
+			"httpRequest", GcpHttp(req, nil, &start))
+		Warn(ctx).MMap("Request failed", "error", err,
+			"error.kind", ErrorKind(err), InlinePairs, Map(pairs...))
+		return resp, err
+	}
+	if loc, err := resp.Location(); nil == err {
+		pairs = append(pairs, "location", loc.String())
+	}
+	if 0 < ct.bodyMax && (resp.StatusCode < 200 || 300 <= resp.StatusCode) {
+		if body := ct.captureBody(resp); "" != body {
+			pairs = append(pairs, "responseBody", body)
+		}
+	}
+	GcpLogAccess(req, resp, &start).MMap(
+		"Received response", InlinePairs, Map(pairs...))
+	return resp, nil
+}
+
+// WithClientTrace() returns a Context that has an httptrace.ClientTrace
+// installed [via httptrace.WithClientTrace()] for one outbound request made
+// using it.  Each milestone of the request (DNS lookup done, connected, TLS
+// handshake done, and first response byte received) is logged at Debug
+// level with the time "elapsed" since WithClientTrace() was called and any
+// details (such as the address connected to or the error):
+//
+//      ctx = lager.WithClientTrace(ctx)
+//      req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+//      ...
+//      resp, err := client.Do(req)
+//
+// The milestones are also recorded so that they can be summarized as
+// fields on the final client access entry [see ClientTimings()], which is
+// useful when Debug logs are not enabled.  Call WithClientTrace() again
+// for each request so the timings of different requests are not mixed.
+// [ClientTransport() gets the same summary for each request it sends
+// without needing WithClientTrace().]
+//
+func WithClientTrace(ctx Ctx) Ctx {
+	if nil == ctx {
+		ctx = context.Background()
+	}
+	ct := &clientTimings{ctx: ctx, start: time.Now()}
+	ctx = context.WithValue(ctx, clientTraceKey{}, ct)
+	return httptrace.WithClientTrace(ctx, ct.clientTrace())
+}
+
+// ClientTimings() returns the milestones recorded for the request made
+// using 'ctx' [see WithClientTrace()] as an AMap holding the same
+// "timings", "reused", and "informational" pairs that ClientTransport()
+// logs.  It returns 'nil' if WithClientTrace() was not used.  Use it to
+// summarize the milestones on your own access entry:
+//
+//      lager.GcpLogAccess(req, resp, &start).MMap("Received response",
+//          lager.InlinePairs, lager.ClientTimings(ctx))
+//
+func ClientTimings(ctx Ctx) AMap {
+	if nil == ctx {
+		return nil
+	}
+	ct, _ := ctx.Value(clientTraceKey{}).(*clientTimings)
+	if nil == ct {
+		return nil
+	}
+	return AMap(nil).AddPairs(ct.pairs()...)
+}
+
+// Returns the (redacted) start of the response body, if it is textual,
+// while leaving the full body available to be read.
+func (ct clientTransport) captureBody(resp *http.Response) string {
+	if nil == resp.Body || !textualType(resp.Header.Get("Content-Type")) {
+		return ""
+	}
+	buf := make([]byte, ct.bodyMax)
+	n, err := io.ReadFull(resp.Body, buf)
+	buf = buf[:n]
+	rest := resp.Body
+	if nil != err {
+		// Keep any read error (other than reaching the end) for the caller.
+		if io.EOF != err && io.ErrUnexpectedEOF != err {
+			rest = errBody{err: err, Closer: resp.Body}
+		}
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buf), rest), resp.Body}
+	return ct.redactor(string(buf))
+}
+
+// A response body that returns an error once the captured bytes are read.
+type errBody struct {
+	err error
+	io.Closer
+}
+
+func (eb errBody) Read([]byte) (int, error) { return 0, eb.err }
+
+// Whether a Content-Type is for text that is useful to log.
+func textualType(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if nil != err {
+		return false
+	}
+	return strings.HasPrefix(mt, "text/") ||
+		strings.HasSuffix(mt, "/json") || strings.HasSuffix(mt, "+json") ||
+		strings.HasSuffix(mt, "/xml") || strings.HasSuffix(mt, "+xml") ||
+		"application/x-www-form-urlencoded" == mt
+}
+
+// Returns an httptrace.ClientTrace that records the timings.
+func (ct *clientTimings) clientTrace() *httptrace.ClientTrace {
+	mark := func(t *time.Time, first bool, msg string, pairs ...interface{}) {
+		ct.mu.Lock()
+		if !first || t.IsZero() {
+			*t = time.Now()
+		}
+		at := *t
+		ct.mu.Unlock()
+		if nil != ct.ctx && "" != msg {
+			lag := fmt.Sprintf("%.4fs", at.Sub(ct.start).Seconds())
+			Debug(ct.ctx).MMap(msg, append(pairs, "elapsed", lag)...)
+		}
+	}
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { mark(&ct.dnsStart, true, "") },
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			mark(&ct.dnsDone, false, "DNS lookup done",
+				"addrs", fmt.Sprint(info.Addrs),
+				Unless(nil == info.Err, "error"), info.Err)
+		},
+		ConnectStart: func(string, string) {
+			mark(&ct.connStart, true, "")
+		},
+		ConnectDone: func(network, addr string, err error) {
+			mark(&ct.connDone, false, "Connected", "network", network,
+				"addr", addr, Unless(nil == err, "error"), err)
+		},
+		TLSHandshakeStart: func() { mark(&ct.tlsStart, true, "") },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			mark(&ct.tlsDone, false, "TLS handshake done",
+				Unless(nil == err, "error"), err)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			defer AutoLock(&ct.mu)()
+			ct.reused = info.Reused
+		},
+		Got1xxResponse: func(code int, _ textproto.MIMEHeader) error {
+			defer AutoLock(&ct.mu)()
+			ct.informational = append(ct.informational, code)
+			return nil
+		},
+		GotFirstResponseByte: func() {
+			mark(&ct.firstByte, true, "Got first response byte")
+		},
+	}
+}
+
+// Returns the pairs to log for the recorded timings.
+func (ct *clientTimings) pairs() []interface{} {
+	defer AutoLock(&ct.mu)()
+	lag := func(from, to time.Time) string {
+		if from.IsZero() || to.IsZero() {
+			return ""
+		}
+		return fmt.Sprintf("%.4fs", to.Sub(from).Seconds())
+	}
+	dns := lag(ct.dnsStart, ct.dnsDone)
+	conn := lag(ct.connStart, ct.connDone)
+	hand := lag(ct.tlsStart, ct.tlsDone)
+	ttfb := lag(ct.start, ct.firstByte)
+	pairs := []interface{}{"timings", Map(
+		Unless("" == dns, "dns"), dns,
+		Unless("" == conn, "connect"), conn,
+		Unless("" == hand, "tls"), hand,
+		Unless("" == ttfb, "ttfb"), ttfb,
+	)}
+	if ct.reused {
+		pairs = append(pairs, "reused", true)
+	}
+	if 0 < len(ct.informational) {
+		pairs = append(pairs, "informational", ct.informational)
+	}
+	return pairs
+}
+
+// ErrorKind() classifies an error from making an outbound request (or from
+// other network operations) so that dashboards can break down failures by
+// kind without parsing error messages.  It returns one of:
+//
+//      "dns"       The host name could not be resolved.
+//      "refused"   The connection was refused.
+//      "timeout"   A deadline or timeout was exceeded.
+//      "tls"       The TLS handshake or certificate verification failed.
+//      "reset"     The connection was reset or closed by the peer.
+//      "canceled"  The request's Context was canceled.
+//      "other"     Any other (non-nil) error.
+//
+// It returns "" if 'err' is 'nil'.
+//
+func ErrorKind(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var hdrErr tls.RecordHeaderError
+	var authErr x509.UnknownAuthorityError
+	var certErr x509.CertificateInvalidError
+	var hostErr x509.HostnameError
+	switch {
+	case nil == err:
+		return ""
+	case errors.As(err, &dnsErr):
+		if dnsErr.IsTimeout {
+			return "timeout"
+		}
+		return "dns"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.As(err, &hdrErr), errors.As(err, &authErr),
+		errors.As(err, &certErr), errors.As(err, &hostErr),
+		strings.Contains(err.Error(), "tls: "):
+		return "tls"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "reset"
+	}
+	return "other"
+}
@@ -0,0 +1,181 @@
+package lager_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/Unity-Technologies/go-lager-internal"
+	"github.com/Unity-Technologies/go-tutl-internal"
+)
+
+func TestClientTransport(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	lager.Init("FWNA")
+	log := new(bytes.Buffer)
+	defer lager.SetOutput(log)()
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			switch req.URL.Path {
+			case "/old":
+				http.Redirect(w, req, "/new", http.StatusFound)
+			case "/new":
+				w.Header().Set("Link", "</style.css>; rel=preload")
+				w.WriteHeader(http.StatusEarlyHints)
+				w.Write([]byte("hi"))
+			}
+		}))
+	defer srv.Close()
+	client := &http.Client{
+		Transport: lager.ClientTransport(srv.Client().Transport),
+	}
+
+	resp, err := client.Get(srv.URL + "/old?q=1")
+	if !u.Is(nil, err, "get") {
+		return
+	}
+	resp.Body.Close()
+	u.Is(200, resp.StatusCode, "final status")
+	lines := strings.Split(log.String(), "\n")
+	if u.Is(3, len(lines), "log lines") {
+		u.Like(lines[0], "redirect hop", `"ACCESS", "Received response"`,
+			`"status":302`, `"location":"`+srv.URL+`/new"`,
+			`"timings":{"connect":"0[.]`, `"tls":"0[.]`, `"ttfb":"0[.]`)
+		u.Like(lines[0], "first hop", `!"redirects"`, `!"reused"`)
+		u.Like(lines[1], "final hop", `"requestUrl":"`+srv.URL+`/new"`,
+			`"status":200`, `"informational":\[103\]`, `"reused":true`,
+			`*"redirects":1, "originalUrl":"`+srv.URL+`/old?"`)
+		u.Like(lines[1], "pooled connection", `!"connect":`, `!"tls":`)
+	}
+	log.Reset()
+
+	srv.Close()
+	_, err = client.Get(srv.URL + "/gone")
+	u.Like(err, "request error", "*/gone")
+	u.Like(log.String(), "failure logged", `"WARN", "Request failed"`,
+		`"error":"`, `"error.kind":"refused"`, `"status":0`,
+		`"latency":"0[.]`)
+}
+
+func TestErrorKind(t *testing.T) {
+	u := tutl.New(t)
+	op := func(err error) error {
+		return &url.Error{Op: "Get", URL: "http://x", Err: &net.OpError{
+			Op: "dial", Net: "tcp", Err: err}}
+	}
+	u.Is("", lager.ErrorKind(nil), "nil")
+	u.Is("dns", lager.ErrorKind(op(&net.DNSError{Err: "no such host"})),
+		"dns")
+	u.Is("timeout", lager.ErrorKind(
+		op(&net.DNSError{Err: "i/o timeout", IsTimeout: true})), "dns timeout")
+	u.Is("refused", lager.ErrorKind(
+		op(os.NewSyscallError("connect", syscall.ECONNREFUSED))), "refused")
+	u.Is("reset", lager.ErrorKind(
+		op(os.NewSyscallError("read", syscall.ECONNRESET))), "reset")
+	u.Is("reset", lager.ErrorKind(op(io.EOF)), "eof")
+	u.Is("timeout", lager.ErrorKind(op(context.DeadlineExceeded)), "deadline")
+	u.Is("canceled", lager.ErrorKind(op(context.Canceled)), "canceled")
+	u.Is("tls", lager.ErrorKind(op(x509.UnknownAuthorityError{})), "x509")
+	u.Is("tls", lager.ErrorKind(
+		errors.New("remote error: tls: handshake failure")), "tls alert")
+	u.Is("other", lager.ErrorKind(errors.New("oops")), "other")
+}
+
+func TestWithClientTrace(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	defer lager.Init("FWNA")
+	lager.Init("FWNAD")
+	log := new(bytes.Buffer)
+	defer lager.SetOutput(log)()
+
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte("hi"))
+		}))
+	defer srv.Close()
+
+	u.Is(nil, lager.ClientTimings(context.Background()), "no trace")
+	ctx := lager.WithClientTrace(
+		lager.AddPairs(context.Background(), "job", 7))
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if !u.Is(nil, err, "get") {
+		return
+	}
+	resp.Body.Close()
+	u.Like(log.String(), "milestones", `"DEBUG", "Connected"`,
+		`"network":"tcp", "addr":"127.0.0.1:`, `"elapsed":"0[.]`,
+		`"DEBUG", "Got first response byte"`, `"job":7`)
+	u.Like(log.String(), "no TLS", `!TLS handshake`)
+	sum := lager.ClientTimings(ctx).InContext(context.Background())
+	u.Like(lager.MarshalPairs(sum), "summary",
+		`^{"timings":{"connect":"0[.][0-9]+s", "ttfb":"0[.][0-9]+s"}}$`)
+}
+
+func TestCaptureErrorBody(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	lager.Init("FWNA")
+	log := new(bytes.Buffer)
+	defer lager.SetOutput(log)()
+
+	body := `{"error":"quota exceeded", "token":"abc\"123", "n":1}`
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			switch req.URL.Path {
+			case "/json":
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(429)
+				w.Write([]byte(body))
+			case "/png":
+				w.Header().Set("Content-Type", "image/png")
+				w.WriteHeader(500)
+				w.Write([]byte("\x89PNG"))
+			default:
+				w.Header().Set("Content-Type", "text/plain")
+				w.Write([]byte("fine"))
+			}
+		}))
+	defer srv.Close()
+	client := &http.Client{Transport: lager.ClientTransport(
+		nil, lager.CaptureErrorBody(48, nil))}
+
+	get := func(path string) string {
+		resp, err := client.Get(srv.URL + path)
+		if !u.Is(nil, err, "get "+path) {
+			return ""
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return string(b)
+	}
+	u.Is(body, get("/json"), "full body still read")
+	u.Like(log.String(), "snippet logged",
+		`*"responseBody":"{\"error\":\"quota exceeded\", \"token\":`+
+			`\"[REDACTED]\", `)
+	u.Like(log.String(), "secret not logged", `!abc`)
+	log.Reset()
+	u.Is("\x89PNG", get("/png"), "binary body")
+	u.Is("fine", get("/ok"), "ok body")
+	u.Like(log.String(), "not captured", `!responseBody`)
+
+	u.Is(`user=kim&password=[REDACTED]&x=1`,
+		lager.RedactSecrets("user=kim&password=hunter2&x=1"), "form")
+	u.Is(`Authorization: Bearer [REDACTED]`,
+		lager.RedactSecrets("Authorization: Bearer eyJhbGc.x-y"), "bearer")
+	u.Is(`{"api_key":"[REDACTED]", "ok":true}`,
+		lager.RedactSecrets(`{"api_key":"k1", "ok":true}`), "json")
+}
@@ -0,0 +1,210 @@
+package lager
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Set while the clock is believed to not be synchronized.
+var _clockUnsynced int32
+
+// ClockCheck reports the host clock's synchronization status: how far off
+// the clock is believed to be and whether it is currently synchronized
+// (such as via NTP).  See WatchClock().
+type ClockCheck func() (offset time.Duration, synced bool, err error)
+
+// WatchClock() checks the host clock's synchronization status when called
+// and then every 'every' (default 5 minutes), so that log timestamps that
+// cannot be trusted are flagged.  When 'check' reports that the clock is
+// not synchronized or that its offset is larger than 'threshold' (in
+// either direction), a Note line is logged:
+//
+//      ["2021-06-09 13:10:07.0447Z", "NOTE", "Clock is not synchronized",
+//          {"offset":"-2.5s", "synced":true, "threshold":"1s"}]
+//
+// and, until a later check finds the clock is fine again, every log line
+// gets a "clock_unsynced":true pair.  A Note "Clock is synchronized" line
+// is logged once the clock is fine again.  A failed check is logged as a
+// Warn line and does not change whether lines are flagged.
+//
+// ChronyClock() and TimedatectlClock() are provided as checks, but you can
+// pass in your own (such as one that compares with a trusted time server).
+// It returns a function that stops the periodic checks (waiting for any
+// check in progress to finish) and stops flagging log lines:
+//
+//      defer lager.WatchClock(lager.ChronyClock, time.Second, 0)()
+//
+func WatchClock(check ClockCheck, threshold, every time.Duration) func() {
+	if every <= 0 {
+		every = 5 * time.Minute
+	}
+	checkClock(check, threshold)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tick := time.NewTicker(every)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				checkClock(check, threshold)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		atomic.StoreInt32(&_clockUnsynced, 0)
+	}
+}
+
+// Runs one clock check and logs about any change in status.
+func checkClock(check ClockCheck, threshold time.Duration) {
+	offset, synced, err := check()
+	if nil != err {
+		Warn().MMap("Could not check clock synchronization", "error", err)
+		return
+	}
+	bad := !synced || threshold < offset || offset < -threshold
+	was := 0 != atomic.LoadInt32(&_clockUnsynced)
+	if bad && !was {
+		Note().MMap("Clock is not synchronized", "offset", offset,
+			"synced", synced, "threshold", threshold)
+		atomic.StoreInt32(&_clockUnsynced, 1)
+	} else if !bad && was {
+		atomic.StoreInt32(&_clockUnsynced, 0)
+		Note().MMap("Clock is synchronized", "offset", offset)
+	}
+}
+
+// Returns a copy of the logger that adds "clock_unsynced":true.
+func (l *logger) withClockUnsynced() *logger {
+	cp := *l
+	cp.kvp = cp.kvp.AddPairs("clock_unsynced", true)
+	return &cp
+}
+
+// DetectClockRegression() makes each log line check whether the wall clock
+// has gone backwards since the prior line (such as after a VM migration or
+// an NTP step), since out-of-order timestamps can break assumptions made
+// by log processors.  After a line whose timestamp is earlier than that of
+// the prior line, a Note line is logged with how far the clock went back:
+//
+//      ["2021-06-09 13:10:07.0447Z", "NOTE", "Clock went backwards",
+//          {"delta":"-2.5s", "corrected":true}]
+//
+// If 'correct' is 'true', then timestamps are also kept from going
+// backwards: until the wall clock catches up, each timestamp is that of
+// the last line before the regression plus the time elapsed since then
+// (per the monotonic clock).  It returns a function that restores the
+// prior setting:
+//
+//      defer lager.DetectClockRegression(true)()
+//
+func DetectClockRegression(correct bool) func() {
+	guard := &clockGuard{correct: correct, clock: time.Now}
+	var prior *clockGuard
+	updateGlobals(func(g *globals) {
+		prior = g.clockGuard
+		g.clockGuard = guard
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.clockGuard = prior
+		})
+	}
+}
+
+// Tracks log timestamps to detect [and correct] the wall clock going
+// backwards.  See DetectClockRegression().
+type clockGuard struct {
+	correct bool
+	clock   func() time.Time
+	mu      sync.Mutex
+	prior   time.Time     // Clock reading for the prior line.
+	last    time.Time     // Timestamp of the prior line.
+	back    time.Duration // Largest regression not yet reported.
+}
+
+// Returns the timestamp to use for a log line.
+func (c *clockGuard) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock()
+	if !c.prior.IsZero() {
+		back := time.Duration(c.prior.UnixNano() - now.UnixNano())
+		if c.back < back {
+			c.back = back
+		}
+	}
+	c.prior = now
+	if c.correct && !c.last.IsZero() && now.UnixNano() < c.last.UnixNano() {
+		// Uses the monotonic clock readings, if both have them:
+		if d := now.Sub(c.last); 0 < d {
+			now = c.last.Add(d)
+		} else {
+			now = c.last
+		}
+	}
+	c.last = now
+	return now
+}
+
+// Logs about any regression of the clock that has not yet been reported.
+func (c *clockGuard) report() {
+	c.mu.Lock()
+	back := c.back
+	c.back = 0
+	c.mu.Unlock()
+	if 0 < back {
+		Note().MMap("Clock went backwards",
+			"delta", -back, "corrected", c.correct)
+	}
+}
+
+// ChronyClock() is a ClockCheck that runs "chronyc -c tracking" to get the
+// status from the chrony NTP daemon.
+//
+func ChronyClock() (time.Duration, bool, error) {
+	out, err := exec.Command("chronyc", "-c", "tracking").Output()
+	if nil != err {
+		return 0, false, fmt.Errorf("chronyc failed: %w", err)
+	}
+	return parseChrony(string(out))
+}
+
+// Parses the CSV output of "chronyc -c tracking", where the 5th field is
+// how far the system time is from NTP time (in seconds) and the last field
+// is the leap status.
+func parseChrony(out string) (time.Duration, bool, error) {
+	fields := strings.Split(strings.TrimSpace(out), ",")
+	if len(fields) < 14 {
+		return 0, false, fmt.Errorf("unexpected chronyc output: %q", out)
+	}
+	secs, err := strconv.ParseFloat(fields[4], 64)
+	if nil != err {
+		return 0, false, fmt.Errorf("bad chronyc offset: %w", err)
+	}
+	synced := "Not synchronised" != fields[len(fields)-1]
+	return time.Duration(secs * float64(time.Second)), synced, nil
+}
+
+// TimedatectlClock() is a ClockCheck that runs "timedatectl show" to ask
+// systemd whether the clock is synchronized.  It does not report an offset.
+//
+func TimedatectlClock() (time.Duration, bool, error) {
+	out, err := exec.Command(
+		"timedatectl", "show", "-p", "NTPSynchronized", "--value").Output()
+	if nil != err {
+		return 0, false, fmt.Errorf("timedatectl failed: %w", err)
+	}
+	return 0, "yes" == strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,68 @@
+package lager
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// How many writes can be waiting to be coalesced.
+const coalesceQueueLen = 1024
+
+// Remembers which *os.Files are pipes or sockets.
+var _streamFiles sync.Map
+
+// SetCoalescing() enables (or, if 'maxDelay' is 0, disables) coalescing of
+// writes to outputs that are pipes or sockets (an *os.File that is a pipe
+// or Unix socket, such as when stdout is piped to a log shipper, or any
+// net.Conn).  Log lines going to such an output are held for up to
+// 'maxDelay' (or until 64KiB are waiting) and then written together using a
+// single Write() call, or a single writev() for a net.Conn.  This greatly
+// reduces the number of system calls when logging heavily.  Other outputs
+// (regular files and terminals) are still written immediately.
+//
+// Like with SetAsync() (which takes precedence and already coalesces writes
+// to any output), Flush() waits for held lines to be written, as do Panic and
+// Exit log lines.  It returns a function that restores the prior setting:
+//
+//      defer lager.SetCoalescing(20*time.Millisecond)()
+//
+func SetCoalescing(maxDelay time.Duration) func() {
+	var c *asyncOutput
+	if 0 < maxDelay {
+		c = newAsyncOutput(coalesceQueueLen, maxDelay)
+	}
+	var prior *asyncOutput
+	updateGlobals(func(g *globals) {
+		prior = g.coalesce
+		g.coalesce = c
+	})
+	prior.stop()
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.coalesce = prior
+		})
+		c.stop()
+	}
+}
+
+// Returns whether 'w' is a pipe or a socket.
+func isStream(w io.Writer) bool {
+	switch x := w.(type) {
+	case net.Conn:
+		return true
+	case *os.File:
+		if is, ok := _streamFiles.Load(x); ok {
+			return is.(bool)
+		}
+		is := false
+		if fi, err := x.Stat(); nil == err {
+			is = 0 != fi.Mode()&(os.ModeNamedPipe|os.ModeSocket)
+		}
+		_streamFiles.Store(x, is)
+		return is
+	}
+	return false
+}
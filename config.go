@@ -0,0 +1,196 @@
+package lager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How often WatchConfig() checks the config file for changes.
+var configPollInterval = 10 * time.Second
+
+// The contents of a config file for WatchConfig().  A 'nil' field leaves
+// that part of the configuration unchanged.
+type configDoc struct {
+	Levels   *string           `json:"levels"`
+	Modules  map[string]string `json:"modules"`
+	Keys     *[]string         `json:"keys"`
+	Sampling map[string]int    `json:"sampling"`
+}
+
+// WatchConfig() reads a small JSON config file and applies it, then
+// checks the file every 10 seconds and re-applies it whenever its contents
+// change.  This lets log levels be changed without restarting the process,
+// such as via a Kubernetes ConfigMap mounted as a file.  For example:
+//
+//      {
+//          "levels": "FWNAI",
+//          "modules": {"db": "FWNAITD", "grpc*": "Warn"},
+//          "keys": ["time", "level", "msg", "data", "", "module"],
+//          "sampling": {"TD": 100, "I": 10}
+//      }
+//
+// "levels" is as for SetLevels().  "modules" gives module level rules as
+// for SetModuleTreeLevels() (replacing any prior rules).  "keys" gives the
+// 6 keys to pass to Keys() (or [] to log JSON lists).  "sampling" maps
+// levels to sampling rates as for SetSampling(), replacing any prior
+// sampling (so {} turns off sampling).  Parts that are left out are not
+// changed.
+//
+// Only JSON is supported.  A file whose name ends in ".yaml" or ".yml", or
+// whose contents are not a JSON object, is rejected with an error that
+// says so (rather than with a confusing JSON syntax error).
+//
+// An error is returned (and nothing is changed) if the file cannot be
+// read or is not valid.  After that, a Note line is logged each time the
+// changed file is applied, while a Warn line is logged (and the prior
+// configuration is kept) if it cannot be read or is not valid.  It returns
+// a function that stops watching the file (which does not undo changes):
+//
+//      stop, err := lager.WatchConfig("/etc/app/logging.json")
+//      if nil != err {
+//          lager.Exit().MMap("Bad logging config", "error", err)
+//      }
+//      defer stop()
+//
+func WatchConfig(path string) (func(), error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		return nil, fmt.Errorf(
+			"log config %s must be JSON (%s files are not supported)",
+			path, ext)
+	}
+	prior, err := os.ReadFile(path)
+	if nil == err {
+		err = applyConfig(path, prior)
+	}
+	if nil != err {
+		return nil, err
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		tick := time.NewTicker(configPollInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				prior = reloadConfig(path, prior)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}, nil
+}
+
+// Re-applies a config file if it changed.  Returns its current contents.
+func reloadConfig(path string, prior []byte) []byte {
+	cur, err := os.ReadFile(path)
+	if nil != err {
+		if nil != prior { // Only log the first failure in a row.
+			Warn().MMap("Could not read log config",
+				"path", path, "error", err)
+		}
+		return nil
+	} else if bytes.Equal(cur, prior) {
+		return prior
+	}
+	if err := applyConfig(path, cur); nil != err {
+		Warn().MMap("Could not reload log config", "error", err)
+	} else {
+		Note().MMap("Reloaded log config", "path", path)
+	}
+	return cur
+}
+
+// Parses and applies the contents of a config file.
+func applyConfig(path string, data []byte) error {
+	if trim := bytes.TrimSpace(data); 0 == len(trim) || '{' != trim[0] {
+		return fmt.Errorf(
+			"invalid log config in %s: must be a JSON object"+
+				" (only JSON is supported)", path)
+	}
+	var doc configDoc
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&doc)
+	var update func()
+	if nil == err {
+		update, err = doc.compile()
+	}
+	if nil != err {
+		return fmt.Errorf("invalid log config in %s: %w", path, err)
+	}
+	update()
+	return nil
+}
+
+// Validates a config document.  Returns a function that applies it.
+func (doc *configDoc) compile() (func(), error) {
+	var keys *keyStrs
+	if nil != doc.Keys {
+		k := *doc.Keys
+		if 0 != len(k) && 6 != len(k) {
+			return nil, fmt.Errorf("\"keys\" needs 6 keys not %d", len(k))
+		} else if 0 != len(k) &&
+			("" == k[0] || "" == k[1] || "" == k[3] || "" == k[5]) {
+			return nil, fmt.Errorf("only keys for msg and ctx can be blank")
+		} else if 6 == len(k) {
+			keys = &keyStrs{
+				when: k[0], lev: k[1], msg: k[2],
+				args: k[3], ctx: k[4], mod: k[5],
+			}
+		}
+	}
+	var sample [int(nLevels)]uint32
+	for levels, n := range doc.Sampling {
+		if n < 1 {
+			return nil, fmt.Errorf(
+				"sampling rate for %q must be positive not %d", levels, n)
+		}
+		var g globals
+		setSampling(levels, n)(&g)
+		for i, rate := range g.sample {
+			if 0 != rate {
+				sample[i] = rate
+			}
+		}
+	}
+	var tree *modTree
+	if nil != doc.Modules {
+		rules := make([]string, 0, len(doc.Modules))
+		for name, levels := range doc.Modules {
+			rules = append(rules, name+"="+levels)
+		}
+		sort.Strings(rules)
+		var err error
+		if tree, err = parseModTree(strings.Join(rules, ",")); nil != err {
+			return nil, err
+		}
+	}
+	return func() {
+		updateGlobals(func(g *globals) {
+			if nil != doc.Levels {
+				setLevels(*doc.Levels)(g)
+			}
+			if nil != doc.Keys {
+				setKeys(keys)(g)
+			}
+			if nil != doc.Sampling {
+				g.sample = sample
+			}
+		})
+		if nil != doc.Modules {
+			applyModTree(tree)
+		}
+	}, nil
+}
@@ -0,0 +1,71 @@
+package lager
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Unity-Technologies/go-tutl-internal"
+)
+
+func TestWatchConfig(t *testing.T) {
+	u := tutl.New(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "log.json")
+	write := func(doc string) {
+		u.Is(nil, os.WriteFile(path, []byte(doc), 0600), "write config")
+	}
+	out := new(bytes.Buffer)
+	defer SetOutput(out)()
+	defer SetLevels(GetLevels())()
+	defer applyModTree(getGlobals().modTree)
+	defer updateGlobals(setKeys(getGlobals().keys))
+	defer func(was time.Duration) { configPollInterval = was }(
+		configPollInterval)
+	configPollInterval = 10 * time.Millisecond
+	mod := NewModule("cfg.db")
+
+	_, err := WatchConfig(filepath.Join(dir, "missing.json"))
+	u.Like(err, "missing", "*no such file")
+	_, err = WatchConfig(filepath.Join(dir, "log.yaml"))
+	u.Like(err, "yaml name", "*must be JSON", "*.yaml files are not")
+	write("levels: FW\n")
+	_, err = WatchConfig(path)
+	u.Like(err, "yaml content", "*must be a JSON object", "*only JSON")
+	write(`{"levels": "FW", "bogus": 1}`)
+	_, err = WatchConfig(path)
+	u.Like(err, "unknown field", `*unknown field "bogus"`)
+	write(`{"keys": ["time"]}`)
+	_, err = WatchConfig(path)
+	u.Like(err, "bad keys", `*needs 6 keys not 1`)
+	u.Is(nil, getGlobals().keys, "nothing changed")
+
+	write(`{"levels": "FWN", "modules": {"cfg*": "Debug"},
+		"keys": ["t", "lev", "msg", "data", "", "mod"],
+		"sampling": {"TD": 100}}`)
+	stop, err := WatchConfig(path)
+	u.Is(nil, err, "valid config")
+	u.Is("FWN", GetLevels(), "levels")
+	u.Is(`'F''W''N''A''I''T''D'`, GetModuleLevels("cfg.db"), "module")
+	u.Is("lev", getGlobals().keys.lev, "keys")
+	u.Is(uint32(100), getGlobals().sample[int(lDebug)], "sampling")
+
+	write(`{"levels": "FWNAI", "sampling": {}}`)
+	for i := 0; i < 100 && "FWNAI" != GetLevels(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	u.Is("FWNAI", GetLevels(), "reloaded levels")
+	u.Is(uint32(0), getGlobals().sample[int(lDebug)], "sampling off")
+	u.Is("lev", getGlobals().keys.lev, "keys kept")
+	time.Sleep(30 * time.Millisecond)
+
+	write(`{"levels": 7}`)
+	time.Sleep(50 * time.Millisecond)
+	stop()
+	u.Is("FWNAI", GetLevels(), "kept after bad reload")
+	mod.Debug().MMap("Still enabled")
+	u.Like(out.String(), "logged", `*"msg":"Reloaded log config"`,
+		`*"Still enabled"`, `*"msg":"Could not reload log config"`)
+}
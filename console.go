@@ -0,0 +1,253 @@
+package lager
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Which modules have their log lines shown in console format.
+type consoleModules struct {
+	only bool            // If set, just the listed modules are shown.
+	mods map[string]bool // The listed modules.
+}
+
+// The length of the longest module name, used to align the module column.
+var _modWidth int32
+
+// The ANSI colors used for module names.
+var modColors = []int{36, 35, 33, 32, 34, 31, 96, 95, 93, 92, 94, 91}
+
+// The ANSI color sequences used for each level's name.
+var levColors = [int(nLevels)]string{
+	lPanic: "1;31", lExit: "1;31", lFail: "31", lWarn: "33", lNote: "36",
+	lAcc: "32", lInfo: "34", lTrace: "2", lDebug: "2", lObj: "2", lGuts: "2",
+}
+
+// UseConsoleFormat() causes log lines to be written in a single-line format
+// that is easy for humans to read rather than as JSON, which is useful for
+// local debugging.  Each line shows the time of day, the level, the module
+// (if any), the message, and then any key/value pairs:
+//
+//      14:03:27.4817 WARN   db   | Slow query table=users ms=2219
+//
+// Each module's name is shown in an aligned column.  If 'color' is true,
+// then the level is colored based on its severity, the time is dimmed, and
+// each module's name is shown in its own color (which stays the same from
+// run to run).  Set LAGER_CONSOLE_MODULES to limit which modules are shown
+// [see SetConsoleModules()].  It returns a function that restores the prior
+// setting:
+//
+//      defer lager.UseConsoleFormat(true)()
+//
+// Setting LAGER_CONSOLE in the environment has the same effect as calling
+// UseConsoleFormat() when the program starts.  "color" or "plain" select
+// whether to use colors; any other non-empty value (like "1") uses colors
+// only if os.Stdout is a terminal and NO_COLOR is not set.  The "console"
+// format can also be selected via FlagSet().
+//
+func UseConsoleFormat(color bool) func() {
+	var prior *lineFormat
+	updateGlobals(func(g *globals) {
+		prior = g.format
+		g.format = &lineFormat{color: color}
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.format = prior
+		})
+	}
+}
+
+// SetConsoleModules() selects which modules have their log lines shown
+// when console format is in use [see UseConsoleFormat()].  'spec' is
+// "only:" or "hide:" followed by a comma-separated list of module names:
+//
+//      lager.SetConsoleModules("only:api,db")
+//
+// Lines not logged via a Module are always shown, as are Panic and Exit
+// lines.  "" shows all modules.  An error is returned if 'spec' is not
+// valid (and the setting is not changed).  Setting LAGER_CONSOLE_MODULES
+// in the environment has the same effect as calling SetConsoleModules()
+// when the program starts.
+//
+func SetConsoleModules(spec string) error {
+	mods, err := parseConsoleModules(spec)
+	if nil != err {
+		return err
+	}
+	updateGlobals(func(g *globals) {
+		g.conMods = mods
+	})
+	return nil
+}
+
+// Parses a LAGER_CONSOLE_MODULES value.
+func parseConsoleModules(spec string) (consoleModules, error) {
+	mods := consoleModules{}
+	if "" == spec {
+		return mods, nil
+	}
+	colon := strings.Index(spec, ":")
+	if colon < 0 {
+		return mods, fmt.Errorf(
+			"console modules must start with only: or hide: (%q)", spec)
+	}
+	switch spec[:colon] {
+	case "only":
+		mods.only = true
+	case "hide":
+	default:
+		return mods, fmt.Errorf(
+			"console modules must start with only: or hide: not %q",
+			spec[:colon+1])
+	}
+	mods.mods = make(map[string]bool)
+	for _, name := range strings.Split(spec[colon+1:], ",") {
+		if name = strings.TrimSpace(name); "" != name {
+			mods.mods[name] = true
+		}
+	}
+	return mods, nil
+}
+
+// Returns whether lines from the named module should not be shown.
+func (cm consoleModules) hides(mod string) bool {
+	if nil == cm.mods || "" == mod {
+		return false
+	}
+	return cm.only != cm.mods[mod]
+}
+
+// Records the length of a module name so the module column can fit it.
+func noteModWidth(name string) {
+	for {
+		w := atomic.LoadInt32(&_modWidth)
+		if int32(len(name)) <= w ||
+			atomic.CompareAndSwapInt32(&_modWidth, w, int32(len(name))) {
+			return
+		}
+	}
+}
+
+// Formats a decoded log line in console format.
+func (fe *formatEncoder) console(tl textLine) []byte {
+	when, lev, rest, pairs := tl.when, tl.lev, tl.rest, tl.pairs
+	out := make([]byte, 0, 256)
+	if i := strings.IndexAny(when, " T"); 0 <= i {
+		when = when[i+1:]
+	}
+	color := nil != fe.g.format && fe.g.format.color
+	when = strings.TrimSuffix(when, "Z")
+	pad := ""
+	if len(lev) < 6 {
+		pad = strings.Repeat(" ", 6-len(lev))
+	}
+	if color {
+		when = "\x1b[2m" + when + "\x1b[0m"
+		lev = "\x1b[" + levColors[int(fe.lev)] + "m" + lev + "\x1b[0m"
+	}
+	lev += pad
+	out = append(out, when...)
+	out = append(out, ' ')
+	out = append(out, lev...)
+	out = append(out, ' ')
+	if width := int(atomic.LoadInt32(&_modWidth)); 0 < width ||
+		"" != fe.mod {
+		out = fe.appendModule(out, width, color)
+		out = append(out, " | "...)
+	}
+	for i, elt := range rest {
+		if 0 < i {
+			out = append(out, ' ')
+		}
+		if s, ok := elt.(string); ok {
+			out = append(out, s...)
+		} else {
+			out = append(out, consoleValue(elt)...)
+		}
+	}
+	for _, kvp := range pairs {
+		for i, k := range kvp.keys {
+			if 0 < len(out) && ' ' != out[len(out)-1] {
+				out = append(out, ' ')
+			}
+			out = append(out, k...)
+			out = append(out, '=')
+			out = append(out, consoleValue(kvp.vals[i])...)
+		}
+	}
+	return append(out, '\n')
+}
+
+// Appends the (possibly colored) module name, padded to 'width'.
+func (fe *formatEncoder) appendModule(
+	out []byte, width int, color bool,
+) []byte {
+	pad := width - len(fe.mod)
+	if color && "" != fe.mod {
+		h := fnv.New32a()
+		h.Write([]byte(fe.mod))
+		color := modColors[int(h.Sum32()%uint32(len(modColors)))]
+		out = append(out, "\x1b["+strconv.Itoa(color)+"m"...)
+		out = append(out, fe.mod...)
+		out = append(out, "\x1b[0m"...)
+	} else {
+		out = append(out, fe.mod...)
+	}
+	for ; 0 < pad; pad-- {
+		out = append(out, ' ')
+	}
+	return out
+}
+
+// Formats a value for a key=value pair in console format.
+func consoleValue(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		if "" == x || strings.ContainsAny(x, " =\"\\") ||
+			strconv.Quote(x) != `"`+x+`"` {
+			return strconv.Quote(x)
+		}
+		return x
+	case nil:
+		return "null"
+	case *KVPairs, AList:
+		return string(encodeJSON(x))
+	}
+	return fmt.Sprint(v)
+}
+
+// Returns whether to use colors when they have not been explicitly chosen.
+func autoColor() bool {
+	if "" != os.Getenv("NO_COLOR") {
+		return false
+	}
+	fi, err := os.Stdout.Stat()
+	return nil == err && 0 != fi.Mode()&os.ModeCharDevice
+}
+
+// Reads LAGER_CONSOLE and LAGER_CONSOLE_MODULES.
+func envConsole(g *globals) {
+	switch os.Getenv("LAGER_CONSOLE") {
+	case "":
+	case "color":
+		g.format = &lineFormat{color: true}
+	case "plain":
+		g.format = &lineFormat{color: false}
+	default:
+		g.format = &lineFormat{color: autoColor()}
+	}
+	spec := os.Getenv("LAGER_CONSOLE_MODULES")
+	if "" == spec {
+		return
+	}
+	mods, err := parseConsoleModules(spec)
+	if nil != err {
+		initFatal(g, "Invalid LAGER_CONSOLE_MODULES", "error", err)
+	}
+	g.conMods = mods
+}
@@ -0,0 +1,209 @@
+package lager
+
+import (
+	"encoding"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// The most items logged from one iterator function.
+const maxIterItems = 1000
+
+var (
+	stringerType = reflect.TypeOf((*Stringer)(nil)).Elem()
+	errorType    = reflect.TypeOf((*error)(nil)).Elem()
+	boolType     = reflect.TypeOf(true)
+)
+
+// MapKeyPolicy selects how maps with keys that json.Marshal() does not
+// support get logged [see SetMapKeyPolicy()].
+type MapKeyPolicy int
+
+const (
+	// Log such maps as a list of [key, value] pairs, sorted by key.  This is
+	// the default and keeps keys that are structs, etc. intact.
+	MapKeysAsPairs MapKeyPolicy = iota
+
+	// Log such maps as JSON objects, converting each key to a string via
+	// its MarshalText(), Error(), or String() method, or else fmt.Sprint().
+	MapKeysAsStrings
+)
+
+// SetMapKeyPolicy() selects how maps with keys that json.Marshal() does
+// not support (like map[point]bool or map[interface{}]int) get logged.
+// Maps with keys that are strings, integers, or encoding.TextMarshalers
+// are always logged as JSON objects [as by json.Marshal()].  It returns a
+// function that restores the prior policy:
+//
+//      defer lager.SetMapKeyPolicy(lager.MapKeysAsStrings)()
+//
+// Setting LAGER_MAP_KEYS to "strings" (or "pairs") in the environment has
+// the same effect as calling SetMapKeyPolicy() when the program starts.
+//
+func SetMapKeyPolicy(policy MapKeyPolicy) func() {
+	var prior MapKeyPolicy
+	updateGlobals(func(g *globals) {
+		prior = g.mapKeys
+		g.mapKeys = policy
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.mapKeys = prior
+		})
+	}
+}
+
+// Reads LAGER_MAP_KEYS.
+func envMapKeys(g *globals) {
+	switch policy := os.Getenv("LAGER_MAP_KEYS"); policy {
+	case "", "pairs":
+	case "strings":
+		g.mapKeys = MapKeysAsStrings
+	default:
+		initFatal(g,
+			"LAGER_MAP_KEYS must be pairs or strings", "not", policy)
+	}
+}
+
+// Returns the string to use for a map key when using MapKeysAsStrings.
+func keyString(k interface{}) string {
+	switch v := k.(type) {
+	case encoding.TextMarshaler:
+		if text, err := v.MarshalText(); nil == err {
+			return string(text)
+		}
+	case error:
+		return v.Error()
+	case Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(k)
+}
+
+// SyncMap() returns a snapshot of the contents of a sync.Map as an AMap so
+// that it can be logged.  Keys are converted to strings via fmt.Sprint()
+// and are sorted:
+//
+//      lager.Debug().MMap("Cache state", "entries", lager.SyncMap(&cache))
+//
+func SyncMap(m *sync.Map) AMap {
+	if nil == m {
+		return nil
+	}
+	vals := make(map[string]interface{})
+	m.Range(func(k, v interface{}) bool {
+		vals[fmt.Sprint(k)] = v
+		return true
+	})
+	keys := make([]string, 0, len(vals))
+	for k := range vals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvp := &KVPairs{}
+	for _, k := range keys {
+		kvp.keys = append(kvp.keys, k)
+		kvp.vals = append(kvp.vals, vals[k])
+	}
+	return kvp
+}
+
+// Returns a slice or array of Stringers (or errors) as a list of the
+// String() (or Error()) values, since json.Marshal() would not use those,
+// or 'nil, false' for other values.
+func stringerList(s interface{}) (interface{}, bool) {
+	t := reflect.TypeOf(s)
+	if k := t.Kind(); reflect.Slice != k && reflect.Array != k {
+		return nil, false
+	} else if e := t.Elem(); !e.Implements(stringerType) &&
+		!e.Implements(errorType) {
+		return nil, false
+	}
+	v := reflect.ValueOf(s)
+	if reflect.Slice == v.Kind() && v.IsNil() {
+		return nil, true
+	}
+	list := make(AList, v.Len())
+	for i := range list {
+		list[i] = v.Index(i).Interface()
+	}
+	return list, true
+}
+
+// Returns a version of a container value that json.Marshal() rejected but
+// that we can log, or 'nil, false' for other values:
+//
+//      Maps with keys that json.Marshal() does not support become a list
+//      of [key, value] pairs, sorted by key (or an AMap, for
+//      MapKeysAsStrings).
+//
+//      Iterator functions [func(yield func(V) bool) or func(yield func(K, V)
+//      bool)] become a list of the values (or of [key, value] pairs).
+//
+func containerValue(s interface{}, policy MapKeyPolicy) (interface{}, bool) {
+	v := reflect.ValueOf(s)
+	t := v.Type()
+	switch v.Kind() {
+	case reflect.Map:
+		if reflect.String == t.Key().Kind() {
+			return nil, false
+		} else if v.IsNil() {
+			return nil, true
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return lessKey(keys[i], keys[j])
+		})
+		if MapKeysAsStrings == policy {
+			pairs := make([]interface{}, 0, 2*len(keys))
+			for _, k := range keys {
+				pairs = append(pairs,
+					keyString(k.Interface()), v.MapIndex(k).Interface())
+			}
+			return AMap(nil).AddPairs(pairs...), true
+		}
+		list := make(AList, len(keys))
+		for i, k := range keys {
+			list[i] = AList{k.Interface(), v.MapIndex(k).Interface()}
+		}
+		return list, true
+	case reflect.Func:
+		if v.IsNil() || 1 != t.NumIn() || 0 != t.NumOut() {
+			return nil, false
+		}
+		y := t.In(0)
+		if reflect.Func != y.Kind() || 1 != y.NumOut() ||
+			boolType != y.Out(0) || y.NumIn() < 1 || 2 < y.NumIn() {
+			return nil, false
+		}
+		list := make(AList, 0)
+		yield := reflect.MakeFunc(y, func(in []reflect.Value) []reflect.Value {
+			if 1 == len(in) {
+				list = append(list, in[0].Interface())
+			} else {
+				list = append(list, AList{in[0].Interface(), in[1].Interface()})
+			}
+			return []reflect.Value{reflect.ValueOf(len(list) < maxIterItems)}
+		})
+		v.Call([]reflect.Value{yield})
+		return list, true
+	}
+	return nil, false
+}
+
+// Returns whether map key 'a' sorts before 'b'.
+func lessKey(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return a.Uint() < b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() < b.Float()
+	}
+	return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+}
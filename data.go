@@ -1,8 +1,11 @@
 package lager
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"strconv"
 )
 
 type skipThisPair string
@@ -125,7 +128,12 @@ func Unless(cond bool, label string) interface{} {
 	return SkipThisPair
 }
 
-// Add/update Lager key/value pairs to/in a context.Context.
+// Add/update Lager key/value pairs to/in a context.Context.  A key that is
+// already in the context keeps its place in the order of keys but gets the
+// new value, so middleware can replace a stale value, like replacing the
+// request-only "httpRequest" with the final one.  If a key is passed in
+// more than once, the last value is used.  Use RemovePairs() to delete
+// pairs.
 func AddPairs(ctx Ctx, pairs ...interface{}) Ctx {
 	if 0 == len(pairs) {
 		return ctx
@@ -133,6 +141,20 @@ func AddPairs(ctx Ctx, pairs ...interface{}) Ctx {
 	return ContextPairs(ctx).AddPairs(pairs...).InContext(ctx)
 }
 
+// RemovePairs() returns a context.Context that has none of the Lager
+// key/value pairs for the given keys.  If 'ctx' has no pairs for any of
+// the keys, then 'ctx' is returned:
+//
+//      ctx = lager.RemovePairs(ctx, "httpRequest")
+//
+func RemovePairs(ctx Ctx, keys ...string) Ctx {
+	kvp := ContextPairs(ctx)
+	if rest := kvp.RemovePairs(keys...); rest != kvp {
+		return rest.InContext(ctx)
+	}
+	return ctx
+}
+
 // Fetches the lager key/value pairs stored in a context.Context.
 func ContextPairs(ctx Ctx) AMap {
 	if nil == ctx {
@@ -148,11 +170,155 @@ func ContextPairs(ctx Ctx) AMap {
 	panic(fmt.Sprintf("Invalid type (%T not *lager.KVPairs) in context", x))
 }
 
+// PairsFromCtx() returns a copy of the lager key/value pairs stored in a
+// context.Context [see AddPairs()], so middleware and tests can inspect
+// what will be logged, such as to forward a correlation ID to a downstream
+// request:
+//
+//      if id, ok := lager.PairsFromCtx(ctx)["trace"].(string); ok {
+//          req.Header.Set("X-Correlation-ID", id)
+//      }
+//
+// Changing the returned map does not change the pairs in the context.  It
+// returns an empty map if 'ctx' has no pairs.
+//
+func PairsFromCtx(ctx Ctx) map[string]interface{} {
+	kvp := ContextPairs(ctx)
+	if nil == kvp {
+		return map[string]interface{}{}
+	}
+	m := make(map[string]interface{}, len(kvp.keys))
+	for i, k := range kvp.keys {
+		m[k] = kvp.vals[i]
+	}
+	return m
+}
+
 // Get a new context with this map stored in it.
 func (p AMap) InContext(ctx Ctx) Ctx {
 	return context.WithValue(ctx, noop{}, p)
 }
 
+// MarshalPairs() returns the lager key/value pairs stored in a
+// context.Context encoded as a JSON object (or 'nil' if there are none).
+// Use this to pass logging context (trace IDs, request IDs, etc.) along
+// with an enqueued job (such as a Pub/Sub message or a Cloud Task) and
+// then call UnmarshalPairs() in the worker so its logs can be correlated:
+//
+//      msg.Attributes["lager"] = string(lager.MarshalPairs(ctx))
+//      // ...and in the worker:
+//      ctx = lager.UnmarshalPairs(ctx, []byte(msg.Attributes["lager"]))
+//
+// Values are encoded the same way they are in log lines.
+//
+func MarshalPairs(ctx Ctx) []byte {
+	kvp := ContextPairs(ctx)
+	if nil == kvp || 0 == len(kvp.keys) {
+		return nil
+	}
+	return encodeJSON(kvp)
+}
+
+// Returns the JSON encoding of 'v', as it would appear in a log line.
+func encodeJSON(v interface{}) []byte {
+	out := new(bytes.Buffer)
+	b := bufPool.Get().(*buffer)
+	b.g = getGlobals()
+	b.w = out
+	b.private = true
+	b.scalar(v)
+	b.unlock()
+	b.w, b.private = nil, false
+	bufPool.Put(b)
+	return out.Bytes()
+}
+
+// UnmarshalPairs() decodes a JSON object (usually from MarshalPairs()) and
+// returns a context.Context with its key/value pairs added to (and/or
+// replacing) the pairs already in 'ctx'.  The order of keys is preserved.
+// If 'data' is empty or is not a valid JSON object, 'ctx' is returned.
+//
+func UnmarshalPairs(ctx Ctx, data []byte) Ctx {
+	if 0 == len(bytes.TrimSpace(data)) {
+		return ctx
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	val, err := decodeValue(dec)
+	kvp, ok := val.(AMap)
+	if nil != err || !ok {
+		return ctx
+	}
+	if _, err := dec.Token(); nil == err {
+		return ctx // Trailing garbage
+	}
+	if 0 == len(kvp.keys) {
+		return ctx
+	}
+	if nil == ctx {
+		ctx = context.Background()
+	}
+	return ContextPairs(ctx).Merge(kvp).InContext(ctx)
+}
+
+// Decodes one JSON value, keeping objects as AMaps so the order of their
+// keys is preserved.
+func decodeValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if nil != err {
+		return nil, err
+	}
+	switch v := tok.(type) {
+	case json.Delim:
+		if '[' == v {
+			list := make(AList, 0)
+			for dec.More() {
+				elt, err := decodeValue(dec)
+				if nil != err {
+					return nil, err
+				}
+				list = append(list, elt)
+			}
+			_, err = dec.Token()
+			return list, err
+		}
+		kvp := &KVPairs{}
+		for dec.More() {
+			key, err := dec.Token()
+			if nil != err {
+				return nil, err
+			}
+			val, err := decodeValue(dec)
+			if nil != err {
+				return nil, err
+			}
+			kvp = kvp.AddPairs(key, val)
+		}
+		_, err = dec.Token()
+		return kvp, err
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); nil == err {
+			return i, nil
+		}
+		f, err := v.Float64()
+		return f, err
+	}
+	return tok, nil
+}
+
+// Get() returns the value for 'key' or 'nil' if there is no such key.
+//
+func (p AMap) Get(key string) interface{} {
+	if nil != p {
+		for i, k := range p.keys {
+			if k == key {
+				return p.vals[i]
+			}
+		}
+	}
+	return nil
+}
+
 // Return an AMap with the keys/values from the passed-in AMap added to and/or
 // replacing the keys/values from the method receiver.
 func (a AMap) Merge(b AMap) AMap {
@@ -197,8 +363,11 @@ func (a AMap) Merge(b AMap) AMap {
 }
 
 // Return an AMap with the passed-in key/value pairs added to and/or replacing
-// the keys/values from the method receiver.
+// the keys/values from the method receiver.  Replaced keys keep their place
+// in the order of keys.  Pairs labeled SkipThisPair are left out.  The pairs
+// of a Group() are added to any map already under its name.
 func (p AMap) AddPairs(pairs ...interface{}) AMap {
+	pairs = expandFields(inheritGroups(p, pairs))
 	n := len(pairs)
 	if 0 == n {
 		return p
@@ -222,6 +391,9 @@ func (p AMap) AddPairs(pairs ...interface{}) AMap {
 	}
 	o := m
 	for i := 0; i < n; i++ {
+		if _, ok := pairs[2*i].(skipThisPair); ok {
+			continue
+		}
 		key := S(pairs[2*i])
 		val := interface{}(nil)
 		if 2*i+1 < len(pairs) {
@@ -238,3 +410,32 @@ func (p AMap) AddPairs(pairs ...interface{}) AMap {
 	}
 	return &KVPairs{keys: keys[:o], vals: vals[:o]}
 }
+
+// RemovePairs() returns an AMap without the pairs for the passed-in keys.
+// If none of the keys are present, then the method receiver is returned.
+//
+func (p AMap) RemovePairs(keys ...string) AMap {
+	if nil == p {
+		return p
+	}
+	var rest *KVPairs
+	for i, k := range p.keys {
+		drop := false
+		for _, key := range keys {
+			drop = drop || key == k
+		}
+		if drop && nil == rest {
+			rest = &KVPairs{
+				keys: append([]string(nil), p.keys[:i]...),
+				vals: append([]interface{}(nil), p.vals[:i]...),
+			}
+		} else if !drop && nil != rest {
+			rest.keys = append(rest.keys, k)
+			rest.vals = append(rest.vals, p.vals[i])
+		}
+	}
+	if nil == rest {
+		return p
+	}
+	return rest
+}
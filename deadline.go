@@ -0,0 +1,92 @@
+package lager
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+)
+
+// ContextWriter is an io.Writer that can also be given a Context that
+// bounds how long a write may take.  Outputs (especially ones that send
+// logs over a network) can implement this to honor SetWriteTimeout().
+// WriteContext() should give up and return an error (such as ctx.Err())
+// once 'ctx' is done.
+type ContextWriter interface {
+	io.Writer
+	WriteContext(ctx context.Context, data []byte) (int, error)
+}
+
+// An output that supports write deadlines, like a net.Conn or an *os.File
+// for a pipe.
+type deadlineWriter interface {
+	SetWriteDeadline(time.Time) error
+}
+
+// SetWriteTimeout() limits how long each write to an output can take, so
+// that a hung log collector cannot block logging (or the background
+// goroutine used by SetAsync() and SetCoalescing()) indefinitely.  A write
+// that takes longer than 'timeout' fails, so the log line goes to the
+// fallback output [see SetFallbackOutput()].  A 'timeout' of 0 (the
+// default) means writes are not limited.
+//
+// The limit is applied to outputs that implement ContextWriter (via a
+// Context with that timeout) and to outputs with a SetWriteDeadline()
+// method, such as a net.Conn or an *os.File for a pipe or socket.  Outputs
+// combined via TeeOutput() or FormatOutput() get the limit applied to each
+// underlying output.  Other outputs are written to as usual.
+//
+// It returns a function that restores the prior setting:
+//
+//      defer lager.SetWriteTimeout(5*time.Second)()
+//
+func SetWriteTimeout(timeout time.Duration) func() {
+	var prior time.Duration
+	updateGlobals(func(g *globals) {
+		prior = g.writeTimeout
+		g.writeTimeout = timeout
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.writeTimeout = prior
+		})
+	}
+}
+
+// Writes 'data' to 'w', limited by the configured write timeout (if any).
+func (g *globals) write(w io.Writer, data []byte) (int, error) {
+	if nil == g || g.writeTimeout <= 0 {
+		return w.Write(data)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), g.writeTimeout)
+	defer cancel()
+	return writeContext(ctx, w, data)
+}
+
+// Writes 'data' to 'w', giving up once 'ctx' is done if 'w' supports that.
+func writeContext(ctx context.Context, w io.Writer, data []byte) (int, error) {
+	switch x := w.(type) {
+	case ContextWriter:
+		return x.WriteContext(ctx, data)
+	case deadlineWriter:
+		if dl, ok := ctx.Deadline(); ok && nil == x.SetWriteDeadline(dl) {
+			defer x.SetWriteDeadline(time.Time{})
+		}
+	}
+	return w.Write(data)
+}
+
+// Writes a list of buffers (using writev() when 'w' is a net.Conn), limited
+// by the configured write timeout (if any).
+func (g *globals) writeBuffers(w io.Writer, bufs *net.Buffers) error {
+	if nil != g && 0 < g.writeTimeout {
+		if x, ok := w.(deadlineWriter); ok {
+			dl := time.Now().Add(g.writeTimeout)
+			if nil == x.SetWriteDeadline(dl) {
+				defer x.SetWriteDeadline(time.Time{})
+			}
+		}
+	}
+	_, err := bufs.WriteTo(w)
+	return err
+}
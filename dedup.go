@@ -0,0 +1,226 @@
+package lager
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Tracks the most recent log line so that identical lines that follow it
+// can be collapsed [see SetDedupWindow()].
+type dedupState struct {
+	window time.Duration
+	mu     sync.Mutex
+	key    []byte    // The last line written, without its timestamp.
+	first  time.Time // When 'key' was last written.
+	count  int       // How many repeats of 'key' were not written yet.
+	last   []byte    // The most recent repeat (not written yet).
+	inMap  bool      // Whether 'last' was composed as a JSON map.
+	w      io.Writer // Where to write 'last'.
+	timer  *time.Timer
+}
+
+// SetDedupWindow() collapses identical consecutive log lines, similar to
+// syslog's "message repeated N times".  When a log line is the same as the
+// line logged just before it (other than the timestamp) and it is within
+// 'window' of when that line was written, it is not written right away.
+// Instead, once a different line is logged (or 'window' has passed), the
+// last of the repeated lines is written with a "repeat_count" pair added,
+// giving how many times the line was repeated:
+//
+//      ["2021-06-09 13:10:07.0447Z", "WARN", "Cache miss", {"key":"a"}]
+//      ["2021-06-09 13:10:09.9721Z", "WARN", "Cache miss", {"key":"a"},
+//          {"repeat_count":17}]
+//
+// Panic and Exit lines, and lines logged with a Context from FlushOn(), are
+// never collapsed (any held repeat is written before them) and Flush()
+// also writes any held repeat.  A 'window' of 0
+// (or less) turns this off.  It returns a function that restores the prior setting:
+//
+//      defer lager.SetDedupWindow(10*time.Second)()
+//
+// If the environment variable LAGER_DEDUP_WINDOW is set to a duration
+// (like "10s"), then that is used as the initial 'window'.
+//
+func SetDedupWindow(window time.Duration) func() {
+	var prior *dedupState
+	updateGlobals(func(g *globals) {
+		prior = g.dedup
+		setDedupWindow(window)(g)
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.dedup = prior
+		})
+	}
+}
+
+// How the dedup window is updated safely.
+func setDedupWindow(window time.Duration) func(*globals) {
+	return func(g *globals) {
+		g.dedup = nil
+		if 0 < window {
+			g.dedup = &dedupState{window: window}
+		}
+	}
+}
+
+// Sets the initial dedup window from LAGER_DEDUP_WINDOW.
+func envDedupWindow(g *globals) {
+	env := os.Getenv("LAGER_DEDUP_WINDOW")
+	if "" == env {
+		return
+	}
+	window, err := time.ParseDuration(env)
+	if nil != err {
+		initFatal(g, "Invalid LAGER_DEDUP_WINDOW", "error", err)
+		return
+	}
+	setDedupWindow(window)(g)
+}
+
+// The io.Writer used for a log line when SetDedupWindow() is in effect.
+type dedupWriter struct {
+	d     *dedupState
+	w     io.Writer
+	inMap bool
+	pass  bool   // Never collapse the line (Panic, Exit, or FlushOn()).
+	stamp int    // Offset to just after the timestamp [see withoutTimestamp()].
+	epoch [2]int // Offsets of the epoch pair, if any [SetEpochTimestamp()].
+	buf   []byte
+}
+
+// Collects the JSON log line and, once it is complete, decides whether to
+// write it.
+func (dw *dedupWriter) Write(data []byte) (int, error) {
+	dw.buf = append(dw.buf, data...)
+	if 0 == len(dw.buf) || '\n' != dw.buf[len(dw.buf)-1] {
+		return len(data), nil
+	}
+	line := dw.buf
+	dw.buf = nil
+	if dw.pass {
+		dw.d.mu.Lock()
+		dw.d.writeRepeats()
+		dw.d.key = nil
+		dw.d.mu.Unlock()
+		_, err := dw.w.Write(line)
+		if nil != err {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	key := withoutTimestamp(line, dw.stamp, dw.epoch)
+	if err := dw.d.line(dw.w, line, key, dw.inMap); nil != err {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Writes any held repeat and then flushes the underlying output.
+func (dw *dedupWriter) Flush() error {
+	dw.d.flush()
+	if f, ok := dw.w.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Handles one complete log line, holding it back if it is a repeat of the
+// prior line.  'key' is the line without its timestamp.
+func (d *dedupState) line(
+	w io.Writer, line, key []byte, inMap bool,
+) error {
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if nil != d.key && bytes.Equal(key, d.key) &&
+		now.Sub(d.first) <= d.window {
+		if 0 == d.count {
+			d.timer = time.AfterFunc(d.window-now.Sub(d.first), d.expire)
+		}
+		d.count++
+		d.last, d.w, d.inMap = line, w, inMap
+		return nil
+	}
+	d.writeRepeats()
+	d.key, d.first = key, now
+	_, err := w.Write(line)
+	return err
+}
+
+// Writes any held repeat once the window has passed.
+func (d *dedupState) expire() {
+	outMu.Lock()
+	defer outMu.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.writeRepeats()
+	d.key = nil
+}
+
+// Writes any held repeat right away [see Flush()].
+func (d *dedupState) flush() {
+	outMu.Lock()
+	defer outMu.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.writeRepeats()
+}
+
+// Writes the held repeat (if any) with its "repeat_count".  Must be called
+// with 'mu' locked.
+func (d *dedupState) writeRepeats() {
+	if 0 == d.count {
+		return
+	}
+	if nil != d.timer {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	line := d.last[:len(d.last)-2] // Remove "]\n" or "}\n".
+	end := d.last[len(d.last)-2:]
+	count := strconv.Itoa(d.count)
+	if d.inMap {
+		line = append(line, `, "repeat_count":`+count...)
+	} else {
+		line = append(line, `, {"repeat_count":`+count+`}`...)
+	}
+	writeRepeat(d.w, append(line, end...), d.count)
+	d.count, d.last, d.w = 0, nil, nil
+}
+
+// Writes the held repeat 'line' to 'w', except that an output using an
+// Encoder [see UseEncoder()] or another format [see FormatOutput()] gets
+// its own version of the line with the "repeat_count" added.
+func writeRepeat(w io.Writer, line []byte, count int) {
+	switch x := w.(type) {
+	case tee:
+		for _, w := range x {
+			writeRepeat(w, line, count)
+		}
+	case *encodedWriter:
+		x.w.Write(x.s.repeated(count))
+	default:
+		w.Write(line)
+	}
+}
+
+// Returns a copy of a JSON log line without its timestamp, which is
+// everything after the opening '[' or '{' up to offset 'stamp' (so also
+// the key for the timestamp), and without the epoch pair from
+// line[epoch[0]:epoch[1]] (if any) [see SetEpochTimestamp()].
+func withoutTimestamp(line []byte, stamp int, epoch [2]int) []byte {
+	if stamp < 1 || len(line) < stamp {
+		return append([]byte(nil), line...)
+	}
+	key := append(make([]byte, 0, 1+len(line)-stamp), line[0])
+	if stamp <= epoch[0] && epoch[0] < epoch[1] && epoch[1] <= len(line) {
+		key = append(key, line[stamp:epoch[0]]...)
+		return append(key, line[epoch[1]:]...)
+	}
+	return append(key, line[stamp:]...)
+}
@@ -0,0 +1,133 @@
+package lager
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// How many log lines of each level were dropped because the output could
+// not keep up.
+var _dropped [int(nLevels)]uint64
+
+// The io.Writer used for a log line (of a level that can be dropped) when
+// async mode or coalescing is enabled.
+type dropWriter struct {
+	asyncWriter
+	line    *droppedLine
+	started bool // Part of the line has been queued.
+	dropped bool // The line is being dropped.
+}
+
+// A log line that can be dropped.  It is shared by the dropWriter of each
+// of the line's outputs so a line is only counted once when dropped.
+type droppedLine struct {
+	lev     level
+	counted bool
+}
+
+// DropWhenBehind() makes logging of the given levels never block the
+// caller waiting for the output to catch up.  When log lines are being
+// queued [see SetAsync() and SetCoalescing()] and the queue is full, then
+// lines of these levels are dropped instead.  'levels' is a string of
+// letters from "FWNAITDOG" (other characters are ignored, so Panic and Exit
+// lines are never dropped); "" turns this off.
+//
+// Every 'every' (default 1 minute), if any lines were dropped, a Warn log
+// line is written with how many lines of each level were dropped since the
+// prior such summary.  Lines written directly (when nothing is queued) are
+// never dropped.  It returns a function that restores the prior setting
+// (and stops the summaries):
+//
+//      defer lager.DropWhenBehind("AITDOG", 10*time.Second)()
+//
+func DropWhenBehind(levels string, every time.Duration) func() {
+	var drop [int(nLevels)]bool
+	for _, c := range []byte(levels) {
+		if lev, ok := letterLevel(c); ok && lFail <= lev {
+			drop[int(lev)] = true
+		}
+	}
+	var prior [int(nLevels)]bool
+	updateGlobals(func(g *globals) {
+		prior = g.drop
+		g.drop = drop
+	})
+	if every <= 0 {
+		every = time.Minute
+	}
+	stop := make(chan struct{})
+	var last [int(nLevels)]uint64
+	for i := range last {
+		last[i] = atomic.LoadUint64(&_dropped[i])
+	}
+	go reportDropped(last, every, stop)
+	return func() {
+		close(stop)
+		updateGlobals(func(g *globals) {
+			g.drop = prior
+		})
+	}
+}
+
+// Dropped() returns how many log lines of each level have been dropped
+// because the output could not keep up [see DropWhenBehind()].  The keys
+// are level names (like "INFO") and only levels with dropped lines are
+// included.
+//
+func Dropped() map[string]uint64 {
+	counts := make(map[string]uint64)
+	for l := lFail; l < nLevels; l++ {
+		if n := atomic.LoadUint64(&_dropped[int(l)]); 0 < n {
+			counts[l.String()] = n
+		}
+	}
+	return counts
+}
+
+// Periodically logs how many more lines were dropped (than 'last'), until
+// 'stop' is closed.
+func reportDropped(
+	last [int(nLevels)]uint64, every time.Duration, stop chan struct{},
+) {
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+		}
+		counts := AMap(nil)
+		for l := lFail; l < nLevels; l++ {
+			n := atomic.LoadUint64(&_dropped[int(l)])
+			if last[int(l)] < n {
+				counts = counts.AddPairs(l.String(), n-last[int(l)])
+			}
+			last[int(l)] = n
+		}
+		if nil != counts {
+			Warn().MMap("Dropped log lines because output is behind",
+				"dropped", counts)
+		}
+	}
+}
+
+// Queues the data unless the queue is full when the line starts, in which
+// case the whole line is dropped.
+func (dw *dropWriter) Write(data []byte) (int, error) {
+	if dw.dropped {
+		return len(data), nil
+	} else if dw.started {
+		return dw.asyncWriter.Write(data)
+	}
+	dw.started = true
+	if queued, err := dw.tryWrite(data); nil != err || queued {
+		return len(data), err
+	}
+	dw.dropped = true
+	if !dw.line.counted {
+		dw.line.counted = true
+		atomic.AddUint64(&_dropped[int(dw.line.lev)], 1)
+	}
+	return len(data), nil
+}
@@ -0,0 +1,106 @@
+package lager
+
+import (
+	"os"
+)
+
+// EcsVersion is the version of the Elastic Common Schema that log lines
+// follow when RunningInEcs() is in effect.
+const EcsVersion = "8.11"
+
+// RunningInEcs() tells Lager to log messages using Elastic Common Schema
+// (ECS) field names so that log lines can be ingested into Elasticsearch
+// without needing an ingest pipeline to rename fields.  Each line is
+// written as a JSON map like:
+//
+//      {"@timestamp":"2026-01-02T15:04:05.1234Z", "log.level":"warn",
+//       "ecs.version":"8.11", "message":"Slow query", "ms":2219,
+//       "labels":{"trace":"x7"}, "log.logger":"db"}
+//
+// In particular, RunningInEcs() is similar to running:
+//
+//      if "" == os.Getenv("LAGER_KEYS") {
+//          // LAGER_KEYS has precedence over LAGER_ECS.
+//          lager.Keys("@timestamp", "log.level", "message", "data",
+//              "labels", "log.logger")
+//      }
+//      lager.SetLevelNotation(lager.EcsLevelName)
+//
+// plus adding the "ecs.version" pair to each line.  So pairs from Contexts
+// are logged under "labels" while other pairs are logged in-line.  It
+// undoes RunningInGcp(), RunningInAws(), and RunningInAzure().  It returns
+// a function that restores the prior settings:
+//
+//      defer lager.RunningInEcs()()
+//
+// Setting LAGER_ECS in the environment has the same effect as calling
+// RunningInEcs() when the program starts.  The "ecs" format can also be
+// selected via FlagSet().
+//
+func RunningInEcs() func() {
+	var keys *keyStrs
+	var levDesc func(string) string
+	var inEcs, inGcp, inAws, inAzure bool
+	updateGlobals(func(g *globals) {
+		keys, levDesc = g.keys, g.levDesc
+		inEcs, inGcp, inAws, inAzure = g.inEcs, g.inGcp, g.inAws, g.inAzure
+		setRunningInEcs(true)(g)
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.keys, g.levDesc = keys, levDesc
+			g.inEcs, g.inGcp, g.inAws, g.inAzure = inEcs, inGcp, inAws, inAzure
+		})
+	}
+}
+
+// How ECS options are set safely.
+func setRunningInEcs(enabled bool) func(*globals) {
+	return func(g *globals) {
+		g.inEcs = enabled
+		if enabled {
+			g.inGcp, g.inAws, g.inAzure = false, false, false
+			if "" == os.Getenv("LAGER_KEYS") {
+				g.keys = &keyStrs{
+					when: "@timestamp", lev: "log.level", msg: "message",
+					args: "data", mod: "log.logger", ctx: "labels",
+				}
+			}
+			g.levDesc = EcsLevelName
+		} else {
+			g.levDesc = identLevelNotation
+		}
+	}
+}
+
+// EcsLevelName takes a Lager level name (only the first letter matters and
+// it must be upper case) and returns the corresponding "log.level" value
+// commonly used with ECS.  Levels are mapped as:
+//      Panic, Exit - "fatal"
+//      Fail - "error"
+//      Warn - "warn"
+//      Note - "notice"
+//      Access, Info - "info"
+//      Trace - "trace"
+//      Debug, Obj, Guts - "debug"
+//      If an invalid level name is passed: "unknown"
+//
+func EcsLevelName(lev string) string {
+	switch lev[0] {
+	case 'P', 'E':
+		return "fatal"
+	case 'F':
+		return "error"
+	case 'W':
+		return "warn"
+	case 'N':
+		return "notice"
+	case 'A', 'I':
+		return "info"
+	case 'T':
+		return "trace"
+	case 'D', 'O', 'G':
+		return "debug"
+	}
+	return "unknown"
+}
@@ -0,0 +1,489 @@
+package lager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Encoder is implemented to add a custom wire format for log lines [see
+// UseEncoder() and RegisterEncoder()].  Each method appends part of the
+// encoding of a log line to 'out' and returns the result, much like the
+// append() built-in.
+//
+// The log line is passed to the Encoder as a sequence of calls while it is
+// being composed.  A list (such as a whole log line when Keys() is not set)
+// is passed as OpenList(), then for each element 'i' (counting from 0),
+// Next() then the element, and finally CloseList().  A map is passed as
+// OpenMap(), then for each pair 'i', Next(), Key(), and then the value, and
+// finally CloseMap().  Other values are passed to Scalar() as a string,
+// int64, uint64 (only if too large for an int64), float64, bool, or 'nil'.
+// EndLine() is called after the whole log line.
+//
+// The number of elements or pairs is not known until the list or map is
+// closed, so CloseList() and CloseMap() are passed 'n', the number of
+// elements or pairs, and 'start', the length 'out' had when OpenList() or
+// OpenMap() was called.  So a binary format that puts a count first can
+// insert it at out[start:] when closing.
+//
+// For example, Next() for the built-in Encoder that writes log lines as
+// JSON adds a comma when 'i' is not 0, while a binary format might do
+// nothing but close lists and maps.
+//
+type Encoder interface {
+	OpenList(out []byte) []byte
+	CloseList(out []byte, start, n int) []byte
+	OpenMap(out []byte) []byte
+	CloseMap(out []byte, start, n int) []byte
+	Next(out []byte, i int) []byte
+	Key(out []byte, key string) []byte
+	Scalar(out []byte, v interface{}) []byte
+	EndLine(out []byte) []byte
+}
+
+// UseEncoder() causes each log line to be written using a custom Encoder
+// rather than as JSON.  The Encoder is passed each value as the line is
+// composed, so it sees the same lists, maps, and values that would have
+// been written as JSON but never has to parse JSON.  Nor is the line
+// composed as JSON at all, unless another of its outputs needs that [such
+// as one passed to TeeOutput()] or SetDedupWindow() or SetFallbackOutput()
+// is in effect.  It returns a function that restores the prior setting:
+//
+//      defer lager.UseEncoder(myEncoder{})()
+//
+// UseCborFormat() and UseMsgpackFormat() are built on this.
+//
+func UseEncoder(enc Encoder) func() {
+	var prior *lineFormat
+	updateGlobals(func(g *globals) {
+		prior = g.format
+		g.format = &lineFormat{encoder: enc}
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.format = prior
+		})
+	}
+}
+
+// RegisterEncoder() adds an Encoder as a format that can be selected by
+// 'name', such as via the --log-format option [see FlagSet()] or
+// FormatOutput().  Selecting it is like calling UseEncoder(), but also
+// disables the RunningInGcp() (and similar) settings and Keys().  It is
+// usually called when the program starts (such as from an init() function)
+// and returns an error if 'name' is already in use.
+//
+func RegisterEncoder(name string, enc Encoder) error {
+	defer logFormatsMu.Unlock()
+	logFormatsMu.Lock()
+	if _, ok := logFormats[name]; ok {
+		return fmt.Errorf("log format %q is already in use", name)
+	}
+	logFormats[name] = func(g *globals) {
+		setRunningInGcp(false)(g)
+		g.inEcs, g.inAws, g.inAzure = false, false, false
+		setKeys(nil)(g)
+		g.format = &lineFormat{encoder: enc}
+	}
+	return nil
+}
+
+// A list or map that has been opened but not yet closed.
+type encFrame struct {
+	start int // The length of 'out' before the list or map was opened.
+	n     int // The number of elements or pairs so far.
+	isMap bool
+}
+
+// Receives the parts of one log line (as it is composed) and encodes them
+// using an Encoder.  A buffer has one for the line as JSON [see 'json']
+// plus one for each output that uses another format [see 'encs'].
+type encSink struct {
+	enc      Encoder
+	typed    typedEncoder // 'enc' if it has faster ways to encode values.
+	out      []byte
+	frames   []encFrame
+	stack    [4]encFrame // Initial space for 'frames'.
+	afterKey bool        // Whether the next value is the value of a pair.
+
+	// The line before its outermost list or map was closed:
+	tail int
+	top  encFrame
+}
+
+// Implemented by the built-in Encoders so that strings and numbers need
+// not be converted to interface{} values (and so allocated) just to encode
+// them.  A string of 'n' bytes is encoded as strHead(), then strBody() for
+// each part of it, then strTail().  A float has 'bits' of precision.
+type typedEncoder interface {
+	strHead(out []byte, n int) []byte
+	strBody(out []byte, s string) []byte
+	strTail(out []byte) []byte
+	scalarInt(out []byte, v int64) []byte
+	scalarFloat(out []byte, v float64, bits int) []byte
+}
+
+// Implemented by an Encoder that keeps the context pairs of a log line
+// apart from its other pairs [see formatEncoder].  context(true) is
+// called before the context pairs and context(false) after them.
+type contextEncoder interface {
+	context(begin bool)
+}
+
+// The Encoder used for the line as JSON when no output of the line needs
+// it [see needsJSON()].  It only writes the final newline, so that each
+// encodedWriter knows to write its encoding of the line.
+type newlineEncoder struct{}
+
+// The io.Writer used for a log line going to an output that uses another
+// format.  The JSON log line (which is just the final newline when no
+// output needs JSON) is ignored and, once it is complete, the encoding
+// from 's' is written instead.
+type encodedWriter struct {
+	w io.Writer
+	s encSink
+}
+
+func (newlineEncoder) OpenList(out []byte) []byte            { return out }
+func (newlineEncoder) CloseList(out []byte, _, _ int) []byte { return out }
+func (newlineEncoder) OpenMap(out []byte) []byte             { return out }
+func (newlineEncoder) CloseMap(out []byte, _, _ int) []byte  { return out }
+func (newlineEncoder) Next(out []byte, _ int) []byte         { return out }
+func (newlineEncoder) Key(out []byte, _ string) []byte       { return out }
+
+func (newlineEncoder) Scalar(out []byte, _ interface{}) []byte {
+	return out
+}
+
+func (newlineEncoder) strHead(out []byte, _ int) []byte     { return out }
+func (newlineEncoder) strBody(out []byte, _ string) []byte  { return out }
+func (newlineEncoder) strTail(out []byte) []byte            { return out }
+func (newlineEncoder) scalarInt(out []byte, _ int64) []byte { return out }
+func (newlineEncoder) scalarFloat(out []byte, _ float64, _ int) []byte {
+	return out
+}
+
+func (newlineEncoder) EndLine(out []byte) []byte {
+	return append(out, '\n')
+}
+
+// Starts passing the parts of a log line to the Encoder 'enc', appending
+// the encoding to 'out'.
+func (s *encSink) reset(enc Encoder, out []byte) {
+	*s = encSink{enc: enc, out: out}
+	s.typed, _ = enc.(typedEncoder)
+	s.frames = s.stack[:0]
+}
+
+// Gets ready to encode the next element of a list or the next pair of a
+// map (but not the value of a pair).
+func (s *encSink) next() {
+	if s.afterKey {
+		s.afterKey = false
+	} else if 0 < len(s.frames) {
+		f := &s.frames[len(s.frames)-1]
+		s.out = s.enc.Next(s.out, f.n)
+		f.n++
+	}
+}
+
+// Opens a list or map.
+func (s *encSink) open(isMap bool) {
+	s.next()
+	s.frames = append(s.frames, encFrame{start: len(s.out), isMap: isMap})
+	if isMap {
+		s.out = s.enc.OpenMap(s.out)
+	} else {
+		s.out = s.enc.OpenList(s.out)
+	}
+}
+
+// Closes the most recently opened list or map.
+func (s *encSink) close() {
+	f := s.frames[len(s.frames)-1]
+	s.frames = s.frames[:len(s.frames)-1]
+	if 0 == len(s.frames) {
+		s.tail, s.top = len(s.out), f
+	}
+	if f.isMap {
+		s.out = s.enc.CloseMap(s.out, f.start, f.n)
+	} else {
+		s.out = s.enc.CloseList(s.out, f.start, f.n)
+	}
+}
+
+// Encodes the key of a pair.
+func (s *encSink) key(k string) {
+	s.next()
+	s.out = s.enc.Key(s.out, k)
+	s.afterKey = true
+}
+
+// Encodes a string value made of the parts 'strs'.
+func (s *encSink) str(strs []string) {
+	s.next()
+	if nil == s.typed {
+		if 1 == len(strs) {
+			s.out = s.enc.Scalar(s.out, strs[0])
+		} else {
+			s.out = s.enc.Scalar(s.out, strings.Join(strs, ""))
+		}
+		return
+	}
+	n := 0
+	for _, str := range strs {
+		n += len(str)
+	}
+	s.out = s.typed.strHead(s.out, n)
+	for _, str := range strs {
+		s.out = s.typed.strBody(s.out, str)
+	}
+	s.out = s.typed.strTail(s.out)
+}
+
+// Encodes a string value that never needs escaping (such as a timestamp)
+// without converting it to a string.
+func (s *encSink) text(t []byte) {
+	s.next()
+	if nil == s.typed {
+		s.out = s.enc.Scalar(s.out, string(t))
+		return
+	}
+	s.out = append(s.typed.strHead(s.out, len(t)), t...)
+	s.out = s.typed.strTail(s.out)
+}
+
+// Encodes an integer value.
+func (s *encSink) int(v int64) {
+	s.next()
+	if nil == s.typed {
+		s.out = s.enc.Scalar(s.out, v)
+	} else {
+		s.out = s.typed.scalarInt(s.out, v)
+	}
+}
+
+// Encodes a (finite) floating-point value that has 'bits' of precision.
+func (s *encSink) float(v float64, bits int) {
+	s.next()
+	if nil == s.typed {
+		s.out = s.enc.Scalar(s.out, exactFloat(v, bits))
+	} else {
+		s.out = s.typed.scalarFloat(s.out, v, bits)
+	}
+}
+
+// Encodes any other value (a bool, a uint64 too large for an int64, or
+// 'nil').
+func (s *encSink) scalar(v interface{}) {
+	s.next()
+	s.out = s.enc.Scalar(s.out, v)
+}
+
+// Appends values that were already encoded:  'n' pairs or, if 'n' is 0,
+// one value.
+func (s *encSink) raw(data []byte, n int) {
+	s.next()
+	if 1 < n {
+		s.frames[len(s.frames)-1].n += n - 1
+	}
+	s.out = append(s.out, data...)
+}
+
+// Ends the log line.
+func (s *encSink) endLine() {
+	s.out = s.enc.EndLine(s.out)
+}
+
+// Returns the encoding of the completed log line with a "repeat_count"
+// pair added [see SetDedupWindow()].
+func (s *encSink) repeated(count int) []byte {
+	if fe, ok := s.enc.(*formatEncoder); ok {
+		return fe.repeated(count)
+	}
+	r := &encSink{enc: s.enc, typed: s.typed}
+	r.out = append([]byte(nil), s.out[:s.tail]...)
+	r.frames = append(r.stack[:0], s.top)
+	if !s.top.isMap {
+		r.open(true)
+	}
+	r.key("repeat_count")
+	r.int(int64(count))
+	if !s.top.isMap {
+		r.close()
+	}
+	r.close()
+	r.endLine()
+	return r.out
+}
+
+// Returns the io.Writer to use for a log line going to 'w' that is to be
+// encoded using 'enc'.  If 'kept' is set, the io.Writer can still be used
+// after the line is written (such as by the flight recorder), otherwise
+// one from an earlier line using 'b' gets reused.
+func (b *buffer) encodedWriter(
+	w io.Writer, enc Encoder, kept bool,
+) io.Writer {
+	var ew *encodedWriter
+	if !kept && b.encUsed < len(b.encFree) {
+		ew = b.encFree[b.encUsed]
+	} else {
+		ew = &encodedWriter{}
+		ew.s.out = make([]byte, 0, 512)
+		if !kept {
+			b.encFree = append(b.encFree, ew)
+		}
+	}
+	if !kept {
+		b.encUsed++
+	}
+	ew.w = w
+	ew.s.reset(enc, ew.s.out[:0])
+	b.encs = append(b.encs, &ew.s)
+	return ew
+}
+
+// Sets up 'b' to compose log lines as JSON only, as it is after each line.
+func (b *buffer) resetEncs() {
+	for i := range b.encs {
+		b.encs[i] = nil
+	}
+	b.json.reset(jsonEncoder{}, b.scratch[0:0])
+	b.encs = append(b.encs[:0], &b.json)
+	b.encUsed = 0
+}
+
+// Returns whether any output of a log line going to 'w' [as returned by
+// bindOutput()] needs the line composed as JSON, which is not the case
+// for outputs that use another format.
+func needsJSON(w io.Writer) bool {
+	switch x := w.(type) {
+	case tee:
+		for _, w := range x {
+			if needsJSON(w) {
+				return true
+			}
+		}
+		return false
+	case *encodedWriter:
+		return false
+	}
+	return io.Discard != w
+}
+
+// Returns whether the log line is being composed as JSON.
+func (b *buffer) composingJSON() bool {
+	_, ok := b.json.enc.(jsonEncoder)
+	return ok
+}
+
+// Returns the size of the log line that was written.
+func (b *buffer) lineSize() int {
+	if b.composingJSON() {
+		return b.size
+	}
+	size := 0
+	for _, s := range b.encs[1:] {
+		size += len(s.out)
+	}
+	return size
+}
+
+// Ignores the JSON log line and, once it is complete, writes the encoding.
+func (ew *encodedWriter) Write(data []byte) (int, error) {
+	return ew.WriteContext(context.Background(), data)
+}
+
+// Like Write() but passes 'ctx' to the output [see SetWriteTimeout()].
+func (ew *encodedWriter) WriteContext(
+	ctx context.Context, data []byte,
+) (int, error) {
+	if 0 == len(data) || '\n' != data[len(data)-1] {
+		return len(data), nil
+	}
+	if _, err := writeContext(ctx, ew.w, ew.s.out); nil != err {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Flushes the underlying output.
+func (ew *encodedWriter) Flush() error {
+	if f, ok := ew.w.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Passes values that were already encoded as JSON ('n' pairs or, if 'n'
+// is 0, one value) to the JSON encoding of the line and calls 'values' to
+// pass the same values to the line's other encodings (if any).
+func (b *buffer) preEncoded(data []byte, n int, values func()) {
+	b.room()
+	if b.composingJSON() {
+		b.json.raw(data, n)
+	}
+	if 1 < len(b.encs) {
+		encs := b.encs
+		b.encs = encs[1:]
+		values()
+		b.encs = encs
+	}
+}
+
+// Returns a value that was encoded via json.Marshal(), decoded so it can
+// be passed to encodings other than JSON.  Such values (of types that
+// lager does not know how to log) are the only ones that get decoded.
+func decodedJSON(data []byte) interface{} {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if v, err := decodeValue(dec); nil == err {
+		return v
+	}
+	return string(data)
+}
+
+// Returns a floating-point value that has 'bits' of precision as a
+// float64 that is written the same way [so float32(0.1) is not passed to
+// an Encoder as 0.10000000149011612].
+func exactFloat(v float64, bits int) float64 {
+	if 32 == bits {
+		var num [32]byte
+		v, _ = strconv.ParseFloat(
+			string(strconv.AppendFloat(num[:0], v, 'g', -1, 32)), 64)
+	}
+	return v
+}
+
+// Returns 's' with each run of bytes that are not valid UTF-8 replaced by
+// their hex digits between "«x" and "»", just as they are written in JSON.
+func validUtf8(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	out := make([]byte, 0, len(s)+8)
+	for i := 0; i < len(s); {
+		r, rl := utf8.DecodeRuneInString(s[i:])
+		if utf8.RuneError != r || 1 != rl {
+			out = append(out, s[i:i+rl]...)
+			i += rl
+			continue
+		}
+		out = append(out, "«x"...)
+		for 1 == rl && utf8.RuneError == r {
+			out = append(out, hexDigits[s[i]>>4], hexDigits[s[i]&0xF])
+			i++
+			if i == len(s) {
+				break
+			}
+			r, rl = utf8.DecodeRuneInString(s[i:])
+		}
+		out = append(out, "»"...)
+	}
+	return string(out)
+}
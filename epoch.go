@@ -0,0 +1,86 @@
+package lager
+
+import (
+	"os"
+	"time"
+)
+
+// The default key for the numeric timestamp [see SetEpochTimestamp()].
+const epochKey = "ts_epoch_ms"
+
+// SetEpochTimestamp() adds the timestamp of each log line as a number of
+// milliseconds since 1970-01-01 UTC (the Unix epoch), which is what some
+// log consumers (like BigQuery partitioning and ClickHouse) prefer, so
+// that they do not have to parse the string timestamp.
+//
+// If 'only' is 'false', then the number is added alongside the string
+// timestamp, under 'key' (default "ts_epoch_ms").  When logging a JSON map
+// [see Keys()], it is added right after the log level, so tools that
+// expect the level right after the timestamp still find it:
+//
+//      {"time":"2021-06-09T13:10:07.0447Z", "severity":"INFO",
+//          "ts_epoch_ms":1623244207044, ...}
+//
+// When logging a JSON list, it is added to the context pairs (near the end
+// of the line), so the elements of the list stay in the usual order.
+//
+// If 'only' is 'true', then the number replaces the string timestamp (as
+// the first element of a JSON list or under the timestamp's key of a JSON
+// map) and 'key' is not used:
+//
+//      [1623244207044, "INFO", ...]
+//
+// It returns a function that restores the prior setting:
+//
+//      defer lager.SetEpochTimestamp("", false)()
+//
+// Setting LAGER_EPOCH_TIMESTAMP in the environment to a key has the same
+// effect as calling SetEpochTimestamp(key, false) when the program starts
+// and setting it to "only" has the same effect as calling
+// SetEpochTimestamp("", true).
+//
+func SetEpochTimestamp(key string, only bool) func() {
+	if "" == key {
+		key = epochKey
+	}
+	var priorKey string
+	var priorOnly bool
+	updateGlobals(func(g *globals) {
+		priorKey, priorOnly = g.epochKey, g.epochOnly
+		g.epochKey, g.epochOnly = key, only
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.epochKey, g.epochOnly = priorKey, priorOnly
+		})
+	}
+}
+
+// Sets the initial numeric timestamp setting from LAGER_EPOCH_TIMESTAMP.
+func envEpochTimestamp(g *globals) {
+	switch env := os.Getenv("LAGER_EPOCH_TIMESTAMP"); env {
+	case "":
+	case "only":
+		g.epochKey, g.epochOnly = epochKey, true
+	default:
+		g.epochKey = env
+	}
+}
+
+// Returns the timestamp as milliseconds since the Unix epoch.
+func epochMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// Appends the timestamp as milliseconds since the Unix epoch.
+func (b *buffer) epoch() {
+	b.int64(epochMillis(b.now))
+}
+
+// Returns a copy of the logger that adds the numeric timestamp to its
+// context pairs.
+func (l *logger) withEpoch(b *buffer) *logger {
+	cp := *l
+	cp.kvp = cp.kvp.AddPairs(l.g.epochKey, epochMillis(b.now))
+	return &cp
+}
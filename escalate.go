@@ -0,0 +1,279 @@
+package lager
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Escalation describes when to automatically raise the verbosity of a
+// module because it is logging errors.  See AddEscalation().
+//
+type Escalation struct {
+	// The levels of log lines counted, as letters from "PEFWNAITDOG".  An
+	// empty string means "F" (Fail).
+	Levels string
+
+	// If not "", only lines logged via the Module of this name count.
+	// Otherwise lines from each module are counted separately (and lines
+	// not from any Module escalate the global levels).
+	Module string
+
+	// Escalation happens once Count lines have been logged (in one module)
+	// within the time span of Within.
+	Count  int
+	Within time.Duration
+
+	// The levels to enable during the escalation.  An empty string means
+	// "ITD" (Info, Trace, and Debug).
+	Enable string
+
+	// How long the escalation lasts.  0 means 5 minutes.
+	For time.Duration
+}
+
+// An Escalation after validation along with its per-module state.
+type escalation struct {
+	Escalation
+	levels [int(nLevels)]bool
+	mu     sync.Mutex
+	counts map[string]*trigger // Recent matching lines for each module.
+	active map[string]func()   // Ends each escalation in progress.
+	timers map[string]*time.Timer
+	closed bool
+	owner  *Logger       // Whose levels get raised; 'nil' for the defaults.
+	copies []*escalation // Copies made for each Logger from New().
+}
+
+// AddEscalation() arranges for the verbosity of a module to be raised for
+// a while whenever it logs e.Count lines of the given levels within
+// e.Within.  For example, to log Debug lines from any module for 10
+// minutes after it logs 5 Fail lines within a minute:
+//
+//      remove, err := lager.AddEscalation(lager.Escalation{
+//          Count: 5, Within: time.Minute, Enable: "D", For: 10*time.Minute,
+//      })
+//
+// The e.Enable levels are added to the module's levels [see Module.Init()]
+// and, after e.For, the module's prior levels are restored.  A Note line is
+// logged when the escalation starts and when it ends:
+//
+//      ["2021-06-09 13:10:07.0447Z", "NOTE", "Escalated log levels",
+//          {"module":"db", "lines":5, "levels":"'F''W''N''A''D'",
+//          "for":"10m0s"}]
+//
+// Lines logged while a module is escalated do not extend the escalation.
+// Only lines that are actually written count (not lines for disabled
+// levels).  The returned function removes the escalation policy and
+// restores the levels of any modules that are still escalated.  An error
+// is returned if e.Count is not positive or if e.Within is not positive.
+//
+func AddEscalation(e Escalation) (func(), error) {
+	if e.Count < 1 || e.Within <= 0 {
+		return nil, fmt.Errorf(
+			"lager.Escalation needs positive Count and Within")
+	}
+	if "" == e.Enable {
+		e.Enable = "ITD"
+	}
+	if e.For <= 0 {
+		e.For = 5 * time.Minute
+	}
+	esc := newEscalation(e)
+	levels := e.Levels
+	if "" == levels {
+		levels = "F"
+	}
+	for _, c := range []byte(levels) {
+		if lev, ok := letterLevel(c); ok {
+			esc.levels[int(lev)] = true
+		}
+	}
+	updateGlobals(func(g *globals) {
+		g.escalations = append(
+			append([]*escalation(nil), g.escalations...), esc)
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			kept := make([]*escalation, 0, len(g.escalations))
+			for _, o := range g.escalations {
+				if o != esc {
+					kept = append(kept, o)
+				}
+			}
+			if 0 == len(kept) {
+				kept = nil
+			}
+			g.escalations = kept
+		})
+		esc.close()
+	}, nil
+}
+
+// Returns an escalation for 'e' with no lines counted yet.
+func newEscalation(e Escalation) *escalation {
+	return &escalation{
+		Escalation: e,
+		counts:     map[string]*trigger{},
+		active:     map[string]func(){},
+		timers:     map[string]*time.Timer{},
+	}
+}
+
+// Returns a copy of the policy that counts the lines of 'lg' separately
+// and raises its levels.  Closing 'e' also closes the copy.
+func (e *escalation) copyFor(lg *Logger) *escalation {
+	c := newEscalation(e.Escalation)
+	c.levels, c.owner = e.levels, lg
+	e.mu.Lock()
+	c.closed = e.closed
+	e.copies = append(e.copies, c)
+	e.mu.Unlock()
+	return c
+}
+
+// Returns the Module named 'mod' whose levels get raised ('nil' if none).
+func (e *escalation) module(mod string) *Module {
+	if nil != e.owner {
+		return findMod(e.owner.globals().mods, mod)
+	}
+	return getMod(mod)
+}
+
+// Returns the levels that are enabled for lines not from a Module.
+func (e *escalation) enabled() string {
+	if nil != e.owner {
+		return e.owner.GetLevels()
+	}
+	return GetLevels()
+}
+
+// Adds the e.Enable levels to those enabled for lines not from a Module.
+// Returns a function that restores the prior levels.
+func (e *escalation) enableMore() func() {
+	if nil != e.owner {
+		prior := e.owner.GetLevels()
+		if "" == prior {
+			prior = "-" // Not "" as that means "FWNA".
+		}
+		e.owner.Apply(WithLevels(prior + e.Enable))
+		return func() { e.owner.Apply(WithLevels(prior)) }
+	}
+	return SetLevels(GetLevels() + e.Enable)
+}
+
+// Returns the Lager for the Note lines about escalations.
+func (e *escalation) noteLager() Lager {
+	if nil != e.owner {
+		return e.owner.Note()
+	}
+	return Note()
+}
+
+// Counts a log line against any escalation policies that it matches.
+func (g *globals) noteEscalations(lev level, mod string, now time.Time) {
+	for _, e := range g.escalations {
+		if e.levels[int(lev)] && ("" == e.Module || e.Module == mod) {
+			e.note(mod, now)
+		}
+	}
+}
+
+// Records a matching line for 'mod'.
+func (e *escalation) note(mod string, now time.Time) {
+	e.mu.Lock()
+	if _, ok := e.active[mod]; ok || e.closed {
+		e.mu.Unlock()
+		return
+	}
+	t := e.counts[mod]
+	if nil == t {
+		t = &trigger{
+			Trigger: Trigger{
+				Count: e.Count, Within: e.Within,
+				Func: func(_ Trigger, n int) { e.raise(mod, n) },
+			},
+			times: make([]time.Time, 0, e.Count),
+		}
+		e.counts[mod] = t
+	}
+	e.mu.Unlock()
+	t.note(now)
+}
+
+// Starts an escalation for 'mod' after 'n' matching lines.
+func (e *escalation) raise(mod string, n int) {
+	e.mu.Lock()
+	if _, ok := e.active[mod]; ok || e.closed {
+		e.mu.Unlock()
+		return
+	}
+	var restore func()
+	levels := ""
+	if "" == mod {
+		restore = e.enableMore()
+		levels = e.enabled()
+	} else if m := e.module(mod); nil != m {
+		prior := m.cur().levels
+		levels = m.Init(prior + e.Enable).cur().levels
+		// Add "-" so "" does not become the global levels:
+		restore = func() { m.Init(prior + "-") }
+	} else {
+		e.mu.Unlock()
+		return
+	}
+	e.active[mod] = restore
+	e.timers[mod] = time.AfterFunc(e.For, func() { e.lower(mod) })
+	e.mu.Unlock()
+	// Log without holding e.mu since the line could be counted:
+	e.noteLager().MMap("Escalated log levels", "module", mod, "lines", n,
+		"levels", levels, "for", e.For.String())
+}
+
+// Ends the escalation for 'mod'.
+func (e *escalation) lower(mod string) {
+	e.mu.Lock()
+	ended := e.end(mod)
+	e.mu.Unlock()
+	ended()
+}
+
+// Ends the escalation for 'mod' (while holding e.mu).  Returns a function
+// that logs that it ended (to be called after releasing e.mu).
+func (e *escalation) end(mod string) func() {
+	restore, ok := e.active[mod]
+	if !ok {
+		return func() {}
+	}
+	restore()
+	delete(e.active, mod)
+	e.timers[mod].Stop()
+	delete(e.timers, mod)
+	delete(e.counts, mod) // Start counting again from scratch.
+	levels := e.enabled()
+	if m := e.module(mod); "" != mod && nil != m {
+		levels = m.cur().levels
+	}
+	return func() {
+		e.noteLager().MMap("Restored log levels after escalation",
+			"module", mod, "levels", levels)
+	}
+}
+
+// Ends all escalations in progress and ignores any further lines.
+func (e *escalation) close() {
+	e.mu.Lock()
+	e.closed = true
+	ended := make([]func(), 0, len(e.active))
+	for mod := range e.active {
+		ended = append(ended, e.end(mod))
+	}
+	copies := e.copies
+	e.mu.Unlock()
+	for _, f := range ended {
+		f()
+	}
+	for _, c := range copies {
+		c.close()
+	}
+}
@@ -0,0 +1,37 @@
+package lager
+
+// Test-only hooks for the external (lager_test) tests.
+
+// UnregisterFormat() undoes RegisterEncoder(name, ...) so that tests which
+// register formats can be re-run (such as via "go test -count=2").
+func UnregisterFormat(name string) {
+	defer logFormatsMu.Unlock()
+	logFormatsMu.Lock()
+	delete(logFormats, name)
+}
+
+// SaveModules() records which modules exist, their levels, and the module
+// level rules [see SetModuleTreeLevels()].  It returns a function that
+// restores them, forgetting any modules created since, so tests that
+// create or re-level modules can be re-run.
+func SaveModules() func() {
+	tree := getGlobals().modTree
+	saved := make(map[string]interface{})
+	modMap.Range(func(key, value interface{}) bool {
+		saved[key.(string)] = value.(*Module).state.Load()
+		return true
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.modTree = tree
+		})
+		modMap.Range(func(key, value interface{}) bool {
+			if state, ok := saved[key.(string)]; !ok {
+				modMap.Delete(key)
+			} else if nil != state {
+				value.(*Module).state.Store(state)
+			}
+			return true
+		})
+	}
+}
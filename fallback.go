@@ -0,0 +1,95 @@
+package lager
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// SetFallbackOutput() sets an io.Writer (such as os.Stderr) to be used as
+// a "dead-letter" destination for log lines.  If writing a log line to
+// the usual output [see SetOutput()] returns an error, then the line is
+// written to the fallback writer, followed by a FAIL log line describing
+// the error and how many writes have failed so far.  With no fallback
+// writer set (the default), such lines are just counted.  Pass in 'nil'
+// to remove the fallback writer.
+//
+// Like SetOutput(), it returns a function that restores the prior setting:
+//
+//      defer lager.SetFallbackOutput(os.Stderr)()
+//
+// If a very long log line [over 16KiB] fails part way through, only the
+// parts that were not written successfully go to the fallback writer.
+//
+func SetFallbackOutput(writer io.Writer) func() {
+	var prior io.Writer
+	updateGlobals(func(g *globals) {
+		prior = g.fallback
+		g.fallback = writer
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.fallback = prior
+		})
+	}
+}
+
+// FailedWrites() returns how many log lines have failed to be (fully)
+// written to the output [see SetFallbackOutput()].
+//
+func FailedWrites() uint64 {
+	return atomic.LoadUint64(getGlobals().failedWrites)
+}
+
+// Writes part of a log line to the output.  Once a write fails, the rest
+// of the line goes to the fallback writer (if any).
+func (b *buffer) output(data []byte) {
+	b.size += len(data)
+	if nil == b.failed {
+		_, b.failed = b.g.write(b.w, data)
+		if nil == b.failed {
+			return
+		}
+	}
+	if nil != b.g && nil != b.g.fallback {
+		b.g.fallback.Write(data)
+	}
+}
+
+// Counts a failed log line and reports it to the fallback writer.
+func (g *globals) outputFailed(err error) {
+	n := atomic.AddUint64(g.failedWrites, 1)
+	if nil == g.fallback {
+		return
+	}
+	(&logger{lev: lFail, g: g.forFallback()}).MMap(
+		"Failed to write log line to output", "error", err, "failedWrites", n)
+}
+
+// Returns the globals for writing a line directly to the fallback writer.
+// Only the settings for how a line is formatted (and the count of failed
+// writes) are copied, so none of the filtering, sampling, routing, or other
+// processing of log lines applies (and the line cannot fail over to the
+// fallback writer again).
+func (g *globals) forFallback() *globals {
+	return &globals{
+		dest:       g.fallback,
+		keys:       g.keys,
+		format:     g.format,
+		conMods:    g.conMods,
+		keyFuncs:   g.keyFuncs,
+		epochKey:   g.epochKey,
+		epochOnly:  g.epochOnly,
+		mapKeys:    g.mapKeys,
+		pathParts:  g.pathParts,
+		levDesc:    g.levDesc,
+		sevNum:     g.sevNum,
+		sevKey:     g.sevKey,
+		inGcp:      g.inGcp,
+		inEcs:      g.inEcs,
+		inAws:      g.inAws,
+		inAzure:    g.inAzure,
+		gcpLatency: g.gcpLatency,
+
+		failedWrites: g.failedWrites,
+	}
+}
@@ -0,0 +1,291 @@
+package lager
+
+import (
+	"math"
+	"time"
+)
+
+// A Field is one key/value pair with a value of a known type, as returned
+// by Str(), Int64(), Dur(), etc.  A Field can be passed to Map(), MMap(),
+// or AddPairs() in place of a key and its value, and each Field takes up
+// only one argument:
+//
+//      lager.Info().MMap("Fetched", lager.Str("key", key),
+//          lager.Int("bytes", len(body)), lager.Dur("elapsed", elapsed),
+//          "cached", false)
+//
+// When passed to List() or MList(), a Field is logged as a map holding its
+// one pair.
+//
+// A Field gets encoded directly from its typed value, so the encoder does
+// not have to figure out how to encode a value via a type switch nor fall
+// back to reflection or json.Marshal() (as happens for a time.Time, for
+// example).  This makes Fields useful in hot code paths.  Passing a Field
+// as an interface{} allocates a copy of it (64 bytes), so use MFields()
+// in the hottest paths [see the Lager interface], which logs Fields
+// without converting each to an interface{}.
+//
+type Field struct {
+	key  string
+	str  string      // For strings.
+	val  interface{} // For Err(), Any(), and the time.Location for Time().
+	num  int64       // For integers, floats (as bits), bools, Durations,
+	nsec int32       // and Unix seconds (plus these nanoseconds) for Time().
+	kind fieldKind
+}
+
+// The type of the value of a Field.
+type fieldKind byte
+
+const (
+	fieldAny fieldKind = iota
+	fieldStr
+	fieldInt
+	fieldUint
+	fieldFloat
+	fieldBool
+	fieldDur
+	fieldTime
+	fieldErr
+	fieldGroup
+)
+
+// Str() returns a Field for a string value.
+//
+func Str(key, val string) Field {
+	return Field{key: key, kind: fieldStr, str: val}
+}
+
+// Int() returns a Field for an int value.
+//
+func Int(key string, val int) Field {
+	return Field{key: key, kind: fieldInt, num: int64(val)}
+}
+
+// Int64() returns a Field for an int64 value.
+//
+func Int64(key string, val int64) Field {
+	return Field{key: key, kind: fieldInt, num: val}
+}
+
+// Uint64() returns a Field for a uint64 value.
+//
+func Uint64(key string, val uint64) Field {
+	return Field{key: key, kind: fieldUint, num: int64(val)}
+}
+
+// Float64() returns a Field for a float64 value.  Inf and NaN values are
+// logged as strings (as JSON numbers cannot represent them).
+//
+func Float64(key string, val float64) Field {
+	return Field{key: key, kind: fieldFloat, num: int64(math.Float64bits(val))}
+}
+
+// Bool() returns a Field for a bool value.
+//
+func Bool(key string, val bool) Field {
+	f := Field{key: key, kind: fieldBool}
+	if val {
+		f.num = 1
+	}
+	return f
+}
+
+// Dur() returns a Field for a time.Duration, which is logged as a string
+// like "1.5s", just like when a Duration is passed as a value.
+//
+func Dur(key string, val time.Duration) Field {
+	return Field{key: key, kind: fieldDur, num: int64(val)}
+}
+
+// Time() returns a Field for a time.Time, which is logged as an RFC 3339
+// string with as many fractional digits of seconds as needed, like
+// "2021-06-09T13:10:07.0447Z".
+//
+func Time(key string, val time.Time) Field {
+	return Field{key: key, kind: fieldTime, num: val.Unix(),
+		nsec: int32(val.Nanosecond()), val: val.Location()}
+}
+
+// Err() returns a Field for an error, which is logged just like when an
+// error is passed as a value.
+//
+func Err(key string, err error) Field {
+	return Field{key: key, kind: fieldErr, val: err}
+}
+
+// Any() returns a Field for a value of any type, which is logged just like
+// when it is passed as a value.
+//
+func Any(key string, val interface{}) Field {
+	return Field{key: key, kind: fieldAny, val: val}
+}
+
+// Group() returns a Field that logs the passed-in key/value pairs as a map
+// nested under 'name', which keeps related pairs together and avoids key
+// collisions:
+//
+//      lager.Info().MMap("Queried",
+//          lager.Group("db", "query", q, "rows", n, lager.Dur("took", d)))
+//
+//      ["2021-06-09 13:10:07.0447Z", "INFO", "Queried",
+//          {"db":{"query":"SELECT ...", "rows":12, "took":"3.2ms"}}]
+//
+// The pairs can include Fields (even other Groups), lager.InlinePairs, and
+// lager.Unless().  When a Group is added to a context [see AddPairs()] that
+// already has a map under 'name', such as from an earlier Group, then the
+// pairs are added to that map (replacing any with the same keys) rather
+// than replacing it, so code deeper in a request can add to a group:
+//
+//      ctx = lager.AddPairs(ctx, lager.Group("db", "host", host))
+//      // ...
+//      ctx = lager.AddPairs(ctx, lager.Group("db", "table", "users"))
+//      // Logs {"db":{"host":"db-3", "table":"users"}} as context.
+//
+func Group(name string, pairs ...interface{}) Field {
+	return Field{key: name, kind: fieldGroup, val: flatPairs(nil, pairs)}
+}
+
+// Key() returns the Field's key.
+//
+func (f Field) Key() string { return f.key }
+
+// Value() returns the Field's value (as an interface{}).
+//
+func (f Field) Value() interface{} {
+	switch f.kind {
+	case fieldStr:
+		return f.str
+	case fieldInt:
+		return f.num
+	case fieldUint:
+		return uint64(f.num)
+	case fieldFloat:
+		return math.Float64frombits(uint64(f.num))
+	case fieldBool:
+		return 0 != f.num
+	case fieldDur:
+		return time.Duration(f.num)
+	case fieldTime:
+		return f.time()
+	}
+	return f.val
+}
+
+// Returns the time.Time of a Field from Time().
+func (f Field) time() time.Time {
+	return time.Unix(f.num, int64(f.nsec)).In(f.val.(*time.Location))
+}
+
+// Appends the pair for a Field to the log line.
+func (b *buffer) field(f Field) {
+	b.key(f.key)
+	switch f.kind {
+	case fieldStr:
+		b.quote(f.str)
+		return
+	case fieldDur:
+		var dur [32]byte // So String() need not escape to the heap.
+		b.text(append(dur[:0], time.Duration(f.num).String()...))
+		return
+	case fieldErr, fieldAny, fieldGroup:
+		b.valKey = f.key
+		b.scalar(f.val)
+		return
+	}
+	switch f.kind {
+	case fieldInt:
+		b.int64(f.num)
+	case fieldUint:
+		b.uint64(uint64(f.num))
+	case fieldFloat:
+		b.float(math.Float64frombits(uint64(f.num)), 64)
+	case fieldBool:
+		b.bool(0 != f.num)
+	case fieldTime:
+		var stamp [40]byte
+		b.text(f.time().AppendFormat(stamp[:0], time.RFC3339Nano))
+	}
+}
+
+// Returns the value for 'key' in a list of key/value pairs (which can
+// include Fields) and whether it was found.  If 'key' appears more than
+// once, the last value is returned.
+func pairValue(pairs []interface{}, key string) (interface{}, bool) {
+	var val interface{}
+	found := false
+	for i := 0; i < len(pairs); i++ {
+		if f, ok := pairs[i].(Field); ok {
+			if key == f.key {
+				val, found = f.Value(), true
+			}
+			continue
+		}
+		if i+1 < len(pairs) && key == pairs[i] {
+			val, found = pairs[i+1], true
+		}
+		i++ // Skip the value.
+	}
+	return val, found
+}
+
+// Returns 'pairs' with each Field replaced by its key and value.  Returns
+// 'pairs' itself if it contains no Fields.
+func expandFields(pairs []interface{}) []interface{} {
+	n := 0
+	for i := 0; i < len(pairs); i++ {
+		if _, ok := pairs[i].(Field); ok {
+			n++
+		} else {
+			i++ // Skip the value.
+		}
+	}
+	if 0 == n {
+		return pairs
+	}
+	exp := make([]interface{}, 0, len(pairs)+n)
+	for i := 0; i < len(pairs); i++ {
+		if f, ok := pairs[i].(Field); ok {
+			exp = append(exp, f.key, f.Value())
+			continue
+		}
+		exp = append(exp, pairs[i])
+		if i+1 < len(pairs) {
+			i++
+			exp = append(exp, pairs[i])
+		}
+	}
+	return exp
+}
+
+// Returns 'pairs' with each Group whose name already holds a map in 'p'
+// replaced by a Group that also has the pairs of that map.  Returns 'pairs'
+// itself if there are no such Groups.
+func inheritGroups(p AMap, pairs []interface{}) []interface{} {
+	if nil == p {
+		return pairs
+	}
+	var cp []interface{}
+	for i := 0; i < len(pairs); i++ {
+		f, ok := pairs[i].(Field)
+		if !ok {
+			i++ // Skip the value.
+			continue
+		} else if fieldGroup != f.kind {
+			continue
+		}
+		prior, ok := p.Get(f.key).(AMap)
+		if !ok {
+			continue
+		}
+		if nil == cp {
+			cp = append([]interface{}(nil), pairs...)
+		}
+		f.val = prior.Merge(f.val.(AMap))
+		cp[i] = f
+	}
+	if nil == cp {
+		return pairs
+	}
+	return cp
+}
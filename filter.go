@@ -0,0 +1,89 @@
+package lager
+
+// A filter added via AddFilter().  Kept as a pointer so it can be removed.
+type filter struct {
+	keep func(level, module string, pairs AMap) bool
+}
+
+// AddFilter() adds a function that decides whether each log line gets
+// written, so lines can be dropped based on things like a tenant ID or a
+// noisy endpoint without changing the code that logs them.  'keep' is
+// passed the name of the line's log level (like "WARN"), its module name
+// ("" if not logged via a Module), and its key/value pairs (those from
+// contexts plus those passed to Map() or MMap()).  The line is only
+// written if every filter returns 'true':
+//
+//      defer lager.AddFilter(func(lev, mod string, pairs lager.AMap) bool {
+//          return "healthz" != pairs.Get("path")
+//      })()
+//
+// Filters are only called for enabled log levels (after any level rules
+// are applied [see SetLevelRules()]) and before the line is encoded, so
+// 'pairs' must not be modified.  Filters are called on each log line, so
+// they should be fast.  It returns a function that removes the filter.
+//
+func AddFilter(keep func(level, module string, pairs AMap) bool) func() {
+	f := &filter{keep: keep}
+	updateGlobals(func(g *globals) {
+		g.filters = append(append([]*filter(nil), g.filters...), f)
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			kept := make([]*filter, 0, len(g.filters))
+			for _, o := range g.filters {
+				if o != f {
+					kept = append(kept, o)
+				}
+			}
+			if 0 == len(kept) {
+				kept = nil
+			}
+			g.filters = kept
+		})
+	}
+}
+
+// Applies any filters to a log line about to be written.  'pairs' is the
+// list of key/value pairs passed to Map() or MMap() ('nil' for List() and
+// MList()).  Returns 'nil' if the line should not be written.
+func (l *logger) filter(pairs []interface{}) *logger {
+	if nil == l || nil == l.g.filters {
+		return l
+	}
+	kvp := l.ctxPairs().Merge(flatPairs(nil, pairs))
+	for _, f := range l.g.filters {
+		if !f.keep(l.lev.String(), l.mod, kvp) {
+			return nil
+		}
+	}
+	return l
+}
+
+// Converts a list of key/value pairs to an AMap, expanding any InlinePairs
+// and Fields and leaving out pairs labeled SkipThisPair.
+func flatPairs(kvp AMap, pairs []interface{}) AMap {
+	pairs = expandFields(pairs)
+	for i := 0; i < len(pairs); i += 2 {
+		var val interface{}
+		if i+1 < len(pairs) {
+			val = pairs[i+1]
+		}
+		if _, ok := pairs[i].(skipThisPair); ok {
+			continue
+		} else if _, ok := pairs[i].(inlinePairs); ok {
+			switch x := val.(type) {
+			case RawMap:
+				kvp = flatPairs(kvp, x)
+			case []interface{}:
+				kvp = flatPairs(kvp, x)
+			case KVPairs:
+				kvp = kvp.Merge(&x)
+			case AMap:
+				kvp = kvp.Merge(x)
+			}
+			continue
+		}
+		kvp = kvp.AddPairs(pairs[i], val)
+	}
+	return kvp
+}
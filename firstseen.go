@@ -0,0 +1,88 @@
+package lager
+
+import (
+	"sync"
+)
+
+// How many distinct messages MarkFirstSeen() remembers.
+const maxFirstSeen = 10000
+
+// Remembers which messages have been logged [see MarkFirstSeen()].
+type firstSeen struct {
+	levels [int(nLevels)]bool
+	mu     sync.Mutex
+	seen   map[string]struct{}
+}
+
+// MarkFirstSeen() adds a "first_seen" pair (with a value of 'true') to the
+// first log line written for each distinct message, which helps operators
+// spot novel errors among familiar noise, such as during a deploy.  Only
+// lines of the 'levels' given (as letters from "PEFWNAITDOG") are marked:
+//
+//      defer lager.MarkFirstSeen("EFW")()
+//
+//      ["2021-06-09 13:10:07.0447Z", "FAIL", "Lost connection",
+//          {"host":"db2"}, {"first_seen":true}]
+//      ["2021-06-09 13:10:09.9721Z", "FAIL", "Lost connection",
+//          {"host":"db3"}]
+//
+// Messages are told apart by their level, their module, and the message
+// string passed to MList(), MMap(), etc.  For MFmt() (and CMFmt()), the
+// format string is used rather than the formatted message, so lines that
+// differ only in the values formatted into them are not each marked.  So
+// messages work best when they are stable, like templates or event codes.
+// Lines that have no message (such as from Map()) are never marked.
+//
+// Messages seen since MarkFirstSeen() was called are remembered, up to
+// 10,000 distinct messages (after which no further lines are marked).
+// Passing "" turns this off.  It returns a function that restores the prior
+// setting.
+//
+func MarkFirstSeen(levels string) func() {
+	var fs *firstSeen
+	if "" != levels {
+		fs = &firstSeen{seen: map[string]struct{}{}}
+		for _, c := range []byte(levels) {
+			if lev, ok := letterLevel(c); ok {
+				fs.levels[int(lev)] = true
+			}
+		}
+	}
+	var prior *firstSeen
+	updateGlobals(func(g *globals) {
+		prior = g.firstSeen
+		g.firstSeen = fs
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.firstSeen = prior
+		})
+	}
+}
+
+// Returns a copy of the logger with the "first_seen" pair added if this is
+// the first time that the message has been seen.  Otherwise returns 'l'.
+func (l *logger) withFirstSeen(msg string) *logger {
+	fs := l.g.firstSeen
+	if "" != l.tmpl {
+		msg = l.tmpl
+	}
+	if !fs.levels[int(l.lev)] || "" == msg {
+		return l
+	}
+	key := l.lev.String() + "\x00" + l.mod + "\x00" + msg
+	fs.mu.Lock()
+	_, seen := fs.seen[key]
+	if !seen && maxFirstSeen <= len(fs.seen) {
+		seen = true
+	} else if !seen {
+		fs.seen[key] = struct{}{}
+	}
+	fs.mu.Unlock()
+	if seen {
+		return l
+	}
+	cp := *l
+	cp.kvp = cp.kvp.AddPairs("first_seen", true)
+	return &cp
+}
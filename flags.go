@@ -0,0 +1,170 @@
+package lager
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Held while reading 'logFormats' or adding to it [see RegisterEncoder()].
+var logFormatsMu sync.RWMutex
+
+// The log formats that can be selected via the --log-format flag.
+var logFormats = map[string]func(*globals){
+	"list": func(g *globals) {
+		setRunningInGcp(false)(g)
+		g.inEcs, g.inAws, g.inAzure = false, false, false
+		setKeys(nil)(g)
+		g.format = nil
+	},
+	"map": func(g *globals) {
+		setRunningInGcp(false)(g)
+		g.inEcs, g.inAws, g.inAzure = false, false, false
+		setKeys(&keyStrs{
+			when: "time", lev: "level", msg: "msg",
+			args: "data", mod: "module", ctx: "",
+		})(g)
+		g.format = nil
+	},
+	"gcp": func(g *globals) {
+		setRunningInGcp(true)(g)
+		g.format = nil
+	},
+	"ecs": func(g *globals) {
+		setRunningInEcs(true)(g)
+		g.format = nil
+	},
+	"aws": func(g *globals) {
+		setRunningInAws(true)(g)
+		g.format = nil
+	},
+	"azure": func(g *globals) {
+		setRunningInAzure(true)(g)
+		g.format = nil
+	},
+	"flat": func(g *globals) {
+		setRunningInGcp(false)(g)
+		g.inEcs, g.inAws, g.inAzure = false, false, false
+		setKeys(nil)(g)
+		g.format = newFlatFormat(nil)
+	},
+	"console": func(g *globals) {
+		setRunningInGcp(false)(g)
+		g.inEcs, g.inAws, g.inAzure = false, false, false
+		setKeys(nil)(g)
+		g.format = &lineFormat{color: autoColor()}
+	},
+	"logfmt": func(g *globals) {
+		setRunningInGcp(false)(g)
+		g.inEcs, g.inAws, g.inAzure = false, false, false
+		setKeys(nil)(g)
+		g.format = &lineFormat{logfmt: true}
+	},
+}
+
+// A boolean flag that adds log levels when set.
+type verboseFlag string
+
+func (v verboseFlag) String() string   { return "false" }
+func (v verboseFlag) IsBoolFlag() bool { return true }
+
+func (v verboseFlag) Set(val string) error {
+	if "true" == val {
+		Init(getGlobals().enabled + string(v))
+	} else if "false" != val {
+		return fmt.Errorf("not a boolean (%s)", val)
+	}
+	return nil
+}
+
+// A boolean flag that calls Mute() when set.
+type quietFlag struct{}
+
+func (q quietFlag) String() string   { return "false" }
+func (q quietFlag) IsBoolFlag() bool { return true }
+
+func (q quietFlag) Set(val string) error {
+	if "true" == val {
+		Mute()
+	} else if "false" == val {
+		Unmute()
+	} else {
+		return fmt.Errorf("not a boolean (%s)", val)
+	}
+	return nil
+}
+
+// A flag that selects the log format.
+type formatFlag struct{ cur *string }
+
+func (f formatFlag) String() string {
+	if nil == f.cur {
+		return ""
+	}
+	return *f.cur
+}
+
+func (f formatFlag) Set(val string) error {
+	set, ok := logFormat(val)
+	if !ok {
+		return fmt.Errorf("must be one of %s not %q", formatNames(), val)
+	}
+	*f.cur = val
+	updateGlobals(set)
+	return nil
+}
+
+// Returns the function that selects the log format called 'name'.
+func logFormat(name string) (func(*globals), bool) {
+	defer logFormatsMu.RUnlock()
+	logFormatsMu.RLock()
+	set, ok := logFormats[name]
+	return set, ok
+}
+
+// Returns the names of the supported log formats, like "gcp|list|map".
+func formatNames() string {
+	defer logFormatsMu.RUnlock()
+	logFormatsMu.RLock()
+	names := make([]string, 0, len(logFormats))
+	for name := range logFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, "|")
+}
+
+// FlagSet() registers command-line flags that configure logging, so tools
+// built using Lager get consistent logging flags:
+//
+//      -v              Also enable Info logs.
+//      -vv             Also enable Info, Trace, and Debug logs.
+//      --quiet         Suppress all logs other than Panic and Exit [Mute()].
+//      --log-format    One of "list" (JSON lists, the default), "map" (JSON
+//                      maps), "gcp" [see RunningInGcp()], "ecs" [see
+//                      RunningInEcs()], "aws" [see RunningInAws()],
+//                      "azure" [see RunningInAzure()], "flat" [see
+//                      UseFlatJSON()], "console" [see UseConsoleFormat()],
+//                      or "logfmt" [see UseLogfmtFormat()].
+//
+// The settings are applied as the flags are parsed, adding to any levels
+// already enabled [such as via LAGER_LEVELS].  Pass in 'nil' to register
+// the flags with flag.CommandLine:
+//
+//      func main() {
+//          lager.FlagSet(nil)
+//          flag.Parse()
+//
+func FlagSet(fs *flag.FlagSet) {
+	if nil == fs {
+		fs = flag.CommandLine
+	}
+	format := ""
+	fs.Var(verboseFlag("I"), "v", "Enable verbose (Info) logs")
+	fs.Var(verboseFlag("ITD"), "vv", "Enable very verbose (Debug) logs")
+	fs.Var(quietFlag{}, "quiet", "Suppress all non-fatal logs")
+	fs.Var(formatFlag{&format}, "log-format",
+		"Format of logs ("+formatNames()+")")
+}
@@ -0,0 +1,109 @@
+package lager
+
+import (
+	"os"
+	"strings"
+)
+
+// UseFlatJSON() causes each log line to be written as a flat JSON object,
+// for consumers that cannot handle the mix of lists and maps that Lager
+// otherwise writes.  Every part of the line is a top-level key:
+//
+//      {"time":"2021-06-09T13:10:07.0447Z", "level":"WARN", "msg":"Slow
+//       query", "module":"db", "table":"users", "ms":2219, "trace":"5x1"}
+//
+// The message (the first string logged without a key) goes under "msg".
+// Any other values logged without keys go in a list under "data".  Pairs
+// from Contexts, from Map() arguments to List(), and from MMap() all go
+// in-line.  If the same key is used more than once, only one of the values
+// is kept, so each key appears only once.  Nested maps stay nested.
+//
+// The key names are fully controlled via 'renames', which maps any
+// top-level key (one of the standard "time", "level", "msg", "data", and
+// "module", or any other key like "_file") to the key to use in its place.
+// Mapping a key to "" omits it.  For example:
+//
+//      defer lager.UseFlatJSON(map[string]string{
+//          "time": "@t", "msg": "message", "_file": "", "_line": "",
+//      })()
+//
+// This works whether or not Keys() has been set.  Lines are composed
+// directly in the flattened form, without parsing JSON.  It returns a
+// function that restores the prior setting.
+//
+// Setting LAGER_FLAT_JSON in the environment has the same effect as
+// calling UseFlatJSON() when the program starts.  Its value can be "1" or
+// a comma-separated list of renames like "time=@t,msg=message,_file=".
+// The "flat" format can also be selected via FlagSet().
+//
+func UseFlatJSON(renames map[string]string) func() {
+	var prior *lineFormat
+	updateGlobals(func(g *globals) {
+		prior = g.format
+		g.format = newFlatFormat(renames)
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.format = prior
+		})
+	}
+}
+
+// Returns the format settings for flat JSON with the given renames.
+func newFlatFormat(renames map[string]string) *lineFormat {
+	flat := make(map[string]string, len(renames))
+	for k, v := range renames {
+		flat[k] = v
+	}
+	return &lineFormat{flat: flat}
+}
+
+// Reads LAGER_FLAT_JSON.
+func envFlatJSON(g *globals) {
+	val := os.Getenv("LAGER_FLAT_JSON")
+	if "" == val {
+		return
+	}
+	renames := map[string]string{}
+	for _, r := range strings.Split(val, ",") {
+		if kv := strings.SplitN(r, "=", 2); 2 == len(kv) {
+			renames[kv[0]] = kv[1]
+		}
+	}
+	g.format = newFlatFormat(renames)
+}
+
+// Formats a decoded log line as a flat JSON object.
+func (fe *formatEncoder) flatJSON(tl textLine) []byte {
+	renames := fe.g.format.flat
+	flat := AMap(nil)
+	add := func(k string, v interface{}) {
+		if to, ok := renames[k]; ok {
+			k = to
+		}
+		if "" != k {
+			flat = flat.AddPairs(k, v)
+		}
+	}
+	add("time", strings.Replace(tl.when, " ", "T", 1))
+	add("level", tl.lev)
+	rest, pairs := fe.args(tl)
+	if 0 < len(rest) {
+		if s, ok := rest[0].(string); ok {
+			add("msg", s)
+			rest = rest[1:]
+		}
+	}
+	if 0 < len(rest) {
+		add("data", AList(rest))
+	}
+	if "" != fe.mod {
+		add("module", fe.mod)
+	}
+	for _, kvp := range pairs {
+		for i, k := range kvp.keys {
+			add(k, kvp.vals[i])
+		}
+	}
+	return append(encodeJSON(flat), '\n')
+}
@@ -0,0 +1,147 @@
+package lager
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Holds recent detailed log lines until a failure is logged [see
+// SetFlightRecorder()].
+type flightRecorder struct {
+	levels [int(nLevels)]bool
+	mu     sync.Mutex
+	held   []heldLine // Oldest line is at 'next' once 'held' is full.
+	next   int
+}
+
+// A log line held by the flight recorder and where it would have gone.
+type heldLine struct {
+	w    io.Writer
+	line []byte
+}
+
+// SetFlightRecorder() makes log lines of the given levels be held in
+// memory rather than written, so you get full detail around failures
+// without the volume of always writing Debug logs.  Only the most recent
+// 'size' such lines are kept (1000 if 'size' is not positive).  When a
+// Fail, Exit, or Panic line is logged, the held lines are first written
+// (oldest first, each with its original timestamp) and then forgotten.
+//
+// 'levels' is a string of letters from "WNAITDOG" ("F" and other
+// characters are ignored); "" means "TDOG".  The levels must also be
+// enabled [see Init()] for their lines to be held:
+//
+//      lager.Init("FWNAITD")
+//      defer lager.SetFlightRecorder("TD", 5000)()
+//
+// Held lines are not written to any output until a failure is logged, so
+// they are lost if the process ends without one (except through Exit() or
+// Panic()).  It returns a function that restores the prior setting (and
+// forgets any held lines).
+//
+// If the environment variable LAGER_FLIGHT_RECORDER is set, it is parsed
+// as "{levels}[,{size}]" (such as "TD,5000") to provide the initial
+// setting.
+//
+func SetFlightRecorder(levels string, size int) func() {
+	var prior *flightRecorder
+	updateGlobals(func(g *globals) {
+		prior = g.flight
+		g.flight = newFlightRecorder(levels, size)
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.flight = prior
+		})
+	}
+}
+
+// Returns a flightRecorder for the given levels.
+func newFlightRecorder(levels string, size int) *flightRecorder {
+	if "" == levels {
+		levels = "TDOG"
+	}
+	if size < 1 {
+		size = 1000
+	}
+	fr := &flightRecorder{held: make([]heldLine, 0, size)}
+	for _, c := range []byte(levels) {
+		if lev, ok := letterLevel(c); ok && lFail < lev {
+			fr.levels[int(lev)] = true
+		}
+	}
+	return fr
+}
+
+// Sets the initial flight recorder from LAGER_FLIGHT_RECORDER.
+func envFlightRecorder(g *globals) {
+	spec := os.Getenv("LAGER_FLIGHT_RECORDER")
+	if "" == spec {
+		return
+	}
+	parts := strings.Split(spec, ",")
+	size := 0
+	var err error
+	if 2 < len(parts) {
+		err = fmt.Errorf("expected {levels}[,{size}] not %q", spec)
+	} else if 2 == len(parts) {
+		size, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+	}
+	if nil != err {
+		initFatal(g, "Invalid LAGER_FLIGHT_RECORDER", "error", err)
+		return
+	}
+	g.flight = newFlightRecorder(strings.TrimSpace(parts[0]), size)
+}
+
+// Holds a complete log line.
+func (fr *flightRecorder) hold(h heldLine) {
+	defer AutoLock(&fr.mu)()
+	if len(fr.held) < cap(fr.held) {
+		fr.held = append(fr.held, h)
+		return
+	}
+	fr.held[fr.next] = h
+	fr.next = (fr.next + 1) % len(fr.held)
+}
+
+// Writes out (and forgets) the held lines.  Called before a failure is
+// logged.  Lines are written the way the failure line is, so when log lines
+// are queued [see SetAsync()], the held lines are queued as well.
+func (fr *flightRecorder) dump() {
+	fr.mu.Lock()
+	held := append(fr.held[fr.next:], fr.held[:fr.next]...)
+	fr.held, fr.next = make([]heldLine, 0, cap(fr.held)), 0
+	fr.mu.Unlock()
+	if 0 == len(held) {
+		return
+	}
+	outMu.Lock()
+	defer outMu.Unlock()
+	for _, h := range held {
+		h.w.Write(h.line)
+	}
+}
+
+// The io.Writer used for a log line that the flight recorder holds.
+type flightWriter struct {
+	fr  *flightRecorder
+	h   heldLine // Where the line would have gone.
+	buf []byte
+}
+
+// Collects the log line and holds it once it is complete.
+func (fw *flightWriter) Write(data []byte) (int, error) {
+	fw.buf = append(fw.buf, data...)
+	if 0 < len(fw.buf) && '\n' == fw.buf[len(fw.buf)-1] {
+		h := fw.h
+		h.line = fw.buf
+		fw.fr.hold(h)
+		fw.buf = nil
+	}
+	return len(data), nil
+}
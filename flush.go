@@ -0,0 +1,51 @@
+package lager
+
+import (
+	"context"
+)
+
+// Used as a context.Context key for FlushOn().
+type flushOn struct{}
+
+// An output that holds on to log lines must implement this interface to
+// allow FlushOn() to work with it.
+type flusher interface {
+	Flush() error
+}
+
+// FlushOn() returns a Context that causes each log line that is written
+// while using it [such as via 'lager.Fail(ctx)'] to be fully written out
+// before the logging method returns, bypassing any batching done by the
+// output.  Use it for critical code paths (like payments) where losing
+// buffered log lines in a crash is unacceptable:
+//
+//      ctx = lager.FlushOn(ctx)
+//      lager.Note(ctx).MMap("Charged card", "amount", amt)
+//
+// This works for any output [see SetOutput()] that has a 'Flush() error'
+// method, such as the batch.Writer used by Lager's network sinks.  Such
+// lines are also never held back as repeats [see SetDedupWindow()] nor by
+// the flight recorder [see SetFlightRecorder()].  Panic and Exit log lines
+// are always flushed.
+//
+func FlushOn(ctx Ctx) Ctx {
+	if flushesOn(ctx) {
+		return ctx
+	}
+	if nil == ctx {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, flushOn{}, true)
+}
+
+// Whether log lines written using 'ctx' should be flushed.
+func flushesOn(ctx Ctx) bool {
+	return nil != ctx && nil != ctx.Value(flushOn{})
+}
+
+// Flushes the output, if it supports that.
+func flushOutput(w interface{}) {
+	if f, ok := w.(flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,301 @@
+package lager
+
+import (
+	"io"
+)
+
+// Settings for writing log lines in a format other than JSON.  The values
+// of each line are passed to the format as the line is composed [see
+// formatEncoder and UseEncoder()], so lines need not be composed as JSON.
+type lineFormat struct {
+	color   bool          // Use colors in console format.
+	logfmt  bool          // Use logfmt rather than console format.
+	encoder Encoder       // If not nil, use a custom format instead.
+	siem    *siemFormat   // If not nil, use CEF or LEEF instead.
+	syslog  *syslogFormat // If not nil, use RFC 5424 instead.
+
+	// If not nil, write flat JSON objects using these key renames.
+	flat map[string]string
+}
+
+// The Encoder used for a log line when a format other than JSON is used
+// (or when an output has its own format [see FormatOutput()]).  It builds
+// the values of the line and writes them in the format once it ends.
+type formatEncoder struct {
+	g    *globals // The settings for the output format.
+	keys *keyStrs // The Keys() used when composing the line.
+	lev  level
+	mod  string
+	t    treeSink
+}
+
+// The values of a log line [as AList, *KVPairs, and scalars] being built
+// from its parts as it is composed.
+type treeSink struct {
+	frames []treeFrame // The lists and maps not yet closed.
+	top    interface{} // The whole line, once it is complete.
+	ctx    *KVPairs    // The context pairs [see contextEncoder].
+	inCtx  bool        // Whether the context pairs are being composed.
+	ctxAt  int         // How many frames were open when they started.
+	inline bool        // Whether the context pairs are not in their own map.
+}
+
+// A list or map being built by a treeSink.
+type treeFrame struct {
+	list AList
+	kvp  *KVPairs
+	key  string // The key for the next value put in 'kvp'.
+}
+
+// Returns the io.Writer to use for the log line being composed in 'b' and
+// going to 'w' when a format other than JSON is used [see encodedWriter()
+// for 'kept'].
+func (l *logger) formatWriter(b *buffer, w io.Writer, kept bool) io.Writer {
+	f := l.g.format
+	if nil != f.siem && !f.siem.levels[int(l.lev)] {
+		return io.Discard
+	} else if nil == f.siem && nil == f.flat && !f.logfmt &&
+		nil == f.syslog && l.g.conMods.hides(l.mod) && lFail <= l.lev {
+		return io.Discard
+	}
+	return b.encodedWriter(w, l.formatEncoder(l.g), kept)
+}
+
+// Returns a formatEncoder for the log line that writes it using the
+// format settings from 'g'.
+func (l *logger) formatEncoder(g *globals) *formatEncoder {
+	fe := &formatEncoder{g: g, keys: l.g.keys, lev: l.lev, mod: l.mod}
+	fe.t.inline = nil != l.g.keys && "" == l.g.keys.ctx
+	return fe
+}
+
+func (fe *formatEncoder) OpenList(out []byte) []byte {
+	fe.t.frames = append(fe.t.frames, treeFrame{list: AList{}})
+	return out
+}
+
+func (fe *formatEncoder) OpenMap(out []byte) []byte {
+	fe.t.frames = append(fe.t.frames, treeFrame{kvp: &KVPairs{}})
+	return out
+}
+
+func (fe *formatEncoder) CloseList(out []byte, _, _ int) []byte {
+	fe.t.close()
+	return out
+}
+
+func (fe *formatEncoder) CloseMap(out []byte, _, _ int) []byte {
+	fe.t.close()
+	return out
+}
+
+func (fe *formatEncoder) Next(out []byte, _ int) []byte { return out }
+
+func (fe *formatEncoder) Key(out []byte, key string) []byte {
+	fe.t.frames[len(fe.t.frames)-1].key = key
+	return out
+}
+
+func (fe *formatEncoder) Scalar(out []byte, v interface{}) []byte {
+	fe.t.put(v)
+	return out
+}
+
+// Writes the line out in the selected format.
+func (fe *formatEncoder) EndLine(out []byte) []byte {
+	return append(out, fe.render(fe.t.top)...)
+}
+
+// Notes where the context pairs begin and end.
+func (fe *formatEncoder) context(begin bool) {
+	t := &fe.t
+	t.inCtx = begin
+	if begin {
+		t.ctxAt = len(t.frames)
+		if t.inline {
+			t.ctx = &KVPairs{}
+		}
+	}
+}
+
+// Returns the line with a "repeat_count" added, in the selected format
+// [see SetDedupWindow()].
+func (fe *formatEncoder) repeated(count int) []byte {
+	rc := &KVPairs{keys: []string{"repeat_count"}, vals: AList{count}}
+	switch x := fe.t.top.(type) {
+	case AList:
+		return fe.render(append(x[:len(x):len(x)], rc))
+	case *KVPairs:
+		return fe.render(x.AddPairs("repeat_count", count))
+	}
+	return fe.render(fe.t.top)
+}
+
+// Closes the innermost open list or map.
+func (t *treeSink) close() {
+	f := t.frames[len(t.frames)-1]
+	t.frames = t.frames[:len(t.frames)-1]
+	var v interface{} = f.list
+	if nil != f.kvp {
+		v = f.kvp
+	}
+	if 0 == len(t.frames) {
+		t.top = v
+	} else {
+		t.put(v)
+	}
+}
+
+// Puts a value into the innermost open list or map.  Context pairs that
+// are not in their own map are kept separately, in 't.ctx'.
+func (t *treeSink) put(v interface{}) {
+	f := &t.frames[len(t.frames)-1]
+	isCtx := t.inCtx && len(t.frames) == t.ctxAt
+	if nil == f.kvp {
+		if kvp, ok := v.(*KVPairs); ok && isCtx {
+			t.ctx = kvp
+		}
+		f.list = append(f.list, v)
+	} else if isCtx && t.inline {
+		t.ctx.setPair(f.key, v)
+	} else {
+		f.kvp.setPair(f.key, v)
+	}
+}
+
+// Sets the value for 'key', adding it if it is not already present.
+func (p *KVPairs) setPair(key string, val interface{}) {
+	for i, k := range p.keys {
+		if k == key {
+			p.vals[i] = val
+			return
+		}
+	}
+	p.keys = append(p.keys, key)
+	p.vals = append(p.vals, val)
+}
+
+// The parts of a log line, as logged.
+type textLine struct {
+	when, lev string
+	rest      []interface{} // The message and other values.
+	pairs     []*KVPairs
+	ctx       *KVPairs // The pairs from 'pairs' known to be from Contexts.
+}
+
+// Writes the values of a log line in the selected format.  If they are
+// not in the expected form, they are written as JSON.
+func (fe *formatEncoder) render(v interface{}) []byte {
+	f := fe.g.format
+	tl, ok := fe.parse(v)
+	if !ok {
+		return append(encodeJSON(v), '\n')
+	} else if nil == f {
+		return fe.json(tl)
+	} else if nil != fe.g.format.flat {
+		return fe.flatJSON(tl)
+	} else if nil != fe.g.format.siem {
+		return fe.siem(tl)
+	} else if nil != fe.g.format.syslog {
+		return fe.syslog(tl)
+	} else if fe.g.format.logfmt {
+		return fe.logfmt(tl)
+	}
+	return fe.console(tl)
+}
+
+// Splits the values of a log line into its parts.
+func (fe *formatEncoder) parse(v interface{}) (tl textLine, ok bool) {
+	switch x := v.(type) {
+	case AList:
+		if len(x) < 2 {
+			return tl, false
+		}
+		tl.when, _ = x[0].(string)
+		tl.lev = fe.levName(x[1])
+		for _, elt := range x[2:] {
+			if s, ok := elt.(string); ok && "" != fe.mod && s == "mod="+fe.mod {
+				continue
+			} else if kvp, ok := elt.(*KVPairs); ok {
+				if kvp == fe.t.ctx {
+					tl.ctx = kvp
+				}
+				tl.pairs = append(tl.pairs, kvp)
+			} else {
+				tl.rest = append(tl.rest, elt)
+			}
+		}
+	case *KVPairs:
+		keys := fe.keys
+		if nil == keys {
+			return tl, false
+		}
+		top := &KVPairs{}
+		for i, k := range x.keys {
+			val := x.vals[i]
+			switch k {
+			case keys.when:
+				tl.when, _ = val.(string)
+			case keys.lev:
+				tl.lev = fe.levName(val)
+			case keys.mod:
+			case keys.msg:
+				tl.rest = append(tl.rest, val)
+			case keys.args, keys.ctx:
+				if kvp, ok := val.(*KVPairs); ok && k == keys.ctx {
+					tl.ctx = kvp
+				} else if ok {
+					tl.pairs = append(tl.pairs, kvp)
+				} else if list, ok := val.(AList); ok {
+					tl.rest = append(tl.rest, list...)
+				} else {
+					tl.rest = append(tl.rest, val)
+				}
+			default:
+				top = top.AddPairs(k, val)
+			}
+		}
+		tl.pairs = append(tl.pairs, top)
+		if fe.t.inline && nil != fe.t.ctx && 0 < len(fe.t.ctx.keys) {
+			tl.ctx = fe.t.ctx
+		}
+		if nil != tl.ctx {
+			tl.pairs = append(tl.pairs, tl.ctx)
+		}
+	default:
+		return tl, false
+	}
+	return tl, true
+}
+
+// Returns the level to display for the logged level value, which is not a
+// string when SetSeverityNumbers() replaces it with a number.
+func (fe *formatEncoder) levName(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fe.lev.String()
+}
+
+// Returns the values logged without keys [other than the message] and the
+// pairs that were logged, with the arguments to List() expanded when the
+// line was composed without using Keys().
+func (fe *formatEncoder) args(tl textLine) ([]interface{}, []*KVPairs) {
+	rest, pairs := []interface{}{}, []*KVPairs{}
+	for _, elt := range tl.rest {
+		if list, ok := elt.(AList); ok && nil == fe.keys {
+			for _, v := range list {
+				if kvp, ok := v.(*KVPairs); ok {
+					pairs = append(pairs, kvp)
+				} else {
+					rest = append(rest, v)
+				}
+			}
+		} else if kvp, ok := elt.(*KVPairs); ok {
+			pairs = append(pairs, kvp)
+		} else {
+			rest = append(rest, elt)
+		}
+	}
+	return rest, append(pairs, tl.pairs...)
+}
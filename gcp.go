@@ -4,14 +4,11 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"time"
-
-	"github.com/Unity-Technologies/go-lager-internal/gcp-spans"
 )
 
 const GcpSpanKey = "logging.googleapis.com/spanId"
@@ -47,7 +44,7 @@ func GcpProjectID(ctx Ctx) (string, error) {
 			return "", fmt.Errorf("Can't get GCP project ID (from %s): %w",
 				projIdUrl, err)
 		}
-		b, err := ioutil.ReadAll(resp.Body)
+		b, err := io.ReadAll(resp.Body)
 		if nil != err {
 			return "", fmt.Errorf(
 				"Can't read GCP project ID from response body: %w", err)
@@ -88,6 +85,7 @@ func setRunningInGcp(enabled bool) func(*globals) {
 	return func(g *globals) {
 		g.inGcp = enabled
 		if enabled {
+			g.inEcs, g.inAws, g.inAzure = false, false, false
 			if "" == os.Getenv("LAGER_KEYS") {
 				g.keys = &keyStrs{
 					when: "time", lev: "severity", msg: "message",
@@ -208,6 +206,7 @@ func RequestUrl(req *http.Request) *url.URL {
 //      "requestSize"       Omitted if the request body size is not yet known.
 //      "responseSize"      Omitted if 'resp' is 'nil' or body size not known.
 //      "latency"           E.g. "0.1270s".  Omitted if 'start' is 'nil'.
+//                          [See SetGcpLatencyFormat() for other formats.]
 //      "remoteIp"          E.g. "127.0.0.1"
 //      "serverIp"          Not currently ever included.
 //      "referer"           Omitted if there is no Referer[sic] header.
@@ -246,9 +245,9 @@ func GcpHttp(req *http.Request, resp *http.Response, start *time.Time) RawMap {
 		status = 0
 	}
 
-	lag := ""
+	var lag interface{}
 	if nil != start {
-		lag = fmt.Sprintf("%.4fs", time.Now().Sub(*start).Seconds())
+		lag = gcpLatency(time.Now().Sub(*start))
 	}
 
 	uri := RequestUrl(req)
@@ -260,7 +259,7 @@ func GcpHttp(req *http.Request, resp *http.Response, start *time.Time) RawMap {
 		Unless(-1 == status, "status"), status,
 		Unless(reqSize < 0, "requestSize"), reqSize,
 		Unless(respSize < 0, "responseSize"), respSize,
-		Unless("" == lag, "latency"), lag,
+		Unless(nil == lag, "latency"), lag,
 		"remoteIp", remoteAddr,
 		// "serverIp", ?,
 		Unless("" == ref, "referer"), ref,
@@ -320,286 +319,3 @@ func GcpLogAccess(
 	return Acc(
 		AddPairs(req.Context(), "httpRequest", GcpHttp(req, resp, pStart)))
 }
-
-// GcpContextAddTrace() takes a Context and returns one that has the span
-// added as 2 pairs that will be logged and recognized by GCP when that
-// Context is passed to lager.Warn() or similar methods.  If 'span' is 'nil'
-// or an empty Factory, then the original 'ctx' is just returned.
-//
-// 'ctx' is the Context from which the new Context is created.  'span'
-// contains the GCP CloudTrace span to be added.
-//
-// See also GcpContextReceivedRequest() and/or GcpContextSendingRequest()
-// which call this and do several other useful things.
-//
-func GcpContextAddTrace(ctx Ctx, span spans.Factory) Ctx {
-	if nil != span && 0 != span.GetSpanID() {
-		ctx = AddPairs(ctx,
-			GcpTraceKey, span.GetTracePath(),
-			GcpSpanKey, spans.HexSpanID(span.GetSpanID()))
-	}
-	return ctx
-}
-
-// GcpContextReceivedRequest() does several things that are useful when
-// a server receives a new request.  'ctx' is the Context passed to the
-// request handler and 'req' is the received request.
-//
-// An "httpRequest" key/value pair is added to the Context so that the
-// request details will be included in any subsequent log lines [when the
-// returned Context is passed to lager.Warn() or similar methods].
-//
-// If the request headers include GCP trace information, then that is
-// extracted [see spans.Factory.ImportFromHeaders()].
-//
-// If 'ctx' contains a spans.Factory, then that is fetched and used to
-// create either a new sub-span or (if there is no CloudTrace context in
-// the headers) a new trace (and span).  If the Factory is able to create
-// a new span, then it is marked as a "SERVER" span, its Display Name is
-// set to GetSpanPrefix() + ".in.request", and it is stored in the context
-// via spans.ContextStoreSpan().  Also, an "http.url" attribute is set
-// to the request's URL (minus query parameters), and if the request method
-// is not "GET", then an "http.method" attribute is set to that.
-//
-// If a span was imported or created, then the span information is added
-// to the Context as pairs to be logged [see GcpContextAddTrace()] and
-// a span will be contained in the returned Factory.
-//
-// The updated Context is returned (Contexts are immutable).
-//
-// It is usually called in a manner similar to:
-//
-//      ctx, span := lager.GcpContextReceivedRequest(ctx, req)
-//      defer spans.FinishSpan(span)
-// or
-//      ctx, span := lager.GcpContextReceivedRequest(ctx, req)
-//      var resp *http.Response
-//      defer lager.GcpSendingResponse(span, req, resp)
-//
-// See also GcpReceivedRequest().
-//
-// The order of arguments is 'ctx' then 'req' as information moves only in
-// the direction ctx <- req (if we consider 'ctx' to represent both the
-// argument and the returned value) and information always moves right-to-
-// left in Go (in assignment statements and when using channels).
-//
-func GcpContextReceivedRequest(
-	ctx Ctx, req *http.Request,
-) (Ctx, spans.Factory) {
-	ctx = AddPairs(ctx, "httpRequest", GcpHttp(req, nil, nil))
-	span := spans.ContextGetSpan(ctx)
-	if nil == span {
-		if proj, err := GcpProjectID(nil); nil != err {
-			Fail(ctx).MMap("Could not get GCP Project ID", "err", err)
-		} else { // Can't write new spans; just do read-only span operations:
-			span = spans.NewROSpan(proj)
-		}
-	}
-	if nil != span {
-		span = span.ImportFromHeaders(req.Header)
-		if sub := span.NewSpan(); nil != sub {
-			span = sub
-			span.SetDisplayName(GetSpanPrefix() + ".in.request")
-			span.SetIsServer()
-			span.AddAttribute("http.url", RequestUrl(req).String())
-			if "" != req.Method {
-				span.AddAttribute("http.method", req.Method)
-			}
-			ctx = spans.ContextStoreSpan(ctx, span)
-		}
-		ctx = GcpContextAddTrace(ctx, span)
-	}
-	return ctx, span
-}
-
-// GcpReceivedRequest() gets the Context from '*pReq' and uses it to call
-// GcpContextReceivedRequest().  Then it replaces '*pReq' with a version of
-// the request with the new Context attached.  Then it returns the Factory.
-//
-// It is usually called in a manner similar to:
-//
-//      defer spans.FinishSpan(lager.GcpReceivedRequest(&req))
-// or
-//      var resp *http.Response // Will be set in later code
-//      defer lager.GcpSendingResponse(
-//          lager.GcpReceivedRequest(&req), req, resp)
-//
-// Using GcpContextReceivedRequest() can be slightly more efficient if you
-// either start with a Context different from the one attached to the
-// Request or will not attach the new Context to the Request (or will adjust
-// it further before attaching it) since each time WithContext() is called
-// on a Request, the Request must be copied.
-//
-func GcpReceivedRequest(pReq **http.Request) spans.Factory {
-	ctx, span := GcpContextReceivedRequest((*pReq).Context(), *pReq)
-	*pReq = (*pReq).WithContext(ctx)
-	return span
-}
-
-// GcpContextSendingRequest() does several things that are useful when a
-// server is about to send a request to a dependent service.  'req' is the
-// Request that is about to be sent.  'ctx' is the server's current Context.
-//
-// The current span is fetched from 'ctx' [such as the one placed there
-// by GcpReceivedRequest() when the original request was received].  A new
-// sub-span is created, if possible.  If so, then it is marked as a "CLIENT"
-// span, its Display Name is set to GetSpanPrefix() + ".out.request",
-// attributes for "http.url" and maybe "http.method" are added to it,
-// it is stored in the Context via spans.ContextStoreSpan(), the returned
-// Factory will contain the new span, and the updated Context will contain
-// 2 pairs (to be logged) from the new span.  Note that the original Context
-// is not (cannot be) modified, so the trace/span pair logged after the
-// request-sending function returns will revert to the prior span.
-//
-// If a span was found or created, then its CloudContext is added to the
-// headers for 'req' so that the dependent service can log it and add its
-// own spans to the trace (unless 'req' is 'nil').
-//
-// The updated Context is returned (Contexts are immutable).
-//
-// The order of arguments is 'req' then 'ctx' as information moves only
-// in the direction req <- ctx and information always moves right-to-left
-// in Go (in assignment statements and when using channels).
-//
-// It is usually called in a manner similar to:
-//
-//      ctx, span := lager.GcpContextSendingRequest(req, ctx)
-//      defer spans.FinishSpan(span)
-//
-// See also GcpSendingRequest().
-//
-func GcpContextSendingRequest(
-	req *http.Request, ctx Ctx,
-) (Ctx, spans.Factory) {
-	span := spans.ContextGetSpan(ctx)
-	if nil != span {
-		subspan := span.NewSpan()
-		if nil != subspan {
-			span = subspan
-			span.SetDisplayName(GetSpanPrefix() + ".out.request")
-			span.SetIsClient()
-			if nil != req {
-				span.AddAttribute("http.url", RequestUrl(req).String())
-				if "" != req.Method && "GET" != req.Method {
-					span.AddAttribute("http.method", req.Method)
-				}
-			}
-			ctx = spans.ContextStoreSpan(ctx, span)
-			ctx = GcpContextAddTrace(ctx, span)
-		}
-		if nil != req {
-			span.SetHeader(req.Header)
-		}
-	}
-	return ctx, span
-}
-
-// GcpSendingNewRequest() does several things that are useful when a
-// server is about to send a request to a dependent service, by calling
-// GcpContextSendingRequest().  It takes the same arguments as
-// http.NewRequestWithContext() but returns extra values.
-//
-// It is usually called in a manner similar to:
-//
-//      req, ctx, span, err := lager.GcpSendingNewRequest(ctx, "GET", url, nil)
-//      if nil != err { ... }
-//      defer spans.FinishSpan(span)
-//
-// The returned Context is the same as 'req.Context()' but it is returned
-// separately to ease updating 'ctx' such as shown above.
-//
-func GcpSendingNewRequest(
-	ctx Ctx, method, url string, body io.Reader,
-) (*http.Request, Ctx, spans.Factory, error) {
-	ctx, span := GcpContextSendingRequest(nil, ctx)
-	req, err := http.NewRequestWithContext(ctx, method, url, body)
-	if nil != err {
-		// ('span' will just get garbage collected and not registered.)
-		return nil, ctx, nil, err
-	}
-	if nil != span {
-		span.AddAttribute("http.url", RequestUrl(req).String())
-		if "" != req.Method && "GET" != req.Method {
-			span.AddAttribute("http.method", req.Method)
-		}
-		span.SetHeader(req.Header)
-	}
-	return req, ctx, span, nil
-}
-
-// GcpSendingRequest() does several things that are useful when a
-// server is about to send a request to a dependent service, by calling
-// GcpContextSendingRequest().  It uses the Context from '*pReq' and then
-// replaces '*pReq' with a copy of the original Request but with the new
-// Context attached.
-//
-// It is usually called in a manner similar to:
-//
-//      defer spans.FinishSpan(lager.GcpSendingRequest(&req))
-//
-func GcpSendingRequest(pReq **http.Request) spans.Factory {
-	ctx, span := GcpContextSendingRequest(*pReq, (*pReq).Context())
-	*pReq = (*pReq).WithContext(ctx)
-	return span
-}
-
-// GcpFinishSpan() updates a span with the status information from a
-// http.Response and Finish()es the span (which registers it with GCP).
-//
-func GcpFinishSpan(span spans.Factory, resp *http.Response) time.Duration {
-	if nil == span || span.GetStart().IsZero() {
-		return time.Duration(0)
-	}
-	span.SetStatusCode(int64(resp.StatusCode))
-	if "" != resp.Status {
-		span.SetStatusMessage(resp.Status)
-	}
-	return span.Finish()
-}
-
-// GcpSendingResponse() does several things that are useful when a server
-// is about to send a response to a request it received.  It combines
-// GcpLogAccess() and GcpFinishSpan().  The access log line written will
-// use the message "Sending response" and will include the passed-in 'pairs'
-// which should be zero or more pairs of a string key followed by an
-// arbitrary value.
-//
-// 'resp' will often be constructed via GcpFakeResponse().
-//
-func GcpSendingResponse(
-	span spans.Factory,
-	req *http.Request,
-	resp *http.Response,
-	pairs ...interface{},
-) {
-	var pStart *time.Time
-	if nil != span {
-		start := span.GetStart()
-		pStart = &start
-	}
-	GcpLogAccess(req, resp, pStart).MMap(
-		"Sending response", InlinePairs, pairs)
-	GcpFinishSpan(span, resp)
-}
-
-// GcpReceivedResponse() combines GcpLogAccess() and GcpFinishSpan().
-// The access log line written will use the message "Received response"
-// and will include the passed-in 'pairs' which should be zero or more
-// pairs of a string key followed by an arbitrary value.  However, logging
-// every response received from a dependent service may be excessive.
-//
-func GcpReceivedResponse(
-	span spans.Factory,
-	req *http.Request,
-	resp *http.Response,
-	pairs ...interface{},
-) {
-	var pStart *time.Time
-	if nil != span {
-		start := span.GetStart()
-		pStart = &start
-	}
-	GcpLogAccess(req, resp, pStart).MMap(
-		"Received response", InlinePairs, pairs)
-	GcpFinishSpan(span, resp)
-}
@@ -0,0 +1,445 @@
+/*
+Package gcp provides the Lager helpers that work with GCP CloudTrace spans
+[see the gcp-spans package], so that log lines can be correlated with
+traces.  They are kept out of the lager package so that programs that do
+not use GCP spans do not need to import the gcp-spans package.  For
+example:
+
+	func handler(w http.ResponseWriter, req *http.Request) {
+		ctx, span := gcp.ContextReceivedRequest(req.Context(), req)
+		defer spans.FinishSpan(span)
+		lager.Info(ctx).MMap("Handling request")
+
+The helpers that do not involve spans, such as lager.GcpHttp() and
+lager.RunningInGcp(), remain in the lager package.  The helpers here
+replace the lager.Gcp*() functions of the same names (without the "Gcp"
+prefix), such as lager.GcpReceivedRequest() [see CHANGELOG.md].
+*/
+package gcp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Unity-Technologies/go-lager-internal"
+	"github.com/Unity-Technologies/go-lager-internal/gcp-spans"
+)
+
+// ContextAddTrace() takes a Context and returns one that has the span
+// added as 2 pairs that will be logged and recognized by GCP when that
+// Context is passed to lager.Warn() or similar methods.  If 'span' is 'nil'
+// or an empty Factory, then the original 'ctx' is just returned.
+//
+// 'ctx' is the Context from which the new Context is created.  'span'
+// contains the GCP CloudTrace span to be added.
+//
+// See also ContextReceivedRequest() and/or ContextSendingRequest()
+// which call this and do several other useful things.  For tracing systems
+// other than GCP, see lager.ContextAddTrace().
+//
+func ContextAddTrace(
+	ctx context.Context, span spans.Factory,
+) context.Context {
+	if nil != span && 0 != span.GetSpanID() {
+		ctx = lager.AddPairs(ctx,
+			lager.GcpTraceKey, span.GetTracePath(),
+			lager.GcpSpanKey, spans.HexSpanID(span.GetSpanID()))
+	}
+	return ctx
+}
+
+// ContextReceivedRequest() does several things that are useful when
+// a server receives a new request.  'ctx' is the Context passed to the
+// request handler and 'req' is the received request.
+//
+// An "httpRequest" key/value pair is added to the Context so that the
+// request details will be included in any subsequent log lines [when the
+// returned Context is passed to lager.Warn() or similar methods].
+//
+// If the request headers include GCP trace information, then that is
+// extracted [see spans.Factory.ImportFromHeaders()].
+//
+// If 'ctx' contains a spans.Factory, then that is fetched and used to
+// create either a new sub-span or (if there is no CloudTrace context in
+// the headers) a new trace (and span).  If the Factory is able to create
+// a new span, then it is marked as a "SERVER" span, its Display Name is
+// set to lager.GetSpanPrefix() + ".in.request", and it is stored in the
+// context via spans.ContextStoreSpan().  Also, an "http.url" attribute is set
+// to the request's URL (minus query parameters), and if the request method
+// is not "GET", then an "http.method" attribute is set to that.
+//
+// If a span was imported or created, then the span information is added
+// to the Context as pairs to be logged [see ContextAddTrace()] and
+// a span will be contained in the returned Factory.
+//
+// The updated Context is returned (Contexts are immutable).
+//
+// It is usually called in a manner similar to:
+//
+//      ctx, span := gcp.ContextReceivedRequest(ctx, req)
+//      defer spans.FinishSpan(span)
+// or
+//      ctx, span := gcp.ContextReceivedRequest(ctx, req)
+//      var resp *http.Response
+//      defer gcp.SendingResponse(span, req, resp)
+//
+// See also ReceivedRequest().
+//
+// The order of arguments is 'ctx' then 'req' as information moves only in
+// the direction ctx <- req (if we consider 'ctx' to represent both the
+// argument and the returned value) and information always moves right-to-
+// left in Go (in assignment statements and when using channels).
+//
+func ContextReceivedRequest(
+	ctx context.Context, req *http.Request,
+) (context.Context, spans.Factory) {
+	ctx = lager.AddPairs(ctx, "httpRequest", lager.GcpHttp(req, nil, nil))
+	span := spans.ContextGetSpan(ctx)
+	if nil == span {
+		if proj, err := lager.GcpProjectID(nil); nil != err {
+			lager.Fail(ctx).MMap("Could not get GCP Project ID", "err", err)
+		} else { // Can't write new spans; just do read-only span operations:
+			span = spans.NewROSpan(proj)
+		}
+	}
+	if nil != span {
+		span = span.ImportFromHeaders(req.Header)
+		if sub := span.NewSpan(); nil != sub {
+			span = sub
+			span.SetDisplayName(lager.GetSpanPrefix() + ".in.request")
+			span.SetIsServer()
+			span.AddAttribute("http.url", lager.RequestUrl(req).String())
+			if "" != req.Method {
+				span.AddAttribute("http.method", req.Method)
+			}
+			ctx = spans.ContextStoreSpan(ctx, span)
+		}
+		ctx = ContextAddTrace(ctx, span)
+	}
+	return ctx, span
+}
+
+// ReceivedRequest() gets the Context from '*pReq' and uses it to call
+// ContextReceivedRequest().  Then it replaces '*pReq' with a version of
+// the request with the new Context attached.  Then it returns the Factory.
+//
+// It is usually called in a manner similar to:
+//
+//      defer spans.FinishSpan(gcp.ReceivedRequest(&req))
+// or
+//      var resp *http.Response // Will be set in later code
+//      defer gcp.SendingResponse(
+//          gcp.ReceivedRequest(&req), req, resp)
+//
+// Using ContextReceivedRequest() can be slightly more efficient if you
+// either start with a Context different from the one attached to the
+// Request or will not attach the new Context to the Request (or will adjust
+// it further before attaching it) since each time WithContext() is called
+// on a Request, the Request must be copied.
+//
+func ReceivedRequest(pReq **http.Request) spans.Factory {
+	ctx, span := ContextReceivedRequest((*pReq).Context(), *pReq)
+	*pReq = (*pReq).WithContext(ctx)
+	return span
+}
+
+// SpanHandler() wraps an http.Handler so that a server span is started
+// for each request received and is finished when the handler returns.  It
+// calls ContextReceivedRequest() [using 'factory' if the request's
+// Context does not already contain a spans.Factory] so the handler's log
+// lines include the trace and span IDs.  After the handler returns, the
+// span's status is set from the response status code (200 if the handler
+// never set one) before the span is Finish()ed:
+//
+//      http.Handle("/", gcp.SpanHandler(mux, factory))
+//
+// If 'factory' is 'nil' and the Context has no Factory, then only
+// read-only span operations are possible [as described for
+// ContextReceivedRequest()].
+//
+func SpanHandler(h http.Handler, factory spans.Factory) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		if nil != factory && nil == spans.ContextGetSpan(ctx) {
+			ctx = spans.ContextStoreSpan(ctx, factory)
+		}
+		ctx, span := ContextReceivedRequest(ctx, req)
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, req.WithContext(ctx))
+		if 0 == rec.status {
+			rec.status = http.StatusOK
+		}
+		FinishSpan(span, lager.GcpFakeResponse(rec.status, 0, ""))
+	})
+}
+
+// NewChildSpanPairs() returns a Context whose trace/span pairs [see
+// ContextAddTrace()] are for a new child span.  Use it when a request fans
+// out into several operations done in parallel so that each operation's
+// log lines get their own span ID and can be told apart in Cloud Logging's
+// trace view:
+//
+//      for _, shard := range shards {
+//          go func(shard string) {
+//              ctx, span := gcp.NewChildSpanPairs(ctx)
+//              defer spans.FinishSpan(span)
+//              ...
+//          }(shard)
+//      }
+//
+// If 'ctx' contains a spans.Factory that is able to create a new span,
+// then that is used, the new span is stored in the returned Context [via
+// spans.ContextStoreSpan()], and it is returned so it can be Finish()ed.
+// Otherwise, a random span ID is used, keeping the trace from the trace
+// pair already in 'ctx' or from the Factory, and a 'nil' Factory is
+// returned.  If neither has a trace, then a new random trace ID is used
+// [which requires lager.GcpProjectID() to succeed; if it fails, then 'ctx'
+// is returned unchanged].
+//
+func NewChildSpanPairs(ctx context.Context) (context.Context, spans.Factory) {
+	span := spans.ContextGetSpan(ctx)
+	if nil != span {
+		if sub := span.NewSpan(); nil != sub && 0 != sub.GetSpanID() &&
+			!sub.GetStart().IsZero() {
+			ctx = spans.ContextStoreSpan(ctx, sub)
+			return ContextAddTrace(ctx, sub), sub
+		}
+	}
+	trace, _ := lager.ContextPairs(ctx).Get(lager.GcpTraceKey).(string)
+	if "" == trace && nil != span && "" != span.GetTraceID() {
+		trace = "projects/" + span.GetProjectID() +
+			"/traces/" + span.GetTraceID()
+	}
+	if "" == trace {
+		proj, err := lager.GcpProjectID(ctx)
+		if nil != err {
+			return ctx, nil
+		}
+		trace = "projects/" + proj + "/traces/" +
+			spans.HexSpanID(randomID()) + spans.HexSpanID(randomID())
+	}
+	return lager.AddPairs(ctx, lager.GcpTraceKey, trace,
+		lager.GcpSpanKey, spans.HexSpanID(randomID())), nil
+}
+
+// Returns a random, non-zero 64-bit ID.
+func randomID() uint64 {
+	var b [8]byte
+	for {
+		if _, err := rand.Read(b[:]); nil != err {
+			binary.BigEndian.PutUint64(b[:], uint64(time.Now().UnixNano()))
+		}
+		if id := binary.BigEndian.Uint64(b[:]); 0 != id {
+			return id
+		}
+	}
+}
+
+// Records the response status for SpanHandler().
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if 0 == sr.status {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(buf []byte) (int, error) {
+	if 0 == sr.status {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(buf)
+}
+
+// Flush() passes through to the wrapped http.ResponseWriter, if it is an
+// http.Flusher, so streaming responses still work.
+func (sr *statusRecorder) Flush() {
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		if 0 == sr.status {
+			sr.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Unwrap() returns the wrapped http.ResponseWriter so that an
+// http.ResponseController can find any other optional methods.
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// ContextSendingRequest() does several things that are useful when a
+// server is about to send a request to a dependent service.  'req' is the
+// Request that is about to be sent.  'ctx' is the server's current Context.
+//
+// The current span is fetched from 'ctx' [such as the one placed there
+// by ReceivedRequest() when the original request was received].  A new
+// sub-span is created, if possible.  If so, then it is marked as a "CLIENT"
+// span, its Display Name is set to lager.GetSpanPrefix() + ".out.request",
+// attributes for "http.url" and maybe "http.method" are added to it,
+// it is stored in the Context via spans.ContextStoreSpan(), the returned
+// Factory will contain the new span, and the updated Context will contain
+// 2 pairs (to be logged) from the new span.  Note that the original Context
+// is not (cannot be) modified, so the trace/span pair logged after the
+// request-sending function returns will revert to the prior span.
+//
+// If a span was found or created, then its CloudContext is added to the
+// headers for 'req' so that the dependent service can log it and add its
+// own spans to the trace (unless 'req' is 'nil').
+//
+// The updated Context is returned (Contexts are immutable).
+//
+// The order of arguments is 'req' then 'ctx' as information moves only
+// in the direction req <- ctx and information always moves right-to-left
+// in Go (in assignment statements and when using channels).
+//
+// It is usually called in a manner similar to:
+//
+//      ctx, span := gcp.ContextSendingRequest(req, ctx)
+//      defer spans.FinishSpan(span)
+//
+// See also SendingRequest().
+//
+func ContextSendingRequest(
+	req *http.Request, ctx context.Context,
+) (context.Context, spans.Factory) {
+	span := spans.ContextGetSpan(ctx)
+	if nil != span {
+		subspan := span.NewSpan()
+		if nil != subspan {
+			span = subspan
+			span.SetDisplayName(lager.GetSpanPrefix() + ".out.request")
+			span.SetIsClient()
+			if nil != req {
+				span.AddAttribute("http.url", lager.RequestUrl(req).String())
+				if "" != req.Method && "GET" != req.Method {
+					span.AddAttribute("http.method", req.Method)
+				}
+			}
+			ctx = spans.ContextStoreSpan(ctx, span)
+			ctx = ContextAddTrace(ctx, span)
+		}
+		if nil != req {
+			span.SetHeader(req.Header)
+		}
+	}
+	return ctx, span
+}
+
+// SendingNewRequest() does several things that are useful when a
+// server is about to send a request to a dependent service, by calling
+// ContextSendingRequest().  It takes the same arguments as
+// http.NewRequestWithContext() but returns extra values.
+//
+// It is usually called in a manner similar to:
+//
+//      req, ctx, span, err := gcp.SendingNewRequest(ctx, "GET", url, nil)
+//      if nil != err { ... }
+//      defer spans.FinishSpan(span)
+//
+// The returned Context is the same as 'req.Context()' but it is returned
+// separately to ease updating 'ctx' such as shown above.
+//
+func SendingNewRequest(
+	ctx context.Context, method, url string, body io.Reader,
+) (*http.Request, context.Context, spans.Factory, error) {
+	ctx, span := ContextSendingRequest(nil, ctx)
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if nil != err {
+		// ('span' will just get garbage collected and not registered.)
+		return nil, ctx, nil, err
+	}
+	if nil != span {
+		span.AddAttribute("http.url", lager.RequestUrl(req).String())
+		if "" != req.Method && "GET" != req.Method {
+			span.AddAttribute("http.method", req.Method)
+		}
+		span.SetHeader(req.Header)
+	}
+	return req, ctx, span, nil
+}
+
+// SendingRequest() does several things that are useful when a
+// server is about to send a request to a dependent service, by calling
+// ContextSendingRequest().  It uses the Context from '*pReq' and then
+// replaces '*pReq' with a copy of the original Request but with the new
+// Context attached.
+//
+// It is usually called in a manner similar to:
+//
+//      defer spans.FinishSpan(gcp.SendingRequest(&req))
+//
+func SendingRequest(pReq **http.Request) spans.Factory {
+	ctx, span := ContextSendingRequest(*pReq, (*pReq).Context())
+	*pReq = (*pReq).WithContext(ctx)
+	return span
+}
+
+// FinishSpan() updates a span with the status information from a
+// http.Response and Finish()es the span (which registers it with GCP).
+//
+func FinishSpan(span spans.Factory, resp *http.Response) time.Duration {
+	if nil == span || span.GetStart().IsZero() {
+		return time.Duration(0)
+	}
+	span.SetStatusCode(int64(resp.StatusCode))
+	if "" != resp.Status {
+		span.SetStatusMessage(resp.Status)
+	}
+	return span.Finish()
+}
+
+// SendingResponse() does several things that are useful when a server
+// is about to send a response to a request it received.  It combines
+// lager.GcpLogAccess() and FinishSpan().  The access log line written
+// will use the message "Sending response" and will include the passed-in
+// 'pairs' which should be zero or more pairs of a string key followed by
+// an arbitrary value.
+//
+// 'resp' will often be constructed via lager.GcpFakeResponse().
+//
+func SendingResponse(
+	span spans.Factory,
+	req *http.Request,
+	resp *http.Response,
+	pairs ...interface{},
+) {
+	var pStart *time.Time
+	if nil != span {
+		start := span.GetStart()
+		pStart = &start
+	}
+	lager.GcpLogAccess(req, resp, pStart).MMap(
+		"Sending response", lager.InlinePairs, pairs)
+	FinishSpan(span, resp)
+}
+
+// ReceivedResponse() combines lager.GcpLogAccess() and FinishSpan().
+// The access log line written will use the message "Received response"
+// and will include the passed-in 'pairs' which should be zero or more
+// pairs of a string key followed by an arbitrary value.  However, logging
+// every response received from a dependent service may be excessive.
+//
+func ReceivedResponse(
+	span spans.Factory,
+	req *http.Request,
+	resp *http.Response,
+	pairs ...interface{},
+) {
+	var pStart *time.Time
+	if nil != span {
+		start := span.GetStart()
+		pStart = &start
+	}
+	lager.GcpLogAccess(req, resp, pStart).MMap(
+		"Received response", lager.InlinePairs, pairs)
+	FinishSpan(span, resp)
+}
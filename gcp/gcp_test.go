@@ -0,0 +1,158 @@
+package gcp_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Unity-Technologies/go-lager-internal"
+	"github.com/Unity-Technologies/go-lager-internal/gcp"
+	"github.com/Unity-Technologies/go-lager-internal/gcp-spans"
+	"github.com/Unity-Technologies/go-tutl-internal"
+)
+
+// A span Factory that records what is done to the server span it creates.
+type serverSpan struct {
+	spans.ROSpan
+	name     string
+	server   bool
+	status   int64
+	finished bool
+}
+
+func (s *serverSpan) ImportFromHeaders(h http.Header) spans.Factory {
+	return &serverSpan{ROSpan: s.ROSpan.ImportFromHeaders(h).(spans.ROSpan)}
+}
+
+func (s *serverSpan) NewSpan() spans.Factory {
+	child := &serverSpan{ROSpan: s.ROSpan}
+	if "" == s.GetTraceID() {
+		im, _ := s.Import(strings.Repeat("0123456789abcdef", 2), 1)
+		child.ROSpan = im.(spans.ROSpan)
+	}
+	child.SetSpanID(77)
+	return child
+}
+
+func (s *serverSpan) GetStart() time.Time { return time.Now() }
+
+func (s *serverSpan) SetIsServer() spans.Factory { s.server = true; return s }
+
+func (s *serverSpan) SetDisplayName(n string) spans.Factory {
+	s.name = n
+	return s
+}
+
+func (s *serverSpan) SetStatusCode(c int64) spans.Factory {
+	s.status = c
+	return s
+}
+
+func (s *serverSpan) Finish() time.Duration { s.finished = true; return 0 }
+
+func TestSpanHandler(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	lager.Init("FWNA")
+	log := new(bytes.Buffer)
+	defer lager.SetOutput(log)()
+
+	factory := &serverSpan{ROSpan: spans.NewROSpan("proj")}
+	var inner spans.Factory
+	h := gcp.SpanHandler(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			inner = spans.ContextGetSpan(req.Context())
+			lager.Warn(req.Context()).List("working")
+			if "/bad" == req.URL.Path {
+				w.WriteHeader(503)
+			}
+		}), factory)
+
+	req := httptest.NewRequest("GET", "/bad", nil)
+	trace := strings.Repeat("fedcba9876543210", 2)
+	req.Header.Set(spans.TraceHeader, trace+"/5")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	span, ok := inner.(*serverSpan)
+	if u.Is(true, ok, "handler sees server span") {
+		u.Is(77, span.GetSpanID(), "span ID")
+		u.Is(trace, span.GetTraceID(), "imported trace ID")
+		u.Is(true, span.server, "span is SERVER")
+		u.Like(span.name, "display name", "*.in.request")
+		u.Is(503, span.status, "span status")
+		u.Is(true, span.finished, "span finished")
+	}
+	u.Like(log.String(), "trace logged", `"working"`,
+		`"logging.googleapis.com/spanId":"000000000000004d"`,
+		`"logging.googleapis.com/trace":"projects/proj/traces/fedcba98`)
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if span, ok = inner.(*serverSpan); u.Is(true, ok, "new trace") {
+		u.Is(strings.Repeat("0123456789abcdef", 2), span.GetTraceID(),
+			"new trace ID")
+		u.Is(200, span.status, "default status")
+		u.Is(true, span.finished, "new trace span finished")
+	}
+}
+
+func TestNewChildSpanPairs(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+
+	trace := strings.Repeat("fedcba9876543210", 2)
+	path := "projects/proj/traces/" + trace
+	im, _ := spans.NewROSpan("proj").Import(trace, 5)
+	ctx, span := gcp.NewChildSpanPairs(spans.ContextStoreSpan(
+		context.Background(), &serverSpan{ROSpan: im.(spans.ROSpan)}))
+	if u.Is(true, nil != span, "Factory span") {
+		u.Is(77, span.GetSpanID(), "sub-span ID")
+		u.Is(span, spans.ContextGetSpan(ctx), "sub-span stored")
+	}
+	u.Like(lager.MarshalPairs(ctx), "sub-span pairs", `*"`+path+`"`,
+		`*"000000000000004d"`)
+
+	ctx, span = gcp.NewChildSpanPairs(spans.ContextStoreSpan(
+		context.Background(), im))
+	u.Is(nil, span, "read-only span gives no Factory")
+	u.Like(lager.MarshalPairs(ctx), "random child", `*"`+path+`"`,
+		`!"0000000000000005"`)
+
+	parent := lager.AddPairs(context.Background(),
+		lager.GcpTraceKey, path, lager.GcpSpanKey, "0000000000000005")
+	ctx, span = gcp.NewChildSpanPairs(parent)
+	u.Is(nil, span, "no Factory")
+	ctx2, _ := gcp.NewChildSpanPairs(parent)
+	p1, p2 := lager.MarshalPairs(ctx), lager.MarshalPairs(ctx2)
+	u.Like(p1, "trace from pairs", `*"`+path+`"`)
+	u.Like(p1, "child has new span", `!"0000000000000005"`)
+	u.Is(false, string(p1) == string(p2), "children differ")
+
+	defer os.Unsetenv("GCP_PROJECT_ID")
+	os.Setenv("GCP_PROJECT_ID", "proj")
+	ctx, span = gcp.NewChildSpanPairs(context.Background())
+	u.Is(nil, span, "new trace no Factory")
+	u.Like(lager.MarshalPairs(ctx), "new trace", `*"projects/proj/traces/`,
+		`*"logging.googleapis.com/spanId":"`)
+}
+
+func TestContextAddTrace(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	bg := context.Background()
+
+	u.Is(bg, gcp.ContextAddTrace(bg, nil), "nil span")
+	im, _ := spans.NewROSpan("proj").Import(strings.Repeat("ab", 16), 9)
+	u.Like(lager.MarshalPairs(gcp.ContextAddTrace(bg, im)), "pairs",
+		`*"logging.googleapis.com/trace":"projects/proj/traces/abab`,
+		`*"logging.googleapis.com/spanId":"0000000000000009"`)
+
+	defer lager.RunningInEcs()() // Restores settings changed by:
+	lager.RunningInGcp()
+	u.Is(string(lager.MarshalPairs(gcp.ContextAddTrace(bg, im))),
+		string(lager.MarshalPairs(lager.ContextAddTrace(bg, im))),
+		"same as lager.ContextAddTrace")
+}
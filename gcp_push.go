@@ -0,0 +1,217 @@
+package lager
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The parts of a Pub/Sub push envelope that we log.
+type pubSubPush struct {
+	Message *struct {
+		Attributes  map[string]string `json:"attributes"`
+		MessageID   string            `json:"messageId"`
+		PublishTime string            `json:"publishTime"`
+		OrderingKey string            `json:"orderingKey"`
+	} `json:"message"`
+	Subscription    string `json:"subscription"`
+	DeliveryAttempt int    `json:"deliveryAttempt"`
+}
+
+// The largest request body we will examine for a Pub/Sub envelope (big
+// enough for a 10MiB message after base64 encoding).
+const maxPushBody = 14 << 20
+
+// Records the response status for GcpPushHandler() [and the response size
+// for AccessLogHandler()].
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	size    int64 // How many bytes of the body were written.
+	failure error // The first error from writing the body (if any).
+
+	// The size before compression [see SetUncompressedSize()]; -1 if not
+	// known.
+	rawSize int64
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if 0 == sr.status {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(buf []byte) (int, error) {
+	if 0 == sr.status {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(buf)
+	sr.size += int64(n)
+	if nil != err && nil == sr.failure {
+		sr.failure = err
+	}
+	return n, err
+}
+
+// Flush() passes through to the wrapped http.ResponseWriter, if it is an
+// http.Flusher, so streaming responses still work.
+func (sr *statusRecorder) Flush() {
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		if 0 == sr.status {
+			sr.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Hijack() passes through to the wrapped http.ResponseWriter, if it is an
+// http.Hijacker, so protocols like WebSockets still work.
+func (sr *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := sr.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// Unwrap() returns the wrapped http.ResponseWriter so that an
+// http.ResponseController can find any other optional methods.
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// GcpPushHandler() wraps an http.Handler that receives Pub/Sub push
+// subscription deliveries and/or Cloud Tasks (or App Engine task queue)
+// HTTP requests.  Details about the message or task are added as pairs to
+// the request's Context so they are included in any log lines that the
+// handler writes using that Context:
+//
+//      http.Handle("/push", lager.GcpPushHandler(http.HandlerFunc(handle)))
+//
+// For a Pub/Sub push (a POST of a JSON body containing "message" and
+// "subscription"), a "pubsub" pair is added with a map of "messageId",
+// "subscription", "publishTime", and, if present, "orderingKey" and
+// "deliveryAttempt".  If the message has a "lager" attribute, it is
+// restored via UnmarshalPairs() [see MarshalPairs()].  The request body is
+// still available to the handler.
+//
+// For a Cloud Task (a request with X-CloudTasks-* or X-AppEngine-Task*
+// headers), a "task" pair is added with a map of "queue", "name",
+// "retryCount", "executionCount", and, if present, "eta".
+//
+// After the handler returns, an access log entry is written at the Acc
+// level describing the logical message or task (rather than the POST that
+// delivered it), including the response "status", whether the message was
+// acknowledged ("acked", any 2xx status), and the "latency".  For Pub/Sub,
+// "delay" records how long after publishing the handling finished.
+// Requests that are neither are passed to the handler unchanged.
+//
+func GcpPushHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		ctx, kind, published := req.Context(), "", time.Time{}
+		if task := cloudTaskPairs(req); nil != task {
+			ctx, kind = AddPairs(ctx, "task", task), "Cloud Task"
+		} else if push := pubSubEnvelope(req); nil != push {
+			ctx, kind = pubSubContext(ctx, push)
+			published, _ = time.Parse(time.RFC3339Nano, push.Message.PublishTime)
+		}
+		if "" == kind {
+			h.ServeHTTP(w, req)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, req.WithContext(ctx))
+		if 0 == rec.status {
+			rec.status = http.StatusOK
+		}
+		now := time.Now()
+		Acc(ctx).MMap(kind+" handled",
+			"status", rec.status,
+			"acked", 200 <= rec.status && rec.status < 300,
+			"latency", fmt.Sprintf("%.4fs", now.Sub(start).Seconds()),
+			Unless(published.IsZero(), "delay"),
+			fmt.Sprintf("%.4fs", now.Sub(published).Seconds()),
+		)
+	})
+}
+
+// Returns the pairs describing a Cloud Task request or 'nil' if 'req' is
+// not one.
+func cloudTaskPairs(req *http.Request) AMap {
+	prefix := "X-Cloudtasks-Task"
+	queue := req.Header.Get("X-CloudTasks-QueueName")
+	if "" == queue {
+		prefix = "X-Appengine-Task"
+		queue = req.Header.Get("X-AppEngine-QueueName")
+	}
+	if "" == queue {
+		return nil
+	}
+	hdr := func(name string) string { return req.Header.Get(prefix + name) }
+	count := func(name string) interface{} {
+		if n, err := strconv.Atoi(hdr(name)); nil == err {
+			return n
+		}
+		return nil
+	}
+	task := Pairs(
+		"queue", queue,
+		"name", hdr("Name"),
+		"retryCount", count("RetryCount"),
+		"executionCount", count("ExecutionCount"),
+	)
+	if secs, err := strconv.ParseFloat(hdr("ETA"), 64); nil == err {
+		whole, frac := math.Modf(secs)
+		task = task.AddPairs("eta",
+			time.Unix(int64(whole), int64(frac*1e9)).UTC().Format(
+				time.RFC3339Nano))
+	}
+	return task
+}
+
+// Returns the parsed Pub/Sub push envelope or 'nil' if 'req' is not one.
+// If the body gets read, then req.Body is replaced so it can be read again.
+func pubSubEnvelope(req *http.Request) *pubSubPush {
+	if "POST" != req.Method || nil == req.Body ||
+		!strings.Contains(req.Header.Get("Content-Type"), "json") ||
+		maxPushBody < req.ContentLength {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxPushBody))
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+	push := new(pubSubPush)
+	if nil != err || maxPushBody <= len(body) ||
+		nil != json.Unmarshal(body, push) ||
+		nil == push.Message || "" == push.Subscription {
+		return nil
+	}
+	return push
+}
+
+// Adds the pairs describing a Pub/Sub push delivery to 'ctx'.
+func pubSubContext(ctx Ctx, push *pubSubPush) (Ctx, string) {
+	msg := push.Message
+	if saved, ok := msg.Attributes["lager"]; ok {
+		ctx = UnmarshalPairs(ctx, []byte(saved))
+	}
+	return AddPairs(ctx, "pubsub", Map(
+		"messageId", msg.MessageID,
+		"subscription", push.Subscription,
+		"publishTime", msg.PublishTime,
+		Unless("" == msg.OrderingKey, "orderingKey"), msg.OrderingKey,
+		Unless(0 == push.DeliveryAttempt, "deliveryAttempt"),
+		push.DeliveryAttempt,
+	)), "Pub/Sub message"
+}
@@ -0,0 +1,72 @@
+package lager_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Unity-Technologies/go-lager-internal"
+	"github.com/Unity-Technologies/go-tutl-internal"
+)
+
+func TestGcpPushHandler(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	lager.Init("FWNA")
+	log := new(bytes.Buffer)
+	defer lager.SetOutput(log)()
+
+	var body string
+	h := lager.GcpPushHandler(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			b, _ := io.ReadAll(req.Body)
+			body = string(b)
+			lager.Warn(req.Context()).List("working")
+			if strings.Contains(body, "bad") {
+				w.WriteHeader(500)
+			}
+		}))
+
+	push := `{"message":{"attributes":{"lager":"{\"trace\":\"t1\"}"},` +
+		`"data":"aGk=","messageId":"42",` +
+		`"publishTime":"2021-02-03T04:05:06.789Z"},` +
+		`"subscription":"projects/p/subscriptions/s","deliveryAttempt":3}`
+	req := httptest.NewRequest("POST", "/push", strings.NewReader(push))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+	u.Is(push, body, "body still readable")
+	lines := strings.Split(log.String(), "\n")
+	if u.Is(3, len(lines), "log lines") {
+		u.Like(lines[0], "handler line", `"working"`, `"trace":"t1"`,
+			`"pubsub":{"messageId":"42", "subscription":"projects/p/subs`,
+			`"publishTime":"2021-02-03T04:05:06.789Z", "deliveryAttempt":3}`)
+		u.Like(lines[1], "access line", `"ACCESS", "Pub/Sub message handled"`,
+			`"status":200, "acked":true, "latency":"0.0`, `"delay":"`,
+			`"messageId":"42"`)
+		u.Like(lines[1], "no POST wrapper", `!requestMethod`)
+	}
+	log.Reset()
+
+	req = httptest.NewRequest("POST", "/task", strings.NewReader("bad"))
+	req.Header.Set("X-CloudTasks-QueueName", "q")
+	req.Header.Set("X-CloudTasks-TaskName", "t7")
+	req.Header.Set("X-CloudTasks-TaskRetryCount", "1")
+	req.Header.Set("X-CloudTasks-TaskExecutionCount", "2")
+	req.Header.Set("X-CloudTasks-TaskETA", "1612325106.5")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	u.Like(log.String(), "task",
+		`"task":{"queue":"q", "name":"t7", "retryCount":1,`+
+			` "executionCount":2, "eta":"2021-02-03T04:05:06.5Z"}`,
+		`"Cloud Task handled", {"status":500, "acked":false`)
+	log.Reset()
+
+	req = httptest.NewRequest("POST", "/push", strings.NewReader(`{"a":1}`))
+	req.Header.Set("Content-Type", "application/json")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	u.Is(`{"a":1}`, body, "other body still readable")
+	u.Like(log.String(), "plain request", `"working"`, `!handled`, `!pubsub`)
+}
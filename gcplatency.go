@@ -0,0 +1,98 @@
+package lager
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// SetGcpLatencyFormat() selects how GcpHttp() [and so GcpLogAccess()]
+// logs the "latency" of a request, for ingestion paths that are strict
+// about the format of GCP's httpRequest.latency (which is a protobuf
+// Duration).  'format' must be one of:
+//
+//      ""          The default, seconds with 4 decimal places: "1.2700s"
+//      "duration"  A protobuf JSON Duration string, which has 0, 3, 6, or
+//                  9 decimal places: "1.270s"
+//      "object"    A protobuf Duration object:
+//                  {"seconds":1, "nanos":270000000}
+//
+// Any other 'format' causes a panic().  It returns a function that
+// restores the prior format:
+//
+//      defer lager.SetGcpLatencyFormat("duration")()
+//
+// Setting LAGER_GCP_LATENCY in the environment to one of the formats has
+// the same effect as calling SetGcpLatencyFormat() when the program
+// starts.
+//
+func SetGcpLatencyFormat(format string) func() {
+	if err := checkGcpLatency(format); nil != err {
+		panic(err.Error())
+	}
+	var prior string
+	updateGlobals(func(g *globals) {
+		prior = g.gcpLatency
+		g.gcpLatency = format
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.gcpLatency = prior
+		})
+	}
+}
+
+// Returns an error if 'format' is not a valid latency format.
+func checkGcpLatency(format string) error {
+	switch format {
+	case "", "duration", "object":
+		return nil
+	}
+	return fmt.Errorf("latency format must be \"\", \"duration\", or"+
+		" \"object\" not %q", format)
+}
+
+// Sets the initial latency format from LAGER_GCP_LATENCY.
+func envGcpLatency(g *globals) {
+	env := os.Getenv("LAGER_GCP_LATENCY")
+	if err := checkGcpLatency(env); nil != err {
+		initFatal(g,
+			"Invalid LAGER_GCP_LATENCY", "error", err, "got", env)
+		return
+	}
+	g.gcpLatency = env
+}
+
+// Returns the value to log for a latency [see SetGcpLatencyFormat()].
+func gcpLatency(d time.Duration) interface{} {
+	switch getGlobals().gcpLatency {
+	case "duration":
+		return durationString(d)
+	case "object":
+		return Map("seconds", int64(d/time.Second),
+			"nanos", int64(d%time.Second))
+	}
+	return fmt.Sprintf("%.4fs", d.Seconds())
+}
+
+// Returns a duration in the format of a protobuf JSON Duration, with 0, 3,
+// 6, or 9 decimal places, like "1.270s".
+func durationString(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	secs, nanos := int64(d/time.Second), int64(d%time.Second)
+	s := sign + strconv.FormatInt(secs, 10)
+	switch {
+	case 0 == nanos:
+	case 0 == nanos%1e6:
+		s += fmt.Sprintf(".%03d", nanos/1e6)
+	case 0 == nanos%1e3:
+		s += fmt.Sprintf(".%06d", nanos/1e3)
+	default:
+		s += fmt.Sprintf(".%09d", nanos)
+	}
+	return s + "s"
+}
@@ -0,0 +1,81 @@
+package lager
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SetGlobalPairs() sets key/value pairs (such as deployment metadata) that
+// are added to the context pairs of every log line, so they do not have to
+// be added to every context.Context:
+//
+//      defer lager.SetGlobalPairs(
+//          "service", "billing-api", "version", version, "env", "prod")()
+//
+//      ["2021-06-09 13:10:07.0447Z", "INFO", "Charged card", {"amt":12},
+//          {"service":"billing-api", "version":"1.4.2", "env":"prod",
+//          "trace":"..."}]
+//
+// The global pairs come before any other context pairs, and a context pair
+// with the same key replaces the global pair for that line.  The pairs can
+// include Fields [like Str()].  Calling it with no pairs removes the global
+// pairs.  It returns a function that restores the prior pairs.
+//
+// Setting LAGER_GLOBAL_PAIRS in the environment to a comma-separated list
+// of {key}={value} pairs, like "service=billing-api,env=prod", has the
+// same effect as calling SetGlobalPairs() when the program starts.
+//
+func SetGlobalPairs(pairs ...interface{}) func() {
+	kvp := flatPairs(nil, pairs)
+	if nil != kvp && 0 == len(kvp.keys) {
+		kvp = nil
+	}
+	var prior AMap
+	updateGlobals(func(g *globals) {
+		prior = g.globalPairs
+		g.globalPairs = kvp
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.globalPairs = prior
+		})
+	}
+}
+
+// Sets the initial global pairs from LAGER_GLOBAL_PAIRS.
+func envGlobalPairs(g *globals) {
+	env := os.Getenv("LAGER_GLOBAL_PAIRS")
+	if "" == env {
+		return
+	}
+	var pairs []interface{}
+	for _, part := range strings.Split(env, ",") {
+		eq := strings.Index(part, "=")
+		key := ""
+		if 0 < eq {
+			key = strings.TrimSpace(part[:eq])
+		}
+		if "" == key {
+			initFatal(g, "Invalid LAGER_GLOBAL_PAIRS",
+				"error", fmt.Errorf("expected {key}={value} not %q", part),
+				"got", env)
+			return
+		}
+		pairs = append(pairs, key, strings.TrimSpace(part[eq+1:]))
+	}
+	g.globalPairs = AMap(nil).AddPairs(pairs...)
+}
+
+// Returns the host pairs [see AddHostPairs()] and global pairs.
+func (g *globals) basePairs() AMap {
+	return g.hostPairs.Merge(g.globalPairs)
+}
+
+// Returns a copy of the logger with the host and global pairs placed
+// before its context pairs [see SetGlobalPairs()].
+func (l *logger) withGlobalPairs() *logger {
+	cp := *l
+	cp.kvp = l.g.basePairs().Merge(cp.kvp)
+	return &cp
+}
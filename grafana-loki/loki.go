@@ -0,0 +1,227 @@
+/*
+Package loki provides a Lager output that pushes log lines to Grafana Loki
+via its "/loki/api/v1/push" endpoint, so Lager can be used in Loki-based
+stacks without a sidecar to ship logs:
+
+	sink, err := loki.New(loki.Config{
+		URL:    "http://loki:3100/loki/api/v1/push",
+		Labels: map[string]string{"app": "billing", "env": "prod"},
+	})
+	if nil != err {
+		lager.Exit().MMap("Can't create Loki sink", "err", err)
+	}
+	defer sink.Close()
+	defer lager.SetOutput(sink)()
+
+Each line is assigned to a Loki stream based on the static labels plus
+labels for the line's log level and module.  Lines are sent in batches
+and failed batches are retried.
+*/
+package loki
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Unity-Technologies/go-lager-internal/batch"
+)
+
+// Config describes where and how to push log lines.
+type Config struct {
+	// The URL of the push endpoint (ending in "/loki/api/v1/push").
+	// Required.
+	URL string
+
+	// Static labels added to every stream.
+	Labels map[string]string
+
+	// The label names to use for the log level (default "level") and the
+	// module name (default "module").  Use "-" to omit either label.
+	LevelLabel, ModuleLabel string
+
+	// When lines are logged as JSON maps [see lager.Keys()], the key that
+	// holds the log level.  The default is to check for "level",
+	// "severity", "severityLevel", and "log.level" [see batch.LineLevel()].
+	LevelKey string
+
+	// When lines are logged as JSON maps [see lager.Keys()], the key that
+	// holds the module name.  The default is to check for "mod" and then
+	// "module".  For JSON lists, the trailing "mod=..." value is used.
+	ModuleKey string
+
+	// If not "", sent as the "X-Scope-OrgID" header (for multi-tenant Loki).
+	TenantID string
+
+	// If Username is not "", then HTTP Basic authentication is used.
+	Username, Password string
+
+	// The http.Client to use (default is http.DefaultClient).
+	Client *http.Client
+
+	// How lines are batched and retried.
+	batch.Options
+}
+
+// Sink is an io.Writer suitable for passing to lager.SetOutput().
+type Sink struct {
+	*batch.Writer
+	conf Config
+}
+
+// The JSON structure Loki expects for each stream.
+type stream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// New() returns a Sink that pushes log lines as configured.  Call Close()
+// on it when done so that any pending lines get sent.
+//
+func New(conf Config) (*Sink, error) {
+	if "" == conf.URL {
+		return nil, fmt.Errorf("loki.New() requires a URL")
+	}
+	if "" == conf.LevelLabel {
+		conf.LevelLabel = "level"
+	}
+	if "" == conf.ModuleLabel {
+		conf.ModuleLabel = "module"
+	}
+	if nil == conf.Client {
+		conf.Client = http.DefaultClient
+	}
+	s := &Sink{conf: conf}
+	s.Writer = batch.New(s.send, conf.Options)
+	return s, nil
+}
+
+// Pushes one batch of lines to Loki.
+func (s *Sink) send(lines [][]byte) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"streams": s.streams(lines),
+	})
+	if nil != err {
+		return batch.Permanent(err)
+	}
+	req, err := http.NewRequest("POST", s.conf.URL, bytes.NewReader(body))
+	if nil != err {
+		return batch.Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if "" != s.conf.TenantID {
+		req.Header.Set("X-Scope-OrgID", s.conf.TenantID)
+	}
+	if "" != s.conf.Username {
+		req.SetBasicAuth(s.conf.Username, s.conf.Password)
+	}
+	resp, err := s.conf.Client.Do(req)
+	if nil != err {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if 200 <= resp.StatusCode && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("Loki returned %s: %s",
+		resp.Status, strings.TrimSpace(string(msg)))
+	if 400 <= resp.StatusCode && resp.StatusCode < 500 &&
+		http.StatusTooManyRequests != resp.StatusCode {
+		return batch.Permanent(err)
+	}
+	return err
+}
+
+// Groups lines into streams by their labels, preserving order.
+func (s *Sink) streams(lines [][]byte) []*stream {
+	streams := make([]*stream, 0, 1)
+	byKey := make(map[string]*stream)
+	for _, line := range lines {
+		labels := s.labels(line)
+		key := labelKey(labels)
+		st := byKey[key]
+		if nil == st {
+			st = &stream{Stream: labels}
+			byKey[key] = st
+			streams = append(streams, st)
+		}
+		t, ok := batch.LineTime(line)
+		if !ok {
+			t = time.Now()
+		}
+		st.Values = append(st.Values,
+			[2]string{strconv.FormatInt(t.UnixNano(), 10), string(line)})
+	}
+	return streams
+}
+
+// Computes the stream labels for one line.
+func (s *Sink) labels(line []byte) map[string]string {
+	labels := make(map[string]string, len(s.conf.Labels)+2)
+	for k, v := range s.conf.Labels {
+		labels[k] = v
+	}
+	lev, mod := s.levelAndModule(line)
+	if "" != lev && "-" != s.conf.LevelLabel {
+		labels[s.conf.LevelLabel] = lev
+	}
+	if "" != mod && "-" != s.conf.ModuleLabel {
+		labels[s.conf.ModuleLabel] = mod
+	}
+	return labels
+}
+
+// Extracts the level and module name from a Lager log line.
+func (s *Sink) levelAndModule(line []byte) (lev, mod string) {
+	var parsed interface{}
+	if nil != json.Unmarshal(line, &parsed) {
+		return "", ""
+	}
+	switch v := parsed.(type) {
+	case []interface{}:
+		if 1 < len(v) {
+			lev = fmt.Sprint(v[1])
+		}
+		if last, ok := v[len(v)-1].(string); ok && 2 < len(v) &&
+			strings.HasPrefix(last, "mod=") {
+			mod = last[4:]
+		}
+	case map[string]interface{}:
+		lev, _ = batch.LineLevel(line, s.conf.LevelKey)
+		keys := []string{"mod", "module"}
+		if "" != s.conf.ModuleKey {
+			keys = []string{s.conf.ModuleKey}
+		}
+		for _, k := range keys {
+			if m, ok := v[k].(string); ok {
+				mod = m
+				break
+			}
+		}
+	}
+	return lev, mod
+}
+
+// Returns a string that is the same only for identical label sets.
+func labelKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(strconv.Quote(k))
+		b.WriteString("=")
+		b.WriteString(strconv.Quote(labels[k]))
+		b.WriteString(",")
+	}
+	return b.String()
+}
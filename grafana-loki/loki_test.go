@@ -0,0 +1,147 @@
+package loki_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Unity-Technologies/go-lager-internal"
+	"github.com/Unity-Technologies/go-lager-internal/batch"
+	"github.com/Unity-Technologies/go-lager-internal/grafana-loki"
+	"github.com/Unity-Technologies/go-tutl-internal"
+)
+
+type push struct {
+	Streams []struct {
+		Stream map[string]string
+		Values [][2]string
+	}
+}
+
+func TestSink(t *testing.T) {
+	u := tutl.New(t)
+
+	var mu sync.Mutex
+	pushes := make([]push, 0)
+	tenants := make([]string, 0)
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			defer lager.AutoLock(&mu)()
+			tenants = append(tenants, r.Header.Get("X-Scope-OrgID"))
+			var p push
+			if u.Is(nil, json.NewDecoder(r.Body).Decode(&p), "valid push") {
+				pushes = append(pushes, p)
+			}
+			w.WriteHeader(204)
+		}))
+	defer srv.Close()
+
+	_, err := loki.New(loki.Config{})
+	u.Like(err, "no URL", "*requires a URL")
+
+	sink, err := loki.New(loki.Config{
+		URL: srv.URL, TenantID: "team", Labels: map[string]string{"app": "x"},
+		Options: batch.Options{Interval: time.Hour},
+	})
+	if !u.Is(nil, err, "New") {
+		return
+	}
+	defer lager.Keys(lager.GetKeys())
+	lager.Keys("", "", "", "", "", "")
+	defer lager.SetLevels("FWI")()
+	restore := lager.SetOutput(sink)
+	lager.Fail().List("one")
+	lager.NewModule("db").Warn().MMap("two", "n", 2)
+	lager.Fail().List("three")
+	restore()
+	u.Is(nil, sink.Close(), "Close")
+
+	defer lager.AutoLock(&mu)()
+	if !u.Is(1, len(pushes), "pushes") {
+		return
+	}
+	u.Is("team", tenants[0], "tenant header")
+	st := pushes[0].Streams
+	if !u.Is(2, len(st), "streams") {
+		return
+	}
+	u.Is(`map[app:x level:FAIL]`, st[0].Stream, "stream 0 labels")
+	u.Is(`map[app:x level:WARN module:db]`, st[1].Stream, "stream 1 labels")
+	u.Is(2, len(st[0].Values), "stream 0 lines")
+	u.Like(st[0].Values[1][1], "line", `*"three"`)
+	ns, _ := strconv.ParseInt(st[0].Values[0][0], 10, 64)
+	u.Circa(3, float64(time.Now().UnixNano()), float64(ns), "time")
+}
+
+func TestMapLabels(t *testing.T) {
+	u := tutl.New(t)
+	var p push
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&p)
+		}))
+	defer srv.Close()
+
+	sink, _ := loki.New(loki.Config{URL: srv.URL, LevelLabel: "-"})
+	defer lager.Keys(lager.GetKeys())
+	lager.Keys("t", "sev", "msg", "data", "", "component")
+	restore := lager.SetOutput(sink)
+	lager.NewModule("cache").Warn().MMap("miss")
+	restore()
+	sink.Close()
+	if u.Is(1, len(p.Streams), "streams") {
+		u.Is(`map[]`, p.Streams[0].Stream, "component key not found")
+	}
+
+	sink, _ = loki.New(loki.Config{URL: srv.URL, ModuleKey: "component"})
+	restore = lager.SetOutput(sink)
+	lager.NewModule("cache").Warn().MMap("miss")
+	restore()
+	sink.Close()
+	if u.Is(1, len(p.Streams), "streams") {
+		u.Is(`map[level:WARN module:cache]`, p.Streams[0].Stream, "map labels")
+	}
+}
+
+func TestEpochLines(t *testing.T) {
+	u := tutl.New(t)
+	var p push
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&p)
+		}))
+	defer srv.Close()
+
+	sink, _ := loki.New(loki.Config{URL: srv.URL})
+	defer lager.Keys(lager.GetKeys())
+	lager.Keys("time", "severity", "msg", "data", "", "module")
+	restore := lager.SetOutput(sink)
+	undo := lager.SetEpochTimestamp("", false)
+	lager.Warn().MMap("both")
+	undo()
+	undo = lager.SetEpochTimestamp("", true)
+	lager.Warn().MMap("only")
+	undo()
+	restore()
+	sink.Close()
+	if !u.Is(1, len(p.Streams), "streams") {
+		return
+	}
+	u.Is(`map[level:WARN]`, p.Streams[0].Stream, "level label")
+	if !u.Is(2, len(p.Streams[0].Values), "lines") {
+		return
+	}
+	var both, only map[string]interface{}
+	json.Unmarshal([]byte(p.Streams[0].Values[0][1]), &both)
+	json.Unmarshal([]byte(p.Streams[0].Values[1][1]), &only)
+	when, _ := time.Parse(time.RFC3339Nano, both["time"].(string))
+	u.Is(strconv.FormatInt(when.UnixNano(), 10), p.Streams[0].Values[0][0],
+		"string time with epoch pair")
+	ms, _ := only["time"].(float64)
+	u.Is(strconv.FormatInt(int64(ms)*1e6, 10), p.Streams[0].Values[1][0],
+		"epoch time")
+}
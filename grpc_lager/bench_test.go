@@ -0,0 +1,73 @@
+package grpc_lager_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/Unity-Technologies/go-lager-internal"
+	"github.com/Unity-Technologies/go-lager-internal/grpc_lager"
+	pb_testproto "github.com/Unity-Technologies/go-lager-internal/grpc_lager/testproto"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"google.golang.org/grpc"
+)
+
+var benchInfo = &grpc.UnaryServerInfo{FullMethod: "/grpc_lager.testproto.TestService/Ping"}
+
+func benchHandler(ctx context.Context, req interface{}) (interface{}, error) {
+	return &pb_testproto.PingResponse{Value: req.(*pb_testproto.PingRequest).Value, Counter: 42}, nil
+}
+
+// The interceptor chains whose per-call overhead is measured, with the most allocations each call may make.
+var benchChains = []struct {
+	name   string
+	chain  grpc.UnaryServerInterceptor
+	budget float64
+}{
+	{"TagsOnly", grpc_middleware.ChainUnaryServer(
+		grpc_ctxtags.UnaryServerInterceptor(),
+	), 8},
+	{"CallLog", grpc_middleware.ChainUnaryServer(
+		grpc_ctxtags.UnaryServerInterceptor(),
+		grpc_lager.UnaryServerInterceptor(),
+	), 50},
+	{"PayloadLog", grpc_middleware.ChainUnaryServer(
+		grpc_ctxtags.UnaryServerInterceptor(),
+		grpc_lager.UnaryServerInterceptor(),
+		grpc_lager.PayloadUnaryServerInterceptor(
+			func(context.Context, string, interface{}) bool { return true }),
+	), 125},
+}
+
+func benchCall(chain grpc.UnaryServerInterceptor) {
+	chain(context.Background(), goodPing, benchInfo, benchHandler)
+}
+
+func BenchmarkInterceptors(b *testing.B) {
+	defer lager.SetOutput(io.Discard)()
+	lager.Init("FWNAI")
+	defer lager.Init("FWNA")
+	for _, bc := range benchChains {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				benchCall(bc.chain)
+			}
+		})
+	}
+}
+
+// Makes sure that the overhead of each interceptor chain stays within its allocation budget.
+func TestInterceptorAllocs(t *testing.T) {
+	defer lager.SetOutput(io.Discard)()
+	lager.Init("FWNAI")
+	defer lager.Init("FWNA")
+	for _, bc := range benchChains {
+		allocs := testing.AllocsPerRun(100, func() { benchCall(bc.chain) })
+		t.Logf("%s: %.0f allocations per call", bc.name, allocs)
+		if bc.budget < allocs {
+			t.Errorf("%s makes %.0f allocations per call, over its budget of %.0f", bc.name, allocs, bc.budget)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/Unity-Technologies/go-lager-internal"
+	"github.com/Unity-Technologies/go-lager-internal/gcp-spans"
 	grpc_logging "github.com/grpc-ecosystem/go-grpc-middleware/logging"
 	"google.golang.org/grpc/codes"
 )
@@ -27,6 +28,7 @@ type options struct {
 	durationFunc    DurationToPairs
 	messageFunc     MessageProducer
 	timestampFormat string
+	spanFactory     spans.Factory
 }
 
 func evaluateServerOpt(opts []Option) *options {
@@ -90,6 +92,15 @@ func WithTimestampFormat(format string) Option {
 	}
 }
 
+// WithSpanFactory starts a server span (using the passed-in spans.Factory unless the call's Context already
+// holds one) for each call, adds its trace and span IDs to the call's Context via gcp.ContextAddTrace,
+// and finishes the span with the call's gRPC status code once the handler returns.
+func WithSpanFactory(f spans.Factory) Option {
+	return func(o *options) {
+		o.spanFactory = f
+	}
+}
+
 // DefaultCodeToLevel is the default implementation of gRPC return codes and interceptor log level for server side.
 func DefaultCodeToLevel(code codes.Code) byte {
 	switch code {
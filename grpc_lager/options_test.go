@@ -2,12 +2,20 @@ package grpc_lager_test
 
 import (
 	"context"
+	"io"
+	"net/http"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/Unity-Technologies/go-lager-internal"
+	"github.com/Unity-Technologies/go-lager-internal/gcp-spans"
 	"github.com/Unity-Technologies/go-lager-internal/grpc_lager"
 	"github.com/Unity-Technologies/go-tutl-internal"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 func TestDurationToTimeMillisField(t *testing.T) {
@@ -18,3 +26,61 @@ func TestDurationToTimeMillisField(t *testing.T) {
 
 	u.Is(expectedCtx, ctx, "sub millisecond values in context should be correct")
 }
+
+// A span Factory that records what is done to the server span it creates.
+type callSpan struct {
+	spans.ROSpan
+	name     string
+	status   int64
+	msg      string
+	finished bool
+}
+
+func (s *callSpan) ImportFromHeaders(h http.Header) spans.Factory {
+	return &callSpan{ROSpan: s.ROSpan.ImportFromHeaders(h).(spans.ROSpan)}
+}
+
+func (s *callSpan) NewSpan() spans.Factory {
+	child := &callSpan{ROSpan: s.ROSpan}
+	child.SetSpanID(9)
+	return child
+}
+
+func (s *callSpan) GetStart() time.Time { return time.Now() }
+
+func (s *callSpan) SetDisplayName(n string) spans.Factory { s.name = n; return s }
+func (s *callSpan) SetStatusCode(c int64) spans.Factory   { s.status = c; return s }
+func (s *callSpan) SetStatusMessage(m string) spans.Factory {
+	s.msg = m
+	return s
+}
+func (s *callSpan) Finish() time.Duration { s.finished = true; return 0 }
+
+func TestWithSpanFactory(t *testing.T) {
+	u := tutl.New(t)
+	defer lager.SetOutput(io.Discard)()
+
+	trace := strings.Repeat("0123456789abcdef", 2)
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs("x-cloud-trace-context", trace+"/5"))
+	var inner context.Context
+	intercept := grpc_lager.UnaryServerInterceptor(
+		grpc_lager.WithSpanFactory(&callSpan{ROSpan: spans.NewROSpan("proj")}))
+	_, err := intercept(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/pkg.Svc/Ping"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			inner = ctx
+			return nil, status.Error(codes.NotFound, "no such thing")
+		})
+	u.Is(true, nil != err, "handler error returned")
+	span, ok := spans.ContextGetSpan(inner).(*callSpan)
+	if u.Is(true, ok, "handler sees server span") {
+		u.Is(trace, span.GetTraceID(), "imported trace ID")
+		u.Is(9, span.GetSpanID(), "span ID")
+		u.Like(span.name, "display name", "*.in.pkg.Svc/Ping")
+		u.Is(int64(codes.NotFound), span.status, "span status")
+		u.Like(span.msg, "span message", "*no such thing")
+		u.Is(true, span.finished, "span finished")
+	}
+	u.Like(lager.ContextPairs(inner), "trace pairs",
+		"*projects/proj/traces/"+trace, "*0000000000000009")
+}
@@ -2,6 +2,8 @@ package grpc_lager
 
 import (
 	"context"
+	"path"
+	"sync"
 
 	"github.com/Unity-Technologies/go-lager-internal"
 	"google.golang.org/grpc"
@@ -42,6 +44,207 @@ func PayloadUnaryServerInterceptor(decider ServerPayloadLoggingDecider) grpc.Una
 	}
 }
 
+// PayloadOption customizes how PayloadStreamServerInterceptor logs the messages of a stream.
+type PayloadOption func(*payloadOptions)
+
+type payloadOptions struct {
+	first, last int
+}
+
+// WithStreamPayloadLimit logs only the first 'first' and the last 'last' messages received and sent on a stream
+// (counted separately for each direction), so long streams do not produce unbounded payload logs.  The last
+// messages are logged when the stream ends.  If both are 0 (the default), every message is logged.
+func WithStreamPayloadLimit(first, last int) PayloadOption {
+	return func(o *payloadOptions) {
+		o.first, o.last = first, last
+	}
+}
+
+// PayloadStreamServerInterceptor logs the payloads of the messages received and sent on server-side streams.
+// Each message is tagged with its direction (`grpc.msg_direction` of "recv" or "send") and its ordinal in that
+// direction (`grpc.msg_seq`, starting at 1).
+func PayloadStreamServerInterceptor(decider ServerPayloadLoggingDecider, opts ...PayloadOption) grpc.StreamServerInterceptor {
+	o := payloadOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := stream.Context()
+		if !decider(ctx, info.FullMethod, srv) {
+			return handler(srv, stream)
+		}
+
+		loggerCtx := lager.ContextPairs(TagsToPairs(ctx)).Merge(serverCallFields(info.FullMethod)).InContext(ctx)
+		ps := &payloadServerStream{ServerStream: stream}
+		ps.payloadLog = payloadLog{logEntry: lager.Acc(loggerCtx), opts: o, dirs: &serverPayloadDirs}
+		err := handler(srv, ps)
+		ps.logLast()
+		return err
+	}
+}
+
+// ClientPayloadLoggingDecider is a user-provided function for deciding whether to log the client-side
+// request/response payloads
+type ClientPayloadLoggingDecider func(ctx context.Context, fullMethodName string) bool
+
+// PayloadStreamClientInterceptor logs the payloads of the messages sent and received on client-side streams,
+// tagging each message the same way as PayloadStreamServerInterceptor does.  The last messages [see
+// WithStreamPayloadLimit] are logged once the stream ends: when receiving from it fails (including with
+// io.EOF) or, if the server does not stream, when its one response is received.
+func PayloadStreamClientInterceptor(decider ClientPayloadLoggingDecider, opts ...PayloadOption) grpc.StreamClientInterceptor {
+	o := payloadOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		stream, err := streamer(ctx, desc, cc, method, callOpts...)
+		if err != nil || !decider(ctx, method) {
+			return stream, err
+		}
+
+		loggerCtx := lager.ContextPairs(ctx).Merge(clientCallFields(method)).InContext(ctx)
+		ps := &payloadClientStream{ClientStream: stream, serverStreams: desc.ServerStreams}
+		ps.payloadLog = payloadLog{logEntry: lager.Acc(loggerCtx), opts: o, dirs: &clientPayloadDirs}
+		return ps, nil
+	}
+}
+
+func clientCallFields(fullMethodString string) *lager.KVPairs {
+	service := path.Dir(fullMethodString)[1:]
+	method := path.Base(fullMethodString)
+
+	return lager.Pairs(
+		"grpc.service", service,
+		"grpc.method", method,
+		"system", SystemField,
+		"span.kind", ClientField,
+	)
+}
+
+// The directions of stream messages that are logged.
+const (
+	dirRecv = iota
+	dirSend
+)
+
+type payloadDir struct{ name, key, msg string }
+
+var serverPayloadDirs = [...]payloadDir{
+	dirRecv: {"recv", "grpc.request.content", "server request payload logged as grpc.request.content field"},
+	dirSend: {"send", "grpc.response.content", "server response payload logged as grpc.response.content field"},
+}
+
+var clientPayloadDirs = [...]payloadDir{
+	dirRecv: {"recv", "grpc.response.content", "client response payload logged as grpc.response.content field"},
+	dirSend: {"send", "grpc.request.content", "client request payload logged as grpc.request.content field"},
+}
+
+// Logs the payloads of the messages received and sent on one stream.
+type payloadLog struct {
+	logEntry lager.Lager
+	opts     payloadOptions
+	dirs     *[2]payloadDir
+	mu       sync.Mutex
+	seq      [2]int
+	tail     [2][]heldPayload // The most recent messages not yet logged, if opts.last > 0.
+}
+
+type heldPayload struct {
+	seq     int
+	content string
+}
+
+// A grpc.ServerStream that logs the payloads of the messages it receives and sends.
+type payloadServerStream struct {
+	grpc.ServerStream
+	payloadLog
+}
+
+func (s *payloadServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.note(dirRecv, m)
+	}
+	return err
+}
+
+func (s *payloadServerStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.note(dirSend, m)
+	}
+	return err
+}
+
+// A grpc.ClientStream that logs the payloads of the messages it sends and receives.
+type payloadClientStream struct {
+	grpc.ClientStream
+	payloadLog
+	serverStreams bool
+}
+
+func (s *payloadClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == nil {
+		s.note(dirRecv, m)
+	}
+	if err != nil || !s.serverStreams {
+		s.logLast()
+	}
+	return err
+}
+
+func (s *payloadClientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		s.note(dirSend, m)
+	}
+	return err
+}
+
+// Logs a message now if it is within the first messages, or holds onto it in case it is one of the last.
+func (s *payloadLog) note(dir int, m interface{}) {
+	p, ok := m.(proto.Message)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	s.seq[dir]++
+	seq := s.seq[dir]
+	unlimited := 0 == s.opts.first && 0 == s.opts.last
+	if !unlimited && s.opts.first < seq {
+		if 0 < s.opts.last {
+			tail := append(s.tail[dir], heldPayload{seq, JSONPbFormatter.Format(p)})
+			if s.opts.last < len(tail) {
+				tail = tail[1:]
+			}
+			s.tail[dir] = tail
+		}
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	s.logPayload(dir, seq, JSONPbFormatter.Format(p))
+}
+
+// Logs the held last messages once the stream has ended.
+func (s *payloadLog) logLast() {
+	s.mu.Lock()
+	tails := s.tail
+	s.tail = [2][]heldPayload{}
+	s.mu.Unlock()
+	for dir, tail := range tails {
+		for _, h := range tail {
+			s.logPayload(dir, h.seq, h.content)
+		}
+	}
+}
+
+func (s *payloadLog) logPayload(dir, seq int, content string) {
+	d := s.dirs[dir]
+	s.logEntry.MMap(d.msg, d.key, content, "grpc.msg_direction", d.name, "grpc.msg_seq", seq)
+}
+
 func logProtoMessageAsJSON(logger lager.Lager, pbMsg interface{}, key string, msg string) {
 	if p, ok := pbMsg.(proto.Message); ok {
 		logger.MMap(msg, key, JSONPbFormatter.Format(p))
@@ -2,6 +2,8 @@ package grpc_lager_test
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"runtime"
 	"strings"
 	"testing"
@@ -87,3 +89,100 @@ func (s *payloadSuite) TestPingError_LogsOnlyRequestsOnError() {
 
 	assert.Contains(s.T(), serverMsgs[0][2], "grpc.request.content", "request payload must be logged in a structured way")
 }
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	recvs int
+}
+
+func (f *fakeServerStream) Context() context.Context    { return context.Background() }
+func (f *fakeServerStream) SendMsg(m interface{}) error { return nil }
+
+func (f *fakeServerStream) RecvMsg(m interface{}) error {
+	if 0 == f.recvs {
+		return io.EOF
+	}
+	f.recvs--
+	m.(*pb_testproto.PingRequest).Value = fmt.Sprintf("req%d", 5-f.recvs)
+	return nil
+}
+
+func TestPayloadStreamServerInterceptor(t *testing.T) {
+	b := newBaseSuite(t, "FWNA")
+	alwaysLog := func(context.Context, string, interface{}) bool { return true }
+	interceptor := grpc_lager.PayloadStreamServerInterceptor(alwaysLog, grpc_lager.WithStreamPayloadLimit(1, 2))
+	info := &grpc.StreamServerInfo{FullMethod: "/grpc_lager.testproto.TestService/PingStream"}
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		for {
+			req := &pb_testproto.PingRequest{}
+			if err := stream.RecvMsg(req); err != nil {
+				return nil
+			}
+			if err := stream.SendMsg(&pb_testproto.PingResponse{Value: req.Value}); err != nil {
+				return err
+			}
+		}
+	}
+	require.NoError(t, interceptor(nil, &fakeServerStream{recvs: 5}, info, handler))
+
+	msgs := b.getOutputJSONs()
+	require.Len(t, msgs, 6, "first 1 and last 2 of each direction must be logged")
+	var got []string
+	for _, m := range msgs {
+		pairs := m[3].(map[string]interface{})
+		got = append(got, fmt.Sprintf("%v %v", pairs["grpc.msg_direction"], pairs["grpc.msg_seq"]))
+		last := m[len(m)-1].(map[string]interface{})
+		assert.Equal(t, "PingStream", last["grpc.method"], "all lines must contain method name")
+	}
+	assert.Equal(t, []string{"recv 1", "send 1", "recv 4", "recv 5", "send 4", "send 5"}, got)
+	assert.Contains(t, msgs[2][3], "grpc.request.content", "received payload must be logged")
+	assert.Contains(t, msgs[5][3], "grpc.response.content", "sent payload must be logged")
+}
+
+type fakeClientStream struct {
+	grpc.ClientStream
+	recvs int
+}
+
+func (f *fakeClientStream) SendMsg(m interface{}) error { return nil }
+
+func (f *fakeClientStream) RecvMsg(m interface{}) error {
+	if 0 == f.recvs {
+		return io.EOF
+	}
+	f.recvs--
+	m.(*pb_testproto.PingResponse).Value = fmt.Sprintf("resp%d", 5-f.recvs)
+	return nil
+}
+
+func TestPayloadStreamClientInterceptor(t *testing.T) {
+	b := newBaseSuite(t, "FWNA")
+	alwaysLog := func(context.Context, string) bool { return true }
+	interceptor := grpc_lager.PayloadStreamClientInterceptor(alwaysLog, grpc_lager.WithStreamPayloadLimit(1, 2))
+	desc := &grpc.StreamDesc{StreamName: "PingStream", ServerStreams: true, ClientStreams: true}
+	streamer := func(context.Context, *grpc.StreamDesc, *grpc.ClientConn, string, ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &fakeClientStream{recvs: 5}, nil
+	}
+	stream, err := interceptor(context.Background(), desc, nil, "/grpc_lager.testproto.TestService/PingStream", streamer)
+	require.NoError(t, err)
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, stream.SendMsg(&pb_testproto.PingRequest{Value: fmt.Sprintf("req%d", i)}))
+		require.NoError(t, stream.RecvMsg(&pb_testproto.PingResponse{}))
+	}
+	assert.Len(t, b.getOutputJSONs(), 2, "the last messages must not be logged before the stream ends")
+	require.Equal(t, io.EOF, stream.RecvMsg(&pb_testproto.PingResponse{}))
+
+	msgs := b.getOutputJSONs()
+	require.Len(t, msgs, 4, "the last 2 of each direction must be logged when the stream ends")
+	var got []string
+	for _, m := range msgs {
+		pairs := m[3].(map[string]interface{})
+		got = append(got, fmt.Sprintf("%v %v", pairs["grpc.msg_direction"], pairs["grpc.msg_seq"]))
+		last := m[len(m)-1].(map[string]interface{})
+		assert.Equal(t, "PingStream", last["grpc.method"], "all lines must contain method name")
+		assert.Equal(t, "client", last["span.kind"], "all lines must be marked as client-side")
+	}
+	assert.Equal(t, []string{"recv 4", "recv 5", "send 4", "send 5"}, got)
+	assert.Contains(t, msgs[0][3], "grpc.response.content", "received payload must be logged")
+	assert.Contains(t, msgs[3][3], "grpc.request.content", "sent payload must be logged")
+}
@@ -2,11 +2,17 @@ package grpc_lager
 
 import (
 	"context"
+	"net/http"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/Unity-Technologies/go-lager-internal"
+	"github.com/Unity-Technologies/go-lager-internal/gcp"
+	"github.com/Unity-Technologies/go-lager-internal/gcp-spans"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 )
 
 var (
@@ -15,6 +21,9 @@ var (
 
 	// ServerField is used in every server-side log statement made through grpc_lager. Can be overwritten before initialization.
 	ServerField = "server"
+
+	// ClientField is used in every client-side log statement made through grpc_lager. Can be overwritten before initialization.
+	ClientField = "client"
 )
 
 func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
@@ -25,11 +34,17 @@ func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
 
 		ctx = newContextForCall(ctx, info.FullMethod, startTime, o.timestampFormat)
 
+		var span spans.Factory
+		if nil != o.spanFactory {
+			ctx, span = startServerSpan(ctx, o.spanFactory, info.FullMethod)
+		}
+
 		resp, err := handler(ctx, req)
+		code := o.codeFunc(err)
+		finishServerSpan(span, code, err)
 		if !o.shouldLog(info.FullMethod, err) {
 			return resp, err
 		}
-		code := o.codeFunc(err)
 		level := o.levelFunc(code)
 		duration := o.durationFunc(time.Since(startTime))
 
@@ -59,3 +74,36 @@ func serverCallFields(fullMethodString string) *lager.KVPairs {
 		"span.kind", ServerField,
 	)
 }
+
+// startServerSpan imports any trace context sent in the call's metadata and starts a server span for the call.
+func startServerSpan(ctx context.Context, factory spans.Factory, fullMethodString string) (context.Context, spans.Factory) {
+	span := spans.ContextGetSpan(ctx)
+	if nil == span {
+		span = factory
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(spans.TraceHeader); 0 < len(vals) {
+			span = span.ImportFromHeaders(http.Header{spans.TraceHeader: vals[:1]})
+		}
+	}
+	if sub := span.NewSpan(); nil != sub {
+		span = sub
+		span.SetDisplayName(lager.GetSpanPrefix() + ".in." + strings.TrimPrefix(fullMethodString, "/"))
+		span.SetIsServer()
+		span.AddAttribute("grpc.method", fullMethodString)
+		ctx = spans.ContextStoreSpan(ctx, span)
+	}
+	return gcp.ContextAddTrace(ctx, span), span
+}
+
+// finishServerSpan sets the span's status from the call's result and finishes it.
+func finishServerSpan(span spans.Factory, code codes.Code, err error) {
+	if nil == span || span.GetStart().IsZero() {
+		return
+	}
+	span.SetStatusCode(int64(code))
+	if nil != err {
+		span.SetStatusMessage(err.Error())
+	}
+	span.Finish()
+}
@@ -43,6 +43,8 @@ type InterceptorTestSuite struct {
 
 	restartServerWithDelayedStart chan time.Duration
 	serverRunning                 chan bool
+
+	cancels []context.CancelFunc
 }
 
 func (s *InterceptorTestSuite) SetupSuite() {
@@ -132,17 +134,22 @@ func (s *InterceptorTestSuite) ServerAddr() string {
 }
 
 func (s *InterceptorTestSuite) SimpleCtx() context.Context {
-	ctx, _ := context.WithTimeout(context.TODO(), 2*time.Second)
+	ctx, cancel := context.WithTimeout(context.TODO(), 2*time.Second)
+	s.cancels = append(s.cancels, cancel)
 	return ctx
 }
 
 func (s *InterceptorTestSuite) DeadlineCtx(deadline time.Time) context.Context {
-	ctx, _ := context.WithDeadline(context.TODO(), deadline)
+	ctx, cancel := context.WithDeadline(context.TODO(), deadline)
+	s.cancels = append(s.cancels, cancel)
 	return ctx
 }
 
 func (s *InterceptorTestSuite) TearDownSuite() {
 	time.Sleep(10 * time.Millisecond)
+	for _, cancel := range s.cancels {
+		cancel()
+	}
 	if s.ServerListener != nil {
 		s.Server.GracefulStop()
 		s.T().Logf("stopped grpc.Server at: %v", s.ServerAddr())
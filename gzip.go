@@ -0,0 +1,94 @@
+package lager
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// The io.WriteCloser returned by GzipOutput().
+type gzipOutput struct {
+	mu     sync.Mutex
+	gz     *gzip.Writer
+	w      io.Writer
+	stop   chan struct{}
+	closed bool
+}
+
+// Returned when writing to a GzipOutput() after it was closed.
+var errGzipClosed = errors.New("lager.GzipOutput() already closed")
+
+// GzipOutput() returns an io.WriteCloser that gzip-compresses log lines
+// before writing them to 'w'.  This is useful for high-volume batch jobs
+// that archive their logs directly to object storage:
+//
+//      gz := lager.GzipOutput(archive, 10*time.Second)
+//      defer gz.Close()
+//      defer lager.SetOutput(gz)()
+//
+// If 'interval' is positive, then the compressed data is flushed to 'w'
+// that often so that not too much is lost if the process dies.  It is also
+// flushed by Flush() and FlushOn() [it has a 'Flush() error' method that
+// also flushes 'w' if it has such a method].  You must call Close() to
+// write the end of the gzip stream.  Close() does not close 'w'.
+//
+func GzipOutput(w io.Writer, interval time.Duration) io.WriteCloser {
+	g := &gzipOutput{gz: gzip.NewWriter(w), w: w, stop: make(chan struct{})}
+	if 0 < interval {
+		go g.flushEvery(interval)
+	}
+	return g
+}
+
+func (g *gzipOutput) Write(buf []byte) (int, error) {
+	defer g.mu.Unlock()
+	g.mu.Lock()
+	if g.closed {
+		return 0, errGzipClosed
+	}
+	return g.gz.Write(buf)
+}
+
+// Flush() writes any buffered compressed data to the underlying writer.
+func (g *gzipOutput) Flush() error {
+	defer g.mu.Unlock()
+	g.mu.Lock()
+	if g.closed {
+		return errGzipClosed
+	}
+	if err := g.gz.Flush(); nil != err {
+		return err
+	}
+	if f, ok := g.w.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close() writes the end of the gzip stream.
+func (g *gzipOutput) Close() error {
+	defer g.mu.Unlock()
+	g.mu.Lock()
+	if g.closed {
+		return errGzipClosed
+	}
+	g.closed = true
+	close(g.stop)
+	return g.gz.Close()
+}
+
+// Periodically flushes compressed data until closed.
+func (g *gzipOutput) flushEvery(interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-tick.C:
+			g.Flush()
+		}
+	}
+}
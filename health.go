@@ -0,0 +1,126 @@
+package lager
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// WarnWhenBacklogged() makes Lager report on its own health when log lines
+// are being queued [see SetAsync() and SetCoalescing()], so that a
+// saturated logging pipeline shows up in the logs.  A Warn log line is
+// written when the queue has held at least 'highWater' writes for longer
+// than 'after' or when lines have been dropped [see DropWhenBehind()]:
+//
+//      ["2021-06-09 13:10:07.0447Z", "WARN", "Log queue is backlogged",
+//       {"queueDepth":3900, "queueLen":4096, "backlogged":"2.1s",
+//       "dropped":{"INFO":512}, "slowestOutput":"*net.TCPConn",
+//       "slowestWrite":"1.6s"}]
+//
+// "dropped" holds how many lines of each level were dropped since the
+// prior such report.  "slowestOutput" is the output that was slowest to
+// write to since then (a file name or a Go type name) and "slowestWrite"
+// is how long that write took.  Only one such line is written every 'every'
+// (default 1 minute), no matter how long the queue stays backlogged.
+//
+// If 'highWater' is not positive, then 3/4 of the queue length is used.  If
+// 'after' is not positive, then 1 second is used.  It returns a function
+// that stops the reports:
+//
+//      defer lager.WarnWhenBacklogged(0, 5*time.Second, 0)()
+//
+func WarnWhenBacklogged(highWater int, after, every time.Duration) func() {
+	if after <= 0 {
+		after = time.Second
+	}
+	if every <= 0 {
+		every = time.Minute
+	}
+	stop := make(chan struct{})
+	go watchBacklog(highWater, after, every, stop)
+	return func() { close(stop) }
+}
+
+// Returns the queue (if any) with the most writes waiting, the number of
+// writes waiting, and the queue length.
+func deepestQueue(g *globals) (a *asyncOutput, depth, size int) {
+	for _, q := range []*asyncOutput{g.async, g.coalesce} {
+		if nil != q && (nil == a || depth < len(q.queue)) {
+			a, depth, size = q, len(q.queue), cap(q.queue)
+		}
+	}
+	return a, depth, size
+}
+
+// Periodically checks how backlogged the queue is and logs a report when
+// needed, until 'stop' is closed.
+func watchBacklog(
+	highWater int, after, every time.Duration, stop chan struct{},
+) {
+	poll := after / 4
+	if poll < time.Millisecond {
+		poll = time.Millisecond
+	}
+	tick := time.NewTicker(poll)
+	defer tick.Stop()
+	var last [int(nLevels)]uint64
+	for i := range last {
+		last[i] = atomic.LoadUint64(&_dropped[i])
+	}
+	var since, warned time.Time
+	for {
+		var now time.Time
+		select {
+		case <-stop:
+			return
+		case now = <-tick.C:
+		}
+		a, depth, size := deepestQueue(getGlobals())
+		hw := highWater
+		if hw <= 0 {
+			hw = 3 * size / 4
+		}
+		if nil == a || depth < hw || 0 == depth {
+			since = time.Time{}
+		} else if since.IsZero() {
+			since = now
+		}
+		dropped := false
+		for i := range last {
+			dropped = dropped || last[i] < atomic.LoadUint64(&_dropped[i])
+		}
+		backlogged := !since.IsZero() && after <= now.Sub(since)
+		if !backlogged && !dropped ||
+			!warned.IsZero() && now.Sub(warned) < every {
+			continue
+		}
+		warned = now
+
+		pairs := []interface{}{"queueDepth", depth, "queueLen", size}
+		if backlogged {
+			pairs = append(pairs, "backlogged", now.Sub(since))
+		}
+		counts := AMap(nil)
+		for l := lFail; l < nLevels; l++ {
+			n := atomic.LoadUint64(&_dropped[int(l)])
+			if last[int(l)] < n {
+				counts = counts.AddPairs(l.String(), n-last[int(l)])
+			}
+			last[int(l)] = n
+		}
+		if nil != counts {
+			pairs = append(pairs, "dropped", counts)
+		}
+		if nil != a {
+			if w, took := a.takeSlowest(); nil != w {
+				name := fmt.Sprintf("%T", w)
+				if n, ok := w.(interface{ Name() string }); ok {
+					name = n.Name()
+				}
+				pairs = append(pairs,
+					"slowestOutput", name, "slowestWrite", took)
+			}
+		}
+		Warn().MMap("Log queue is backlogged", pairs...)
+	}
+}
@@ -0,0 +1,98 @@
+package lager
+
+import (
+	"os"
+	"strings"
+)
+
+// AddHostPairs() adds pairs that identify the host, process, and (when it
+// can be detected) the container and Kubernetes pod, to the context pairs
+// of every log line, so lines from many instances of a service can be
+// told apart:
+//
+//      defer lager.AddHostPairs()()
+//
+//      ["2021-06-09 13:10:07.0447Z", "INFO", "Started",
+//          {"host":"web-7f9c-x2p", "pid":1, "pod":"web-7f9c-x2p",
+//          "container":"3f2a9c81d0e4"}]
+//
+// "host" is from os.Hostname() and "pid" is from os.Getpid().  "pod" is
+// from POD_NAME in the environment or, when running in Kubernetes (when
+// KUBERNETES_SERVICE_HOST is set), is the host name.  "container" is the
+// first 12 digits of the container ID found in /proc/self/cgroup.  Pairs
+// that cannot be determined are left out.  The values are looked up once,
+// when AddHostPairs() is called.
+//
+// The pairs come before any global pairs [see SetGlobalPairs()], and a
+// global or context pair with the same key replaces one of them.  It
+// returns a function that restores the prior setting.  Setting
+// LAGER_HOST_PAIRS to a non-empty value in the environment has the same
+// effect as calling AddHostPairs() when the program starts.
+//
+func AddHostPairs() func() {
+	kvp := hostPairs()
+	var prior AMap
+	updateGlobals(func(g *globals) {
+		prior = g.hostPairs
+		g.hostPairs = kvp
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.hostPairs = prior
+		})
+	}
+}
+
+// Sets the initial host pairs if LAGER_HOST_PAIRS is set.
+func envHostPairs(g *globals) {
+	if "" != os.Getenv("LAGER_HOST_PAIRS") {
+		g.hostPairs = hostPairs()
+	}
+}
+
+// Looks up the pairs for AddHostPairs().
+func hostPairs() AMap {
+	var pairs []interface{}
+	host, err := os.Hostname()
+	if nil == err && "" != host {
+		pairs = append(pairs, "host", host)
+	}
+	pairs = append(pairs, "pid", os.Getpid())
+	if pod := os.Getenv("POD_NAME"); "" != pod {
+		pairs = append(pairs, "pod", pod)
+	} else if "" != os.Getenv("KUBERNETES_SERVICE_HOST") && "" != host {
+		pairs = append(pairs, "pod", host)
+	}
+	if cgroup, err := os.ReadFile("/proc/self/cgroup"); nil == err {
+		if id := containerID(string(cgroup)); "" != id {
+			pairs = append(pairs, "container", id)
+		}
+	}
+	return AMap(nil).AddPairs(pairs...)
+}
+
+// Returns the short (12-digit) container ID from the contents of a
+// /proc/{pid}/cgroup file, or "" if none is found.  The ID is the last
+// 64-digit hexadecimal part of a path, like in
+// "0::/system.slice/docker-{id}.scope" or "4:cpu:/kubepods/pod{uid}/{id}".
+func containerID(cgroup string) string {
+	id := ""
+	for _, line := range strings.Split(cgroup, "\n") {
+		for _, part := range strings.FieldsFunc(line, func(r rune) bool {
+			return '/' == r || ':' == r || '-' == r || '.' == r
+		}) {
+			if isContainerID(part) {
+				id = part[:12]
+			}
+		}
+	}
+	return id
+}
+
+// Returns whether 's' is 64 lower-case hexadecimal digits.
+func isContainerID(s string) bool {
+	if 64 != len(s) {
+		return false
+	}
+	return "" == strings.Trim(s, "0123456789abcdef")
+}
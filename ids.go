@@ -0,0 +1,58 @@
+package lager
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"strconv"
+	"sync/atomic"
+)
+
+// Counts log lines given an "_id", to distinguish lines that are otherwise
+// identical (including having the same timestamp).
+var _entrySeq uint64
+
+// SetEntryIDs() enables (or disables) adding an "_id" pair to each log
+// line.  The value is a deterministic ID made by hashing the line's
+// timestamp, a per-process sequence number, its message, and the values of
+// the context pairs named in 'fields'.  Since each copy of a log line that
+// gets shipped more than once has the same "_id", this allows idempotent
+// ingestion of logs into systems like Elasticsearch (by using "_id" as the
+// document ID).  For example:
+//
+//      lager.SetEntryIDs(true, "trace", "user")
+//
+// The "_id" is added to the context pairs of the line, so it appears with
+// any other context pairs.
+//
+func SetEntryIDs(enable bool, fields ...string) {
+	var ids []string
+	if enable {
+		ids = append(make([]string, 0, len(fields)), fields...)
+	}
+	updateGlobals(func(g *globals) {
+		g.entryIDs = ids
+	})
+}
+
+// Returns a copy of the logger with the "_id" pair added.
+func (l *logger) withEntryID(b *buffer) *logger {
+	h := fnv.New64a()
+	var num [8]byte
+	binary.BigEndian.PutUint64(num[:], uint64(b.now.UnixNano()/1e5))
+	h.Write(num[:])
+	binary.BigEndian.PutUint64(num[:], atomic.AddUint64(&_entrySeq, 1))
+	h.Write(num[:])
+	h.Write([]byte(b.msg))
+	if kvp := l.ctxPairs(); nil != kvp {
+		for _, name := range l.g.entryIDs {
+			for i, k := range kvp.keys {
+				if k == name {
+					h.Write([]byte("\x00" + k + "=" + S(kvp.vals[i])))
+				}
+			}
+		}
+	}
+	cp := *l
+	cp.kvp = cp.kvp.AddPairs("_id", strconv.FormatUint(h.Sum64(), 16))
+	return &cp
+}
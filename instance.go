@@ -0,0 +1,349 @@
+package lager
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Logger is an independent set of log levels, outputs, and formatting, so
+// that one process can keep, for example, application logs, audit logs,
+// and access logs apart.  Use lager.New() to get a Logger.  The
+// package-level functions [like lager.Fail()] keep using the default
+// configuration.
+//
+type Logger struct {
+	mu sync.Mutex   // Held while changing the configuration.
+	g  atomic.Value // Holds the current *globals.
+}
+
+// Option configures a Logger [see lager.New() and Logger.Apply()].
+type Option func(*globals)
+
+// New() returns a Logger that starts with a copy of the current
+// package-level configuration and then applies each Option:
+//
+//      audit := lager.New(
+//          lager.WithLevels("FWNA"),
+//          lager.WithOutput(auditFile),
+//          lager.WithFormat("map"),
+//      )
+//      audit.Acc(ctx).MMap("Deleted account", "user", userID)
+//
+// Later changes to the package-level configuration [such as via
+// SetOutput()] do not change the Logger, and changes made via the Logger's
+// Options do not change the package-level configuration.  Each Logger has
+// its own Modules [see Logger.NewModule()], so a library can embed its own
+// Lager configuration without fighting with the program using it.
+//
+// The Logger also gets its own copy of any state that log lines update,
+// such as the async queue [with its own background goroutine, see
+// Logger.Close()], the dedup window, the flight recorder, sequence
+// numbers, volume accounting [see Logger.LogVolume()], the count of
+// failed writes [see Logger.FailedWrites()], and the counts for triggers
+// and escalations [which then raise the Logger's levels].  Removing a
+// trigger or escalation also removes it from each Logger.
+//
+// Subscriptions are not copied, so subscribers [see Subscribe()] only get
+// lines from the package-level configuration.  And Mute() and
+// SetLevelFloor() are process-wide:  they apply to every Logger and the
+// lines that they suppress are counted together [see Suppressed()].
+//
+func New(opts ...Option) *Logger {
+	lg := &Logger{}
+	lg.g.Store(getGlobals().clone(func(g *globals) {
+		g.mods = new(sync.Map)
+		g.freshState(lg)
+		applyOptions(opts)(g)
+	}))
+	return lg
+}
+
+// Replaces the state that log lines update with fresh copies that use the
+// same settings, so that 'lg' shares none of it with the configuration
+// that it was copied from.
+func (g *globals) freshState(lg *Logger) {
+	if a := g.async; nil != a {
+		g.async = newAsyncOutput(cap(a.queue), a.interval)
+	}
+	if c := g.coalesce; nil != c {
+		g.coalesce = newAsyncOutput(cap(c.queue), c.interval)
+	}
+	if nil != g.dedup {
+		g.dedup = &dedupState{window: g.dedup.window}
+	}
+	if fr := g.flight; nil != fr {
+		g.flight = &flightRecorder{
+			levels: fr.levels, held: make([]heldLine, 0, cap(fr.held)),
+		}
+	}
+	if nil != g.seq {
+		g.seq = &sequencer{proc: g.seq.proc}
+	}
+	if a := g.account; nil != a {
+		g.account = &accountant{
+			key: a.key, top: a.top, since: time.Now(),
+			recent: map[string]*Volume{}, total: map[string]*Volume{},
+		}
+	}
+	if fs := g.firstSeen; nil != fs {
+		g.firstSeen = &firstSeen{
+			levels: fs.levels, seen: map[string]struct{}{},
+		}
+	}
+	if cg := g.clockGuard; nil != cg {
+		g.clockGuard = &clockGuard{correct: cg.correct, clock: cg.clock}
+	}
+	if nil != g.triggers {
+		triggers := make([]*trigger, len(g.triggers))
+		for i, t := range g.triggers {
+			triggers[i] = t.copy()
+		}
+		g.triggers = triggers
+	}
+	if nil != g.escalations {
+		escalations := make([]*escalation, len(g.escalations))
+		for i, e := range g.escalations {
+			escalations[i] = e.copyFor(lg)
+		}
+		g.escalations = escalations
+	}
+	g.failedWrites = new(uint64)
+	g.subs = nil
+}
+
+// Apply() changes the Logger's configuration by applying each Option.  It
+// returns a function that restores the prior configuration:
+//
+//      defer lg.Apply(lager.WithLevels("FWNAITD"))()
+//
+// Holding on to a Lager from the Logger [like lg.Info()] may ignore later
+// changes.
+//
+func (lg *Logger) Apply(opts ...Option) func() {
+	defer AutoLock(&lg.mu)()
+	prior := lg.globals()
+	lg.g.Store(prior.clone(applyOptions(opts)))
+	return func() {
+		defer AutoLock(&lg.mu)()
+		lg.g.Store(prior)
+	}
+}
+
+// Returns a function that applies each Option.
+func applyOptions(opts []Option) func(*globals) {
+	return func(g *globals) {
+		for _, opt := range opts {
+			opt(g)
+		}
+	}
+}
+
+// Returns the Logger's current configuration.
+func (lg *Logger) globals() *globals {
+	return lg.g.Load().(*globals)
+}
+
+// Flush() is like lager.Flush() but for the lines queued by the Logger and
+// for the Logger's outputs.
+//
+func (lg *Logger) Flush(ctx Ctx) error {
+	return lg.globals().flush(ctx)
+}
+
+// Close() writes out all lines queued or held back by the Logger [like
+// Logger.Flush()] and then stops the background goroutines that its async
+// mode uses [see SetAsync() and SetCoalescing()], so call it once done
+// with a Logger that was created while async mode was enabled.  Lines
+// logged via the Logger after that are written directly.  It returns the
+// first error from flushing the Logger's outputs.
+//
+func (lg *Logger) Close() error {
+	g := lg.globals()
+	g.async.stop()
+	g.coalesce.stop()
+	return g.flush(nil)
+}
+
+// FailedWrites() is like lager.FailedWrites() but counts the log lines
+// that the Logger failed to write.
+//
+func (lg *Logger) FailedWrites() uint64 {
+	return atomic.LoadUint64(lg.globals().failedWrites)
+}
+
+// LogVolume() is like lager.LogVolume() but for the lines written by the
+// Logger.  No "Log volume" lines are logged for a Logger.
+//
+func (lg *Logger) LogVolume() map[string]Volume {
+	return lg.globals().account.volumes()
+}
+
+// WithLevels() is an Option that sets which log levels are enabled, like
+// SetLevels().
+//
+func WithLevels(levels string) Option {
+	return setLevels(levels)
+}
+
+// WithOutput() is an Option that sets where log lines are written, like
+// SetOutput().
+//
+func WithOutput(writer io.Writer) Option {
+	return func(g *globals) { g.dest = writer }
+}
+
+// WithLevelOutput() is an Option that writes log lines of the given levels
+// to 'writer', like SetLevelOutput().
+//
+func WithLevelOutput(levels string, writer io.Writer) Option {
+	return setLevelOutput(levels, writer)
+}
+
+// WithKeys() is an Option that logs each line as a JSON map using the
+// given keys, like Keys().  Pass in 6 empty strings to log JSON lists.
+//
+func WithKeys(when, lev, msg, args, ctx, mod string) Option {
+	if "" == when && "" == lev && "" == args && "" == mod &&
+		"" == ctx && "" == msg {
+		return setKeys(nil)
+	} else if "" == when || "" == lev || "" == args || "" == mod {
+		Exit().WithCaller(1).List("Only keys for msg and ctx can be blank")
+	}
+	return setKeys(&keyStrs{
+		when: when, lev: lev, msg: msg, args: args, ctx: ctx, mod: mod,
+	})
+}
+
+// WithFormat() is an Option that selects a log format by name, as for the
+// --log-format flag [see FlagSet()], such as "map" or "gcp".  An unknown
+// name is logged via Exit().
+//
+func WithFormat(name string) Option {
+	set, ok := logFormat(name)
+	if !ok {
+		Exit().WithCaller(1).MMap("Unknown log format",
+			"format", name, "formats", formatNames())
+	}
+	return set
+}
+
+// WithSampling() is an Option that only writes about 1 of every 'n' log
+// lines of the given levels, like SetSampling().
+//
+func WithSampling(levels string, n int) Option {
+	return setSampling(levels, n)
+}
+
+// WithFilter() is an Option that adds a function that decides whether each
+// log line gets written, like AddFilter().
+//
+func WithFilter(keep func(level, module string, pairs AMap) bool) Option {
+	f := &filter{keep: keep}
+	return func(g *globals) {
+		g.filters = append(append([]*filter(nil), g.filters...), f)
+	}
+}
+
+// WithLevelNotation() is an Option that sets how level names are logged,
+// like SetLevelNotation().
+//
+func WithLevelNotation(mapper func(string) string) Option {
+	if nil == mapper {
+		mapper = identLevelNotation
+	}
+	return func(g *globals) { g.levDesc = mapper }
+}
+
+// NewModule() is like lager.NewModule() but the Module belongs to the
+// Logger, so it uses the Logger's configuration and is separate from any
+// package-level Module of the same name.  The initial log levels are the
+// last of these that is not "":  the Logger's enabled levels, the passed-in
+// 'defaultLevels', and the levels from the rule that applies to the
+// module name [see SetModuleTreeLevels()].  LAGER_{module_name}_LEVELS is
+// not used.
+//
+func (lg *Logger) NewModule(name string, defaultLevels ...string) *Module {
+	if mod := findMod(lg.globals().mods, name); nil != mod {
+		return mod
+	}
+	mod := &Module{name: name, owner: lg}
+	levels := ""
+	if 1 == len(defaultLevels) {
+		levels = defaultLevels[0]
+	} else if 0 != len(defaultLevels) {
+		panic("Passed more than one defaultLevel string to NewModule()")
+	}
+	if tree, ok := lg.globals().modTree.levels(name); ok {
+		levels = tree
+	}
+	mod.Init(levels)
+	return storeMod(lg.globals().mods, name, mod)
+}
+
+// GetModules() is like lager.GetModules() but for the Logger's Modules.
+//
+func (lg *Logger) GetModules() map[string]string {
+	m := make(map[string]string)
+	lg.globals().mods.Range(func(key, value interface{}) bool {
+		m[key.(string)] = value.(*Module).cur().levels
+		return true
+	})
+	return m
+}
+
+// Returns the Logger's Lager for 'lev', incorporating any contexts.
+func (lg *Logger) forLevel(lev level, cs ...Ctx) Lager {
+	g := lg.globals()
+	return g.promotable(g.lagers[int(lev)], lev, "").With(cs...)
+}
+
+// GetLevels() returns the log levels enabled for the Logger, like
+// lager.GetLevels().
+//
+func (lg *Logger) GetLevels() string { return lg.globals().enabled }
+
+// Panic() is like lager.Panic() but uses the Logger's configuration.
+func (lg *Logger) Panic(cs ...Ctx) Lager { return lg.forLevel(lPanic, cs...) }
+
+// Exit() is like lager.Exit() but uses the Logger's configuration.
+func (lg *Logger) Exit(cs ...Ctx) Lager { return lg.forLevel(lExit, cs...) }
+
+// Fail() is like lager.Fail() but uses the Logger's configuration.
+func (lg *Logger) Fail(cs ...Ctx) Lager { return lg.forLevel(lFail, cs...) }
+
+// Warn() is like lager.Warn() but uses the Logger's configuration.
+func (lg *Logger) Warn(cs ...Ctx) Lager { return lg.forLevel(lWarn, cs...) }
+
+// Note() is like lager.Note() but uses the Logger's configuration.
+func (lg *Logger) Note(cs ...Ctx) Lager { return lg.forLevel(lNote, cs...) }
+
+// Acc() is like lager.Acc() but uses the Logger's configuration.
+func (lg *Logger) Acc(cs ...Ctx) Lager { return lg.forLevel(lAcc, cs...) }
+
+// Info() is like lager.Info() but uses the Logger's configuration.
+func (lg *Logger) Info(cs ...Ctx) Lager { return lg.forLevel(lInfo, cs...) }
+
+// Trace() is like lager.Trace() but uses the Logger's configuration.
+func (lg *Logger) Trace(cs ...Ctx) Lager { return lg.forLevel(lTrace, cs...) }
+
+// Debug() is like lager.Debug() but uses the Logger's configuration.
+func (lg *Logger) Debug(cs ...Ctx) Lager { return lg.forLevel(lDebug, cs...) }
+
+// Obj() is like lager.Obj() but uses the Logger's configuration.
+func (lg *Logger) Obj(cs ...Ctx) Lager { return lg.forLevel(lObj, cs...) }
+
+// Guts() is like lager.Guts() but uses the Logger's configuration.
+func (lg *Logger) Guts(cs ...Ctx) Lager { return lg.forLevel(lGuts, cs...) }
+
+// Level() is like lager.Level() but uses the Logger's configuration.
+//
+func (lg *Logger) Level(lev byte, cs ...Ctx) Lager {
+	if l, ok := letterLevel(lev); ok {
+		return lg.forLevel(l, cs...)
+	}
+	panic(fmt.Sprintf(
+		"Level() must be one char from \"PEFWNAITDOG\" not %q", lev))
+}
@@ -0,0 +1,122 @@
+package lager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Replaced when testing RunJob().
+var exitProcess = os.Exit
+
+// The most stack frames logged for a recovered panic.
+const maxPanicStack = 32
+
+// RunJob() runs the main work of a batch job (such as a Kubernetes Job or
+// CronJob) and then exits the process with an appropriate exit status, so
+// that jobs written by different teams all log their outcome the same way:
+//
+//      func main() {
+//          lager.RunJob(context.Background(), "nightly-export", export)
+//      }
+//
+// A "job" pair (set to 'name') is added to the Context passed to 'fn' and
+// a Note "Job started" line is logged before 'fn' is called.  When 'fn'
+// finishes, a "Job finished" line is logged (at the Note level on success,
+// else at the Fail level) that includes "outcome" ("success", "failure",
+// "panic", or "exit"), "exitCode", and "duration" (like "1.234s").  If 'fn'
+// returned an error, it is included as "error".  If 'fn' panicked, the
+// panic value is included as "panic" [see PanicValue()] along with its
+// "panicType" and a "_stack" trace (of at most 32 frames).
+//
+// The exit status is 0 on success, 2 if 'fn' panicked, and otherwise 1
+// (or the value returned by the error's 'ExitCode() int' method, if it has
+// one, such as for an *exec.ExitError).  If 'fn' calls lager.Exit() while
+// ExitViaPanic() is in effect, the exit status is 1.  Flush() is called
+// before the process exits.
+//
+func RunJob(ctx Ctx, name string, fn func(Ctx) error) {
+	code := runJob(ctx, name, fn)
+	Flush(nil)
+	exitProcess(code)
+}
+
+// Does the work of RunJob() except for exiting.
+func runJob(ctx Ctx, name string, fn func(Ctx) error) int {
+	if nil == ctx {
+		ctx = context.Background()
+	}
+	ctx = AddPairs(ctx, "job", name)
+	start := time.Now()
+	Note(ctx).MMap("Job started")
+
+	err, p, lg := callJob(ctx, fn)
+	outcome, code := "success", 0
+	switch {
+	case p == _panicToExit:
+		outcome, code, p = "exit", 1, nil
+	case nil != p:
+		outcome, code = "panic", 2
+	case nil != err:
+		outcome, code = "failure", 1
+		if ec, ok := err.(interface{ ExitCode() int }); ok && 0 < ec.ExitCode() {
+			code = ec.ExitCode()
+		}
+	default:
+		lg = Note(ctx)
+	}
+	lg.MMap("Job finished",
+		"outcome", outcome,
+		"exitCode", code,
+		"duration", fmt.Sprintf("%.3fs", time.Now().Sub(start).Seconds()),
+		Unless(nil == err, "error"), err,
+		Unless(nil == p, "panic"), PanicValue(p),
+		Unless(nil == p, "panicType"), fmt.Sprintf("%T", p),
+	)
+	return code
+}
+
+// Calls the job's function, recovering from any panic.  Returns the error
+// returned, the panic value, and a Fail Lager to log the outcome with
+// (which includes a stack trace if there was a panic).
+func callJob(ctx Ctx, fn func(Ctx) error) (err error, p interface{}, lg Lager) {
+	lg = Fail(ctx)
+	defer func() {
+		if p = recover(); nil != p && p != _panicToExit {
+			lg = lg.WithStack(2, maxPanicStack)
+		}
+	}()
+	err = fn(ctx)
+	return
+}
+
+// PanicValue() converts a value recovered from a panic into the value to
+// log for it, so recovery code can log panics safely and in a form that
+// can be queried:
+//
+//      if p := recover(); nil != p {
+//          lager.Fail(ctx).WithStack(1, 32).MMap("Recovered from panic",
+//              "panic", lager.PanicValue(p), "panicType", fmt.Sprintf("%T", p))
+//      }
+//
+// Strings, errors (other than ones that combine several errors), and
+// Stringers become strings with any secrets removed via RedactSecrets().
+// Other values (such as structs) are returned as is, so they get logged as
+// structured data the same as any other logged value (rather than as the
+// text from fmt.Sprintf()).
+//
+func PanicValue(p interface{}) interface{} {
+	switch x := p.(type) {
+	case string:
+		return RedactSecrets(x)
+	case error:
+		if nil != joinedErrors(x) {
+			return x
+		}
+		return RedactSecrets(x.Error())
+	case Stringer:
+		return RedactSecrets(x.String())
+	}
+	return p
+}
@@ -0,0 +1,87 @@
+package lager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Unity-Technologies/go-tutl-internal"
+)
+
+type exitErr int
+
+func (e exitErr) Error() string { return "exited" }
+func (e exitErr) ExitCode() int { return int(e) }
+
+func TestRunJob(t *testing.T) {
+	u := tutl.New(t)
+	Keys("", "", "", "", "", "")
+	defer Init("FWNA")
+	Init("FWN")
+	log := new(bytes.Buffer)
+	defer SetOutput(log)()
+	exited := -1
+	defer func() { exitProcess = os.Exit }()
+	exitProcess = func(code int) { exited = code }
+
+	ctx := AddPairs(context.Background(), "run", 7)
+	RunJob(ctx, "ok", func(ctx Ctx) error {
+		Warn(ctx).List("working")
+		return nil
+	})
+	u.Is(0, exited, "success exit code")
+	u.Like(log.String(), "success",
+		`"NOTE", "Job started", {"run":7, "job":"ok"}]`,
+		`"WARN", "working", {"run":7, "job":"ok"}]`,
+		`"NOTE", "Job finished", {"outcome":"success", "exitCode":0,`+
+			` "duration":"0.0[0-9]*s"}`,
+		`!"error"`)
+	log.Reset()
+
+	RunJob(nil, "bad", func(Ctx) error { return errors.New("no data") })
+	u.Is(1, exited, "failure exit code")
+	u.Like(log.String(), "failure", `"FAIL", "Job finished",`,
+		`"outcome":"failure", "exitCode":1,.*"error":"no data"`, `"job":"bad"`)
+	log.Reset()
+
+	u.Is(3, runJob(nil, "code", func(Ctx) error { return exitErr(3) }),
+		"ExitCode() used")
+	log.Reset()
+
+	u.Is(2, runJob(nil, "boom", func(Ctx) error { panic("oops") }),
+		"panic exit code")
+	u.Like(log.String(), "panic", `"FAIL", "Job finished",`,
+		`"outcome":"panic", "exitCode":2,.*"panic":"oops"`,
+		`"panicType":"string"`, `"_stack":\[`, `job_test.go`)
+	log.Reset()
+
+	type badState struct {
+		Shard int
+		State string
+	}
+	runJob(nil, "struct", func(Ctx) error { panic(badState{7, "torn"}) })
+	u.Like(log.String(), "struct panic",
+		`*"panic":{"Shard":7,"State":"torn"}, "panicType":"lager.badState"`)
+	log.Reset()
+
+	runJob(nil, "secret", func(Ctx) error {
+		panic(errors.New("bad login: password=hunter2"))
+	})
+	u.Like(log.String(), "redacted panic",
+		`*"panic":"bad login: password=[REDACTED]", "panicType":"*errors.`)
+	log.Reset()
+
+	u.Is("1.5s", PanicValue(1500*time.Millisecond), "Stringer")
+	u.Is(nil, PanicValue(nil), "nil")
+
+	defer ExitViaPanic()(func(x *int) { *x = -1 })
+	u.Is(1, runJob(nil, "exit", func(Ctx) error {
+		Exit().List("giving up")
+		return nil
+	}), "Exit() exit code")
+	u.Like(log.String(), "exit", `"EXIT", "giving up"`,
+		`"outcome":"exit", "exitCode":1`, `!"_stack"`)
+}
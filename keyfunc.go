@@ -0,0 +1,109 @@
+package lager
+
+import (
+	"time"
+)
+
+// Which key of a JSON map to get the function for [see keyFunc()].
+const (
+	keyWhen = iota // The timestamp key.
+	keyLev         // The log level key.
+)
+
+// A function that computes the value for a key [see SetKeyFunc()].
+type keyFunc struct {
+	key string
+	f   func(when time.Time, level string) interface{}
+}
+
+// SetKeyFunc() registers a function that computes the value logged under
+// the given key for each log line, when logging a JSON map [see Keys()].
+// The function gets the timestamp of the line and the name of its level
+// (like "WARN") and is called as the line is written, so it should be
+// fast.
+//
+// If 'key' is the key used for the timestamp or for the log level, then
+// the function's value replaces the usual timestamp or level value:
+//
+//      lager.Keys("time", "level", "msg", "args", "", "mod")
+//      defer lager.SetKeyFunc("time",
+//          func(when time.Time, _ string) interface{} {
+//              return when.Format(time.RFC1123)
+//          })()
+//
+// Otherwise, the key and the function's value are added to the top-level
+// map right after the log level (before the message).  So you can add a
+// column with a value that can change while the program runs:
+//
+//      defer lager.SetKeyFunc("region",
+//          func(time.Time, string) interface{} { return region.Load() })()
+//
+//      {"time":"...", "level":"INFO", "region":"us-east1", "msg":"..."}
+//
+// Do not use one of the other keys given to Keys() (such as the one for
+// the message).  Extra keys are added in the order they were first
+// registered.  Passing in a 'nil' function removes the one for 'key'.  It
+// returns a function that restores the prior functions.  Key functions
+// are not used when logging a JSON list.
+//
+func SetKeyFunc(
+	key string, f func(when time.Time, level string) interface{},
+) func() {
+	var prior []keyFunc
+	updateGlobals(func(g *globals) {
+		prior = g.keyFuncs
+		funcs := make([]keyFunc, 0, len(prior)+1)
+		found := false
+		for _, kf := range prior {
+			if key != kf.key {
+				funcs = append(funcs, kf)
+			} else if nil != f {
+				funcs = append(funcs, keyFunc{key: key, f: f})
+				found = true
+			}
+		}
+		if !found && nil != f {
+			funcs = append(funcs, keyFunc{key: key, f: f})
+		}
+		if 0 == len(funcs) {
+			funcs = nil
+		}
+		g.keyFuncs = funcs
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.keyFuncs = prior
+		})
+	}
+}
+
+// Returns the function for the timestamp key [keyWhen] or the log level
+// key [keyLev], or 'nil' if there is none.
+func (l *logger) keyFunc(which int) func(time.Time, string) interface{} {
+	if nil == l.g.keyFuncs || nil == l.g.keys {
+		return nil
+	}
+	key := l.g.keys.when
+	if keyLev == which {
+		key = l.g.keys.lev
+	}
+	for _, kf := range l.g.keyFuncs {
+		if key == kf.key {
+			return kf.f
+		}
+	}
+	return nil
+}
+
+// Writes the pairs for the extra keys that have functions.
+func (l *logger) writeKeyFuncs(b *buffer) {
+	if nil == l.g.keys {
+		return
+	}
+	lev := l.lev.String()
+	for _, kf := range l.g.keyFuncs {
+		if l.g.keys.when != kf.key && l.g.keys.lev != kf.key {
+			b.pair(kf.key, kf.f(b.now, lev))
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 /// TYPES ///
@@ -34,17 +35,148 @@ type globals struct {
 	// Optional alternate destination for logs.
 	dest io.Writer
 
+	// Optional destinations for logs of specific levels (override dest).
+	levDest [int(nLevels)]io.Writer
+
+	// Optional destinations for logs from modules (override levDest).
+	modRoutes []ModuleRoute
+
+	// Destinations for logs from module hierarchies [SetModuleOutput()].
+	modOutputs map[string]io.Writer
+
+	// Module levels set by name or by module hierarchy.
+	modTree *modTree
+
+	// If positive, the limit on how long each write to an output can take.
+	writeTimeout time.Duration
+
+	// Callbacks for patterns of log lines [see AddTrigger()].
+	triggers []*trigger
+
+	// Channels that get an Entry for each log line [see Subscribe()].
+	subs []*subscriber
+
+	// Checks for the clock going backwards [see DetectClockRegression()].
+	clockGuard *clockGuard
+
+	// Key for the numeric timestamp, if any [see SetEpochTimestamp()].
+	epochKey  string
+	epochOnly bool // Whether it replaces the string timestamp.
+
+	// Functions that compute the values of keys [see SetKeyFunc()].
+	keyFuncs []keyFunc
+
+	// Pairs added to the context pairs of every line [SetGlobalPairs()].
+	globalPairs AMap
+
+	// Pairs identifying the host and process [see AddHostPairs()].
+	hostPairs AMap
+
+	// Levels that log their call site, and its key [SetCallerLevels()].
+	callerLevels [int(nLevels)]bool
+	callerKey    string
+
+	// How GcpHttp() logs "latency" [see SetGcpLatencyFormat()].
+	gcpLatency string
+
+	// Policies for raising module levels after errors [AddEscalation()].
+	escalations []*escalation
+
+	// Functions that decide whether lines are written [see AddFilter()].
+	filters []*filter
+
+	// Latency objectives checked for access log lines [see SetSLOs()].
+	slos []SLO
+
+	// If not nil, tracks log volume per value of a key.
+	account *accountant
+
+	// Static labels added to every log line [see SetBillingLabels()].
+	billing AMap
+
+	// Default retention class for each level [see SetLevelRetention()].
+	retention [int(nLevels)]string
+
+	// If positive, the cap on estimated log line size [SetMaxLineSize()].
+	maxSize int
+
+	// If not nil, numbers each log line [see EnableSequencing()].
+	seq *sequencer
+
+	// If not nil, marks the first line for each message [MarkFirstSeen()].
+	firstSeen *firstSeen
+
+	// The Modules of a Logger from New(); 'nil' to use modMap.
+	mods *sync.Map
+
+	// If not nil, the context pairs included when computing "_id"s.
+	entryIDs []string
+
+	// If not nil, log lines are written in a format other than JSON.
+	format *lineFormat
+
+	// Which modules are shown in console format.
+	conMods consoleModules
+
+	// Which levels also get written to os.Stderr.
+	mirror [int(nLevels)]bool
+
+	// Optional destination for log lines that could not be written.
+	fallback io.Writer
+
+	// How many log lines could not be written [see FailedWrites()].
+	failedWrites *uint64
+
+	// Set when log lines are written by a background goroutine.
+	async *asyncOutput
+
+	// Set when writes to pipes and sockets are coalesced.
+	coalesce *asyncOutput
+
+	// Which levels get dropped when the async queue is full.
+	drop [int(nLevels)]bool
+
+	// For each level, keep 1 in this many lines (0 or 1 to keep all).
+	sample [int(nLevels)]uint32
+
+	// If not nil, identical consecutive log lines are collapsed.
+	dedup *dedupState
+
+	// If not nil, holds detailed log lines until a failure is logged.
+	flight *flightRecorder
+
+	// How to log maps with keys that json.Marshal() rejects.
+	mapKeys MapKeyPolicy
+
 	// How much of source code file path to include in caller info.
 	pathParts int
 
 	// Required function to transform log level name before logging it.
 	levDesc func(string) string
 
+	// If not nil, maps a level name to a number to log [see sevKey].
+	sevNum func(string) int
+
+	// The key for the number from sevNum; "" to log it instead of levDesc.
+	sevKey string
+
 	// Add '"json": 1' when jsonPayload.text would become textPayload?
 	inGcp bool
 
+	// Add the "ecs.version" pair to each log line?
+	inEcs bool
+
+	// Use AWS X-Ray keys for trace pairs?
+	inAws bool
+
+	// Use Application Insights keys for trace pairs?
+	inAzure bool
+
 	// Used when setting Display Name of a Span.
 	spanPrefix string
+
+	// Rules for changing the level of log lines based on content.
+	levRules []levelRule
 }
 
 // 'Lager' is the interface returned from lager.Warn() and the other
@@ -76,7 +208,9 @@ type globals struct {
 // is disabled or when lager.Unless() causes the key/value pair to be
 // ignored.  [Note:  If more than about 16KiB of that log line has been
 // generated before such a value is reached, then we only wait 10ms for
-// the function to finish as a lock is held in that case.]
+// the function to finish as a lock is held in that case.]  The same is
+// true for a value that implements lager.Valuer, whose LagerValue() method
+// is called instead.
 //
 type Lager interface {
 
@@ -144,11 +278,57 @@ type Lager interface {
 	// Same as '.WithCaller(0).MMap(...)'.
 	CMMap(message string, pairs ...interface{})
 
+	// MFields() is like MMap() but only takes Fields [see Str(), Int(),
+	// etc.], which do not have to be converted to interface{} values, so
+	// it allocates less in hot code paths:
+	//
+	//      lager.Info().MFields("Fetched", lager.Str("key", key),
+	//          lager.Int("bytes", len(body)), lager.Dur("elapsed", took))
+	//
+	MFields(message string, fields ...Field)
+
+	// Same as '.WithCaller(0).MFields(...)'.
+	CMFields(message string, fields ...Field)
+
+	// MFmt() is like MMap() except the message is built by passing 'format'
+	// and 'args' to fmt.Sprintf().  This is only done if the line will be
+	// logged (or if a LevelRule must check the message), so disabled
+	// levels and filtered or sampled lines do not pay for formatting the
+	// message.  If the last argument is from Map() or Pairs(), then it
+	// provides the key/value pairs to log and is not passed to
+	// fmt.Sprintf():
+	//
+	//      lager.Debug().MFmt("Cache hit rate %.1f%%", 100*rate,
+	//          lager.Map("cache", name))
+	//
+	// As with MMap(), prefer logging values as pairs over formatting them
+	// into the message.
+	//
+	MFmt(format string, args ...interface{})
+
+	// Same as '.WithCaller(0).MFmt(...)'.
+	CMFmt(format string, args ...interface{})
+
 	// With() returns a new Lager that adds to each log line the key/value
 	// pairs from zero or more context.Context values.
 	//
 	With(ctxs ...context.Context) Lager
 
+	// WithPairs() returns a new Lager that adds the passed-in key/value
+	// pairs to each log line (along with any context pairs).  The pairs
+	// are encoded just once, when WithPairs() is called, rather than for
+	// each log line, so this is efficient for values that get logged
+	// repeatedly, such as a request ID or a user ID:
+	//
+	//      log := lager.Info(ctx).WithPairs("reqID", reqID, "user", user)
+	//      log.MMap("Fetching", "key", key)
+	//      log.MMap("Fetched", "key", key, "bytes", len(body))
+	//
+	// So a func() interface{} value is only called once.  Pairs bound via
+	// WithPairs() replace context pairs that have the same key.
+	//
+	WithPairs(pairs ...interface{}) Lager
+
 	// Enabled() returns 'false' only if this Lager will log nothing.
 	Enabled() bool
 
@@ -207,7 +387,12 @@ func (_ noop) Map(_ ...interface{})               {}
 func (_ noop) CMap(_ ...interface{})              {}
 func (_ noop) MMap(_ string, _ ...interface{})    {}
 func (_ noop) CMMap(_ string, _ ...interface{})   {}
+func (_ noop) MFields(_ string, _ ...Field)       {}
+func (_ noop) CMFields(_ string, _ ...Field)      {}
+func (_ noop) MFmt(_ string, _ ...interface{})    {}
+func (_ noop) CMFmt(_ string, _ ...interface{})   {}
 func (n noop) With(_ ...Ctx) Lager                { return n }
+func (n noop) WithPairs(_ ...interface{}) Lager   { return n }
 func (n noop) WithStack(_, _ int) Lager           { return n }
 func (n noop) WithCaller(_ int) Lager             { return n }
 func (_ noop) Enabled() bool                      { return false }
@@ -237,10 +422,21 @@ const (
 
 // The 'logger' type is the Lager that actually logs.
 type logger struct {
-	lev level    // Log level.
-	kvp AMap     // Extra key/value pairs to append to each log line.
-	mod string   // The module name where the log level is en/disabled.
-	g   *globals // Global configuration at time logger was allocated.
+	lev     level    // Log level.
+	kvp     AMap     // Extra key/value pairs to append to each log line.
+	mod     string   // The module name where the log level is en/disabled.
+	g       *globals // Global configuration at time logger was allocated.
+	flush   bool     // Whether to flush output after each line [FlushOn()].
+	noFloor bool     // Whether to ignore the floor [SetLevelFloor()].
+	tmpl    string   // The format string passed to MFmt() (if any).
+	acctVal string   // Value of the accounting key [SetVolumeAccounting()].
+	pending bool     // Level disabled; only logs if a LevelRule promotes it.
+
+	// Pairs added via WithPairs() (if any), along with their encoding.
+	bound *boundPairs
+
+	// The pairs passed to Map() or MMap(), kept only for Subscribe().
+	pairs []interface{}
 }
 
 // fakePanic is just used to reliably identify a panic due to lager.Exit().
@@ -310,12 +506,24 @@ func getGlobals() *globals {
 	return p.(*globals)
 }
 
+// Returns the registry of Modules that log lines using 'g' come from.
+func (g *globals) modules() *sync.Map {
+	if nil != g.mods {
+		return g.mods
+	}
+	return &modMap
+}
+
 // How to safely make updates to _globals.
 func updateGlobals(updater func(*globals)) {
 	_firstInit.Do(firstInit)
 	defer AutoLock(&_globalsMutex)()
-	curr := getGlobals()
-	copy := *curr
+	_globals.Store(getGlobals().clone(updater))
+}
+
+// Returns an updated copy of the configuration.
+func (g *globals) clone(updater func(*globals)) *globals {
+	copy := *g
 	// Copy all loggers so we can change the g pointer only in the new copies:
 	for i, l := range copy.lagers {
 		if pLog, ok := l.(*logger); ok {
@@ -330,7 +538,7 @@ func updateGlobals(updater func(*globals)) {
 			pLog.g = &copy
 		}
 	}
-	_globals.Store(&copy)
+	return &copy
 }
 
 // firstInit() is called the first time logging is attempted or configuration
@@ -340,12 +548,16 @@ func updateGlobals(updater func(*globals)) {
 //
 func firstInit() {
 	g := globals{
-		pathParts: 3,
-		levDesc:   identLevelNotation,
+		pathParts:    3,
+		levDesc:      identLevelNotation,
+		failedWrites: new(uint64),
 	}
 	g.lagers[int(lPanic)] = &logger{lev: lPanic}
 	g.lagers[int(lExit)] = &logger{lev: lExit}
-	setLevels(os.Getenv("LAGER_LEVELS"))(&g)
+	levels, _ := splitLevels(os.Getenv("LAGER_LEVELS"))
+	setLevels(levels)(&g)
+	envLevelRules(&g)
+	envModTree(&g)
 
 	g.spanPrefix = os.Getenv("LAGER_SPAN_PREFIX")
 	if "" == g.spanPrefix {
@@ -365,6 +577,50 @@ func firstInit() {
 		setRunningInGcp(true)(&g)
 	}
 
+	if "" != os.Getenv("LAGER_ECS") {
+		setRunningInEcs(true)(&g)
+	}
+
+	if "" != os.Getenv("LAGER_AWS") {
+		setRunningInAws(true)(&g)
+	}
+
+	if "" != os.Getenv("LAGER_AZURE") {
+		setRunningInAzure(true)(&g)
+	}
+
+	if "" != os.Getenv("LAGER_MUTE") {
+		atomic.StoreInt32(&_muted, 1)
+	}
+
+	if levs := os.Getenv("LAGER_MIRROR_STDERR"); "" != levs {
+		setMirrorStderr(levs)(&g)
+	}
+
+	if "" != os.Getenv("LAGER_SPLIT_STDERR") {
+		setLevelOutput(splitStderrLevels, os.Stderr)(&g)
+	}
+
+	envConsole(&g)
+	envLogfmt(&g)
+	envSiem(&g)
+	envSyslog(&g)
+	envSampling(&g)
+	envDedupWindow(&g)
+	envFlightRecorder(&g)
+	envMaxLineSize(&g)
+	envSequence(&g)
+	envFlatJSON(&g)
+	envSeverityNumbers(&g)
+	envMapKeys(&g)
+	envBillingLabels(&g)
+	envLevelRetention(&g)
+	envEpochTimestamp(&g)
+	envGlobalPairs(&g)
+	envHostPairs(&g)
+	envCallerLevels(&g)
+	envGcpLatency(&g)
+
 	if k := os.Getenv("LAGER_KEYS"); "" != k {
 		keys := strings.Split(k, ",")
 		if 6 != len(keys) {
@@ -382,9 +638,17 @@ func firstInit() {
 		})(&g)
 	}
 
+	envLevelFloor(&g)
 	_globals.Store(&g)
 }
 
+// Logs an invalid environment setting at the Exit level (so the process
+// exits) while firstInit() is still setting up 'g'.  Exit() can't be used
+// then, as it would wait for firstInit() to finish (forever).
+func initFatal(g *globals, msg string, pairs ...interface{}) {
+	(&logger{lev: lExit, g: g}).MMap(msg, pairs...)
+}
+
 // Init() en-/disables log levels.  Pass in a string of letters from
 // "FWNAITDOG" to indicate which log levels should be the only ones that
 // produce output.  Each letter is the first letter of a log level (Fail,
@@ -403,6 +667,38 @@ func Init(levels string) {
 	updateGlobals(setLevels(levels))
 }
 
+// SetLevels() is like Init() but returns a function that restores the
+// levels that were enabled before.  Levels can be changed at any time,
+// even while other goroutines are logging, so a service can raise or lower
+// its verbosity without restarting:
+//
+//      restore := lager.SetLevels("FWNAITD")
+//      time.AfterFunc(10*time.Minute, restore)
+//
+// This only changes the global levels.  Use SetModuleLevels() [or
+// Module.Init()] to change the levels of a Module.
+//
+func SetLevels(levels string) func() {
+	var prior string
+	updateGlobals(func(g *globals) {
+		prior = g.enabled
+		if "" == prior {
+			prior = "-" // Not "" as that means "FWNA".
+		}
+		setLevels(levels)(g)
+	})
+	return func() {
+		updateGlobals(setLevels(prior))
+	}
+}
+
+// GetLevels() returns the globally enabled levels (other than Panic and
+// Exit), such as "FWNA".
+//
+func GetLevels() string {
+	return getGlobals().enabled
+}
+
 // How log level initialization is done safely.
 func setLevels(levels string) func(*globals) {
 	return func(g *globals) {
@@ -468,6 +764,93 @@ func SetOutput(writer io.Writer) func() {
 	}
 }
 
+// SetLevelOutput() causes future log lines of the given log levels to be
+// written to the passed-in io.Writer, overriding SetOutput() for just those
+// levels.  'levels' is a string of letters from "PEFWNAITDOG" (any other
+// characters are ignored).  Passing in 'nil' for 'writer' returns those
+// levels to using the output from SetOutput() (or the default).  It
+// returns a function that restores the prior settings:
+//
+//      defer lager.SetLevelOutput("OG", debugFile)()
+//
+// See also SplitStderr().
+//
+func SetLevelOutput(levels string, writer io.Writer) func() {
+	var prior [int(nLevels)]io.Writer
+	updateGlobals(func(g *globals) {
+		prior = g.levDest
+		setLevelOutput(levels, writer)(g)
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.levDest = prior
+		})
+	}
+}
+
+// The levels that SplitStderr() sends to os.Stderr.
+const splitStderrLevels = "PEFW"
+
+// SplitStderr() makes Warn and more severe log lines go to os.Stderr while
+// Note and less severe log lines go to os.Stdout (unless SetOutput() was
+// called), which is a common convention for containers.  This is the same
+// as 'lager.SetLevelOutput("PEFW", os.Stderr)'.  Setting LAGER_SPLIT_STDERR
+// to a non-empty value in the environment does this automatically.  It
+// returns a function that restores the prior settings.
+//
+func SplitStderr() func() {
+	return SetLevelOutput(splitStderrLevels, os.Stderr)
+}
+
+// MirrorStderr() causes log lines of the given levels to also be written
+// to os.Stderr, even when the output is a file or network sink [see
+// SetOutput()], so operators still see serious errors on the console.
+// 'levels' is a string of letters from "PEFWNAITDOG" (other characters are
+// ignored); "" turns off mirroring.  Lines that are already going to
+// os.Stderr are not duplicated.  It returns a function that restores the
+// prior setting:
+//
+//      defer lager.MirrorStderr("PEF")()
+//
+// Setting LAGER_MIRROR_STDERR in the environment (such as to "PEF") has
+// the same effect as calling MirrorStderr() when the program starts.
+//
+func MirrorStderr(levels string) func() {
+	var prior [int(nLevels)]bool
+	updateGlobals(func(g *globals) {
+		prior = g.mirror
+		setMirrorStderr(levels)(g)
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.mirror = prior
+		})
+	}
+}
+
+// Returns a function that sets which levels get mirrored to os.Stderr.
+func setMirrorStderr(levels string) func(*globals) {
+	return func(g *globals) {
+		g.mirror = [int(nLevels)]bool{}
+		for _, c := range []byte(levels) {
+			if lev, ok := letterLevel(c); ok {
+				g.mirror[int(lev)] = true
+			}
+		}
+	}
+}
+
+// Returns a function that sets the output for the given levels.
+func setLevelOutput(levels string, writer io.Writer) func(*globals) {
+	return func(g *globals) {
+		for _, c := range []byte(levels) {
+			if lev, ok := letterLevel(c); ok {
+				g.levDest[int(lev)] = writer
+			}
+		}
+	}
+}
+
 // SetPathParts() sets how many path components to include in the source
 // code file names when recording caller information or a stack trace.
 // Passing in 1 will cause only the source code file name to be included.
@@ -581,7 +964,7 @@ func ExitNotExpected(unexpected bool) {
 // Gets a Lager based on the internal enum for a log level.
 func forLevel(lev level, cs ...Ctx) Lager {
 	g := getGlobals()
-	l := g.lagers[int(lev)].With(cs...)
+	l := g.promotable(g.lagers[int(lev)], lev, "").With(cs...)
 	return l
 }
 
@@ -726,6 +1109,36 @@ func Level(lev byte, cs ...Ctx) Lager {
 		"Level() must be one char from \"PEFWNAITDOG\" not %q", lev))
 }
 
+// Converts one letter from "PEFWNAITDOG" (or its lower-case version) to
+// the internal enum for a log level.  Returns 'false' for anything else.
+func letterLevel(c byte) (level, bool) {
+	switch c {
+	case 'P', 'p':
+		return lPanic, true
+	case 'E', 'e':
+		return lExit, true
+	case 'F', 'f':
+		return lFail, true
+	case 'W', 'w':
+		return lWarn, true
+	case 'N', 'n':
+		return lNote, true
+	case 'A', 'a':
+		return lAcc, true
+	case 'I', 'i':
+		return lInfo, true
+	case 'T', 't':
+		return lTrace, true
+	case 'D', 'd':
+		return lDebug, true
+	case 'O', 'o':
+		return lObj, true
+	case 'G', 'g':
+		return lGuts, true
+	}
+	return nLevels, false
+}
+
 func (l level) String() string {
 	name := levNames[l]
 	if "" != name {
@@ -779,6 +1192,19 @@ func Keys(when, lev, msg, args, ctx, mod string) {
 	}))
 }
 
+// GetKeys() returns the keys set by Keys() (or 6 empty strings if log
+// lines are written as lists), so they can be restored later:
+//
+//      defer lager.Keys(lager.GetKeys())
+//
+func GetKeys() (when, lev, msg, args, ctx, mod string) {
+	k := getGlobals().keys
+	if nil == k {
+		return
+	}
+	return k.when, k.lev, k.msg, k.args, k.ctx, k.mod
+}
+
 // GetSpanPrefix() returns a string to be used as the prefix for the Display
 // Name of trace spans.  It defaults to os.Getenv("LAGER_SPAN_PREFIX") or,
 // if that is not set, to the basename of 'os.Args[0]'.
@@ -801,47 +1227,136 @@ func (l *logger) Enabled() bool { return true }
 // See the Lager interface for documentation.
 func (l *logger) With(ctxs ...Ctx) Lager {
 	kvp := l.kvp
+	flush := l.flush
 	for _, ctx := range ctxs {
 		kvp = kvp.Merge(ContextPairs(ctx))
+		flush = flush || flushesOn(ctx)
 	}
-	if kvp == l.kvp {
+	if kvp == l.kvp && flush == l.flush {
 		return l
 	}
 	cp := *l
 	cp.kvp = kvp
+	cp.flush = flush
 	return &cp
 }
 
 // Opening steps when actually logging a line.
-func (l *logger) start() *buffer {
+func (l *logger) start(msg string) *buffer {
 	b := bufPool.Get().(*buffer)
 	b.g = l.g
+	b.msg, b.size, b.drop = msg, 0, nil
+	b.g.noteTriggers(l.lev, l.mod)
+	if nil != b.g.flight && l.lev <= lFail {
+		b.g.flight.dump()
+	}
 	switch l.lev {
 	case lPanic, lExit:
 		b.w = os.Stderr
 	default:
 		b.w = os.Stdout
 	}
-	if nil != b.g.dest {
+	if w := b.g.moduleOutput(l.mod); nil != w {
+		b.w = w
+	} else if nil != b.g.levDest[int(l.lev)] {
+		b.w = b.g.levDest[int(l.lev)]
+	} else if nil != b.g.dest {
 		b.w = b.g.dest
 	}
+	if b.g.mirror[int(l.lev)] && !sameWriter(b.w, os.Stderr) {
+		b.w = TeeOutput(b.w, os.Stderr)
+	}
+	if 0 != atomic.LoadInt32(&_streamClients) {
+		b.w = TeeOutput(b.w, _streams)
+	}
+	b.w = l.bind(b, b.w, true)
+	var dw *dedupWriter
+	if nil != b.g.dedup {
+		dw = &dedupWriter{d: b.g.dedup, w: b.w, inMap: nil != l.g.keys,
+			pass: l.lev < lFail || l.flush}
+		b.w = dw
+	}
+	if l.held() {
+		b.w = &flightWriter{fr: b.g.flight, h: heldLine{w: b.w}}
+	}
+	if stamp, epoch := l.head(b); nil != dw {
+		dw.stamp, dw.epoch = stamp, epoch
+	}
+	return b
+}
+
+// Returns whether the flight recorder holds lines like this one [see
+// SetFlightRecorder()].
+func (l *logger) held() bool {
+	fr := l.g.flight
+	return nil != fr && fr.levels[int(l.lev)] && !l.flush
+}
+
+// Returns 'w' wrapped so that writes to it are queued, if async mode [see
+// SetAsync()] or coalescing [see SetCoalescing()] applies to it.  Queueing
+// each output (rather than the whole line) lets lines for the same output
+// be written together, even when they also go to other outputs.
+func (l *logger) queued(b *buffer, w io.Writer) io.Writer {
+	a := b.g.async
+	if nil == a && nil != b.g.coalesce && isStream(w) {
+		a = b.g.coalesce
+	}
+	if nil == a {
+		return w
+	}
+	if b.g.drop[int(l.lev)] && !l.held() && nil == b.drop {
+		b.drop = &droppedLine{lev: l.lev}
+	}
+	return a.writer(b.g, w, b.drop)
+}
 
+// Writes the start of a log line:  the timestamp and the log level.  It
+// returns the offset into the line of just after the timestamp and the
+// offsets of the start and end of the epoch pair (if any), which comes
+// after the level so sinks can find the level right after the timestamp.
+func (l *logger) head(b *buffer) (stamp int, epoch [2]int) {
 	if nil == l.g.keys {
 		b.open("[") // ]
 	} else {
 		b.open("{") // }
-		b.quote(l.g.keys.when)
-		b.colon()
+		b.key(l.g.keys.when)
+	}
+	if f := l.keyFunc(keyWhen); nil != f {
+		b.setNow()
+		b.scalar(f(b.now, l.lev.String()))
+	} else {
+		b.timestamp()
 	}
-	b.timestamp()
+	stamp = b.size + len(b.json.out)
 
 	if nil != l.g.keys {
-		b.quote(l.g.keys.lev)
-		b.colon()
+		b.key(l.g.keys.lev)
 	}
-	b.scalar(b.g.levDesc(l.lev.String()))
-
-	return b
+	if f := l.keyFunc(keyLev); nil != f {
+		b.scalar(f(b.now, l.lev.String()))
+		if nil != b.g.sevNum && "" != b.g.sevKey {
+			b.pair(b.g.sevKey, b.g.sevNum(l.lev.String()))
+		}
+	} else if nil != b.g.sevNum && "" == b.g.sevKey {
+		b.scalar(b.g.sevNum(l.lev.String()))
+	} else {
+		b.scalar(b.g.levDesc(l.lev.String()))
+		if nil != b.g.sevNum && nil != l.g.keys {
+			b.pair(b.g.sevKey, b.g.sevNum(l.lev.String()))
+		}
+	}
+	if "" != l.g.epochKey && !l.g.epochOnly && nil != l.g.keys {
+		epoch[0] = b.size + len(b.json.out)
+		b.pair(l.g.epochKey, epochMillis(b.now))
+		epoch[1] = b.size + len(b.json.out)
+	}
+	if l.g.inEcs && nil != l.g.keys {
+		b.pair("ecs.version", EcsVersion)
+	}
+	if nil != l.g.keyFuncs {
+		l.writeKeyFuncs(b)
+	}
+	return stamp, epoch
 }
 
 // Closing steps when actually logging a line.
@@ -850,19 +1365,79 @@ func (l *logger) end(b *buffer) {
 		// 0: skip end(), 1: skip MMap() etc, 2: get caller of MMap() etc:
 		l = l.WithStack(2, 0).(*logger)
 	}
-	if nil != l.kvp && 0 < len(l.kvp.keys) {
-		if nil == l.g.keys {
-			b.scalar(l.kvp)
-		} else if "" == l.g.keys.ctx {
-			b.pairs(l.kvp)
-		} else {
-			b.pair(l.g.keys.ctx, l.kvp)
+	l.tail(b)
+	w := b.w
+	b.unlock()
+	if nil != l.g.account {
+		l.g.account.add(l.acctVal, b.lineSize())
+	}
+	if nil != l.g.subs {
+		l.publish(b)
+	}
+	failed := b.failed
+	b.failed = nil
+	b.resetEncs()
+	bufPool.Put(b)
+	if nil != failed {
+		l.g.outputFailed(failed)
+	}
+	if nil != l.g.clockGuard {
+		l.g.clockGuard.report()
+	}
+	if l.flush || lExit == l.lev || lPanic == l.lev {
+		flushOutput(w)
+	}
+
+	switch l.lev {
+	case lExit:
+		if 0 == atomic.LoadInt32(&_exiters) {
+			os.Exit(1)
 		}
+		panic(_panicToExit)
+	case lPanic:
+		panic("lager.Panic() logged (see above)")
+	}
+}
+
+// Writes the end of a log line:  the context pairs and the module name.
+func (l *logger) tail(b *buffer) {
+	if nil != l.g.globalPairs || nil != l.g.hostPairs {
+		l = l.withGlobalPairs()
+	}
+	if 0 != atomic.LoadInt32(&_clockUnsynced) {
+		l = l.withClockUnsynced()
+	}
+	if nil != l.g.seq {
+		l = l.withSeq()
+	}
+	if nil != l.g.firstSeen {
+		l = l.withFirstSeen(b.msg)
+	}
+	if "" != l.g.retention[int(l.lev)] {
+		l = l.withRetention()
+	}
+	if nil != l.g.entryIDs {
+		l = l.withEntryID(b)
+	}
+	if "" != l.g.epochKey && !l.g.epochOnly && nil == l.g.keys {
+		l = l.withEpoch(b)
+	}
+	if l.g.callerLevels[int(l.lev)] {
+		l = l.withCallSite()
+	}
+	if l.hasCtxPairs() {
+		b.context(true)
+		l.writeCtxPairs(b)
+		b.context(false)
+	}
+
+	if nil != l.g.billing {
+		l.writeBilling(b)
 	}
 
 	if "" != l.mod {
 		if nil == l.g.keys {
-			b.quote("mod=" + l.mod)
+			b.quote("mod=", l.mod)
 		} else {
 			b.pair(l.g.keys.mod, l.mod)
 		}
@@ -873,20 +1448,6 @@ func (l *logger) end(b *buffer) {
 	} else { // {
 		b.close("}\n")
 	}
-
-	b.delim = ""
-	b.unlock()
-	bufPool.Put(b)
-
-	switch l.lev {
-	case lExit:
-		if 0 == atomic.LoadInt32(&_exiters) {
-			os.Exit(1)
-		}
-		panic(_panicToExit)
-	case lPanic:
-		panic("lager.Panic() logged (see above)")
-	}
 }
 
 // See the Lager interface for documentation.
@@ -897,12 +1458,30 @@ func (l *logger) LogLogger(filters ...func(Lager, []byte) []byte) *log.Logger {
 	return log.New(Flusher{l, filters}, "", 0)
 }
 
+// Applies level rules, filters, sampling, and annotations to a log line
+// about to be written.  'args' is what was passed to List(), MMap(), etc.
+// while 'pairs' is 'nil' unless those are key/value pairs.  Returns the
+// logger to use, which is 'nil' if the line is not to be written.
+func (l *logger) prepare(msg string, args, pairs []interface{}) *logger {
+	return l.relevel(msg, args).filter(pairs).sample().slo(pairs).
+		account(pairs).forSubscribers(pairs)
+}
+
 // See the Lager interface for documentation.
 func (l *logger) List(args ...interface{}) {
-	b := l.start()
+	msg := ""
+	if 1 == len(args) {
+		msg, _ = args[0].(string)
+	}
+	if l = l.prepare(msg, args, nil); nil == l ||
+		l.muted() || l.oversized("", args) {
+		return
+	}
+	b := l.start(msg)
 	if nil == l.g.keys {
 		if 0 == len(args) {
-			b.write(", []")
+			b.open("[")
+			b.close("]")
 		} else if 1 == len(args) {
 			b.scalar(args[0])
 		} else {
@@ -910,7 +1489,7 @@ func (l *logger) List(args ...interface{}) {
 		}
 	} else if 1 == len(args) && "" != l.g.keys.msg {
 		b.pair(l.g.keys.msg, args[0])
-		if l.g.inGcp && (nil == l.kvp || 0 == len(l.kvp.keys)) {
+		if l.g.inGcp && !l.hasCtxPairs() {
 			b.pair("json", 1) // Keep jsonPayload.message not textPayload
 		}
 	} else {
@@ -921,7 +1500,11 @@ func (l *logger) List(args ...interface{}) {
 
 // See the Lager interface for documentation.
 func (l *logger) MList(message string, args ...interface{}) {
-	b := l.start()
+	if l = l.prepare(message, args, nil); nil == l ||
+		l.muted() || l.oversized(message, args) {
+		return
+	}
+	b := l.start(message)
 	if nil == l.g.keys {
 		if 0 == len(args) {
 			b.scalar(message)
@@ -932,12 +1515,11 @@ func (l *logger) MList(message string, args ...interface{}) {
 		b.pair(l.g.keys.msg, message)
 		if 0 < len(args) {
 			b.pair(l.g.keys.args, args)
-		} else if l.g.inGcp && (nil == l.kvp || 0 == len(l.kvp.keys)) {
+		} else if l.g.inGcp && !l.hasCtxPairs() {
 			b.pair("json", 1) // Keep jsonPayload.message not textPayload
 		}
 	} else if 0 < len(args) {
-		b.quote(l.g.keys.args)
-		b.colon()
+		b.key(l.g.keys.args)
 		b.msgList(message, args)
 	} else {
 		// Put the single item in a list for sake of consistency:
@@ -948,7 +1530,11 @@ func (l *logger) MList(message string, args ...interface{}) {
 
 // See the Lager interface for documentation.
 func (l *logger) Map(pairs ...interface{}) {
-	b := l.start()
+	if l = l.prepare("", pairs, pairs); nil == l ||
+		l.muted() || l.oversized("", pairs) {
+		return
+	}
+	b := l.start("")
 	if nil == l.g.keys {
 		b.scalar(RawMap(pairs))
 	} else {
@@ -959,7 +1545,64 @@ func (l *logger) Map(pairs ...interface{}) {
 
 // See the Lager interface for documentation.
 func (l *logger) MMap(message string, pairs ...interface{}) {
-	b := l.start()
+	if l = l.prepare(message, pairs, pairs); nil == l ||
+		l.muted() || l.oversized(message, pairs) {
+		return
+	}
+	b := l.start(message)
+	l.mmap(b, message, pairs)
+	l.end(b)
+}
+
+// See the Lager interface for documentation.
+func (l *logger) MFields(message string, fields ...Field) {
+	if l.g.usesPairs() {
+		pairs := make([]interface{}, len(fields))
+		for i, f := range fields {
+			pairs[i] = f
+		}
+		l.MMap(message, pairs...)
+		return
+	}
+	if l = l.prepare(message, nil, nil); nil == l || l.muted() {
+		return
+	}
+	b := l.start(message)
+	if nil == l.g.keys {
+		b.quote(message)
+		if 0 < len(fields) {
+			b.open("{")
+			for _, f := range fields {
+				b.field(f)
+			}
+			b.close("}")
+		}
+	} else {
+		key := l.g.keys.msg
+		if "" == key {
+			key = "msg"
+		}
+		b.key(key)
+		b.quote(message)
+		for _, f := range fields {
+			b.field(f)
+		}
+		if l.g.inGcp && 0 == len(fields) && !l.hasCtxPairs() {
+			b.pair("json", 1) // Keep jsonPayload.message not textPayload
+		}
+	}
+	l.end(b)
+}
+
+// Returns whether deciding how (or whether) to write a log line looks at
+// its pairs, such as for AddFilter() or Subscribe().
+func (g *globals) usesPairs() bool {
+	return nil != g.levRules || nil != g.filters || nil != g.slos ||
+		nil != g.account || nil != g.subs || 0 < g.maxSize
+}
+
+// Writes the message and pairs for MMap() (and AppendEntry()).
+func (l *logger) mmap(b *buffer, message string, pairs []interface{}) {
 	if nil == l.g.keys {
 		b.scalar(message)
 		if 0 < len(pairs) {
@@ -972,10 +1615,43 @@ func (l *logger) MMap(message string, pairs ...interface{}) {
 		}
 		b.pair(key, message)
 		b.rawPairs(RawMap(pairs))
-		if l.g.inGcp && 0 == len(pairs) &&
-			(nil == l.kvp || 0 == len(l.kvp.keys)) {
+		if l.g.inGcp && 0 == len(pairs) && !l.hasCtxPairs() {
 			b.pair("json", 1) // Keep jsonPayload.message not textPayload
 		}
 	}
+}
+
+// See the Lager interface for documentation.
+func (l *logger) MFmt(format string, args ...interface{}) {
+	if l.muted() {
+		return
+	}
+	var pairs []interface{}
+	if n := len(args); 0 < n {
+		switch last := args[n-1].(type) {
+		case RawMap, AMap:
+			pairs, args = []interface{}{InlinePairs, last}, args[:n-1]
+		}
+	}
+	msg, formatted := "", false
+	if l.g.rulesApply(l.lev, l.mod) { // A rule must check the message.
+		msg, formatted = fmt.Sprintf(format, args...), true
+	}
+	if l = l.prepare(msg, pairs, pairs); nil == l || l.muted() {
+		return
+	}
+	if !formatted {
+		msg = fmt.Sprintf(format, args...)
+	}
+	if l.oversized(msg, pairs) {
+		return
+	}
+	if nil != l.g.firstSeen {
+		cp := *l
+		cp.tmpl = format
+		l = &cp
+	}
+	b := l.start(msg)
+	l.mmap(b, msg, pairs)
 	l.end(b)
 }
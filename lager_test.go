@@ -1,15 +1,25 @@
 package lager_test
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -54,7 +64,7 @@ func TestLager(t *testing.T) {
 	log := bytes.NewBuffer(nil)
 	defer lager.SetOutput(log)()
 
-	u.Is("go-lager.test", lager.GetSpanPrefix(), "default span prefix")
+	u.Is("go-lager-internal.test", lager.GetSpanPrefix(), "default span prefix")
 	lager.SetSpanPrefix("lager-test")
 	u.Is("lager-test", lager.GetSpanPrefix(), "updated span prefix")
 
@@ -648,3 +658,3165 @@ func BenchmarkLog(b *testing.B) {
 		}
 	})
 }
+
+// Compares the cost of writing log lines as CBOR or MessagePack to that
+// of writing them as JSON, which should be no cheaper.
+func BenchmarkFormats(b *testing.B) {
+	defer lager.SetOutput(io.Discard)()
+	formats := []struct {
+		name string
+		use  func() func()
+	}{
+		{"json", func() func() { return func() {} }},
+		{"cbor", lager.UseCborFormat},
+		{"msgpack", lager.UseMsgpackFormat},
+	}
+	for _, f := range formats {
+		b.Run(f.name, func(b *testing.B) {
+			defer f.use()()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				lager.Fail().MMap("Fetched", "key", "a7", "bytes", 12345,
+					"took", 3*time.Millisecond, "ratio", 0.25,
+					"tags", lager.List("x", "y"), "error", io.EOF)
+			}
+		})
+	}
+}
+
+type flushCounter struct {
+	bytes.Buffer
+	flushes int
+}
+
+func (fc *flushCounter) Flush() error { fc.flushes++; return nil }
+
+func TestFlushOn(t *testing.T) {
+	u := tutl.New(t)
+	out := new(flushCounter)
+	defer lager.SetOutput(out)()
+
+	ctx := lager.AddPairs(context.Background(), "id", 1)
+	lager.Fail(ctx).List("not flushed")
+	u.Is(0, out.flushes, "no FlushOn")
+
+	fctx := lager.FlushOn(ctx)
+	u.Is(true, fctx == lager.FlushOn(fctx), "FlushOn idempotent")
+	lager.Fail(fctx).List("flushed")
+	u.Is(1, out.flushes, "FlushOn")
+	lager.Fail(ctx, lager.FlushOn(nil)).MMap("flushed", "two", 2)
+	u.Is(2, out.flushes, "FlushOn any ctx")
+	u.Like(out.String(), "pairs kept", `"id":1`)
+
+	u.Is(nil, u.GetPanic(func() {
+		defer lager.ExitViaPanic()(func(x *int) { *x = -1 })
+		lager.Exit().List("always flushed")
+	}), "exit via panic")
+	u.Is(3, out.flushes, "Exit flushes")
+}
+
+func TestMarshalPairs(t *testing.T) {
+	u := tutl.New(t)
+	bg := context.Background()
+	u.Is(0, len(lager.MarshalPairs(bg)), "no pairs")
+	u.Is(true, bg == lager.UnmarshalPairs(bg, nil), "unmarshal nothing")
+	u.Is(true, bg == lager.UnmarshalPairs(bg, []byte(`[1]`)), "not object")
+	u.Is(true, bg == lager.UnmarshalPairs(bg, []byte(`{"a":1`)), "truncated")
+	u.Is(true, bg == lager.UnmarshalPairs(bg, []byte(`{"a":1} 2`)), "garbage")
+
+	ctx := lager.AddPairs(bg, "trace", "abc", "tenant", 12345678901234567,
+		"tags", lager.List("x", 1.5), "req", lager.Pairs("z", 1, "a", nil))
+	data := lager.MarshalPairs(ctx)
+	u.Is(`{"trace":"abc", "tenant":12345678901234567,`+
+		` "tags":["x", 1.5], "req":{"z":1, "a":null}}`, data, "marshal")
+
+	job := lager.AddPairs(bg, "trace", "old", "worker", 2)
+	job = lager.UnmarshalPairs(job, data)
+	u.Is(`{"trace":"abc", "worker":2, "tenant":12345678901234567,`+
+		` "tags":["x", 1.5], "req":{"z":1, "a":null}}`,
+		lager.MarshalPairs(job), "round trip")
+	u.Is(0, len(lager.MarshalPairs(lager.UnmarshalPairs(nil, []byte(`{}`)))),
+		"nil ctx, no pairs")
+	u.Is(`{"a":true}`, lager.MarshalPairs(lager.UnmarshalPairs(nil,
+		[]byte(` {"a": true} `))), "nil ctx")
+}
+
+type failWriter struct{ writes int }
+
+func (fw *failWriter) Write(b []byte) (int, error) {
+	fw.writes++
+	return 0, errors.New("disk full")
+}
+
+func TestTeeOutput(t *testing.T) {
+	u := tutl.New(t)
+	one, two := new(flushCounter), new(flushCounter)
+	bad := new(failWriter)
+	tee := lager.TeeOutput(bad, one, nil, two)
+	restore := lager.SetOutput(tee)
+	lager.Fail().List("both")
+	lager.Fail(lager.FlushOn(nil)).List("flushed")
+	restore()
+	u.Is(2, bad.writes, "failing writer still tried")
+	u.Is(one.String(), two.String(), "same lines to each")
+	u.Like(one.String(), "lines", `"both".*\n.*"flushed"`)
+	u.Is(1, one.flushes, "first flushed")
+	u.Is(1, two.flushes, "second flushed")
+
+	n, err := tee.Write([]byte("x\n"))
+	u.Is(2, n, "write ok if one succeeds")
+	u.Is(nil, err, "no error if one succeeds")
+	n, err = lager.TeeOutput(bad, new(failWriter)).Write([]byte("x\n"))
+	u.Is(0, n, "all failed")
+	u.Like(err, "first error", "disk full")
+}
+
+func TestFallbackOutput(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	bad := new(failWriter)
+	defer lager.SetOutput(bad)()
+	before := lager.FailedWrites()
+	lager.Fail().List("counted")
+	u.Is(before+1, lager.FailedWrites(), "counted without fallback")
+
+	dead := new(bytes.Buffer)
+	restore := lager.SetFallbackOutput(dead)
+	lager.Warn().MMap("dead letter", "n", 1)
+	restore()
+	u.Is(before+2, lager.FailedWrites(), "counted with fallback")
+	lines := strings.Split(dead.String(), "\n")
+	if u.Is(3, len(lines), "fallback lines") {
+		u.Like(lines[0], "original line", `"WARN", "dead letter", {"n":1}`)
+		u.Like(lines[1], "error record", `"FAIL", "Failed to write log line`,
+			`"error":"disk full"`, `"failedWrites":`)
+	}
+
+	lager.Fail().List("after restore")
+	u.Is(3, len(strings.Split(dead.String(), "\n")), "fallback removed")
+	u.Is(3, bad.writes, "output tried each time")
+	dead.Reset()
+
+	lg := lager.New()
+	lg.Fail().List("counted apart")
+	u.Is(uint64(1), lg.FailedWrites(), "Logger counts its own")
+	u.Is(before+3, lager.FailedWrites(), "package count unchanged")
+
+	// Processing of lines does not apply to the error record:
+	defer lager.SetFallbackOutput(dead)()
+	defer lager.EnableSequencing()()
+	defer lager.AddFilter(func(lev, mod string, pairs lager.AMap) bool {
+		return "FAIL" != lev
+	})()
+	subs := lager.Subscribe(nil)
+	defer lager.Unsubscribe(subs)
+	lager.Warn().List("processed")
+	lines = strings.Split(dead.String(), "\n")
+	if u.Is(3, len(lines), "fallback lines when processing") {
+		u.Like(lines[0], "original processed", `"processed", {"seq":`)
+		u.Like(lines[1], "error record not processed",
+			`"FAIL", "Failed to write log line`, `!"seq"`)
+	}
+	u.Is(1, len(subs), "error record not published")
+}
+
+type ctxWriter struct {
+	bytes.Buffer
+	deadline bool
+}
+
+func (cw *ctxWriter) WriteContext(ctx context.Context, b []byte) (int, error) {
+	_, cw.deadline = ctx.Deadline()
+	return cw.Write(b)
+}
+
+func TestWriteTimeout(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	conn, peer := net.Pipe() // Nothing reads from 'peer' so writes hang.
+	defer peer.Close()
+	defer conn.Close()
+	dead := new(bytes.Buffer)
+	defer lager.SetFallbackOutput(dead)()
+	defer lager.SetOutput(conn)()
+	defer lager.SetWriteTimeout(20 * time.Millisecond)()
+
+	before := lager.FailedWrites()
+	lager.Warn().List("hung")
+	u.Is(before+1, lager.FailedWrites(), "sync write timed out")
+	u.Like(dead.String(), "sync fallback", `"WARN", "hung"`,
+		`"Failed to write log line`, `"error":"[^"]*timeout`)
+	dead.Reset()
+
+	restore := lager.SetAsync(10, time.Hour)
+	lager.Warn().List("queued")
+	u.Is(nil, lager.Flush(nil), "Flush")
+	restore()
+	u.Is(before+2, lager.FailedWrites(), "async write timed out")
+	u.Like(dead.String(), "async fallback", `"WARN", "queued"`,
+		`"error":"[^"]*timeout`)
+
+	cw := new(ctxWriter)
+	con, _ := lager.FormatOutput(cw, "logfmt")
+	lager.SetOutput(lager.TeeOutput(con))
+	lager.Warn().List("bounded")
+	u.Is(true, cw.deadline, "deadline passed through")
+	u.Like(cw.String(), "formatted", `*level=WARN msg=bounded`)
+}
+
+func TestAsync(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(flushCounter)
+	defer lager.SetOutput(out)()
+	u.Is(nil, lager.Flush(nil), "sync Flush")
+	u.Is(1, out.flushes, "sync Flush flushes output")
+
+	restore := lager.SetAsync(2, time.Hour)
+	for i := 0; i < 5; i++ {
+		lager.Fail().MMap("queued", "i", i)
+	}
+	u.Is(nil, lager.Flush(context.Background()), "async Flush")
+	u.Is(5, strings.Count(out.String(), `"queued"`), "all lines written")
+	u.Is(2, out.flushes, "async Flush flushes output")
+
+	lager.Fail().List("waiting")
+	lager.Fail(lager.FlushOn(nil)).List("flush on")
+	u.Like(out.String(), "FlushOn waits", `"waiting"\]\n.*"flush on"`)
+	u.Is(3, out.flushes, "FlushOn flushes output")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	lager.Fail().List("last")
+	err := lager.Flush(ctx)
+	if nil != err {
+		u.Is(context.Canceled, err, "canceled Flush")
+	}
+	restore()
+	u.Like(out.String(), "disabling flushes", `"last"\]\n$`)
+	lager.Fail().List("sync again")
+	u.Like(out.String(), "sync after restore", `"sync again"\]\n$`)
+
+	file, con := new(writeCounter), new(writeCounter)
+	fmtd, err := lager.FormatOutput(con, "logfmt")
+	u.Is(nil, err, "logfmt output")
+	defer lager.SetOutput(lager.TeeOutput(file, fmtd))()
+	defer lager.SetAsync(100, time.Hour)()
+	for i := 0; i < 5; i++ {
+		lager.Fail().MMap("teed", "i", i)
+	}
+	u.Is(nil, lager.Flush(nil), "tee Flush")
+	u.Is(5, strings.Count(file.String(), `"teed"`), "tee lines")
+	u.Is(5, strings.Count(con.String(), `msg=teed`), "formatted lines")
+	u.Is(1, file.writes, "tee lines written together")
+	u.Is(1, con.writes, "formatted lines written together")
+}
+
+// Counts how many times Write() is called.
+type writeCounter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (wc *writeCounter) Write(b []byte) (int, error) {
+	wc.writes++
+	return wc.Buffer.Write(b)
+}
+
+func TestLevelOutput(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out, errs := new(flushCounter), new(flushCounter)
+	defer lager.SetOutput(out)()
+	restore := lager.SetLevelOutput("FWx", errs)
+	lager.Fail().List("fail")
+	lager.Warn().List("warn")
+	lager.Note().List("note")
+	lager.Flush(nil)
+	u.Is(1, out.flushes, "main output flushed")
+	u.Is(1, errs.flushes, "level output flushed")
+	restore()
+	lager.Fail().List("restored")
+	u.Like(errs.String(), "level output", `"fail"`, `"warn"`, `!"note"`)
+	u.Like(out.String(), "other output", `"note"`, `"restored"`, `!"warn"`)
+
+	restore = lager.SplitStderr()
+	restore2 := lager.SetLevelOutput("W", nil)
+	lager.Warn().List("back to out")
+	restore2()
+	restore()
+	u.Like(out.String(), "nil writer", `"back to out"`)
+}
+
+func TestModuleRoutes(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out, audit, bill := new(flushCounter), new(flushCounter), new(flushCounter)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevelOutput("F", out)()
+	u.Like(lager.SetModuleRoutes(lager.ModuleRoute{"[", audit}),
+		"bad pattern", "*Invalid module pattern ([)", "*syntax error")
+	u.Is(nil, lager.SetModuleRoutes(
+		lager.ModuleRoute{"audit", audit},
+		lager.ModuleRoute{"bill*", bill},
+		lager.ModuleRoute{"*", nil},
+	), "set routes")
+	lager.NewModule("audit").Fail().List("audited")
+	lager.NewModule("billing").Warn().List("billed")
+	lager.NewModule("other").Warn().List("other")
+	lager.Warn().List("no module")
+	u.Is(nil, lager.Flush(nil), "Flush")
+	u.Is(nil, lager.SetModuleRoutes(), "clear routes")
+	lager.NewModule("audit").Warn().List("not audited")
+
+	u.Like(audit.String(), "audit", `"audited"`, `!"billed"`, `!not audited`)
+	u.Like(bill.String(), "billing", `"billed"`, `!"audited"`)
+	u.Like(out.String(), "rest", `"other"`, `"no module"`, `"not audited"`,
+		`!"billed"`)
+	u.Is(1, audit.flushes, "route flushed")
+}
+
+func TestModuleTreeLevels(t *testing.T) {
+	u := tutl.New(t)
+	t.Cleanup(lager.SaveModules())
+	api := lager.NewModule("svc.api", "FW")
+	u.Is(nil, lager.SetModuleTreeLevels("svc=F, svc.api*=D, svc*=N"), "set")
+	u.Is(`'D'`, lager.GetModuleLevels("svc.api"), "existing")
+	u.Is(true, api.Debug().Enabled(), "existing api debug")
+	lager.NewModule("svc.api.auth", "FW")
+	u.Is(`'D'`, lager.GetModuleLevels("svc.api.auth"), "subtree")
+	lager.NewModule("svc.apix", "FW")
+	u.Is(`'N'`, lager.GetModuleLevels("svc.apix"), "not a segment")
+	lager.NewModule("svc", "W")
+	u.Is(`'F'`, lager.GetModuleLevels("svc"), "exact")
+	lager.NewModule("svc.db")
+	u.Is(`'N'`, lager.GetModuleLevels("svc.db"), "default rule")
+
+	u.Like(lager.SetModuleTreeLevels("svc"), "no levels",
+		`*Module level rule must be`)
+	u.Like(lager.SetModuleTreeLevels("sv[c=F"), "bad pattern",
+		`*Invalid module pattern in level rule "sv[c=F"`)
+	u.Is(`'D'`, lager.GetModuleLevels("svc.api"), "unchanged")
+
+	u.Is(nil, lager.SetModuleTreeLevels(
+		"store/*=Debug, grpc=warn, *.cache=I, svc.api*=FW"), "globs")
+	lager.NewModule("store/blob", "F")
+	u.Is(`'F''W''N''A''I''T''D'`, lager.GetModuleLevels("store/blob"),
+		"slash subtree")
+	lager.NewModule("grpc", "F")
+	u.Is(`'F''W'`, lager.GetModuleLevels("grpc"), "level name")
+	lager.NewModule("svc.api.cache", "F")
+	u.Is(`'I'`, lager.GetModuleLevels("svc.api.cache"), "glob over prefix")
+	u.Is(`'F''W'`, lager.GetModuleLevels("svc.api.auth"), "prefix")
+	u.Is(nil, lager.SetModuleTreeLevels(""), "clear")
+	lager.NewModule("svc.web", "W")
+	u.Is(`'W'`, lager.GetModuleLevels("svc.web"), "cleared")
+}
+
+func TestTrigger(t *testing.T) {
+	u := tutl.New(t)
+	defer lager.SetOutput(new(bytes.Buffer))()
+	pay := lager.NewModule("payments", "FW")
+	tripped := make(chan int, 3)
+	remove, err := lager.AddTrigger(lager.Trigger{
+		Levels: "F", Module: "payments", Count: 3, Within: time.Hour,
+		Func: func(t lager.Trigger, n int) { tripped <- n },
+	})
+	u.Is(nil, err, "AddTrigger")
+	_, err = lager.AddTrigger(lager.Trigger{Count: 3, Within: time.Hour})
+	u.Like(err, "no Func", "*needs positive Count")
+
+	pay.Fail().List("one")
+	pay.Warn().List("not counted")
+	lager.Fail().List("not from module")
+	pay.Fail().List("two")
+	u.Is(0, len(tripped), "not yet")
+	pay.Fail().List("three")
+	select {
+	case n := <-tripped:
+		u.Is(3, n, "tripped")
+	case <-time.After(time.Second):
+		t.Error("trigger not invoked")
+	}
+	pay.Fail().List("four")
+	time.Sleep(10 * time.Millisecond)
+	u.Is(0, len(tripped), "counting restarted")
+	remove()
+	pay.Fail().List("five")
+	pay.Fail().List("six")
+	time.Sleep(10 * time.Millisecond)
+	u.Is(0, len(tripped), "removed")
+
+	remove, _ = lager.AddTrigger(lager.Trigger{
+		Module: "payments", Count: 2, Within: time.Millisecond,
+		Func: func(t lager.Trigger, n int) { tripped <- n },
+	})
+	pay.Fail().List("slow")
+	time.Sleep(5 * time.Millisecond)
+	pay.Fail().List("slower")
+	time.Sleep(10 * time.Millisecond)
+	u.Is(0, len(tripped), "outside window")
+	remove()
+}
+
+type countedStringer struct{ calls *int }
+
+func (c countedStringer) String() string {
+	*c.calls++
+	return "counted"
+}
+
+func TestMFmt(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	defer lager.Init("FWNA")
+	lager.Init("FWNA")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+
+	calls := 0
+	lager.Debug().MFmt("value %v", countedStringer{&calls})
+	u.Is(0, calls, "not formatted when disabled")
+	u.Is("", out.String(), "nothing logged")
+
+	lager.Warn().MFmt("%d%% of %v", 50, countedStringer{&calls},
+		lager.Map("cache", "users"))
+	u.Is(1, calls, "formatted when enabled")
+	u.Like(out.String(), "pairs from Map()",
+		`*"WARN", "50% of counted", {"cache":"users"}]`)
+	out.Reset()
+
+	lager.Warn().CMFmt("hit %s", "a", lager.Pairs("n", 2))
+	u.Like(out.String(), "pairs from Pairs()", `*"WARN", "hit a", {"n":2}`,
+		`lager_test.go", "_line":`, `"_func":"TestMFmt"`)
+	out.Reset()
+
+	lager.Keys("time", "lev", "msg", "args", "", "mod")
+	when, lev, msg, args, ctx, mod := lager.GetKeys()
+	u.Is("time lev msg args  mod",
+		strings.Join([]string{when, lev, msg, args, ctx, mod}, " "), "GetKeys")
+	lager.Warn().MFmt("plain %d", 7)
+	lager.Keys("", "", "", "", "", "")
+	when, _, _, _, _, mod = lager.GetKeys()
+	u.Is("", when+mod, "GetKeys when lines are lists")
+	u.Like(out.String(), "map", `*"msg":"plain 7"`)
+	out.Reset()
+
+	calls = 0
+	remove := lager.AddFilter(func(lev, mod string, pairs lager.AMap) bool {
+		return "skip" != pairs.Get("k")
+	})
+	lager.Warn().MFmt("filtered %v", countedStringer{&calls},
+		lager.Map("k", "skip"))
+	remove()
+	restore := lager.SetSampling("W", 1000)
+	for i := 0; i < 10; i++ {
+		lager.Warn().MFmt("sampled %v", countedStringer{&calls})
+	}
+	restore()
+	kept := strings.Count(out.String(), "sampled counted")
+	u.Is(true, kept < 10, "sampled")
+	u.Is(kept, calls, "not formatted when filtered or sampled out")
+
+	calls = 0
+	defer lager.SetLevelRules()
+	u.Is(nil, lager.SetLevelRules(lager.LevelRule{
+		From: "D", To: 'W', Match: regexp.MustCompile("deadlock"),
+	}), "set rule")
+	lager.Debug().MFmt("maybe %v", countedStringer{&calls})
+	lager.Debug().MFmt("possible %s", "deadlock")
+	u.Is(1, calls, "formatted for a rule to check")
+	u.Like(out.String(), "promoted", `*"WARN", "possible deadlock"]`)
+}
+
+func TestSetLevels(t *testing.T) {
+	u := tutl.New(t)
+	defer lager.Init("FWNA")
+	lager.Init("FWNA")
+	out := new(syncBuffer)
+	defer lager.SetOutput(out)()
+	mod := lager.NewModule("runtime-levels", "FW")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			lager.Debug().List("debug")
+			mod.Info().List("info")
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		lager.SetLevels("FWNAITD")()
+		mod.Init("FWNI").Init("FW")
+	}
+	<-done
+
+	u.Is("FWNA", lager.GetLevels(), "unchanged")
+	restore := lager.SetLevels("FWD")
+	u.Is("FWD", lager.GetLevels(), "set")
+	u.Is(true, lager.Debug().Enabled(), "debug on")
+	u.Is(false, lager.Note().Enabled(), "note off")
+	restore()
+	u.Is("FWNA", lager.GetLevels(), "restored")
+	u.Is(false, lager.Debug().Enabled(), "debug off")
+
+	lager.Init("-")
+	lager.SetLevels("F")()
+	u.Is("", lager.GetLevels(), "restored to none")
+}
+
+func TestWatchClock(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	log := new(syncBuffer)
+	defer lager.SetOutput(log)()
+
+	var mu sync.Mutex
+	offset, synced := 2500*time.Millisecond, true
+	var fail error
+	check := func() (time.Duration, bool, error) {
+		defer lager.AutoLock(&mu)()
+		return offset, synced, fail
+	}
+	stop := lager.WatchClock(check, time.Second, time.Hour)
+	lager.Warn().List("skewed")
+	u.Like(log.String(), "skew noted", `*"NOTE", "Clock is not synchronized", `+
+		`{"offset":"2.5s", "synced":true, "threshold":"1s"}]`,
+		`*"WARN", "skewed", {"clock_unsynced":true}]`)
+	stop()
+	log.Reset()
+
+	lager.Warn().List("stopped")
+	u.Like(log.String(), "not flagged after stop", `!clock_unsynced`)
+	log.Reset()
+
+	offset, synced = 0, false
+	stop = lager.WatchClock(check, time.Second, 5*time.Millisecond)
+	u.Like(log.String(), "unsynced", `*"Clock is not synchronized"`,
+		`*"synced":false`)
+	mu.Lock()
+	fail = errors.New("no chrony")
+	mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	u.Like(log.String(), "failed check",
+		`*"WARN", "Could not check clock synchronization", `+
+			`{"error":"no chrony"}, {"clock_unsynced":true}]`)
+	stop()
+	log.Reset()
+	time.Sleep(20 * time.Millisecond)
+	u.Is("", log.String(), "no checks after stop")
+}
+
+func TestRingViewer(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	ring := lager.NewRingBuffer(4)
+	defer lager.SetOutput(lager.TeeOutput(io.Discard, ring))()
+	defer lager.UseConsoleFormat(false)()
+	lager.Fail().List("one")
+	lager.NewModule("db").Warn().List("two", "<b>")
+	lager.Note().List("three")
+	lager.NewModule("web").Warn().List("four")
+	ring.Write([]byte(`["raw", "line"]` + "\n"))
+
+	lines := ring.Lines()
+	if u.Is(4, len(lines), "kept") {
+		u.Like(lines[0], "oldest", `*"WARN", ["two", "<b>"], "mod=db"]`+"\n")
+		u.Like(lines[3], "raw", `^\["raw", "line"\]\n$`)
+	}
+
+	view := lager.ViewerHandler(ring)
+	get := func(query string) string {
+		resp := httptest.NewRecorder()
+		view.ServeHTTP(resp, httptest.NewRequest("GET", "/logs?"+query, nil))
+		u.Is("text/html; charset=utf-8", resp.Header().Get("Content-Type"),
+			"content type")
+		return resp.Body.String()
+	}
+	page := get("")
+	u.Like(page, "all", `*4 lines shown`, `*&#34;two&#34;, &#34;&lt;b&gt;`,
+		`*<td>WARN</td><td>web</td>`, `*<option>db</option>`,
+		`!&#34;one&#34;`)
+	u.Like(get("levels=w&mod=db"), "filtered", `*1 lines shown`,
+		`*&#34;two&#34;`, `!&#34;four&#34;`, `*<option selected>db</option>`)
+	u.Like(get("n=1"), "limited", `*1 lines shown`, `*&#34;raw&#34;`,
+		`*href="?levels=&amp;mod=&amp;download=1"`)
+
+	lager.NewModule("db").Warn().MMap("auth", "token", "abc")
+	resp := httptest.NewRecorder()
+	lager.SnapshotHandler(ring).ServeHTTP(
+		resp, httptest.NewRequest("GET", "/logs.ndjson?mod=db", nil))
+	u.Is("application/x-ndjson", resp.Header().Get("Content-Type"), "type")
+	u.Like(resp.Header().Get("Content-Disposition"), "file name",
+		`^attachment; filename="logs-[^"]+-[0-9]{8}T[0-9]{6}Z[.]ndjson"$`)
+	snap := strings.SplitAfter(resp.Body.String(), "\n")
+	if u.Is(2, len(snap), "snapshot lines") {
+		u.Like(snap[0], "redacted",
+			`*"auth", {"token":"[REDACTED]"}, "mod=db"]`+"\n")
+		u.Is("", snap[1], "end")
+	}
+	resp = httptest.NewRecorder()
+	view.ServeHTTP(resp, httptest.NewRequest("GET", "/logs?download=1", nil))
+	u.Is(4, strings.Count(resp.Body.String(), "\n"), "viewer download")
+}
+
+func TestLevelHandler(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	t.Cleanup(lager.SetLevels("FWNA"))
+	t.Cleanup(lager.SaveModules())
+	log := new(syncBuffer)
+	defer lager.SetOutput(log)()
+	lager.NewModule("lh.db", "FW")
+
+	h := lager.LevelHandler()
+	call := func(method, body string) (int, string) {
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, httptest.NewRequest(
+			method, "/levels", strings.NewReader(body)))
+		return resp.Code, resp.Body.String()
+	}
+	code, body := call("GET", "")
+	u.Is(200, code, "get code")
+	u.Like(body, "get", `^[{]"levels":"FWNA","modules":[{]`,
+		`*"lh.db":"FW"`)
+
+	code, body = call("PUT", `{"levels":"FWNAI","modules":{"lh.db":"D"}}`)
+	u.Is(200, code, "put code")
+	u.Like(body, "put", `^[{]"levels":"FWNAI",`, `*"lh.db":"D"`)
+	u.Is("FWNAI", lager.GetLevels(), "global changed")
+	u.Is(`'D'`, lager.GetModuleLevels("lh.db"), "module changed")
+	u.Like(log.String(), "logged",
+		`*"Changed log levels via HTTP", {"from":"FWNA", "to":"FWNAI"}`,
+		`*"Changed module log levels via HTTP", `+
+			`{"module":"lh.db", "from":"FW", "to":"D"}`)
+
+	code, body = call("PUT", `{"levels":"F","modules":{"lh.nope":"D"}}`)
+	u.Is(400, code, "unknown module code")
+	u.Like(body, "unknown module", `*no such log module (lh.nope)`)
+	u.Is("FWNAI", lager.GetLevels(), "unchanged")
+	code, body = call("PUT", `{"level":"F"}`)
+	u.Is(400, code, "bad doc code")
+	u.Like(body, "bad doc", `*invalid log levels document`)
+	code, _ = call("DELETE", "")
+	u.Is(405, code, "bad method")
+}
+
+func TestStreamHandler(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	defer lager.SetOutput(io.Discard)()
+	srv := httptest.NewServer(lager.StreamHandler("s3cr3t"))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?token=wrong")
+	if u.Is(nil, err, "get wrong token") {
+		resp.Body.Close()
+		u.Is(401, resp.StatusCode, "wrong token")
+	}
+	resp, err = http.Get(srv.URL + "?token=s3cr3t")
+	if u.Is(nil, err, "get token in query") {
+		resp.Body.Close()
+		u.Is(401, resp.StatusCode, "token in query")
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL+"?levels=FW&mod=st", nil)
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	resp, err = http.DefaultClient.Do(req)
+	if !u.Is(nil, err, "get stream") {
+		return
+	}
+	defer resp.Body.Close()
+	u.Is(200, resp.StatusCode, "status")
+	u.Is("text/event-stream", resp.Header.Get("Content-Type"), "type")
+	events := bufio.NewReader(resp.Body)
+	read := func() string {
+		for {
+			line, err := events.ReadString('\n')
+			if nil != err || strings.HasPrefix(line, "data: ") {
+				return line
+			}
+		}
+	}
+
+	mod := lager.NewModule("st")
+	mod.Note().List("not streamed")
+	lager.Warn().List("other module")
+	mod.Warn().MMap("login", "user", "kim", "token", "abc123")
+	u.Like(read(), "event", `^data: \["[^"]+", "WARN", "login", `,
+		`*{"user":"kim", "token":"[REDACTED]"}, "mod=st"]`+"\n")
+}
+
+func TestSampling(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	defer lager.Init("FWNA")
+	lager.Init("FWNAID")
+	log := new(syncBuffer)
+	defer lager.SetOutput(log)()
+
+	defer lager.SetSampling("D", 1000*1000*1000)()
+	restore := lager.SetSampling("IF", 2)
+	before, debugs := lager.SampledOut()["INFO"], lager.SampledOut()["DEBUG"]
+	for i := 0; i < 1000; i++ {
+		lager.Info().List("info")
+	}
+	lager.Debug().List("debug")
+	lager.Fail().List("fail")
+	restore()
+	lager.Info().List("unsampled")
+
+	kept := strings.Count(log.String(),
+		`"info", {"sampled":true, "sample_rate":2}`)
+	u.Is(true, 400 < kept && kept < 600, u.S("kept ", kept, " of 1000"))
+	u.Is(uint64(1000-kept), lager.SampledOut()["INFO"]-before, "sampled out")
+	u.Is(debugs+1, lager.SampledOut()["DEBUG"], "debug sampled out")
+	u.Like(log.String(), "rest", `!"debug"`,
+		`*"FAIL", "fail"]`, `*"INFO", "unsampled"]`)
+}
+
+func TestDedupWindow(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	log := new(syncBuffer)
+	defer lager.SetOutput(log)()
+
+	restore := lager.SetDedupWindow(time.Hour)
+	for i := 0; i < 3; i++ {
+		lager.Warn().MMap("Cache miss", "key", "a")
+	}
+	lager.Warn().MMap("Cache miss", "key", "b")
+	lines := strings.Split(log.String(), "\n")
+	if u.Is(4, len(lines), "lines") {
+		u.Like(lines[0], "first", `*"Cache miss", {"key":"a"}]`)
+		u.Like(lines[1], "repeat",
+			`*"Cache miss", {"key":"a"}, {"repeat_count":2}]`)
+		u.Like(lines[2], "different", `*"Cache miss", {"key":"b"}]`)
+	}
+	log.Reset()
+
+	lager.Warn().List("held")
+	lager.Warn().List("held")
+	lager.Warn(lager.FlushOn(nil)).List("held")
+	lines = strings.Split(log.String(), "\n")
+	if u.Is(4, len(lines), "flush lines") {
+		u.Like(lines[1], "repeat before flush line",
+			`*"held", {"repeat_count":1}]`)
+		u.Like(lines[2], "flush line not held", `*"WARN", "held"]`)
+	}
+	log.Reset()
+
+	lager.Warn().List("held")
+	lager.Warn().List("held")
+	u.Is(nil, lager.Flush(nil), "flush")
+	u.Like(log.String(), "flushed repeat", `*"held", {"repeat_count":1}]`)
+	log.Reset()
+
+	lager.Keys("t", "l", "msg", "a", "", "mod")
+	lager.Note().MMap("again")
+	lager.Note().MMap("again")
+	lager.Note().MMap("done")
+	u.Like(log.String(), "map", `*"msg":"again",`, `*"repeat_count":1}`,
+		`*"msg":"done"`)
+	lager.Keys("", "", "", "", "", "")
+	restore()
+	log.Reset()
+
+	defer lager.SetDedupWindow(20 * time.Millisecond)()
+	lager.Warn().List("tick")
+	lager.Warn().List("tick")
+	u.Like(log.String(), "held", `!repeat_count`)
+	time.Sleep(60 * time.Millisecond)
+	u.Like(log.String(), "expired", `*"tick", {"repeat_count":1}]`)
+	lager.Warn().List("tick")
+	u.Is(3, strings.Count(log.String(), `"tick"`), "new window")
+	log.Reset()
+
+	lager.Warn().List("tock")
+	lager.Warn().List("tock")
+	u.Is(nil, lager.Flush(nil), "flush")
+	u.Like(log.String(), "flushed", `*"tock", {"repeat_count":1}]`)
+	log.Reset()
+
+	lager.Fail().List("boom")
+	lager.Fail().List("boom")
+	func() {
+		defer func() { recover() }()
+		lager.Panic().List("bye")
+	}()
+	u.Like(log.String(), "before panic",
+		`*"boom", {"repeat_count":1}]`, `*"PANIC", "bye"]`)
+	log.Reset()
+
+	restore = lager.SetEpochTimestamp("", true)
+	lager.Warn().List("epoch")
+	time.Sleep(2 * time.Millisecond)
+	lager.Warn().List("epoch")
+	lager.Warn().List("epoch done")
+	restore()
+	u.Like(log.String(), "epoch only",
+		`^\[[0-9]+, "WARN", "epoch"\]\n`,
+		`*"epoch", {"repeat_count":1}]`)
+	log.Reset()
+
+	lager.Keys("t", "l", "msg", "a", "", "mod")
+	restore = lager.SetEpochTimestamp("ms", false)
+	lager.Note().MMap("epoch")
+	time.Sleep(2 * time.Millisecond)
+	lager.Note().MMap("epoch")
+	lager.Note().MMap("epoch done")
+	restore()
+	lager.Keys("", "", "", "", "", "")
+	u.Like(log.String(), "epoch pair", `*"repeat_count":1}`)
+}
+
+func TestFlightRecorder(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	defer lager.Init("FWNA")
+	lager.Init("FWNAID")
+	log := new(syncBuffer)
+	defer lager.SetOutput(log)()
+
+	stop := lager.SetFlightRecorder("D", 2)
+	for _, s := range []string{"d1", "d2", "d3"} {
+		lager.Debug().List(s)
+	}
+	lager.Info().List("info")
+	u.Like(log.String(), "held", `*"INFO", "info"]`, `!"DEBUG"`)
+	log.Reset()
+	lager.Debug(lager.FlushOn(nil)).List("flushed")
+	u.Like(log.String(), "flush line not held", `*"DEBUG", "flushed"]`)
+	log.Reset()
+	time.Sleep(2 * time.Millisecond)
+
+	lager.Fail().List("boom")
+	lager.Fail().List("again")
+	lines := strings.Split(log.String(), "\n")
+	if u.Is(5, len(lines), "lines") {
+		u.Like(lines[0], "oldest kept", `*"DEBUG", "d2"]`)
+		u.Like(lines[1], "newest", `*"DEBUG", "d3"]`)
+		u.Like(lines[2], "failure", `*"FAIL", "boom"]`)
+		u.Like(lines[3], "no repeat", `*"FAIL", "again"]`)
+		u.Is(true, lines[1][:27] < lines[2][:27], "original timestamps")
+	}
+	log.Reset()
+
+	unasync := lager.SetAsync(100, time.Hour)
+	lager.Debug().List("d4")
+	lager.Info().List("queued")
+	lager.Fail().List("async boom")
+	u.Is(nil, lager.Flush(nil), "flush")
+	unasync()
+	lines = strings.Split(log.String(), "\n")
+	if u.Is(4, len(lines), "async lines") {
+		u.Like(lines[0], "queued first", `*"INFO", "queued"]`)
+		u.Like(lines[1], "then held", `*"DEBUG", "d4"]`)
+		u.Like(lines[2], "then failure", `*"FAIL", "async boom"]`)
+	}
+	stop()
+	log.Reset()
+	lager.Debug().List("direct")
+	u.Like(log.String(), "stopped", `*"DEBUG", "direct"]`)
+}
+
+func TestNew(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FW")()
+	audit := new(bytes.Buffer)
+	lg := lager.New(lager.WithLevels("FWNAI"), lager.WithOutput(audit),
+		lager.WithFormat("map"))
+	u.Is("FWNAI", lg.GetLevels(), "instance levels")
+	u.Is("FW", lager.GetLevels(), "global levels kept")
+
+	lg.Info().MMap("Audited", "user", "u1")
+	lg.Level('d').MMap("Not logged")
+	lager.Info().MMap("Not logged either")
+	lager.Fail().MMap("Global")
+	u.Like(audit.String(), "instance output",
+		`*"level":"INFO"`, `*"msg":"Audited"`, `*"user":"u1"`,
+		`!Not logged`, `!Global`)
+	u.Like(out.String(), "global output", `^\[`, `*"Global"`,
+		`!Audited`, `!Not logged`)
+
+	out.Reset()
+	defer lager.SetOutput(io.Discard)()
+	lg.Warn().List("Still here")
+	u.Like(audit.String(), "not changed by SetOutput", `*"Still here"`)
+	u.Is("", out.String(), "old global output unused")
+
+	lists := lager.New(lager.WithOutput(out),
+		lager.WithKeys("", "", "", "", "", ""))
+	lists.Fail().List("Listed")
+	u.Like(out.String(), "list", `^\["[^"]+", "FAIL", "Listed"\]`)
+	u.Is("FW", lists.GetLevels(), "copied levels")
+}
+
+func TestFilter(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FWN")()
+	seen := []string{}
+	remove := lager.AddFilter(func(lev, mod string, pairs lager.AMap) bool {
+		seen = append(seen, lev+"/"+mod)
+		return "t2" != pairs.Get("tenant")
+	})
+	ctx := lager.AddPairs(context.Background(), "tenant", "t2")
+	lager.Fail().MMap("Kept", "tenant", "t1")
+	lager.Fail().MMap("Dropped", "tenant", "t2")
+	lager.Warn(ctx).List("Dropped via ctx")
+	lager.NewModule("db").Note().MMap("In-line",
+		lager.InlinePairs, lager.Map("tenant", "t2"))
+	lager.Info().MMap("Not enabled", "tenant", "t1")
+	u.Like(out.String(), "filtered", `*"Kept"`, `!Dropped`, `!In-line`)
+	u.Is("FAIL/ FAIL/ WARN/ NOTE/db", strings.Join(seen, " "), "called")
+
+	remove()
+	out.Reset()
+	lager.Fail().MMap("Back", "tenant", "t2")
+	u.Like(out.String(), "removed", `*"Back"`)
+}
+
+func TestNewIsolated(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FW")()
+	lib := new(bytes.Buffer)
+	lg := lager.New(lager.WithOutput(lib), lager.WithLevels("FWN"),
+		lager.WithKeys("t", "lev", "msg", "data", "", "mod"),
+		lager.WithLevelNotation(strings.ToLower))
+	mod := lg.NewModule("iso", "FWNI")
+	u.Is(mod, lg.NewModule("iso"), "same module")
+	glob := lager.NewModule("iso")
+	u.Is(false, mod == glob, "not the package-level module")
+	u.Is(`'F''W'`, lager.GetModuleLevels("iso"), "global module levels")
+	u.Is(map[string]string{"iso": `'F''W''N''I'`}, lg.GetModules(),
+		"instance modules")
+
+	mod.Info().MMap("Instance", "k", 1)
+	glob.Info().MMap("Not logged")
+	glob.Warn().MMap("Global")
+	u.Like(lib.String(), "instance",
+		`*"lev":"info"`, `*"mod":"iso"`, `!Global`, `!Not logged`)
+	u.Like(out.String(), "global", `^\[`, `*"WARN"`, `*"Global"`,
+		`!Instance`)
+
+	lib.Reset()
+	lager.SetLevelNotation(strings.ToUpper)
+	defer lager.SetLevelNotation(nil)
+	mod.Init("W")
+	mod.Info().MMap("Disabled now")
+	mod.Warn().MMap("Still lowercase")
+	u.Like(lib.String(), "notation kept", `*"lev":"warn"`, `!Disabled`)
+}
+
+func TestAppendEntry(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FWA")()
+	buf := []byte("prefix: ")
+	buf = lager.AppendEntry(buf, "Acc", "Request", "path", "/x")
+	u.Like(string(buf), "appended",
+		`^prefix: \["[^"]+", "ACCESS", "Request", \{"path":"/x"\}\]\n$`)
+	u.Is(string(buf), string(lager.AppendEntry(buf, "info", "Off")),
+		"disabled level")
+	u.Is("", out.String(), "nothing written")
+
+	ex := lager.AppendEntry(nil, "E", "No exit")
+	u.Like(string(ex), "exit", `*"EXIT", "No exit"]`)
+
+	lg := lager.New(lager.WithKeys("t", "lev", "msg", "data", "", "mod"))
+	u.Like(string(lg.AppendEntry(nil, "WARN", "Map", "k", 1)), "instance",
+		`*"lev":"WARN"`, `*"msg":"Map"`, `*"k":1}`)
+	u.Is("", out.String(), "still nothing written")
+
+	defer func() {
+		u.Like(recover(), "bad level", `*not "x"`)
+	}()
+	lager.AppendEntry(nil, "x", "Bad")
+}
+
+func TestLevelFloor(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FWNI")()
+	mod := lager.NewModule("floored").Init("FWNAITDOG")
+	before := lager.Suppressed()["INFO"]
+
+	restore := lager.SetLevelFloor('W')
+	u.Like(out.String(), "change noted", `*"NOTE", "Changed log level floor"`,
+		`*{"floor":"WARN", "prior":"none"}`)
+	out.Reset()
+	lager.Warn().List("Kept")
+	lager.Info().List("Dropped")
+	mod.Debug().List("Dropped debug")
+	lager.New().Note().List("Dropped note")
+	u.Like(out.String(), "floor", `*"Kept"`, `!Dropped`)
+	u.Is(before+1, lager.Suppressed()["INFO"], "counted")
+
+	out.Reset()
+	off := lager.SetLevelFloor('E')
+	lager.Fail().List("Off")
+	u.Like(out.String(), "off", `!"Off"`, `*{"floor":"EXIT", "prior":"WARN"}`)
+	off()
+	restore()
+	out.Reset()
+	mod.Debug().List("Back")
+	u.Like(out.String(), "restored", `*"Back"`)
+	u.Like(u.GetPanic(func() { lager.SetLevelFloor('x') }), "bad",
+		`*not 'x'`)
+}
+
+func TestMaxLineSize(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FWNAID")()
+	big := strings.Repeat("x", 20000)
+	u.Is(true, lager.EstimateSize("body", big) > 20000, "big estimate")
+	u.Is(true, lager.EstimateSize("k", 1, "v", "abc") < 64, "small estimate")
+	u.Is(true, lager.EstimateSize(map[string][]string{"a": {big}}) > 20000,
+		"nested via reflection")
+	type resp struct {
+		Body   string
+		hidden string
+	}
+	u.Is(true, lager.EstimateSize(&resp{Body: big}) > 20000, "struct")
+	u.Is(true, lager.EstimateSize(resp{hidden: big}) < 64, "unexported")
+
+	defer lager.SetMaxLineSize(1024)()
+	ctx := lager.AddPairs(context.Background(), "req", "r1")
+	lager.Debug(ctx).MMap("Got response", "body", big)
+	lager.Info().List(big)
+	lager.Info().MMap("Small", "body", "ok")
+	u.Like(out.String(), "rejected", `!xxxxx`, `*"Small"`,
+		`*"WARN", "Log line too large", {"msg":"Got response",`+
+			` "level":"DEBUG", "size":200`, `*"max":1024}, {"req":"r1"}]`,
+		`*{"msg":"", "level":"INFO", "size":200`)
+
+	out.Reset()
+	lager.SetMaxLineSize(5)
+	lager.Fail().MMap("Tiny cap")
+	u.Like(out.String(), "report not rejected", `*"Log line too large"`,
+		`*"msg":"Tiny cap"`)
+	lager.SetMaxLineSize(0)
+	out.Reset()
+	lager.Info().List(big)
+	u.Like(out.String(), "no cap", `*xxxxx`)
+}
+
+func TestSequencing(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.EnableSequencing()()
+	lager.Fail().List("first")
+	ctx := lager.AddPairs(context.Background(), "k", 1)
+	lager.Fail(ctx).List("second")
+	u.Like(out.String(), "numbered",
+		`*"first", {"seq":0, "seq_proc":"0"}]`,
+		`*"second", {"k":1, "seq":1, "seq_proc":"0"}]`)
+	u.Is("LAGER_SEQ=0.1:2", lager.ChildSequenceEnv(), "first child")
+	lager.Fail().List("third")
+	u.Is("LAGER_SEQ=0.2:3", lager.ChildSequenceEnv(), "second child")
+}
+
+func TestEscalation(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(syncBuffer)
+	defer lager.SetOutput(out)()
+	_, err := lager.AddEscalation(lager.Escalation{Count: 2})
+	u.Like(err, "invalid", "*needs positive Count and Within")
+
+	mod := lager.NewModule("escalated").Init("FW")
+	other := lager.NewModule("calm").Init("FW")
+	remove, err := lager.AddEscalation(lager.Escalation{
+		Count: 2, Within: time.Minute, Enable: "D",
+		For: 100 * time.Millisecond,
+	})
+	u.Is(nil, err, "valid")
+	defer remove()
+	waitFor := func(name, want string) string {
+		got := ""
+		for i := 0; i < 100; i++ {
+			if got = lager.GetModuleLevels(name); want == got {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return got
+	}
+	mod.Fail().List("one")
+	other.Fail().List("other")
+	mod.Fail().List("two")
+	u.Is(`'F''W''D'`, waitFor("escalated", `'F''W''D'`), "escalated")
+	u.Is(`'F''W'`, lager.GetModuleLevels("calm"), "other module")
+	mod.Debug().List("Now visible")
+	u.Like(out.String(), "noted", `*"Escalated log levels", {`+
+		`"module":"escalated", "lines":2, "levels":"'F''W''D'"`,
+		`*"Now visible"`)
+	u.Is(`'F''W'`, waitFor("escalated", `'F''W'`), "restored")
+	u.Like(out.String(), "noted end",
+		`*"Restored log levels after escalation", {"module":"escalated"`)
+
+	mod.Fail().List("three")
+	mod.Fail().List("four")
+	u.Is(`'F''W''D'`, waitFor("escalated", `'F''W''D'`), "again")
+	remove()
+	u.Is(`'F''W'`, lager.GetModuleLevels("escalated"), "removed")
+}
+
+func TestLoggerApply(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	lg := lager.New(lager.WithOutput(out), lager.WithLevels("FW"),
+		lager.WithFilter(func(_, _ string, pairs lager.AMap) bool {
+			return nil == pairs.Get("noisy")
+		}))
+	mod := lg.NewModule("applied", "FWI")
+	lg.Info().List("Off")
+	lg.Fail().MMap("Filtered", "noisy", true)
+	restore := lg.Apply(lager.WithLevels("FWNI"),
+		lager.WithSampling("I", 1000000))
+	u.Is("FWNI", lg.GetLevels(), "applied")
+	lg.Note().List("On")
+	for i := 0; i < 5; i++ {
+		lg.Info().List("Sampled")
+	}
+	mod.Warn().List("Module")
+	u.Like(out.String(), "output", `!"Off"`, `!Filtered`, `*"On"`,
+		`!Sampled`, `*"Module", "mod=applied"]`)
+	restore()
+	u.Is("FW", lg.GetLevels(), "restored")
+	u.Is("n/a", lager.GetModuleLevels("applied"), "not a global module")
+}
+
+func TestNewIndependent(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(syncBuffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FWN")()
+	defer lager.EnableSequencing()()
+	defer lager.SetAsync(16, time.Hour)()
+	subs := lager.Subscribe(nil)
+	defer lager.Unsubscribe(subs)
+	remove, err := lager.AddEscalation(lager.Escalation{
+		Count: 2, Within: time.Minute, Enable: "D", For: time.Minute,
+	})
+	u.Is(nil, err, "valid")
+	defer remove()
+
+	a, b := new(syncBuffer), new(syncBuffer)
+	la := lager.New(lager.WithOutput(a))
+	lb := lager.New(lager.WithOutput(b))
+	la.Fail().List("One")
+	la.Fail().List("Two")
+	lb.Fail().List("Three")
+	u.Is(nil, lager.Flush(nil), "global flush")
+	u.Is("", a.String()+b.String(), "own queues")
+	u.Is(nil, la.Flush(nil), "flush a")
+	u.Is(nil, lb.Flush(nil), "flush b")
+	u.Like(a.String(), "own sequence", `*"One", {"seq":0, "seq_proc":"0"}]`,
+		`*"Two", {"seq":1, "seq_proc":"0"}]`)
+	u.Like(b.String(), "other sequence",
+		`*"Three", {"seq":0, "seq_proc":"0"}]`)
+	lager.Fail().List("Global")
+	u.Is(nil, lager.Flush(nil), "flush global")
+	u.Like(out.String(), "global sequence",
+		`*"Global", {"seq":0, "seq_proc":"0"}]`)
+	u.Is(1, len(subs), "only global lines published")
+	u.Is("Global", (<-subs).Message, "published")
+
+	ma := la.NewModule("esc", "FW")
+	mb := lb.NewModule("esc", "FW")
+	ma.Fail().List("First")
+	mb.Fail().List("Not counted for a")
+	u.Is(`'F''W'`, la.GetModules()["esc"], "not escalated yet")
+	ma.Fail().List("Second")
+	got := ""
+	for i := 0; i < 100; i++ {
+		if got = la.GetModules()["esc"]; `'F''W''D'` == got {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	u.Is(`'F''W''D'`, got, "escalated")
+	u.Is(`'F''W'`, lb.GetModules()["esc"], "other Logger not escalated")
+	u.Is("n/a", lager.GetModuleLevels("esc"), "no global module")
+	u.Is(nil, la.Flush(nil), "flush note")
+	u.Like(a.String(), "noted by Logger",
+		`*"Escalated log levels", {"module":"esc"`)
+	u.Like(out.String(), "not noted globally", `!Escalated`)
+
+	remove()
+	u.Is(`'F''W'`, la.GetModules()["esc"], "removed from copy")
+
+	lb.Fail().List("Queued")
+	u.Like(b.String(), "still queued", `!Queued`)
+	u.Is(nil, lb.Close(), "close b")
+	u.Like(b.String(), "written by close", `*"Queued"`)
+	lb.Fail().List("Direct")
+	u.Like(b.String(), "written directly once closed", `*"Direct"`)
+	u.Is(nil, lb.Close(), "close b again")
+	u.Is(nil, la.Close(), "close a")
+}
+
+func TestSLOs(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FWNA")()
+	defer lager.SetSLOs(
+		lager.SLO{Route: "/search", Latency: time.Second},
+		lager.SLO{Latency: 250 * time.Millisecond},
+	)()
+	req := httptest.NewRequest("GET", "https://cool.me/search?q=x", nil)
+	start := time.Now().Add(-500 * time.Millisecond)
+	lager.GcpLogAccess(req, nil, &start).MMap("Search")
+	u.Like(out.String(), "under route SLO", `*"Search"`, `!slo.`)
+
+	out.Reset()
+	req = httptest.NewRequest("GET", "https://cool.me/api/v1", nil)
+	lager.GcpLogAccess(req, nil, &start).List("API")
+	u.Like(out.String(), "over default SLO", `*"API"`,
+		`*"slo.violated":true, "slo.threshold":"250ms"`)
+
+	out.Reset()
+	lager.Acc().MMap("Passed", "httpRequest",
+		lager.Map("requestUrl", "/search", "latency", 2*time.Second))
+	lager.Warn().MMap("Not access", "httpRequest",
+		lager.Map("requestUrl", "/search", "latency", "2s"))
+	u.Like(out.String(), "passed pairs", `*"slo.threshold":"1s"`,
+		`!"Not access".*slo`)
+
+	out.Reset()
+	lager.SetSLOs()
+	lager.GcpLogAccess(req, nil, &start).List("None")
+	u.Like(out.String(), "removed", `*"None"`, `!slo.`)
+}
+
+func TestFirstSeen(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FWN")()
+	defer lager.MarkFirstSeen("FW")()
+	lager.Fail().MMap("Lost connection", "host", "db2")
+	u.Like(out.String(), "first", `*"first_seen":true`)
+
+	out.Reset()
+	lager.Fail().MMap("Lost connection", "host", "db3")
+	lager.Note().MMap("Not marked level")
+	lager.Fail().Map("no", "message")
+	u.Like(out.String(), "repeat", `*"db3"`, `*"Not marked`, `*"no"`,
+		`!first_seen`)
+
+	out.Reset()
+	lager.Warn().MMap("Lost connection")
+	lager.NewModule("fseen").Fail().MMap("Lost connection")
+	u.Is(2, strings.Count(out.String(), `"first_seen":true`),
+		"per level and module")
+
+	out.Reset()
+	lager.Warn().MFmt("Retry %d", 1)
+	lager.Warn().MFmt("Retry %d", 2)
+	u.Is(1, strings.Count(out.String(), `"first_seen":true`),
+		"MFmt uses format")
+
+	out.Reset()
+	lager.MarkFirstSeen("")
+	lager.Warn().MMap("Off now")
+	u.Like(out.String(), "off", `*"Off now"`, `!first_seen`)
+}
+
+func TestNamedModule(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	t.Cleanup(lager.SaveModules())
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FW")()
+	top := lager.NamedModule("nm")
+	u.Is(`'F''W'`, lager.GetModuleLevels("nm"), "top gets global levels")
+	http := lager.NamedModule("nm.http").InitTree("FWNID")
+	u.Is(http, lager.NamedModule("nm.http"), "same module")
+	client := lager.NamedModule("nm.http.client")
+	u.Is(`'F''W''N''I''D'`, lager.GetModuleLevels("nm.http.client"),
+		"inherited")
+	lager.NamedModule("nm/quiet").Init("-")
+	lager.NamedModule("nm/quiet/sub")
+	u.Is("", lager.GetModuleLevels("nm/quiet/sub"), "inherits no levels")
+
+	top.InitTree("F")
+	u.Is(`'F'`, lager.GetModuleLevels("nm.http.client"), "tree set")
+	u.Is(`'F'`, lager.GetModuleLevels("nm/quiet/sub"), "tree set /")
+
+	sub := new(bytes.Buffer)
+	restore := lager.SetModuleOutput("nm.http", sub)
+	client.Fail().MMap("To sub")
+	top.Fail().MMap("To out")
+	u.Like(sub.String(), "sub output", `*"To sub"`, `!To out`)
+	u.Like(out.String(), "main output", `*"To out"`, `!To sub`)
+
+	restore()
+	sub.Reset()
+	client.Fail().MMap("Restored")
+	u.Is("", sub.String(), "output restored")
+	u.Like(out.String(), "back to main", `*"Restored"`)
+}
+
+func TestVolumeAccounting(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(syncBuffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FWN")()
+	u.Is(0, len(lager.LogVolume()), "off")
+	restore := lager.SetVolumeAccounting("tenant", 2, 10*time.Millisecond)
+	ctx := lager.AddPairs(context.Background(), "tenant", "t2")
+	lager.Fail().MMap("One", "tenant", "t1")
+	lager.Fail().MMap("Two", "tenant", "t1", "pad", "xxxxxxxxxxxxxxxx")
+	lager.Warn(ctx).List("Via ctx")
+	lager.Warn(ctx).MMap("Pairs win", "tenant", 3)
+	lager.Note().List("No tenant")
+	lager.Info().MMap("Not enabled", "tenant", "t1")
+	vols := lager.LogVolume()
+	u.Is(uint64(2), vols["t1"].Lines, "t1 lines")
+	u.Is(uint64(1), vols["t2"].Lines, "t2 lines")
+	u.Is(uint64(1), vols["3"].Lines, "non-string value")
+	u.Is(uint64(1), vols[""].Lines, "no value")
+	u.Is(uint64(len(out.String())), vols["t1"].Bytes+vols["t2"].Bytes+
+		vols["3"].Bytes+vols[""].Bytes, "bytes")
+
+	for i := 0; i < 100 && !strings.Contains(out.String(), "volume"); i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	lines := uint64(0)
+	for _, v := range lager.LogVolume() {
+		lines += v.Lines
+	}
+	u.Is(uint64(5), lines, "report not counted")
+	restore()
+	u.Like(out.String(), "report", `*"Log volume", {"key":"tenant"`,
+		`*"lines":5,`, `*"top":[{"value":"t1", "lines":2,`)
+	u.Is(2, strings.Count(out.String(), `"value":`), "top 2")
+	u.Is(0, len(lager.LogVolume()), "restored")
+
+	outer := lager.SetVolumeAccounting("tenant", 0, 0)
+	restore = lager.SetVolumeAccounting("route", 0, time.Hour)
+	restore()
+	restore() // Must not panic nor undo 'outer'.
+	lager.Fail().MMap("Once", "tenant", "t1")
+	u.Is(uint64(1), lager.LogVolume()["t1"].Lines, "restore twice")
+	outer()
+}
+
+func TestFields(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FW")()
+	when := time.Date(2021, 6, 9, 13, 10, 7, 44700000, time.UTC)
+	lager.Fail().MMap("Typed", lager.Str("s", "a\"b"), lager.Int("i", -3),
+		lager.Int64("i64", 1<<40), lager.Uint64("u", 1<<63),
+		"mixed", true, lager.Float64("f", 1.5), lager.Bool("b", false),
+		lager.Dur("d", 1500*time.Millisecond), lager.Time("t", when),
+		lager.Err("err", errors.New("oops")), lager.Any("any", []int{1}))
+	u.Like(out.String(), "pairs", `*"s":"a\"b", "i":-3, `+
+		`"i64":1099511627776, "u":9223372036854775808, "mixed":true, `+
+		`"f":1.5, "b":false, "d":"1.5s", "t":"2021-06-09T13:10:07.0447Z", `+
+		`"err":"oops", "any":[1]}`)
+
+	out.Reset()
+	lager.Fail().MList("In list", lager.Float64("inf", math.Inf(1)), 2)
+	u.Like(out.String(), "list", `*["In list", {"inf":"+Inf"}, 2]`)
+
+	out.Reset()
+	ctx := lager.AddPairs(context.Background(), lager.Str("req", "r1"))
+	lager.Warn(ctx).MMap("Ctx", "k", lager.Map(lager.Int("n", 1), "m", 2))
+	u.Like(out.String(), "ctx and nested",
+		`*"Ctx", {"k":{"n":1, "m":2}}, {"req":"r1"}`)
+
+	out.Reset()
+	zoned := when.In(time.FixedZone("CEST", 2*3600))
+	lager.Fail(ctx).MFields("Only fields", lager.Str("s", "x"),
+		lager.Time("t", zoned), lager.Err("err", io.EOF))
+	lager.Warn().MFields("No fields")
+	lager.Note().MFields("Not enabled", lager.Int("n", 1))
+	u.Like(out.String(), "MFields",
+		`*"FAIL", "Only fields", {"s":"x", `+
+			`"t":"2021-06-09T15:10:07.0447+02:00", "err":"EOF"}, {"req":"r1"}]`,
+		`*"WARN", "No fields"]`, `!Not enabled`)
+	u.Is(zoned, lager.Time("t", zoned).Value(), "time value")
+
+	out.Reset()
+	lager.Keys("t", "lev", "msg", "data", "", "mod")
+	lager.Fail().MFields("Keyed", lager.Int("n", 2))
+	lager.Keys("", "", "", "", "", "")
+	u.Like(out.String(), "MFields map", `*"msg":"Keyed", "n":2}`)
+
+	out.Reset()
+	defer lager.AddFilter(func(lev, mod string, pairs lager.AMap) bool {
+		return "t2" != pairs.Get("tenant")
+	})()
+	lager.Fail().MMap("Dropped", lager.Str("tenant", "t2"))
+	lager.Fail().MMap("Kept", lager.Str("tenant", "t1"), "dangling")
+	lager.Fail().MFields("Dropped too", lager.Str("tenant", "t2"))
+	u.Like(out.String(), "filtered", `!Dropped`,
+		`*"Kept", {"tenant":"t1", "dangling":null}`)
+}
+
+var allocKey, allocBytes, allocTook = "k1", 12345, 3 * time.Millisecond
+
+func TestFieldAllocs(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	defer lager.SetOutput(io.Discard)()
+	defer lager.SetLevels("FW")()
+	pairs := testing.AllocsPerRun(100, func() {
+		lager.Fail().MMap("Fetched", "key", allocKey, "bytes", allocBytes,
+			"took", allocTook, "error", io.EOF)
+	})
+	boxed := testing.AllocsPerRun(100, func() {
+		lager.Fail().MMap("Fetched", lager.Str("key", allocKey),
+			lager.Int("bytes", allocBytes), lager.Dur("took", allocTook),
+			"error", io.EOF)
+	})
+	fields := testing.AllocsPerRun(100, func() {
+		lager.Fail().MFields("Fetched", lager.Str("key", allocKey),
+			lager.Int("bytes", allocBytes), lager.Dur("took", allocTook),
+			lager.Err("error", io.EOF))
+	})
+	u.Is(true, boxed <= pairs,
+		fmt.Sprintf("Fields in MMap: %v allocs vs %v", boxed, pairs))
+	u.Is(true, fields <= 2, fmt.Sprintf("MFields: %v allocs", fields))
+}
+
+func TestBillingLabels(t *testing.T) {
+	u := tutl.New(t)
+	defer lager.RunningInEcs()() // Restores settings changed below.
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FW")()
+	restore := lager.SetBillingLabels(map[string]string{
+		"team": "payments", "cost-center": "cc-1234",
+	})
+	lager.Fail().List("Listed")
+	u.Like(out.String(), "list format", `*"Listed", `+
+		`{"billing":{"cost-center":"cc-1234", "team":"payments"}}]`)
+
+	out.Reset()
+	lager.Keys("t", "l", "msg", "data", "", "mod")
+	lager.NewModule("bill").Warn().MMap("Mapped", "k", 1)
+	u.Like(out.String(), "map format", `*"Mapped", "k":1, `+
+		`"billing":{"cost-center":"cc-1234", "team":"payments"}, `+
+		`"mod":"bill"}`)
+
+	out.Reset()
+	lager.RunningInGcp()
+	lager.Fail().MMap("In GCP")
+	u.Like(out.String(), "gcp", `*"logging.googleapis.com/labels":`+
+		`{"cost-center":"cc-1234", "team":"payments"}`, `!billing`)
+
+	restore()
+	out.Reset()
+	lager.Fail().MMap("Restored")
+	u.Like(out.String(), "restored", `*"Restored"`, `!labels`)
+}
+
+func TestWithPairs(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FW")()
+	calls := 0
+	ctx := lager.AddPairs(context.Background(), "req", "r0", "span", "s1")
+	log := lager.Fail(ctx).WithPairs("req", "r1", "user", func() interface{} {
+		calls++
+		return "bob"
+	})
+	log.MMap("One", "k", 1)
+	log.List("Two")
+	u.Is(1, calls, "encoded once")
+	u.Like(out.String(), "list format",
+		`*"One", {"k":1}, {"span":"s1", "req":"r1", "user":"bob"}]`,
+		`*"Two", {"span":"s1", "req":"r1", "user":"bob"}]`, `!r0`)
+
+	out.Reset()
+	lager.Keys("t", "l", "msg", "data", "", "mod")
+	log = lager.Warn().WithPairs("a", 1).WithPairs(lager.Str("b", "x"))
+	log.MMap("Top")
+	lager.Keys("t", "l", "msg", "data", "ctx", "mod")
+	log = lager.Warn(ctx).WithPairs("a", 1)
+	log.With(lager.AddPairs(ctx, "span", "s2")).MMap("Nested")
+	u.Like(out.String(), "map format", `*"msg":"Top", "a":1, "b":"x"}`,
+		`*"msg":"Nested", "ctx":{"req":"r0", "span":"s2", "a":1}}`)
+
+	out.Reset()
+	defer lager.AddFilter(func(lev, mod string, pairs lager.AMap) bool {
+		return "t2" != pairs.Get("tenant")
+	})()
+	lager.Fail().WithPairs("tenant", "t2").MMap("Dropped")
+	lager.Info().WithPairs("tenant", "t1").MMap("Not enabled")
+	u.Is("", out.String(), "filters see bound pairs")
+}
+
+func TestRemovePairs(t *testing.T) {
+	u := tutl.New(t)
+	bg := context.Background()
+	ctx := lager.AddPairs(bg, "a", 1, "httpRequest", "partial", "b", 2)
+	ctx = lager.AddPairs(ctx, "httpRequest", "final", "c", 3, "c", 4,
+		lager.Unless(true, "skipped"), 5)
+	u.Is(`{"a":1, "httpRequest":"final", "b":2, "c":4}`,
+		string(lager.MarshalPairs(ctx)), "overwritten in place")
+
+	u.Is(ctx, lager.RemovePairs(ctx, "x"), "nothing to remove")
+	u.Is(bg, lager.RemovePairs(bg, "a"), "no pairs")
+	ctx2 := lager.RemovePairs(ctx, "httpRequest", "c", "x")
+	u.Is(`{"a":1, "b":2}`, string(lager.MarshalPairs(ctx2)), "removed")
+	u.Is(`{"a":1, "httpRequest":"final", "b":2, "c":4}`,
+		string(lager.MarshalPairs(ctx)), "original unchanged")
+	u.Is(0, len(lager.MarshalPairs(lager.RemovePairs(ctx2, "a", "b"))),
+		"removed all")
+}
+
+func TestRetention(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FWN")()
+	defer lager.SetLevelRetention("DOG", "7d")()
+	restore := lager.SetLevelRetention("FW", "1y")
+	ctx := lager.Retention(context.Background(), "forever")
+	lager.Fail().MMap("Default")
+	lager.Warn(ctx).MMap("From ctx")
+	lager.Note().MMap("No default")
+	u.Like(out.String(), "retention",
+		`*"Default", {"retention":"1y"}]`,
+		`*"From ctx", {"retention":"forever"}]`,
+		`"No default"\]`)
+
+	out.Reset()
+	restore()
+	lager.Fail().MMap("Restored")
+	u.Like(out.String(), "restored", `*"Restored"`, `!retention`)
+
+	for _, class := range []string{"30d", "2w", "6m", "10y", "forever"} {
+		lager.Retention(context.Background(), class)
+	}
+	for _, class := range []string{"", "d", "0d", "30", "1h", "1.5y"} {
+		u.Like(u.GetPanic(func() {
+			lager.Retention(context.Background(), class)
+		}), "invalid "+class, "*retention class must be like")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FWN")()
+	fails := lager.Subscribe(func(e lager.Entry) bool {
+		return "FAIL" == e.Level && "pay" == e.Module
+	})
+	all := lager.Subscribe(nil)
+	ctx := lager.AddPairs(context.Background(), "req", "r1")
+	pay := lager.NewModule("pay")
+	pay.Fail(ctx).MMap("Charge failed", "card", "visa")
+	pay.Warn().MMap("Slow")
+	lager.Fail().List("Not pay")
+	pay.Info().MMap("Not enabled")
+
+	u.Is(1, len(fails), "filtered")
+	e := <-fails
+	u.Is("FAIL", e.Level, "level")
+	u.Is("pay", e.Module, "module")
+	u.Is("Charge failed", e.Message, "message")
+	u.Is("r1", e.Pairs.Get("req"), "context pair")
+	u.Is("visa", e.Pairs.Get("card"), "pair")
+	u.Is(false, e.Time.IsZero(), "time")
+	u.Is(3, len(all), "all")
+	lager.Unsubscribe(fails)
+	_, open := <-fails
+	u.Is(false, open, "closed")
+
+	for i := 0; i < 300; i++ {
+		lager.Note().MMap("Flood")
+	}
+	u.Is(uint64(300+3-256), lager.SubscriptionDrops(all), "drops")
+	lager.Unsubscribe(all)
+	u.Is(uint64(0), lager.SubscriptionDrops(all), "unsubscribed")
+	lager.Fail().MMap("After")
+}
+
+type countedValuer struct{ calls *int }
+
+func (c countedValuer) LagerValue() interface{} {
+	*c.calls++
+	return lager.Map("hits", *c.calls)
+}
+
+func TestValuer(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FWN")()
+
+	calls := 0
+	lager.Debug().MMap("Disabled", "stats", countedValuer{&calls})
+	u.Is(0, calls, "not called when disabled")
+	u.Is("", out.String(), "nothing logged")
+
+	lager.Warn().MMap("Enabled", "stats", countedValuer{&calls})
+	u.Is(1, calls, "called when enabled")
+	u.Like(out.String(), "map value",
+		`*"WARN", "Enabled", {"stats":{"hits":1}}]`)
+	out.Reset()
+
+	lager.Note().List("In list", countedValuer{&calls})
+	u.Like(out.String(), "list value", `*"NOTE", ["In list", {"hits":2}]]`)
+	out.Reset()
+
+	v := lager.Valuer(countedValuer{&calls})
+	lager.Fail().MMap("Unless", lager.Unless(true, "stats"), v)
+	u.Is(2, calls, "not called when skipped")
+}
+
+func TestGroup(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FWNI")()
+
+	lager.Info().MMap("Queried", lager.Group("db", "query", "SELECT 1",
+		lager.Int("rows", 3), lager.Group("conn", "pool", 2)), "ok", true)
+	u.Like(out.String(), "nested",
+		`*"INFO", "Queried", {"db":{"query":"SELECT 1", "rows":3,`+
+			` "conn":{"pool":2}}, "ok":true}]`)
+	out.Reset()
+
+	lager.Info().MMap("Empty", lager.Group("db"))
+	u.Like(out.String(), "empty", `*"Empty", {"db":{}}]`)
+	out.Reset()
+
+	lager.Info().List(lager.Group("db", "rows", 1))
+	u.Like(out.String(), "in list", `*"INFO", {"db":{"rows":1}}]`)
+	out.Reset()
+
+	ctx := lager.AddPairs(context.Background(),
+		lager.Group("db", "host", "db-3", "table", "orders"), "req", "r1")
+	ctx = lager.AddPairs(ctx, lager.Group("db", "table", "users"))
+	lager.Warn(ctx).MMap("Slow")
+	u.Like(out.String(), "inherited",
+		`*"Slow", {"db":{"host":"db-3", "table":"users"}, "req":"r1"}]`)
+	out.Reset()
+
+	ctx = lager.AddPairs(ctx, "db", "down")
+	ctx = lager.AddPairs(ctx, lager.Group("db", "rows", 0))
+	lager.Warn(ctx).MMap("Replaced")
+	u.Like(out.String(), "non-map replaced",
+		`*"Replaced", {"db":{"rows":0}, "req":"r1"}]`)
+}
+
+func TestPairsFromCtx(t *testing.T) {
+	u := tutl.New(t)
+	ctx := context.Background()
+	u.Is(0, len(lager.PairsFromCtx(ctx)), "no pairs")
+	u.Is(0, len(lager.PairsFromCtx(nil)), "nil context")
+
+	ctx = lager.AddPairs(ctx, "trace", "t-1", lager.Int("n", 2))
+	m := lager.PairsFromCtx(ctx)
+	u.Is(2, len(m), "len")
+	u.Is("t-1", m["trace"], "string")
+	u.Is(int64(2), m["n"], "from Field")
+
+	m["trace"] = "changed"
+	delete(m, "n")
+	m = lager.PairsFromCtx(ctx)
+	u.Is("t-1", m["trace"], "copy changed not context")
+	u.Is(int64(2), m["n"], "copy delete not context")
+}
+
+func TestEpochTimestamp(t *testing.T) {
+	u := tutl.New(t)
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FWN")()
+	lager.Keys("", "", "", "", "", "")
+
+	restore := lager.SetEpochTimestamp("", false)
+	lager.Warn().MMap("Both")
+	u.Like(out.String(), "list alongside",
+		`^\["[-0-9]+ [0-9:.]+Z", "WARN", "Both", `+
+			`\{"ts_epoch_ms":1[0-9]{12}\}\]\n`)
+	out.Reset()
+
+	lager.Keys("time", "severity", "msg", "data", "", "mod")
+	lager.Warn().MMap("Both")
+	u.Like(out.String(), "map alongside",
+		`^\{"time":"[-0-9]+T[0-9:.]+Z", "severity":"WARN", `+
+			`"ts_epoch_ms":1[0-9]{12}, "msg":"Both"[,}]`)
+	out.Reset()
+	restore()
+
+	defer lager.SetEpochTimestamp("ignored", true)()
+	lager.Warn().MMap("Only")
+	u.Like(out.String(), "map only",
+		`^\{"time":1[0-9]{12}, "severity":"WARN", "msg":"Only"[,}]`)
+	out.Reset()
+
+	lager.Keys("", "", "", "", "", "")
+	lager.Warn().MMap("Only", "n", 1)
+	u.Like(out.String(), "list only",
+		`^\[1[0-9]{12}, "WARN", "Only", \{"n":1\}\]\n`)
+}
+
+func TestKeyFunc(t *testing.T) {
+	u := tutl.New(t)
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FWN")()
+	defer lager.RunningInAws()()
+	lager.Keys("time", "level", "msg", "args", "", "mod")
+
+	region := "us-east1"
+	defer lager.SetKeyFunc("region",
+		func(_ time.Time, _ string) interface{} { return region })()
+	defer lager.SetKeyFunc("time", func(when time.Time, _ string) interface{} {
+		return when.Year()
+	})()
+	defer lager.SetKeyFunc("level", func(_ time.Time, lev string) interface{} {
+		return strings.ToLower(lev)
+	})()
+	lager.Warn().MMap("Custom", "n", 1)
+	u.Like(out.String(), "custom values",
+		`^{"time":20[0-9][0-9], "level":"warn", "region":"us-east1",`+
+			` "msg":"Custom", "n":1}`)
+	out.Reset()
+
+	region = "eu-west1"
+	lager.Note().MMap("Changed")
+	u.Like(out.String(), "computed per line", `*"region":"eu-west1"`)
+	out.Reset()
+
+	lager.SetKeyFunc("region", nil)
+	lager.Note().MMap("Removed")
+	u.Like(out.String(), "removed", "!region")
+	out.Reset()
+
+	lager.Keys("", "", "", "", "", "")
+	lager.Note().MMap("List")
+	u.Like(out.String(), "not used for list",
+		`^\["[-0-9]+ [0-9:.]+Z", "[A-Z]+", "List"\]`)
+}
+
+func TestGlobalPairs(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FWN")()
+
+	defer lager.SetGlobalPairs(
+		"service", "api", lager.Str("version", "1.2"), "env", "prod")()
+	lager.Warn().MMap("Plain", "n", 1)
+	u.Like(out.String(), "global pairs",
+		`*"Plain", {"n":1}, {"service":"api", "version":"1.2", "env":"prod"}]`)
+	out.Reset()
+
+	ctx := lager.AddPairs(context.Background(), "env", "test", "req", "r1")
+	lager.Warn(ctx).MMap("Ctx")
+	u.Like(out.String(), "context replaces",
+		`*"Ctx", {"service":"api", "version":"1.2", "env":"test",`+
+			` "req":"r1"}]`)
+	out.Reset()
+
+	restore := lager.SetGlobalPairs()
+	lager.Warn().MMap("None")
+	u.Like(out.String(), "removed", `*"WARN", "None"]`)
+	out.Reset()
+
+	restore()
+	lager.Warn().List("Restored")
+	u.Like(out.String(), "restored", `*"Restored", {"service":"api",`)
+}
+
+func TestHostPairs(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FWN")()
+	defer os.Setenv("POD_NAME", os.Getenv("POD_NAME"))
+	os.Setenv("POD_NAME", "web-1")
+
+	host, _ := os.Hostname()
+	defer lager.SetGlobalPairs("service", "api", "pod", "web-2")()
+	restore := lager.AddHostPairs()
+	os.Setenv("POD_NAME", "web-3") // Only looked up once.
+	lager.Warn().MMap("Started")
+	u.Like(out.String(), "host pairs",
+		`*"Started", {"host":"`+host+`", "pid":`+
+			strconv.Itoa(os.Getpid())+`, "pod":"web-2", "service":"api"`)
+	out.Reset()
+
+	restore()
+	lager.Warn().MMap("Restored")
+	u.Like(out.String(), "restored", `*"Restored", {"service":"api",`)
+}
+
+func TestAccessLogHandler(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FWNA")()
+
+	var cancel func()
+	h := lager.AccessLogHandler(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte("hello"))
+			if nil != cancel {
+				cancel() // As if the client disconnected.
+				w.Write([]byte(" world"))
+			}
+		}))
+
+	req := httptest.NewRequest("GET", "http://example.com/hi", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	u.Like(out.String(), "completed",
+		`*"ACCESS", "Response sent", {"httpRequest":{"requestMethod":"GET",`,
+		`*"status":200,`, `*"responseSize":5, "latency":`, "!client_aborted")
+	out.Reset()
+
+	ctx, c := context.WithCancel(context.Background())
+	cancel = c
+	h.ServeHTTP(httptest.NewRecorder(), req.WithContext(ctx))
+	u.Like(out.String(), "aborted",
+		`*"ACCESS", "Client aborted request",`+
+			` {"client_aborted":true, "bytes_written":11},`,
+		`*"responseSize":11,`)
+	out.Reset()
+
+	raw := strings.Repeat("hello ", 100)
+	h = lager.AccessLogHandler(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("X-Cache", "MISS")
+			w.Header().Set("Age", "37")
+			gz := gzip.NewWriter(w)
+			gz.Write([]byte(raw))
+			gz.Close()
+			lager.SetUncompressedSize(w, int64(len(raw)))
+		}))
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+	u.Like(out.String(), "compression and cache",
+		`*"Response sent", {"content_encoding":"gzip", "original_size":600,`+
+			` "compressed_size":`+strconv.Itoa(resp.Body.Len())+
+			`, "cache":"MISS", "age":37}, {"httpRequest":`)
+	out.Reset()
+
+	// A client that disconnects part way through a write:
+	ctx, c = context.WithCancel(context.Background())
+	h = lager.AccessLogHandler(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			for i := 0; i < 3; i++ {
+				if _, err := w.Write([]byte("chunk")); nil != err {
+					return
+				}
+			}
+		}))
+	broken := &brokenWriter{ResponseRecorder: httptest.NewRecorder(),
+		room: 7, cancel: c}
+	h.ServeHTTP(broken, req.WithContext(ctx))
+	u.Like(out.String(), "aborted mid-write",
+		`*"Client aborted request", `+
+			`{"client_aborted":true, "bytes_written":7}`)
+	out.Reset()
+
+	ctx, c = context.WithTimeout(context.Background(), -time.Second)
+	defer c()
+	broken = &brokenWriter{ResponseRecorder: httptest.NewRecorder(), room: 3}
+	h.ServeHTTP(broken, req.WithContext(ctx))
+	u.Like(out.String(), "write failed after deadline",
+		`*"Client aborted request", `+
+			`{"client_aborted":true, "bytes_written":3}`)
+	out.Reset()
+
+	broken = &brokenWriter{ResponseRecorder: httptest.NewRecorder(), room: 3}
+	h.ServeHTTP(broken, req)
+	u.Like(out.String(), "write failed, context fine",
+		`*"Response sent"`, `!client_aborted`)
+	out.Reset()
+
+	h = lager.AccessLogHandler(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.(http.Flusher).Flush()
+			_, _, err := w.(http.Hijacker).Hijack()
+			u.Is(http.ErrNotSupported, err, "hijack not supported")
+			uw, ok := w.(interface{ Unwrap() http.ResponseWriter })
+			if u.Is(true, ok, "has Unwrap()") {
+				u.Is(resp, uw.Unwrap(), "Unwrap()")
+			}
+			panic("oops")
+		}))
+	resp = httptest.NewRecorder()
+	func() {
+		defer func() { u.Is("oops", recover(), "re-panicked") }()
+		h.ServeHTTP(resp, req)
+	}()
+	u.Is(true, resp.Flushed, "flushed")
+	u.Like(out.String(), "panic",
+		`*"Handler panicked", {"panic":"oops"}, {"httpRequest":`,
+		`*"status":200,`)
+	out.Reset()
+
+	h = lager.AccessLogHandler(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) { panic("early") }))
+	func() {
+		defer func() { recover() }()
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	u.Like(out.String(), "panic before status",
+		`*"Handler panicked", {"panic":"early"}`, `*"status":500,`)
+}
+
+// An http.ResponseWriter whose connection breaks after 'room' bytes (and
+// that then calls 'cancel', if set, as when the client disconnects).
+type brokenWriter struct {
+	*httptest.ResponseRecorder
+	room   int
+	cancel func()
+}
+
+func (bw *brokenWriter) Write(buf []byte) (int, error) {
+	if len(buf) <= bw.room {
+		bw.room -= len(buf)
+		return bw.ResponseRecorder.Write(buf)
+	}
+	n, _ := bw.ResponseRecorder.Write(buf[:bw.room])
+	bw.room = 0
+	if nil != bw.cancel {
+		bw.cancel()
+	}
+	return n, errors.New("write: broken pipe")
+}
+
+
+func TestCallerLevels(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FWN")()
+
+	restore := lager.SetCallerLevels("F", "")
+	lager.Fail().MMap("Failed", "n", 1)
+	u.Like(out.String(), "call site",
+		`"Failed", {"n":1}, {"caller":{"file":"[^"]*lager_test[.]go",`+
+			` "line":[0-9]+, "func":"TestCallerLevels"}}\]`)
+	out.Reset()
+
+	lager.NewModule("db").Fail().MFmt("Failed %d", 2)
+	u.Like(out.String(), "skips lager frames",
+		`"func":"TestCallerLevels"}}, "mod=db"\]`)
+	out.Reset()
+
+	lager.Warn().MMap("Not enabled")
+	u.Like(out.String(), "other level", "!caller")
+	out.Reset()
+	restore()
+
+	defer lager.SetCallerLevels("W", "src")()
+	lager.Warn().List("Keyed")
+	u.Like(out.String(), "custom key", `*{"src":{"file":`)
+	out.Reset()
+	lager.Fail().List("Restored")
+	u.Like(out.String(), "replaced levels", "!src", "!caller")
+}
+
+func TestGcpLatencyFormat(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	defer lager.SetLevels("FWNA")()
+	req := httptest.NewRequest("GET", "http://example.com/api", nil)
+	resp := lager.GcpFakeResponse(200, -1, "")
+	start := time.Now().Add(-1500 * time.Millisecond)
+	latency := func() string {
+		out.Reset()
+		lager.Warn().MMap("Req", "req", lager.GcpHttp(req, resp, &start))
+		return out.String()
+	}
+
+	u.Like(latency(), "default", `"latency":"1[.][0-9]{4}s"`)
+	restore := lager.SetGcpLatencyFormat("duration")
+	u.Like(latency(), "duration", `"latency":"1[.]([0-9]{3}){1,3}s"`)
+	lager.SetGcpLatencyFormat("object")
+	u.Like(latency(), "object",
+		`"latency":{"seconds":1, "nanos":[0-9]+}`)
+	u.Like(u.GetPanic(func() { lager.SetGcpLatencyFormat("ms") }),
+		"invalid", `*must be "", "duration", or "object" not "ms"`)
+
+	defer lager.SetSLOs(lager.SLO{Latency: time.Second})()
+	out.Reset()
+	lager.GcpLogAccess(req, resp, &start).MMap("Response sent")
+	u.Like(out.String(), "SLO with object",
+		`*"slo.violated":true, "slo.threshold":"1s"`)
+	restore()
+	u.Like(latency(), "restored", `"latency":"1[.][0-9]{4}s"`)
+}
+
+func TestMute(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	before := lager.Suppressed()
+
+	lager.Mute()
+	u.Is(true, lager.Muted(), "muted")
+	lager.Fail().List("fail")
+	lager.Warn().MMap("warn")
+	lager.NewModule("muted").Warn().Map("w", 1)
+	lager.Note().MList("note")
+	u.Is(nil, u.GetPanic(func() {
+		defer lager.ExitViaPanic()(func(x *int) { *x = -1 })
+		lager.Exit().List("exit still logged")
+	}), "exit via panic")
+	lager.Unmute()
+	u.Is(false, lager.Muted(), "unmuted")
+	lager.Warn().List("unmuted")
+
+	u.Like(out.String(), "output", `!"fail"`, `!"warn"`, `!"note"`,
+		`"exit still logged"`, `"unmuted"`)
+	after := lager.Suppressed()
+	u.Is(before["FAIL"]+1, after["FAIL"], "fail count")
+	u.Is(before["WARN"]+2, after["WARN"], "warn count")
+	u.Is(before["NOTE"]+1, after["NOTE"], "note count")
+	u.Is(0, after["EXIT"], "exit not counted")
+}
+
+func TestFlagSet(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	defer lager.Init("FWNA")
+	defer lager.Keys("", "", "", "", "", "")
+	lager.Init("FW")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+
+	fs := flag.NewFlagSet("tool", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	lager.FlagSet(fs)
+	u.Is(nil, fs.Parse([]string{"-v", "--log-format", "map", "arg"}), "parse")
+	u.Is("arg", fs.Arg(0), "args left")
+	u.Is(true, lager.Info().Enabled(), "-v enables Info")
+	u.Is(false, lager.Debug().Enabled(), "-v does not enable Debug")
+	lager.Info().List("verbose")
+	u.Like(out.String(), "map format", `^{"time":"`, `"level":"INFO"`,
+		`"msg":"verbose"`)
+
+	u.Is(nil, fs.Parse([]string{"-vv", "--quiet", "-log-format=list"}),
+		"parse 2")
+	u.Is(true, lager.Debug().Enabled(), "-vv enables Debug")
+	u.Is(true, lager.Muted(), "--quiet mutes")
+	u.Is(nil, fs.Parse([]string{"--quiet=false"}), "parse 3")
+	u.Is(false, lager.Muted(), "--quiet=false unmutes")
+	lager.Warn().List("list")
+	u.Like(out.String(), "list format", `\n\["[^"]*", "WARN", "list"\]`)
+
+	out.Reset()
+	u.Is(nil, fs.Parse([]string{"--log-format", "console"}), "parse console")
+	lager.Warn().List("console")
+	u.Is(nil, fs.Parse([]string{"--log-format", "list"}), "parse list")
+	u.Like(out.String(), "console format", `^[0-9:.]+ WARN .*console\n$`)
+
+	u.Like(fs.Parse([]string{"--log-format", "xml"}), "bad format",
+		`*must be one of aws|azure|console|ecs|flat|gcp|list|logfmt|map not "xml"`)
+	u.Like(fs.Parse([]string{"-v=maybe"}), "bad bool", "*not a boolean")
+}
+
+type syncBuffer struct {
+	mu sync.Mutex
+	bytes.Buffer
+}
+
+func (sb *syncBuffer) Write(b []byte) (int, error) {
+	defer lager.AutoLock(&sb.mu)()
+	return sb.Buffer.Write(b)
+}
+
+func (sb *syncBuffer) String() string {
+	defer lager.AutoLock(&sb.mu)()
+	return sb.Buffer.String()
+}
+
+func (sb *syncBuffer) Len() int {
+	defer lager.AutoLock(&sb.mu)()
+	return sb.Buffer.Len()
+}
+
+func TestGzipOutput(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	archive := new(syncBuffer)
+	gz := lager.GzipOutput(archive, time.Millisecond)
+	restore := lager.SetOutput(gz)
+	for i := 0; i < 100; i++ {
+		lager.Warn().MMap("compressed", "i", i)
+	}
+	restore()
+	for i := 0; i < 100 && archive.Len() < 25; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	u.Is(true, 25 <= archive.Len(), "periodic flush")
+	u.Is(nil, gz.Close(), "Close")
+	u.Like(gz.Close(), "Close again", "*already closed")
+	_, err := gz.Write([]byte("x\n"))
+	u.Like(err, "Write after Close", "*already closed")
+
+	zr, err := gzip.NewReader(&archive.Buffer)
+	if !u.Is(nil, err, "gzip reader") {
+		return
+	}
+	plain, err := io.ReadAll(zr)
+	u.Is(nil, err, "gunzip")
+	u.Is(100, strings.Count(string(plain), `"compressed"`), "all lines")
+	u.Like(string(plain), "last line", `"i":99}\]\n$`)
+}
+
+type fakeTest struct {
+	failed   bool
+	logs     []string
+	cleanups []func()
+}
+
+func (ft *fakeTest) Helper()      {}
+func (ft *fakeTest) Failed() bool { return ft.failed }
+
+func (ft *fakeTest) Cleanup(f func()) {
+	ft.cleanups = append(ft.cleanups, f)
+}
+
+func (ft *fakeTest) Log(args ...interface{}) {
+	ft.logs = append(ft.logs, lager.S(args[0]))
+}
+
+func (ft *fakeTest) finish() {
+	for i := len(ft.cleanups) - 1; 0 <= i; i-- {
+		ft.cleanups[i]()
+	}
+}
+
+func TestCaptureTestLogs(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	lager.Init("FWNA")
+
+	pass := new(fakeTest)
+	lager.CaptureTestLogs(pass)
+	lager.Warn().List("quiet")
+	pass.finish()
+	u.Is(0, len(pass.logs), "passing test logs nothing")
+
+	fail := new(fakeTest)
+	lager.CaptureTestLogs(fail, "FWNAID")
+	u.Is(true, lager.Debug().Enabled(), "levels overridden")
+	lager.Warn().List("shown")
+	lager.Debug().List("debug shown")
+	fail.failed = true
+	fail.finish()
+	u.Is(false, lager.Debug().Enabled(), "levels restored")
+	if u.Is(1, len(fail.logs), "failing test logs lines") {
+		u.Like(fail.logs[0], "captured lines", "^Log lines written",
+			`\n\[.*"WARN", "shown"\]\n\[.*"DEBUG", "debug shown"\]$`)
+	}
+	lager.Warn().List("after")
+	u.Like(out.String(), "output restored", `^\[.*"after"\]\n$`)
+}
+
+func TestEntryIDs(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	lager.SetEntryIDs(true, "trace")
+	ctx := lager.AddPairs(context.Background(), "trace", "t1")
+	lager.Warn(ctx).MMap("same", "n", 1)
+	lager.Warn(ctx).MMap("same", "n", 1)
+	lager.Warn().List("no pairs")
+	lager.SetEntryIDs(false)
+	lager.Warn().List("no id")
+
+	lines := strings.Split(out.String(), "\n")
+	if !u.Is(5, len(lines), "lines") {
+		return
+	}
+	ids := make([]string, 3)
+	for i := range ids {
+		var list []interface{}
+		if validJson("entry id", []byte(lines[i]), &list, u) {
+			ctx, _ := list[len(list)-1].(map[string]interface{})
+			ids[i], _ = ctx["_id"].(string)
+		}
+		u.Like(ids[i], "id format", "^[0-9a-f]+$")
+	}
+	u.Like(lines[0], "pairs kept", `{"trace":"t1", "_id":"`)
+	u.Is(false, ids[0] == ids[1], "sequence makes IDs unique")
+	u.Like(lines[3], "disabled", `!_id`)
+}
+
+func TestMirrorStderr(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	stderr, err := os.CreateTemp("", "lager-stderr")
+	if !u.Is(nil, err, "temp file") {
+		return
+	}
+	defer os.Remove(stderr.Name())
+	defer func(orig *os.File) { os.Stderr = orig }(os.Stderr)
+	os.Stderr = stderr
+
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	restore := lager.MirrorStderr("PEF")
+	lager.Fail().List("mirrored")
+	lager.Warn().List("not mirrored")
+	undo := lager.SetOutput(os.Stderr)
+	lager.Fail().List("once")
+	undo()
+	restore()
+	lager.Fail().List("restored")
+
+	u.Like(out.String(), "main output", `"mirrored"`, `"not mirrored"`,
+		`"restored"`)
+	mirror, _ := os.ReadFile(stderr.Name())
+	u.Like(mirror, "stderr", `^\[.*"FAIL", "mirrored"\]\n`, `!not mirrored`,
+		`!restored`)
+	u.Is(1, strings.Count(string(mirror), `"once"`), "not duplicated")
+}
+
+func TestConsoleFormat(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	db := lager.NewModule("db")
+	gw := lager.NewModule("api-gateway")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	restore := lager.UseConsoleFormat(false)
+
+	lager.Warn().MMap("Slow query", "table", "users", "ms", 2219)
+	u.Like(out.String(), "plain",
+		`^[0-9]{2}:[0-9]{2}:[0-9]{2}[.][0-9]{4} WARN +[|] Slow query `+
+			`table=users ms=2219\n$`)
+	out.Reset()
+
+	db.Warn().MMap("Bad row", "row", lager.Map("id", 7), "why", "no name")
+	u.Like(out.String(), "module",
+		`^[0-9:.]+ WARN   db {9,} [|] Bad row row=[{]"id":7[}] why="no name"\n$`)
+	out.Reset()
+
+	lager.Keys("time", "level", "msg", "data", "", "module")
+	gw.Fail().MList("Refused", "port", 443)
+	u.Like(out.String(), "map keys",
+		`^[0-9:.]+ FAIL   api-gateway +[|] Refused port 443\n$`)
+	out.Reset()
+	lager.Keys("", "", "", "", "", "")
+
+	undo := lager.UseConsoleFormat(true)
+	u.Is(nil, lager.SetConsoleModules("only:api-gateway"), "set only")
+	defer lager.SetConsoleModules("")
+	db.Warn().List("hidden")
+	gw.Warn().List("shown")
+	lager.Fail().List("global")
+	u.Like(out.String(), "only",
+		"!hidden", "*mapi-gateway\x1b[0m", "*| shown\n", "*| global\n",
+		"*\x1b[33mWARN\x1b[0m   ", "*\x1b[31mFAIL\x1b[0m   ",
+		`^\x1b\[2m[0-9:.]+\x1b\[0m `)
+	out.Reset()
+
+	u.Like(lager.SetConsoleModules("api"), "no prefix", "*only: or hide:")
+	u.Like(lager.SetConsoleModules("show:api"), "bad prefix", `*not "show:"`)
+	u.Is(nil, lager.SetConsoleModules("hide:db, api-gateway"), "set hide")
+	db.Warn().List("hidden")
+	gw.Warn().List("hidden")
+	lager.Warn().List("global")
+	u.Like(out.String(), "hide", "!hidden", "*| global\n")
+	out.Reset()
+
+	undo()
+	restore()
+	lager.Warn().List("json")
+	u.Like(out.String(), "restored", `^\[.*"WARN", "json"\]\n$`)
+}
+
+func TestCoalescing(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	r, w, err := os.Pipe()
+	if !u.Is(nil, err, "pipe") {
+		return
+	}
+	defer r.Close()
+	defer w.Close()
+	got := make(chan string, 10)
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := r.Read(buf)
+			if nil != err {
+				close(got)
+				return
+			}
+			got <- string(buf[:n])
+		}
+	}()
+
+	restore := lager.SetCoalescing(time.Hour)
+	undo := lager.SetOutput(w)
+	for i := 0; i < 3; i++ {
+		lager.Fail().MMap("held", "i", i)
+	}
+	select {
+	case data := <-got:
+		u.Is("", data, "nothing written before Flush")
+	case <-time.After(20 * time.Millisecond):
+	}
+	u.Is(nil, lager.Flush(nil), "Flush")
+	u.Is(3, strings.Count(<-got, `"held"`), "lines written in one Write")
+
+	out := new(bytes.Buffer)
+	lager.SetOutput(out)
+	lager.Fail().List("immediate")
+	u.Like(out.String(), "non-pipe not held", `"immediate"\]\n$`)
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	done := make(chan []byte)
+	go func() {
+		all, _ := io.ReadAll(peer)
+		done <- all
+	}()
+	lager.SetOutput(conn)
+	lager.Fail().List("via conn")
+	lager.Fail(lager.FlushOn(nil)).List("flushed")
+	undo()
+	restore()
+	conn.Close()
+	u.Like(<-done, "net.Conn", `"via conn"\]\n.*"flushed"\]\n$`)
+}
+
+// An output that blocks writes until 'gate' is closed.
+type gatedWriter struct {
+	syncBuffer
+	gate    chan struct{}
+	entered chan struct{}
+	once    sync.Once
+}
+
+func (gw *gatedWriter) Write(b []byte) (int, error) {
+	gw.once.Do(func() { close(gw.entered) })
+	<-gw.gate
+	return gw.syncBuffer.Write(b)
+}
+
+func (gw *gatedWriter) String() string {
+	defer lager.AutoLock(&gw.mu)()
+	return gw.Buffer.String()
+}
+
+func TestDropWhenBehind(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := &gatedWriter{
+		gate: make(chan struct{}), entered: make(chan struct{}),
+	}
+	defer lager.SetOutput(out)()
+	defer lager.SetAsync(1, time.Hour)()
+	// Dropped() counts since the program started, so allow for prior runs:
+	prior := lager.Dropped()["NOTE"]
+	t.Cleanup(lager.DropWhenBehind("Nx", 10*time.Millisecond))
+
+	lager.Fail().List("first")
+	go lager.Flush(nil)
+	<-out.entered // The background goroutine is now blocked writing.
+	lager.Note().List("queued")
+	lager.Note().List("dropped")
+	lager.Note().List("dropped")
+	close(out.gate)
+	u.Is(nil, lager.Flush(nil), "Flush")
+	u.Like(out.String(), "lines", `"first"`, `"queued"`, `!"dropped"`)
+	u.Is(prior+2, lager.Dropped()["NOTE"], "Dropped()")
+
+	for i := 0; i < 100 && !strings.Contains(out.String(), "behind"); i++ {
+		time.Sleep(5 * time.Millisecond)
+		lager.Flush(nil)
+	}
+	u.Like(out.String(), "summary",
+		`*"Dropped log lines because output is behind", {"dropped":{"NOTE":2}}`)
+}
+
+func TestWarnWhenBacklogged(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := &gatedWriter{
+		gate: make(chan struct{}), entered: make(chan struct{}),
+	}
+	defer lager.SetOutput(out)()
+	defer lager.SetAsync(3, time.Hour)()
+	restore := lager.WarnWhenBacklogged(2, 5*time.Millisecond, time.Hour)
+
+	lager.Fail().List("first")
+	go lager.Flush(nil)
+	<-out.entered // The background goroutine is now blocked writing.
+	lager.Note().List("queued")
+	lager.Note().List("queued")
+	time.Sleep(100 * time.Millisecond)
+	close(out.gate)
+	u.Is(nil, lager.Flush(nil), "Flush")
+	u.Like(out.String(), "health",
+		`*"WARN", "Log queue is backlogged", {"queueDepth":2, "queueLen":3, `+
+			`"backlogged":"`)
+	u.Is(1, strings.Count(out.String(), "Log queue"), "one report")
+	restore()
+
+	out.Reset()
+	restore = lager.WarnWhenBacklogged(0, 0, 0)
+	lager.Note().List("fine")
+	u.Is(nil, lager.Flush(nil), "Flush")
+	time.Sleep(20 * time.Millisecond)
+	u.Like(out.String(), "not backlogged", `!backlogged`)
+	restore()
+}
+
+type testColor int
+
+func (c testColor) String() string { return [...]string{"red", "green"}[c] }
+
+func TestContainers(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	type point struct{ X, Y int }
+	var cache sync.Map
+	cache.Store(2, "two")
+	cache.Store("one", 1)
+	seq := func(yield func(int) bool) {
+		for i := 0; yield(i); i++ {
+		}
+	}
+	seq2 := func(yield func(string, testColor) bool) {
+		_ = yield("a", 0) && yield("b", 1)
+	}
+
+	lager.Fail().MMap("containers",
+		"ints", map[int]string{10: "ten", 2: "two"},
+		"structs", map[point]bool{{1, 2}: true},
+		"bools", map[bool]int{false: 0, true: 1},
+		"nilMap", map[int]int(nil),
+		"colors", []testColor{0, 1},
+		"errs", [2]error{errors.New("oops"), nil},
+		"sync", lager.SyncMap(&cache),
+		"seq2", seq2,
+	)
+	u.Like(out.String(), "logged",
+		`*"ints":{"10":"ten","2":"two"}`,
+		`*"structs":[[{"X":1,"Y":2}, true]]`,
+		`*"bools":[[false, 0], [true, 1]]`,
+		`*"nilMap":null`,
+		`*"colors":["red", "green"]`,
+		`*"errs":["oops", null]`,
+		`*"sync":{"2":"two", "one":1}`,
+		`*"seq2":[["a", "red"], ["b", "green"]]`,
+		"!unsupported",
+	)
+	out.Reset()
+
+	lager.Fail().MMap("iterator", "seq", seq)
+	u.Like(out.String(), "seq limited", `*"seq":[0, 1, 2, `, `*, 998, 999]}`)
+	out.Reset()
+	lager.Fail().MMap("empty", "sync", lager.SyncMap(new(sync.Map)))
+	u.Like(out.String(), "empty SyncMap", `*"sync":{}`)
+
+	out.Reset()
+	restore := lager.SetMapKeyPolicy(lager.MapKeysAsStrings)
+	lager.Fail().MMap("strings",
+		"ints", map[int]string{10: "ten", 2: "two"},
+		"structs", map[point]bool{{1, 2}: true},
+		"bools", map[bool]testColor{true: 1},
+		"times", map[time.Time]int{time.Unix(0, 0).UTC(): 0},
+	)
+	restore()
+	u.Like(out.String(), "MapKeysAsStrings",
+		`*"ints":{"10":"ten","2":"two"}`,
+		`*"structs":{"{1 2}":true}`,
+		`*"bools":{"true":"green"}`,
+		`*"times":{"1970-01-01T00:00:00Z":0}`,
+	)
+	out.Reset()
+	lager.Fail().MMap("pairs", "bools", map[bool]int{true: 2})
+	u.Like(out.String(), "restored policy", `*"bools":[[true, 2]]`)
+}
+
+func TestLineWriter(t *testing.T) {
+	u := tutl.New(t)
+	out := new(bytes.Buffer)
+	err := lager.NewLineWriter(out).Scalar("requests").
+		Pair("count", 12).Pair("route", "/").Scalar(1.5).Finish()
+	u.Is(nil, err, "Finish")
+	u.Is(`["requests", {"count":12, "route":"/"}, 1.5]`+"\n", out.String(),
+		"line")
+
+	out.Reset()
+	big := strings.Repeat("x", 40*1024)
+	u.Is(nil, lager.NewLineWriter(out).Pair("big", big).Finish(), "big")
+	u.Is(`[{"big":"`+big+`"}]`+"\n", out.String(), "big line")
+
+	fw := new(failWriter)
+	u.Like(lager.NewLineWriter(fw).Finish(), "write error", "*disk full")
+	u.Is(1, fw.writes, "one Write")
+}
+
+func TestLogfmt(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	db := lager.NewModule("db")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	restore := lager.UseLogfmtFormat()
+
+	ctx := lager.AddPairs(context.Background(),
+		"req", lager.Map("id", "5x1", "path", "/login"))
+	db.Warn(ctx).MMap("Slow query", "table", "users", "ms", 2219,
+		"args", lager.List(1, "two"), "odd key", "a=b")
+	u.Like(out.String(), "logfmt",
+		`^time=[0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9:.]+Z level=WARN mod=db `+
+			`msg="Slow query" table=users ms=2219 args="\[1, \\"two\\"\]" `+
+			`odd_key="a=b" req.id=5x1 req.path=/login\n$`)
+	out.Reset()
+
+	lager.Keys("time", "level", "msg", "data", "", "module")
+	lager.Fail().List("no", "message", 3)
+	lager.Keys("", "", "", "", "", "")
+	u.Like(out.String(), "map keys",
+		`^time=[0-9T:.-]+Z level=FAIL msg="no message 3"\n$`)
+	out.Reset()
+
+	restore()
+	lager.Warn().List("json")
+	u.Like(out.String(), "restored", `^\[.*"WARN", "json"\]\n$`)
+}
+
+func TestBinaryFormats(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	log := func() {
+		lager.Fail().MMap("hi", "n", 1, "neg", -300, "ok", true,
+			"nil", nil, "f", 1.5, "u", uint64(math.MaxUint64))
+	}
+
+	restore := lager.UseCborFormat()
+	log()
+	restore()
+	data := out.Bytes()
+	if u.Is(true, 28 < len(data), "cbor length") {
+		u.Is([]byte{0x84, 0x78, 25}, data[:3], "cbor array and time")
+		u.Is("\x64FAIL\x62hi\xA6"+
+			"\x61n\x01"+
+			"\x63neg\x39\x01\x2B"+
+			"\x62ok\xF5"+
+			"\x63nil\xF6"+
+			"\x61f\xFB\x3F\xF8\x00\x00\x00\x00\x00\x00"+
+			"\x61u\x1B"+strings.Repeat("\xFF", 8),
+			string(data[28:]), "cbor")
+	}
+	out.Reset()
+
+	restore = lager.UseMsgpackFormat()
+	log()
+	restore()
+	data = out.Bytes()
+	if u.Is(true, 28 < len(data), "msgpack length") {
+		u.Is([]byte{0x94, 0xB9}, data[:2], "msgpack array and time")
+		u.Is("\xA4FAIL\xA2hi\x86"+
+			"\xA1n\x01"+
+			"\xA3neg\xD2\xFF\xFF\xFE\xD4"+
+			"\xA2ok\xC3"+
+			"\xA3nil\xC0"+
+			"\xA1f\xCB\x3F\xF8\x00\x00\x00\x00\x00\x00"+
+			"\xA1u\xCF"+strings.Repeat("\xFF", 8),
+			string(data[27:]), "msgpack")
+	}
+	out.Reset()
+
+	lager.Fail().List("json")
+	u.Like(out.String(), "restored", `^\[.*"FAIL", "json"\]\n$`)
+
+	if raceEnabled {
+		return
+	}
+	defer lager.SetOutput(io.Discard)()
+	// The fewest of a few tries, since a GC can empty the buffer pool.
+	allocs := func() float64 {
+		least := math.Inf(1)
+		for i := 0; i < 3; i++ {
+			least = math.Min(least, testing.AllocsPerRun(100, func() {
+				lager.Fail().MMap("Fetched", "key", allocKey,
+					"bytes", allocBytes, "took", allocTook, "ratio", 0.25,
+					"error", io.EOF)
+			}))
+		}
+		return least
+	}
+	json := allocs()
+	restore = lager.UseCborFormat()
+	cbor := allocs()
+	restore()
+	restore = lager.UseMsgpackFormat()
+	msgpack := allocs()
+	restore()
+	u.Is(true, cbor <= json,
+		fmt.Sprintf("CBOR: %v allocs vs %v for JSON", cbor, json))
+	u.Is(true, msgpack <= json,
+		fmt.Sprintf("MessagePack: %v allocs vs %v for JSON", msgpack, json))
+}
+
+// A toy Encoder that writes lines like "(FAIL hi {n:1})".
+type parenEncoder struct{}
+
+func (parenEncoder) OpenList(out []byte) []byte { return append(out, '(') }
+func (parenEncoder) OpenMap(out []byte) []byte  { return append(out, '{') }
+func (parenEncoder) EndLine(out []byte) []byte  { return append(out, '\n') }
+
+func (parenEncoder) CloseList(out []byte, _, _ int) []byte {
+	return append(out, ')')
+}
+
+func (parenEncoder) CloseMap(out []byte, _, _ int) []byte {
+	return append(out, '}')
+}
+
+func (parenEncoder) Next(out []byte, i int) []byte {
+	if 0 == i {
+		return out
+	}
+	return append(out, ' ')
+}
+
+func (parenEncoder) Key(out []byte, key string) []byte {
+	return append(append(out, key...), ':')
+}
+
+func (parenEncoder) Scalar(out []byte, v interface{}) []byte {
+	if nil == v {
+		return append(out, "null"...)
+	}
+	return append(out, lager.S(v)...)
+}
+
+func TestEncoder(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+
+	restore := lager.UseEncoder(parenEncoder{})
+	lager.Fail().MMap("hi", "n", 1, "l", lager.List(true, nil))
+	restore()
+	u.Like(out.String(), "custom",
+		`^\([-0-9]+ [0-9:.]+Z FAIL hi {n:1 l:\(true null\)}\)\n$`)
+	out.Reset()
+
+	u.Is(nil, lager.RegisterEncoder("paren", parenEncoder{}), "register")
+	t.Cleanup(func() { lager.UnregisterFormat("paren") })
+	u.Like(lager.RegisterEncoder("paren", parenEncoder{}), "duplicate",
+		`*log format "paren" is already in use`)
+	u.Like(lager.RegisterEncoder("map", parenEncoder{}), "built-in",
+		`*log format "map" is already in use`)
+	done := make(chan bool)
+	go func() { // Run with -race to check that formats are locked.
+		lager.RegisterEncoder("paren2", parenEncoder{})
+		close(done)
+	}()
+	_, err := lager.FormatOutput(out, "map")
+	<-done
+	lager.UnregisterFormat("paren2")
+	u.Is(nil, err, "FormatOutput while registering")
+	paren, err := lager.FormatOutput(out, "paren")
+	u.Is(nil, err, "FormatOutput")
+	restore = lager.SetOutput(paren)
+	lager.Warn().List("named")
+	restore()
+	u.Like(out.String(), "registered", `^\([-0-9]+ [0-9:.]+Z WARN named\)\n$`)
+	out.Reset()
+
+	js := new(bytes.Buffer)
+	restore = lager.SetOutput(lager.TeeOutput(paren, js))
+	calls := 0
+	id := func() interface{} { calls++; return 7 }
+	log := lager.Warn().WithPairs("id", id)
+	log.MMap("bound", "s", struct{ X []int }{[]int{1}}, "bad", "a\xffb")
+	log.List(strings.Repeat("x", 20000))
+	restore()
+	u.Is(1, calls, "bound func calls")
+	lines := strings.Split(out.String(), "\n")
+	if u.Is(3, len(lines), "encoded lines") {
+		u.Like(lines[0], "bound",
+			`*WARN bound {s:{X:(1)} bad:a«xFF»b} {id:7})`)
+		u.Is(20000+40, len(lines[1]), "long line")
+		u.Like(lines[1], "long line", `x {id:7}\)$`)
+	}
+	u.Like(js.String(), "tee", `*"WARN", "bound", {"s":{"X":[1]}, `,
+		`\n\["[-0-9]+ [0-9:.]+Z", "WARN", "x+", {"id":7}\]\n$`)
+	out.Reset()
+
+	defer lager.SetOutput(paren)()
+	restore = lager.SetDedupWindow(time.Minute)
+	for _, msg := range []string{"same", "same", "same", "other"} {
+		lager.Warn().List(msg)
+	}
+	restore()
+	u.Like(out.String(), "repeats", `^\([-0-9]+ [0-9:.]+Z WARN same\)\n`,
+		`\n\([-0-9]+ [0-9:.]+Z WARN same {repeat_count:2}\)\n`,
+		`\n\([-0-9]+ [0-9:.]+Z WARN other\)\n$`)
+	out.Reset()
+
+	// Only encoded (no output needs JSON):
+	restore = lager.SetVolumeAccounting("k", 0, 0)
+	lager.Warn().MMap("enc", "k", "a", "f", float32(0.1), "inf", math.Inf(1),
+		lager.Time("t", time.Unix(0, 0).UTC()), lager.Dur("d", time.Second))
+	lager.Warn().List(strings.Repeat("y", 20000))
+	u.Is(uint64(len(out.String())), lager.LogVolume()["a"].Bytes+
+		lager.LogVolume()[""].Bytes, "encoded bytes counted")
+	restore()
+	stop := lager.SetFlightRecorder("W", 2)
+	lager.Warn().List("held")
+	lager.Warn().List("held too")
+	lager.Fail().List("failed")
+	stop()
+	lines = strings.Split(out.String(), "\n")
+	if u.Is(6, len(lines), "encoded only") {
+		u.Like(lines[0], "values", `*WARN enc {k:a f:0.1 inf:+Inf `+
+			`t:1970-01-01T00:00:00Z d:1s})`)
+		u.Is(20000+33, len(lines[1]), "long encoded line")
+		u.Like(lines[2], "held", ` WARN held\)$`)
+		u.Like(lines[3], "held too", ` WARN held too\)$`)
+		u.Like(lines[4], "after dump", ` FAIL failed\)$`)
+	}
+}
+
+func TestEcs(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	log := new(bytes.Buffer)
+	defer lager.SetOutput(log)()
+
+	restore := lager.RunningInEcs()
+	ctx := lager.AddPairs(context.Background(), "trace", "x7")
+	lager.NewModule("db").Warn(ctx).MMap("Slow query", "ms", 2219)
+	lager.Fail().List("plain")
+	restore()
+	lager.Warn().List("list")
+	lines := strings.Split(log.String(), "\n")
+	if u.Is(4, len(lines), "lines") {
+		validJson("ecs", []byte(lines[0]), nil, u)
+		u.Like(lines[0], "ecs line", `^{"@timestamp":"[-0-9]+T[0-9:.]+Z", `,
+			`"log.level":"warn", "ecs.version":"`+lager.EcsVersion+`", `,
+			`"message":"Slow query", "ms":2219, "labels":{"trace":"x7"}, `,
+			`"log.logger":"db"}$`)
+		u.Like(lines[1], "ecs lone message", `"log.level":"error"`,
+			`"message":"plain"`)
+		u.Like(lines[2], "restored", `^\["`, `"WARN", "list"\]$`)
+	}
+	u.Is("fatal", lager.EcsLevelName("PANIC"), "panic level")
+	u.Is("debug", lager.EcsLevelName("GUTS"), "guts level")
+}
+
+func TestContextAddTrace(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+
+	bg := context.Background()
+	u.Is(bg, lager.ContextAddTrace(bg, nil), "nil source")
+	u.Is(bg, lager.ContextAddTrace(bg, lager.TraceIDs("abc", 0)), "no span")
+	src := lager.TraceIDs("1-5759e988-bd862e3fe1be46a994272793", 0x53995c3f)
+	u.Is(`{"trace_id":"1-5759e988-bd862e3fe1be46a994272793",`+
+		` "span_id":"0000000053995c3f"}`,
+		lager.MarshalPairs(lager.ContextAddTrace(bg, src)), "plain keys")
+
+	restore := lager.RunningInEcs()
+	u.Like(lager.MarshalPairs(lager.ContextAddTrace(bg, src)), "ecs keys",
+		`*"trace.id":"1-5759e988-`, `*"span.id":"0000000053995c3f"`)
+	restore()
+
+	defer lager.RunningInEcs()() // Restores settings changed by:
+	lager.RunningInGcp()
+	u.Like(lager.MarshalPairs(lager.ContextAddTrace(bg, projSrc{src})),
+		"gcp keys with project",
+		`*"logging.googleapis.com/trace":"projects/proj/traces/1-5759e988-`,
+		`*"logging.googleapis.com/spanId":"0000000053995c3f"`)
+}
+
+// A TraceSource that also knows its GCP project ID.
+type projSrc struct{ lager.TraceSource }
+
+func (projSrc) GetProjectID() string { return "proj" }
+
+func TestAws(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	log := new(bytes.Buffer)
+	defer lager.SetOutput(log)()
+
+	defer os.Unsetenv("_X_AMZN_TRACE_ID")
+	os.Setenv("_X_AMZN_TRACE_ID",
+		"Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;"+
+			"Sampled=1")
+	restore := lager.RunningInAws()
+	ctx := lager.AwsContextAddTrace(context.Background())
+	lager.NewModule("db").Fail(ctx).MMap("Query failed", "ms", 7)
+	restore()
+	validJson("aws", log.Bytes(), nil, u)
+	u.Like(log.String(), "aws line", `^{"timestamp":"[-0-9]+T[0-9:.]+Z", `,
+		`"level":"ERROR", "message":"Query failed", "ms":7, `,
+		`"xray_trace_id":"1-5759e988-bd862e3fe1be46a994272793", `,
+		`"xray_span_id":"53995c3f42cd8ad8", "module":"db"}\n$`)
+
+	u.Is(nil, lager.AwsTraceSource("Root=1-5759e988-bd86;Sampled=0"),
+		"no parent")
+	os.Setenv("_X_AMZN_TRACE_ID", "")
+	bg := context.Background()
+	u.Is(bg, lager.AwsContextAddTrace(bg), "no trace env")
+	u.Is("FATAL", lager.AwsLevelName("EXIT"), "exit level")
+	u.Is("INFO", lager.AwsLevelName("ACCESS"), "access level")
+}
+
+func TestAzure(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	log := new(bytes.Buffer)
+	defer lager.SetOutput(log)()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("traceparent",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	restore := lager.RunningInAzure()
+	ctx := lager.AzureContextAddTrace(req.Context(), req)
+	lager.NewModule("db").Warn(ctx).MMap("Slow query", "ms", 2219)
+	restore()
+	validJson("azure", log.Bytes(), nil, u)
+	u.Like(log.String(), "azure line", `^{"time":"[-0-9]+T[0-9:.]+Z", `,
+		`"severityLevel":"Warning", "message":"Slow query", "ms":2219, `,
+		`"customDimensions":{`,
+		`"operation_Id":"0af7651916cd43dd8448eb211c80319c", `,
+		`"operation_ParentId":"b7ad6b7169203331"}, "category":"db"}\n$`)
+
+	h := http.Header{}
+	h.Set("Request-Id", "|0af7651916cd43dd8448eb211c80319c.b7ad6b7169203331.")
+	src := lager.AzureTraceSource(h)
+	if u.Is(true, nil != src, "Request-Id") {
+		u.Is("0af7651916cd43dd8448eb211c80319c", src.GetTraceID(),
+			"Request-Id operation")
+		u.Is(uint64(0xb7ad6b7169203331), src.GetSpanID(), "Request-Id parent")
+	}
+	h.Set("Request-Id", "|abc.1.")
+	u.Is(nil, lager.AzureTraceSource(h), "hierarchical Request-Id")
+	bg := context.Background()
+	plain := httptest.NewRequest("GET", "/", nil)
+	u.Is(bg, lager.AzureContextAddTrace(bg, plain), "no headers")
+	u.Is("Critical", lager.AzureLevelName("PANIC"), "panic level")
+	u.Is("Verbose", lager.AzureLevelName("GUTS"), "guts level")
+	u.Is("Information", lager.AzureLevelName("NOTE"), "note level")
+}
+
+// An error that combines several errors, like those from errors.Join().
+type joinedErr []error
+
+func (j joinedErr) Error() string   { return "several failures" }
+func (j joinedErr) Unwrap() []error { return j }
+
+// An error that combines several errors, like a hashicorp "multierror".
+type multiErr []error
+
+func (m multiErr) Error() string          { return "2 errors occurred" }
+func (m multiErr) WrappedErrors() []error { return m }
+
+func TestJoinedErrors(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+
+	_, err := os.Open("/no/such/file")
+	lager.Fail().MMap("sync failed", "error", joinedErr{
+		errors.New("oops"), nil,
+		multiErr{err, &net.AddrError{Err: "bad", Addr: "x"}},
+	})
+	validJson("joined", out.Bytes(), nil, u)
+	u.Like(out.String(), "components logged",
+		`*{"error":"several failures", "error_components":[`+
+			`{"type":"*errors.errorString", "error":"oops"}, `,
+		`*PathError", "error":"open /no/such/file: `,
+		`*{"type":"*net.AddrError", "error":"address x: bad"}]}`)
+	u.Like(out.String(), "nested message not logged", `!2 errors occurred`)
+	out.Reset()
+
+	a, b := errors.New("a failed"), errors.New("b failed")
+	lager.Fail().MMap("multi", "err", fmt.Errorf("both: %w; %w", a, b))
+	u.Like(out.String(), "multiple %w",
+		`*{"err":"both: a failed; b failed", "err_components":[`+
+			`{"type":"*errors.errorString", "error":"a failed"}, `+
+			`{"type":"*errors.errorString", "error":"b failed"}]}`)
+	out.Reset()
+
+	lager.Fail().List("in list", errors.Join(a, b))
+	validJson("list", out.Bytes(), nil, u)
+	u.Like(out.String(), "joined in list",
+		`*"in list", {"error":"a failed\nb failed", "components":[`)
+	out.Reset()
+
+	lager.Fail().MMap("all nil", "error", joinedErr{nil, nil},
+		"join", errors.Join(nil, nil))
+	u.Like(out.String(), "all nil",
+		`*{"error":"several failures", "join":null}`, `!components`, `!\[\]`)
+	out.Reset()
+
+	lager.Fail().MMap("one failed", "error", errors.New("oops"))
+	u.Like(out.String(), "plain error", `*"error":"oops"`)
+}
+
+func TestSiemFormats(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	audit := lager.NewModule("audit")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	restore := lager.UseCefFormat("Acme|Corp", "billing", "2.1", "")
+
+	ctx := lager.AddPairs(context.Background(),
+		"req", lager.Map("id", "5x1"))
+	audit.Warn(ctx).MMap("Refund denied", "user", "kim",
+		"note", "a=b\\c", "odd key", 1)
+	lager.Info().MMap("routine")
+	u.Like(out.String(), "cef",
+		`^CEF:0[|]Acme\\[|]Corp[|]billing[|]2[.]1[|]WARN[|]`+
+			`Refund denied[|]6[|]rt=[0-9]{13} deviceFacility=audit user=kim note=a\\=b\\\\c `+
+			`odd_key=1 req[.]id=5x1\n$`)
+	out.Reset()
+
+	restore()
+	restore = lager.UseLeefFormat("Acme", "billing", "2.1", "F")
+	lager.Warn().MMap("not audited")
+	lager.Fail().List("Login", "failed")
+	restore()
+	u.Like(out.String(), "leef",
+		"^LEEF:1[.]0[|]Acme[|]billing[|]2[.]1[|]FAIL[|]sev=8\t"+
+			"devTime=[A-Z][a-z]{2} [0-9]{2} [0-9]{4} [0-9:.]{12}\t"+
+			"devTimeFormat=MMM dd yyyy HH:mm:ss[.]SSS\tmsg=Login failed\n$")
+	out.Reset()
+
+	lager.Warn().List("json")
+	u.Like(out.String(), "restored", `^\[.*"WARN", "json"\]\n$`)
+	u.Is(10, lager.SiemSeverity("EXIT"), "exit severity")
+	u.Is(0, lager.SiemSeverity("GUTS"), "guts severity")
+}
+
+func TestSyslogFormat(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	audit := lager.NewModule("audit")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	restore := lager.UseSyslogFormat(16, "billing", "")
+
+	ctx := lager.AddPairs(context.Background(),
+		"req", lager.Map("id", "5x1"))
+	audit.Warn(ctx).MMap("Refund denied", "user", "kim",
+		"note", `a"b]c\`, "odd key", 1)
+	u.Like(out.String(), "syslog",
+		`^<132>1 [-0-9]+T[0-9:.]+Z [^ ]+ billing [0-9]+ audit `+
+			`\[lager@32473 user="kim" note="a\\"b\\]c\\\\" `+
+			`odd_key="1" req[.]id="5x1"\] Refund denied\n$`)
+	out.Reset()
+
+	restore()
+	restore = lager.UseSyslogFormat(-1, "", "app@1")
+	lager.Fail().List("Login", "failed")
+	lager.Note().List("Started")
+	restore()
+	lines := strings.Split(out.String(), "\n")
+	u.Like(lines[0], "no module",
+		`^<11>1 [-0-9]+T[0-9:.]+Z [^ ]+ [^ ]+ [0-9]+ - - Login failed$`)
+	u.Like(lines[1], "note", `^<13>1 `, `* - - Started`)
+	out.Reset()
+
+	lager.Warn().List("json")
+	u.Like(out.String(), "restored", `^\[.*"WARN", "json"\]\n$`)
+}
+
+func TestSeverityNumbers(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+
+	restore := lager.SetSeverityNumbers(lager.OtelSeverity, "")
+	lager.Warn().List("numeric")
+	u.Like(out.String(), "instead", `^\["[-0-9 :.]+Z", 13, "numeric"\]\n$`)
+	out.Reset()
+
+	lager.Keys("time", "level", "msg", "data", "", "module")
+	lager.SetSeverityNumbers(lager.SyslogSeverity, "severity")
+	lager.Fail().MMap("both")
+	lager.Keys("", "", "", "", "", "")
+	u.Like(out.String(), "added",
+		`^{"time":"[-0-9T:.]+Z", "level":"FAIL", "severity":3, "msg":"both"}`)
+	out.Reset()
+
+	lager.SetSeverityNumbers(lager.SyslogSeverity, "")
+	undo := lager.UseConsoleFormat(false)
+	lager.Warn().List("console")
+	undo()
+	u.Like(out.String(), "console", `^[0-9:.]+ WARN +([|] )?console\n$`)
+	out.Reset()
+
+	restore()
+	lager.Warn().List("text")
+	u.Like(out.String(), "restored", `^\[.*"WARN", "text"\]\n$`)
+	u.Is(23, lager.OtelSeverity("PANIC"), "otel panic")
+	u.Is(1, lager.OtelSeverity("TRACE"), "otel trace")
+	u.Is(7, lager.SyslogSeverity("GUTS"), "syslog guts")
+	u.Is(6, lager.SyslogSeverity("ACCESS"), "syslog access")
+}
+
+func TestFlatJSON(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	db := lager.NewModule("db")
+	out := new(bytes.Buffer)
+	defer lager.SetOutput(out)()
+	restore := lager.UseFlatJSON(nil)
+
+	ctx := lager.AddPairs(context.Background(), "trace", "5x1", "ms", 1)
+	db.Warn(ctx).List("Slow query", 7, lager.Map("table", "users",
+		"ms", 2219, "req", lager.Map("id", 3)))
+	validJson("flat list", out.Bytes(), nil, u)
+	u.Like(out.String(), "flat from list",
+		`^{"time":"[0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9:.]+Z", "level":"WARN", `+
+			`"msg":"Slow query", "data":\[7\], "module":"db", `+
+			`"table":"users", "ms":1, "req":{"id":3}, "trace":"5x1"}\n$`)
+	out.Reset()
+
+	lager.Keys("when", "lev", "message", "args", "ctx", "mod")
+	lager.UseFlatJSON(map[string]string{
+		"time": "@t", "msg": "message", "level": "", "_line": "",
+	})
+	lager.Fail(ctx).WithCaller(0).MMap("Oops", "err", "bad")
+	lager.Keys("", "", "", "", "", "")
+	validJson("flat map", out.Bytes(), nil, u)
+	u.Like(out.String(), "flat from map",
+		`^{"@t":"[-0-9]+T[0-9:.]+Z", "message":"Oops", `, `"err":"bad"`,
+		`"_file":"`, `"_func":"TestFlatJSON"`, `"trace":"5x1", "ms":1`)
+	u.Like(out.String(), "omitted", `!"lev`, `!"_line"`, `!"ctx"`)
+	out.Reset()
+
+	restore()
+	lager.Warn().List("json")
+	u.Like(out.String(), "restored", `^\[.*"WARN", "json"\]\n$`)
+}
+
+func TestFormatOutput(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	db := lager.NewModule("db")
+	jsonOut, conOut, mapOut := new(bytes.Buffer), new(bytes.Buffer),
+		new(bytes.Buffer)
+	con, err := lager.FormatOutput(conOut, "console")
+	u.Is(nil, err, "console format")
+	gcp, err := lager.FormatOutput(mapOut, "gcp")
+	u.Is(nil, err, "gcp format")
+	_, err = lager.FormatOutput(conOut, "xml")
+	u.Like(err, "bad format", `*lager.FormatOutput() format must be one of`,
+		`"xml"`)
+	defer lager.SetOutput(lager.TeeOutput(jsonOut, con, gcp))()
+
+	ctx := lager.AddPairs(context.Background(), "trace", "5x1")
+	db.Warn(ctx).MMap("Slow query", "ms", 2219)
+	u.Like(jsonOut.String(), "json",
+		`^\["[-0-9]+ [0-9:.]+Z", "WARN", "Slow query", {"ms":2219}, `+
+			`{"trace":"5x1"}, "mod=db"\]\n$`)
+	u.Like(conOut.String(), "console",
+		`^[0-9:.]+ WARN +db +[|] Slow query ms=2219 trace=5x1\n$`)
+	validJson("gcp", mapOut.Bytes(), nil, u)
+	u.Like(mapOut.String(), "gcp",
+		`^{"time":"[-0-9]+T[0-9:.]+Z", "severity":"400", `+
+			`"message":"Slow query", "ms":2219, "trace":"5x1", `+
+			`"module":"db"}\n$`)
+	jsonOut.Reset()
+	conOut.Reset()
+
+	lager.Keys("when", "lev", "msg", "args", "ctx", "mod")
+	defer lager.Keys("", "", "", "", "", "")
+	list, _ := lager.FormatOutput(conOut, "list")
+	defer lager.SetOutput(lager.TeeOutput(jsonOut, list))()
+	db.Warn(ctx).List("Slow", 7)
+	u.Like(jsonOut.String(), "map",
+		`^{"when":"[-0-9]+T[0-9:.]+Z", "lev":"WARN", "args":\["Slow", 7\], `+
+			`"ctx":{"trace":"5x1"}, "mod":"db"}\n$`)
+	u.Like(conOut.String(), "list",
+		`^\["[-0-9]+ [0-9:.]+Z", "WARN", "Slow", 7, {"trace":"5x1"}, `+
+			`"mod=db"\]\n$`)
+	conOut.Reset()
+
+	lager.Keys("time", "level", "msg", "data", "", "module")
+	db.Warn(ctx).MMap("Slow query", "ms", 2219)
+	lager.SetOutput(list) // Not composed as JSON.
+	db.Warn(ctx).MMap("Slow query", "ms", 2219)
+	restore := lager.SetDedupWindow(time.Minute)
+	for i := 0; i < 3; i++ {
+		db.Warn(ctx).MMap("Slow query", "ms", 2219)
+	}
+	u.Is(nil, lager.Flush(nil), "Flush repeat")
+	restore()
+	lines := strings.Split(conOut.String(), "\n")
+	if u.Is(5, len(lines), "list lines") {
+		u.Like(lines[0], "context pairs kept apart",
+			`^\["[-0-9]+ [0-9:.]+Z", "WARN", "Slow query", {"ms":2219}, `+
+				`{"trace":"5x1"}, "mod=db"\]$`)
+		u.Is(lines[0][26:], lines[1][26:], "without JSON")
+		u.Like(lines[3], "repeat", `*"repeat_count":2`)
+	}
+}
@@ -0,0 +1,97 @@
+package lager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// The JSON document used by LevelHandler().
+type levelDoc struct {
+	Levels  string            `json:"levels,omitempty"`
+	Modules map[string]string `json:"modules,omitempty"`
+}
+
+// LevelHandler() returns an http.Handler that lets operators view and
+// change the enabled log levels of a running service, such as via an
+// internal admin port:
+//
+//      http.Handle("/admin/log-levels", lager.LevelHandler())
+//
+// A GET request returns a JSON document with the globally enabled levels
+// and the levels of each Module:
+//
+//      {"levels":"FWNA", "modules":{"db":"FW", "grpc":"FWNAI"}}
+//
+// A PUT (or POST) request takes a JSON document of the same form, where
+// each part is optional, and changes the given levels [as if by Init() and
+// SetModuleLevels()].  Use "-" to disable all optional levels.  The updated
+// levels are returned.  If any module named does not exist, a 400 error is
+// returned and nothing is changed.  Each change is logged as a Note line.
+//
+// Anyone who can reach this handler can make a service log a lot more, so
+// do not expose it publicly.
+//
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET", "HEAD":
+		case "PUT", "POST":
+			if err := putLevels(r.Body); nil != err {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT, POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		doc := levelDoc{Levels: GetLevels(), Modules: GetModules()}
+		for name, levels := range doc.Modules {
+			doc.Modules[name] = plainLevels(levels)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		body, _ := json.Marshal(doc)
+		w.Write(append(body, '\n'))
+	})
+}
+
+// Applies the changes requested in a LevelHandler() PUT request.
+func putLevels(body io.Reader) error {
+	var doc levelDoc
+	dec := json.NewDecoder(io.LimitReader(body, 64*1024))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); nil != err {
+		return fmt.Errorf("invalid log levels document: %v", err)
+	}
+	names := make([]string, 0, len(doc.Modules))
+	for name := range doc.Modules {
+		if nil == getMod(name) {
+			return fmt.Errorf("no such log module (%s)", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if "" != doc.Levels {
+		prior := GetLevels()
+		Init(doc.Levels)
+		Note().MMap("Changed log levels via HTTP",
+			"from", prior, "to", GetLevels())
+	}
+	for _, name := range names {
+		prior := plainLevels(GetModuleLevels(name))
+		SetModuleLevels(name, doc.Modules[name])
+		Note().MMap("Changed module log levels via HTTP", "module", name,
+			"from", prior, "to", plainLevels(GetModuleLevels(name)))
+	}
+	return nil
+}
+
+// Converts a Module's levels, like `'F''W'`, to letters, like "FW".
+func plainLevels(levels string) string {
+	return strings.Replace(levels, "'", "", -1)
+}
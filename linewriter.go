@@ -0,0 +1,82 @@
+package lager
+
+import (
+	"bytes"
+	"io"
+)
+
+// A LineWriter composes one line of JSON using the same fast encoder that
+// Lager uses for log lines [see NewLineWriter()].
+type LineWriter struct {
+	w     io.Writer
+	out   bytes.Buffer
+	b     *buffer
+	inMap bool // The last thing added was a Pair().
+}
+
+// NewLineWriter() returns a LineWriter that composes a single line of JSON
+// (a list, like Lager's default log format) and writes it to 'w' when
+// Finish() is called.  This lets other packages (like a metrics emitter)
+// reuse Lager's JSON encoding without going through log levels:
+//
+//      err := lager.NewLineWriter(os.Stdout).Scalar("requests").
+//          Pair("count", n).Pair("route", route).Finish()
+//
+// writes a line like:
+//
+//      ["requests", {"count":12, "route":"/"}]
+//
+// Scalar() adds a value (of the same types that can be logged) to the
+// list.  Pair() adds a key/value pair to a map at the end of the list (one
+// is started if the prior call was not also to Pair()).  The line is
+// written to 'w' using a single Write() call and never interleaves with
+// Lager log lines written to the same io.Writer.  A LineWriter must not be
+// used after Finish() is called nor used from multiple goroutines at once.
+//
+func NewLineWriter(w io.Writer) *LineWriter {
+	lw := &LineWriter{w: w, b: bufPool.Get().(*buffer)}
+	lw.b.g = getGlobals()
+	lw.b.w = &lw.out
+	lw.b.private = true
+	lw.b.open("[") // ]
+	return lw
+}
+
+// Scalar() adds a value to the line.
+func (lw *LineWriter) Scalar(val interface{}) *LineWriter {
+	if lw.inMap { // {
+		lw.b.close("}")
+		lw.inMap = false
+	}
+	lw.b.scalar(val)
+	return lw
+}
+
+// Pair() adds a key/value pair to the map at the end of the line.
+func (lw *LineWriter) Pair(key string, val interface{}) *LineWriter {
+	if !lw.inMap {
+		lw.b.open("{") // }
+		lw.inMap = true
+	}
+	lw.b.pair(key, val)
+	return lw
+}
+
+// Finish() completes the line and writes it.  It returns any error from
+// writing it.
+func (lw *LineWriter) Finish() error {
+	b := lw.b
+	if lw.inMap { // {
+		b.close("}")
+	} // [
+	b.close("]\n")
+	b.unlock()
+	b.w, b.g, b.private = nil, nil, false
+	bufPool.Put(b)
+	lw.b = nil
+
+	defer outMu.RUnlock()
+	outMu.RLock()
+	_, err := lw.w.Write(lw.out.Bytes())
+	return err
+}
@@ -0,0 +1,122 @@
+package lager
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// UseLogfmtFormat() causes log lines to be written in logfmt format (a
+// single line of key=value pairs) rather than as JSON, for log collectors
+// that prefer it:
+//
+//      time=2021-06-09T13:10:07.0447Z level=WARN mod=db msg="Slow query"
+//      table=users ms=2219 req.id=5x1 req.path=/login
+//
+// (but all on one line).  The message (and any values logged without keys)
+// goes in "msg", the module (if any) in "mod", and pairs from nested maps
+// are flattened into dotted keys (like "req.id").  Lists are written as
+// quoted JSON.  It returns a function that restores the prior setting:
+//
+//      defer lager.UseLogfmtFormat()()
+//
+// Setting LAGER_LOGFMT to a non-empty value in the environment has the same
+// effect as calling UseLogfmtFormat() when the program starts.  The
+// "logfmt" format can also be selected via FlagSet().
+//
+func UseLogfmtFormat() func() {
+	var prior *lineFormat
+	updateGlobals(func(g *globals) {
+		prior = g.format
+		g.format = &lineFormat{logfmt: true}
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.format = prior
+		})
+	}
+}
+
+// Reads LAGER_LOGFMT.
+func envLogfmt(g *globals) {
+	if "" != os.Getenv("LAGER_LOGFMT") {
+		g.format = &lineFormat{logfmt: true}
+	}
+}
+
+// Formats a decoded log line in logfmt format.
+func (fe *formatEncoder) logfmt(tl textLine) []byte {
+	out := make([]byte, 0, 256)
+	out = append(out, "time="...)
+	out = append(out, strings.Replace(tl.when, " ", "T", 1)...)
+	out = append(out, " level="...)
+	out = append(out, logfmtValue(tl.lev)...)
+	if "" != fe.mod {
+		out = append(out, " mod="...)
+		out = append(out, logfmtValue(fe.mod)...)
+	}
+	if 0 < len(tl.rest) {
+		msg := make([]string, len(tl.rest))
+		for i, elt := range tl.rest {
+			if s, ok := elt.(string); ok {
+				msg[i] = s
+			} else {
+				msg[i] = string(encodeJSON(elt))
+			}
+		}
+		out = append(out, " msg="...)
+		out = append(out, logfmtValue(strings.Join(msg, " "))...)
+	}
+	for _, kvp := range tl.pairs {
+		out = appendLogfmtPairs(out, "", kvp)
+	}
+	return append(out, '\n')
+}
+
+// Appends the pairs from 'kvp', flattening nested maps into dotted keys.
+func appendLogfmtPairs(out []byte, prefix string, kvp *KVPairs) []byte {
+	for i, k := range kvp.keys {
+		if sub, ok := kvp.vals[i].(*KVPairs); ok {
+			out = appendLogfmtPairs(out, prefix+k+".", sub)
+			continue
+		}
+		out = append(out, ' ')
+		out = append(out, logfmtKey(prefix+k)...)
+		out = append(out, '=')
+		out = append(out, logfmtValue(kvp.vals[i])...)
+	}
+	return out
+}
+
+// Returns 'key' with any characters not allowed in logfmt keys replaced.
+func logfmtKey(key string) string {
+	if "" == key {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || '=' == r || '"' == r || 0x7F == r {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// Formats a value for logfmt, quoting it if needed.
+func logfmtValue(v interface{}) string {
+	var s string
+	switch x := v.(type) {
+	case string:
+		s = x
+	case nil:
+		return "null"
+	case *KVPairs, AList:
+		s = string(encodeJSON(x))
+	default:
+		return consoleValue(v)
+	}
+	if "" == s || strings.ContainsAny(s, " =\"\\") ||
+		strconv.Quote(s) != `"`+s+`"` {
+		return strconv.Quote(s)
+	}
+	return s
+}
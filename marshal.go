@@ -20,11 +20,22 @@ import (
 // An unshared, temporary structure for efficiently logging one line.
 type buffer struct {
 	scratch [16 * 1024]byte // Space so we can allocate memory only rarely.
-	buf     []byte          // Bytes not yet written (a slice into above).
+	json    encSink         // The line as JSON; not yet written bytes in 'out'.
+	encs    []*encSink      // &json then one per output using another format.
 	w       io.Writer       // Usually os.Stdout, else os.Stderr.
-	delim   string          // Delimiter to go before next value.
 	locked  bool            // Whether we had to lock outMu.
+	private bool            // Writing to memory so outMu is not needed.
+	failed  error           // Set if writing (part of) the line failed.
+	now     time.Time       // The timestamp of the line.
+	msg     string          // The message of the line (if any).
+	size    int             // How many bytes of the line were output.
+	valKey  string          // Key of the pair whose value is next (if any).
+	drop    *droppedLine    // Set if the line can be dropped when behind.
 	g       *globals
+
+	// encodedWriters that can be reused [see encodedWriter()]:
+	encFree []*encodedWriter
+	encUsed int // How many of 'encFree' are in use.
 }
 
 // A Stringer just has a String() method that returns its stringification.
@@ -32,12 +43,28 @@ type Stringer interface {
 	String() string
 }
 
+// A Valuer is a value that is expensive to compute, so its LagerValue()
+// method is only called (to get the value to be logged) when the log line
+// is actually being written.  It is like passing a 'func() interface{}'
+// but lets a type define how it should be logged:
+//
+//      type dump struct{ cache *Cache }
+//      func (d dump) LagerValue() interface{} { return d.cache.Snapshot() }
+//
+//      lager.Debug().MMap("Cache state", "cache", dump{cache})
+//
+// So no snapshot is taken unless Debug log lines are enabled.
+//
+type Valuer interface {
+	LagerValue() interface{}
+}
+
 /// GLOBALS ///
 
 // Minimize how many of these must be allocated:
 var bufPool = sync.Pool{New: func() interface{} {
 	b := new(buffer)
-	b.buf = b.scratch[0:0]
+	b.resetEncs()
 	return b
 }}
 
@@ -47,6 +74,9 @@ var outMu sync.RWMutex
 // The (JSON) delimiter between values:
 const comma = ", "
 
+// The built-in Encoder that writes log lines as JSON.
+type jsonEncoder struct{}
+
 /// FUNCS ///
 
 var noEsc [256]bool
@@ -63,96 +93,140 @@ func init() {
 // Called when we need to flush early, to prevent interleaved log lines.
 func (b *buffer) lock() {
 	if !b.locked {
-		outMu.Lock()
+		if !b.private {
+			outMu.Lock()
+		}
 		b.locked = true
 	}
-	if 0 < len(b.buf) {
-		b.w.Write(b.buf)
-		b.buf = b.scratch[0:0]
+	if 0 < len(b.json.out) {
+		b.output(b.json.out)
+		b.json.out = b.scratch[0:0]
 	}
 }
 
 // Called when finished composing a log line.
 func (b *buffer) unlock() {
-	if !b.locked {
+	if !b.locked && !b.private {
 		outMu.RLock()
 		defer outMu.RUnlock()
 	}
-	if 0 < len(b.buf) {
-		b.w.Write(b.buf)
-		b.buf = b.scratch[0:0]
+	if 0 < len(b.json.out) {
+		b.output(b.json.out)
+		b.json.out = b.scratch[0:0]
 	}
 	if b.locked {
 		b.locked = false
-		outMu.Unlock()
+		if !b.private {
+			outMu.Unlock()
+		}
 	}
 }
 
-// Append a slice of bytes to the log line.
-func (b *buffer) writeBytes(s []byte) {
-	if cap(b.buf) < len(b.buf)+len(s) {
+// Writes out the part of the log line composed so far once the JSON no
+// longer fits in 'scratch' (leaving room for a number or similar), so a
+// line can be larger than 'scratch'.
+func (b *buffer) room() {
+	if len(b.scratch)-64 < len(b.json.out) {
 		b.lock() // Can't fit line in buffer; lock output mutex and flush.
 	}
-	if cap(b.buf) < len(s) {
-		b.w.Write(s) // Next chunk won't fit in buffer, just write it.
-	} else {
-		b.buf = append(b.buf, s...)
+}
+
+func (jsonEncoder) OpenList(out []byte) []byte { return append(out, '[') }
+func (jsonEncoder) OpenMap(out []byte) []byte  { return append(out, '{') }
+func (jsonEncoder) EndLine(out []byte) []byte  { return append(out, '\n') }
+
+func (jsonEncoder) CloseList(out []byte, _, _ int) []byte {
+	return append(out, ']')
+}
+
+func (jsonEncoder) CloseMap(out []byte, _, _ int) []byte {
+	return append(out, '}')
+}
+
+func (jsonEncoder) Next(out []byte, i int) []byte {
+	if 0 == i {
+		return out
 	}
+	return append(out, comma...)
 }
 
-// Append strings to the log line.
-func (b *buffer) write(strs ...string) {
-	for _, s := range strs {
-		if cap(b.buf) < len(b.buf)+len(s) {
-			b.lock()
-		}
-		if cap(b.buf) < len(s) {
-			io.WriteString(b.w, s)
-		} else {
-			b.buf = append(b.buf, s...)
-		}
+func (jsonEncoder) Key(out []byte, key string) []byte {
+	return append(appendEscaped(append(out, '"'), key), `":`...)
+}
+
+func (jsonEncoder) Scalar(out []byte, v interface{}) []byte {
+	switch x := v.(type) {
+	case nil:
+		return append(out, "null"...)
+	case bool:
+		return strconv.AppendBool(out, x)
+	case int64:
+		return strconv.AppendInt(out, x, 10)
+	case uint64:
+		return strconv.AppendUint(out, x, 10)
+	case float64:
+		return jsonEncoder{}.scalarFloat(out, x, 64)
 	}
+	return append(appendEscaped(append(out, '"'), S(v)), '"')
 }
 
-func (b *buffer) escape1Rune(r rune) {
-	b.write("\\uXXXX")
-	end := len(b.buf) - 5
+func (jsonEncoder) strHead(out []byte, _ int) []byte { return append(out, '"') }
+func (jsonEncoder) strTail(out []byte) []byte        { return append(out, '"') }
+
+func (jsonEncoder) strBody(out []byte, s string) []byte {
+	return appendEscaped(out, s)
+}
+
+func (jsonEncoder) scalarInt(out []byte, v int64) []byte {
+	return strconv.AppendInt(out, v, 10)
+}
+
+// Appends a float, quoting it if it is not a valid JSON number (Inf or
+// NaN).
+func (jsonEncoder) scalarFloat(out []byte, v float64, bits int) []byte {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		out = strconv.AppendFloat(append(out, '"'), v, 'g', -1, bits)
+		return append(out, '"')
+	}
+	return strconv.AppendFloat(out, v, 'g', -1, bits)
+}
+
+// Appends one escaped rune as part of a quoted JSON string.
+func appendEscapedRune(out []byte, r rune) []byte {
+	out = append(out, "\\uXXXX"...)
+	end := len(out) - 5
 	switch r {
 	case '"':
-		b.buf[end] = '"'
+		out[end] = '"'
 	case '\\':
-		b.buf[end] = '\\'
+		out[end] = '\\'
 	case '\b':
-		b.buf[end] = 'b'
+		out[end] = 'b'
 	case '\f':
-		b.buf[end] = 'f'
+		out[end] = 'f'
 	case '\n':
-		b.buf[end] = 'n'
+		out[end] = 'n'
 	case '\r':
-		b.buf[end] = 'r'
+		out[end] = 'r'
 	case '\t':
-		b.buf[end] = 't'
+		out[end] = 't'
 	default:
 		for o := 1; o <= 4; o++ {
-			b.buf[len(b.buf)-o] = hexDigits[r&0xF]
+			out[len(out)-o] = hexDigits[r&0xF]
 			r >>= 4
 		}
-		return
+		return out
 	}
-	b.buf = b.buf[:end+1]
+	return out[:end+1]
 }
 
-func (b *buffer) writeByteHex(c byte) {
-	b.write("  ")
-	b.buf[len(b.buf)-2] = hexDigits[c>>4]
-	b.buf[len(b.buf)-1] = hexDigits[c&0xF]
-}
-
-func (b *buffer) nonUtf8Chars(s string) int {
-	b.write("«x")
+// Appends the hex digits of a run of bytes from the start of 's' that are
+// not valid UTF-8 and returns the result and how many bytes were used.
+func appendNonUtf8(out []byte, s string) ([]byte, int) {
+	out = append(out, "«x"...)
 	i := 0
 	for {
-		b.writeByteHex(s[i])
+		out = append(out, hexDigits[s[i]>>4], hexDigits[s[i]&0xF])
 		i++
 		if i == len(s) {
 			break
@@ -162,198 +236,168 @@ func (b *buffer) nonUtf8Chars(s string) int {
 			break
 		}
 	}
-	b.write("»")
-	return i
-}
-
-func (b *buffer) nonUtf8Bytes(s []byte) int {
-	b.write("«x")
-	i := 0
-	for {
-		b.writeByteHex(s[i])
-		i++
-		if i == len(s) {
-			break
-		}
-		r, rl := utf8.DecodeRune(s[i:])
-		if !(r == utf8.RuneError && 1 == rl || 0x110000 <= r) {
-			break
-		}
-	}
-	b.write("»")
-	return i
+	return append(out, "»"...), i
 }
 
-// Append a quoted (JSON) string to the log line.  If more than one string
-// is passed in, then they are concatenated together.
-func (b *buffer) quote(strs ...string) {
-	b.write(b.delim, `"`)
-	for _, s := range strs {
-		b.escape(s)
-	}
-	b.write(`"`)
-	b.delim = comma
-}
-
-// Append a quoted (JSON) string (from a byte slice) to the log line.
-func (b *buffer) quoteBytes(s []byte) {
-	b.write(b.delim, `"`)
-	b.escapeBytes(s)
-	b.write(`"`)
-}
-
-// Append an escaped string as part of a quoted JSON string.
-func (b *buffer) escape(s string) {
+// Appends an escaped string as part of a quoted JSON string.
+func appendEscaped(out []byte, s string) []byte {
 	beg := 0
 	for i := 0; i < len(s); i++ {
 		c := s[i]
 		if noEsc[c] {
 			continue
 		}
-		b.write(s[beg:i])
+		out = append(out, s[beg:i]...)
 		if c < 128 {
-			b.escape1Rune(rune(c))
+			out = appendEscapedRune(out, rune(c))
 			beg = i + 1
 		} else if r, rl := utf8.DecodeRuneInString(
 			s[i:],
 		); r == utf8.RuneError && 1 == rl || 0x110000 <= r {
-			beg = i + b.nonUtf8Chars(s[i:])
+			var n int
+			out, n = appendNonUtf8(out, s[i:])
+			beg = i + n
 			i = beg - 1
 		} else {
 			beg = i + rl
 			if 0xFFFF < r {
 				surr1, surr2 := utf16.EncodeRune(r)
-				b.escape1Rune(surr1)
-				b.escape1Rune(surr2)
+				out = appendEscapedRune(out, surr1)
+				out = appendEscapedRune(out, surr2)
 			} else if r < 0xA0 {
-				b.escape1Rune(r)
+				out = appendEscapedRune(out, r)
 			} else {
-				b.write(s[i:beg])
+				out = append(out, s[i:beg]...)
 			}
 			i = beg - 1
 		}
 	}
-	b.write(s[beg:])
+	return append(out, s[beg:]...)
 }
 
-// Append an escaped string (from a byte slice), part of a quoted JSON string.
-func (b *buffer) escapeBytes(s []byte) {
-	beg := 0
-	for i := 0; i < len(s); i++ {
-		c := s[i]
-		if noEsc[c] {
-			continue
-		}
-		b.writeBytes(s[beg:i])
-		if c < 128 {
-			b.escape1Rune(rune(c))
-			beg = i + 1
-		} else if r, rl := utf8.DecodeRune(
-			s[i:],
-		); r == utf8.RuneError && 1 == rl || 0x110000 <= r {
-			beg = i + b.nonUtf8Bytes(s[i:])
-			i = beg - 1
-		} else {
-			beg = i + rl
-			if 0xFFFF < r {
-				surr1, surr2 := utf16.EncodeRune(r)
-				b.escape1Rune(surr1)
-				b.escape1Rune(surr2)
-			} else if r < 0xA0 {
-				b.escape1Rune(r)
-			} else {
-				b.writeBytes(s[i:beg])
-			}
-			i = beg - 1
-		}
+// Append a 2-digit value (with leading '0').
+func appendInt2(out []byte, val int) []byte {
+	return append(out, '0'+byte(val/10), '0'+byte(val%10))
+}
+
+// Append a decimal value of specified length with leading '0's.
+func appendDigits(out []byte, val int, digits int) []byte {
+	var num [20]byte
+	n := strconv.AppendInt(num[:0], int64(val), 10)
+	for l := len(n); l < digits; l++ {
+		out = append(out, '0')
 	}
-	b.writeBytes(s[beg:])
+	return append(out, n...)
 }
 
-// Append a 2-digit value to the buffer (with leading '0').
-func (b *buffer) int2(val int) {
-	// Not needed so long as calls to int2() remain protected:
-	//  if cap(b.buf) < len(b.buf) + 2 {
-	//      b.lock()
-	//  }
-	l := len(b.buf)
-	b.buf = b.buf[0 : 2+l]
-	b.buf[l] = '0' + byte(val/10)
-	b.buf[l+1] = '0' + byte(val%10)
+// Append a UTC timestamp (without quotes) with 'sep' between the date and
+// the time.
+func appendTimestamp(out []byte, now time.Time, sep byte) []byte {
+	yr, mo, day := now.Date()
+	out = append(strconv.AppendInt(out, int64(yr), 10), '-')
+	out = append(appendInt2(out, int(mo)), '-')
+	out = append(appendInt2(out, day), sep)
+	out = append(appendInt2(out, now.Hour()), ':')
+	out = append(appendInt2(out, now.Minute()), ':')
+	out = append(appendInt2(out, now.Second()), '.')
+	out = appendDigits(out, now.Nanosecond()/100000, 4)
+	return append(out, 'Z')
 }
 
-// Append a decimal value of specified length with leading '0's.
-func (b *buffer) int(val int, digits int) {
-	// Not needed so long as calls to int() remain protected:
-	//  if cap(b.buf) < len(b.buf) + digits {
-	//      b.lock()
-	//  }
-	bef := len(b.buf)
-	b.buf = strconv.AppendInt(b.buf, int64(val), 10)
-	aft := len(b.buf)
-	l := aft - bef
-	// Prepend leading '0's to get desired length:
-	if l < digits {
-		b.buf = b.buf[0 : bef+digits]
-		copy(b.buf[bef+digits-l:bef+digits], b.buf[bef:aft])
-		for i := bef; i < bef+digits-l; i++ {
-			b.buf[i] = '0'
-		}
+// Sets the (UTC) timestamp of the log line.
+func (b *buffer) setNow() {
+	if nil != b.g.clockGuard {
+		b.now = b.g.clockGuard.now().In(time.UTC)
+	} else {
+		b.now = time.Now().In(time.UTC)
 	}
 }
 
 // Append a quoted UTC timestamp to the log line.
 func (b *buffer) timestamp() {
-	// Never needed since timestamp is always first:
-	//  if cap(b.buf) < len(b.buf)+22 {
-	//      b.lock()
-	//  }
-	now := time.Now().In(time.UTC)
-	b.write(`"`)
-	yr, mo, day := now.Date()
-	b.buf = strconv.AppendInt(b.buf, int64(yr), 10)
-	b.write("-")
-	b.int2(int(mo))
-	b.write("-")
-	b.int2(day)
-	if nil == b.g.keys {
-		b.write(" ") // Use easier-for-humans-to-read format
-	} else {
-		b.write("T") // Use standard format (GCP cares)
+	b.setNow()
+	if b.g.epochOnly {
+		b.epoch()
+		return
 	}
-	b.int2(now.Hour())
-	b.write(":")
-	b.int2(now.Minute())
-	b.write(":")
-	b.int2(now.Second())
-	b.write(".")
-	b.int(now.Nanosecond()/100000, 4)
-	b.write(`Z"`)
-	b.delim = comma
+	sep := byte(' ') // Use easier-for-humans-to-read format
+	if nil != b.g.keys {
+		sep = 'T' // Use standard format (GCP cares)
+	}
+	var stamp [40]byte
+	b.text(appendTimestamp(stamp[:0], b.now, sep))
 }
 
-// Begin appending a nested data structure to the log line.
+// Begin appending a nested data structure ("[" or "{") to the log line.
 func (b *buffer) open(punct string) {
-	b.write(b.delim, punct)
-	b.delim = ""
+	b.room()
+	for _, s := range b.encs {
+		s.open("{" == punct)
+	}
 }
 
-// Append the key/value separator ":" to the log line.
-func (b *buffer) colon() {
-	b.write(":")
-	b.delim = ""
+// End appending a nested data structure to the log line.  A 'punct' ending
+// in a newline also ends the log line.
+func (b *buffer) close(punct string) {
+	b.room()
+	end := '\n' == punct[len(punct)-1]
+	for _, s := range b.encs {
+		s.close()
+		if end {
+			s.endLine()
+		}
+	}
 }
 
-// End appending a nested data structure to the log line.
-func (b *buffer) close(punct string) {
-	b.write(punct)
-	b.delim = comma
+// Append a key (and the ":" after it) to the log line.
+func (b *buffer) key(k string) {
+	b.room()
+	k = validUtf8(k)
+	for _, s := range b.encs {
+		s.key(k)
+	}
+}
+
+// Append a quoted string to the log line.  If more than one string is
+// passed in, then they are concatenated together.  Bytes that are not
+// valid UTF-8 are replaced by their hex digits [see validUtf8()].
+func (b *buffer) quote(strs ...string) {
+	b.room()
+	for i, s := range strs {
+		strs[i] = validUtf8(s)
+	}
+	for _, s := range b.encs {
+		s.str(strs)
+	}
+}
+
+// Append a quoted string that never needs escaping (like a timestamp).
+func (b *buffer) text(t []byte) {
+	b.room()
+	for _, s := range b.encs {
+		s.text(t)
+	}
+}
+
+// Tells the encodings of the log line where its context pairs begin or
+// end [see contextEncoder].
+func (b *buffer) context(begin bool) {
+	for _, s := range b.encs {
+		if c, ok := s.enc.(contextEncoder); ok {
+			c.context(begin)
+		}
+	}
+}
+
+// Append the key of a key/value pair (and the ":" after it).
+func (b *buffer) pairKey(k string) {
+	b.key(k)
+	b.valKey = k
 }
 
 // Append a single key/value pair:
 func (b *buffer) pair(k string, v interface{}) {
-	b.quote(k)
-	b.colon()
+	b.pairKey(k)
 	b.scalar(v)
 }
 
@@ -370,17 +414,25 @@ func (b *buffer) pairs(m AMap) {
 func (b *buffer) rawPairs(m RawMap) {
 	skipping := false
 	inlining := false
-	for i, elt := range m {
-		if 0 == 1&i {
+	wantKey := true
+	for _, elt := range m {
+		if wantKey {
+			if f, ok := elt.(Field); ok {
+				b.field(f) // A whole pair.
+				continue
+			}
+			wantKey = false
 			if _, ok := elt.(skipThisPair); ok {
 				skipping = true
 			} else if _, ok := elt.(inlinePairs); ok {
 				inlining = true
 			} else {
-				b.quote(S(elt))
-				b.colon()
+				b.pairKey(S(elt))
 			}
-		} else if skipping {
+			continue
+		}
+		wantKey = true
+		if skipping {
 			skipping = false
 		} else if inlining {
 			switch m := elt.(type) {
@@ -398,7 +450,7 @@ func (b *buffer) rawPairs(m RawMap) {
 			b.scalar(elt)
 		}
 	}
-	if 1 == 1&len(m) && !skipping {
+	if !wantKey && !skipping {
 		b.scalar(nil)
 	}
 }
@@ -435,67 +487,49 @@ func (b *buffer) msgList(msg string, args []interface{}) {
 	b.close("]")
 }
 
-// Append a JSON-encoded scalar value to the log line.
+// Append a scalar value (or a list or map of them) to the log line.
 func (b *buffer) scalar(s interface{}) {
-	if f, ok := s.(func() interface{}); ok {
+	key := b.valKey
+	b.valKey = ""
+	switch f := s.(type) {
+	case func() interface{}:
 		s = b.timeBoxedCall(f)
-	}
-	b.write(b.delim)
-	b.delim = ""
-	if cap(b.buf) < len(b.buf)+64 {
-		b.lock() // Leave room for strconv.AppendFloat() or similar
+	case Valuer:
+		s = b.timeBoxedCall(f.LagerValue)
 	}
 	switch v := s.(type) {
 	case nil:
-		b.write("null")
+		b.value(nil)
 	case string:
 		b.quote(v)
 	case []byte:
-		b.quoteBytes(v)
+		b.quote(string(v))
 	case int:
-		b.buf = strconv.AppendInt(b.buf, int64(v), 10)
+		b.int64(int64(v))
 	case int8:
-		b.buf = strconv.AppendInt(b.buf, int64(v), 10)
+		b.int64(int64(v))
 	case int16:
-		b.buf = strconv.AppendInt(b.buf, int64(v), 10)
+		b.int64(int64(v))
 	case int32:
-		b.buf = strconv.AppendInt(b.buf, int64(v), 10)
+		b.int64(int64(v))
 	case int64:
-		b.buf = strconv.AppendInt(b.buf, v, 10)
+		b.int64(v)
 	case uint:
-		b.buf = strconv.AppendUint(b.buf, uint64(v), 10)
+		b.uint64(uint64(v))
 	case uint8:
-		b.buf = strconv.AppendUint(b.buf, uint64(v), 10)
+		b.uint64(uint64(v))
 	case uint16:
-		b.buf = strconv.AppendUint(b.buf, uint64(v), 10)
+		b.uint64(uint64(v))
 	case uint32:
-		b.buf = strconv.AppendUint(b.buf, uint64(v), 10)
+		b.uint64(uint64(v))
 	case uint64:
-		b.buf = strconv.AppendUint(b.buf, v, 10)
+		b.uint64(v)
 	case float32:
-		needsQuotes := math.IsInf(float64(v), 0) || math.IsNaN(float64(v))
-		if needsQuotes {
-			b.buf = append(b.buf, '"')
-		}
-		b.buf = strconv.AppendFloat(b.buf, float64(v), 'g', -1, 32)
-		if needsQuotes {
-			b.buf = append(b.buf, '"')
-		}
+		b.float(float64(v), 32)
 	case float64:
-		needsQuotes := math.IsInf(v, 0) || math.IsNaN(v)
-		if needsQuotes {
-			b.buf = append(b.buf, '"')
-		}
-		b.buf = strconv.AppendFloat(b.buf, v, 'g', -1, 64)
-		if needsQuotes {
-			b.buf = append(b.buf, '"')
-		}
+		b.float(v, 64)
 	case bool:
-		if v {
-			b.write("true")
-		} else {
-			b.write("false")
-		}
+		b.bool(v)
 	case []string:
 		b.open("[")
 		for _, s := range v {
@@ -514,6 +548,10 @@ func (b *buffer) scalar(s interface{}) {
 		b.open("{")
 		b.pairs(v)
 		b.close("}")
+	case Field:
+		b.open("{")
+		b.field(v)
+		b.close("}")
 	case map[string]interface{}:
 		keys := make([]string, len(v))
 		i := 0
@@ -528,16 +566,123 @@ func (b *buffer) scalar(s interface{}) {
 		}
 		b.close("}")
 	case error:
-		b.quote(v.Error())
+		errs := joinedErrors(v)
+		if nil == errs {
+			b.quote(v.Error())
+		} else if "" != key {
+			b.quote(v.Error())
+			b.pairKey(key + "_components")
+			b.errorList(errs)
+		} else {
+			b.open("{")
+			b.pair("error", v.Error())
+			b.pairKey("components")
+			b.errorList(errs)
+			b.close("}")
+		}
 	case Stringer:
 		b.quote(v.String())
 	default:
+		if c, ok := stringerList(v); ok {
+			b.scalar(c)
+			break
+		}
 		buf, err := json.Marshal(v)
-		if nil != err {
+		if nil == err {
+			b.preEncoded(buf, 0, func() { b.scalar(decodedJSON(buf)) })
+		} else if c, ok := containerValue(v, b.g.mapKeys); ok {
+			b.scalar(c)
+		} else {
 			b.quote("! ", err.Error(), "; ", fmt.Sprintf("%#v", v))
+		}
+	}
+}
+
+// Appends the component errors of a joined error, each with its type.
+func (b *buffer) errorList(errs []error) {
+	b.valKey = ""
+	b.open("[")
+	for _, err := range errs {
+		b.scalar(Map("type", fmt.Sprintf("%T", err), "error", err.Error()))
+	}
+	b.close("]")
+}
+
+// Appends an integer.
+func (b *buffer) int64(v int64) {
+	b.room()
+	for _, s := range b.encs {
+		s.int(v)
+	}
+}
+
+// Appends an unsigned integer (as an int64 unless it is too large).
+func (b *buffer) uint64(v uint64) {
+	if v <= math.MaxInt64 {
+		b.int64(int64(v))
+	} else {
+		b.value(v)
+	}
+}
+
+// Appends "true" or "false".
+func (b *buffer) bool(v bool) {
+	b.value(v)
+}
+
+// Appends a float (that has 'bits' of precision), as a string if it is
+// Inf or NaN (which are not valid JSON numbers).
+func (b *buffer) float(v float64, bits int) {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		var num [32]byte
+		b.quote(string(strconv.AppendFloat(num[:0], v, 'g', -1, bits)))
+		return
+	}
+	b.room()
+	for _, s := range b.encs {
+		s.float(v, bits)
+	}
+}
+
+// Appends any other scalar value:  a bool, a uint64 that is too large for
+// an int64, or 'nil'.
+func (b *buffer) value(v interface{}) {
+	b.room()
+	for _, s := range b.encs {
+		s.scalar(v)
+	}
+}
+
+// Returns the component errors of an error that combines several of them
+// [such as one from errors.Join() or a "multierror"], flattening any that
+// are themselves combined, or 'nil' if 'err' is not such an error (or has
+// no non-nil components).  The combined error is still logged as its
+// Error() string, but each underlying error is also logged, under a
+// separate "{key}_components" key, as {"type":"*fs.PathError",
+// "error":"open x: no such file or directory"} so each is individually
+// searchable.
+func joinedErrors(err error) []error {
+	var errs []error
+	switch v := err.(type) {
+	case interface{ Unwrap() []error }:
+		errs = v.Unwrap()
+	case interface{ WrappedErrors() []error }:
+		errs = v.WrappedErrors()
+	default:
+		return nil
+	}
+	flat := make([]error, 0, len(errs))
+	for _, e := range errs {
+		if nil == e {
+			continue
+		} else if sub := joinedErrors(e); nil != sub {
+			flat = append(flat, sub...)
 		} else {
-			b.writeBytes(buf)
+			flat = append(flat, e)
 		}
 	}
-	b.delim = comma
+	if 0 == len(flat) {
+		return nil
+	}
+	return flat
 }
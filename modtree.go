@@ -0,0 +1,183 @@
+package lager
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Log levels for modules configured by name, by module hierarchy, and by
+// glob pattern [see SetModuleTreeLevels()].
+type modTree struct {
+	exact   map[string]string // Levels for just the named module.
+	subtree map[string]string // Levels for modules under a prefix.
+	globs   []modGlob         // Levels for modules matching a pattern.
+}
+
+// A module level rule using a glob pattern.
+type modGlob struct {
+	pattern, levels string
+}
+
+// SetModuleTreeLevels() sets log levels for whole groups of modules based
+// on their names.  Module names can be split into segments separated by
+// "." or "/", like "svc.api.auth" or "storage/blob", to form a hierarchy.
+// 'spec' is a comma-separated list of rules of the form "{name}={levels}",
+// "{prefix}*={levels}", or "{pattern}={levels}".  A rule ending in "*"
+// (with no other wildcards) applies to the module named by the prefix and
+// to every module under it in the hierarchy:
+//
+//      err := lager.SetModuleTreeLevels("svc=FW, svc.api*=FWNID, svc*=FWN")
+//
+// Here "svc.api", "svc.api.auth", and "svc.api.v2.users" get "FWNID" (but
+// not "svc.apix"), "svc" gets "FW", while "svc.db" gets "FWN".  Other rules
+// with wildcards are glob patterns matched via path.Match(), like
+// "*.cache=D" or "grpc.v?=FW".
+//
+// When several rules apply to a module, a rule without wildcards wins,
+// then the first glob pattern that matches, then the rule with the longest
+// prefix.  A rule for just "*" applies to every module.  The levels are as
+// for Module.Init() except that the name of a single level, like "Warn" or
+// "debug", means that level and every level more severe than it, so
+// "storage/*=Debug, grpc=Warn" gives the "storage" modules "FWNAITD" and
+// "grpc" just "FW".
+//
+// The levels are applied to any existing modules that match and to modules
+// created later [see NewModule()], replacing any prior rules.  A module's
+// LAGER_{module_name}_LEVELS environment variable still takes precedence
+// when the module is created.  Pass in "" to remove all rules (without
+// changing the levels of existing modules).  An error is returned (and
+// nothing is changed) if 'spec' is malformed.
+//
+// If the environment variable LAGER_MODULE_LEVELS is set, then it is used
+// as the initial 'spec'.  Rules can also be included in LAGER_LEVELS after
+// the global levels, like "FWNA, storage/*=Debug, grpc=Warn".
+//
+func SetModuleTreeLevels(spec string) error {
+	tree, err := parseModTree(spec)
+	if nil != err {
+		return err
+	}
+	applyModTree(tree)
+	return nil
+}
+
+// Installs module level rules and applies them to existing modules.
+func applyModTree(tree *modTree) {
+	updateGlobals(func(g *globals) {
+		g.modTree = tree
+	})
+	modMap.Range(func(key, value interface{}) bool {
+		if levels, ok := tree.levels(key.(string)); ok {
+			value.(*Module).Init(levels)
+		}
+		return true
+	})
+}
+
+// Converts a list of module level rules into a modTree ('nil' if empty).
+func parseModTree(spec string) (*modTree, error) {
+	var tree *modTree
+	for _, r := range strings.Split(spec, ",") {
+		r = strings.TrimSpace(r)
+		if "" == r {
+			continue
+		}
+		eq := strings.Index(r, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf(
+				"Module level rule must be {module}[*]={levels} not %q", r)
+		}
+		name, levels := strings.TrimSpace(r[:eq]), ruleLevels(r[eq+1:])
+		if nil == tree {
+			tree = &modTree{
+				exact: map[string]string{}, subtree: map[string]string{},
+			}
+		}
+		prefix := strings.TrimSuffix(name, "*")
+		if strings.ContainsAny(prefix, "*?[\\") {
+			if _, err := path.Match(name, ""); nil != err {
+				return nil, fmt.Errorf(
+					"Invalid module pattern in level rule %q: %v", r, err)
+			}
+			tree.globs = append(tree.globs, modGlob{name, levels})
+		} else if prefix == name {
+			tree.exact[name] = levels
+		} else {
+			tree.subtree[strings.TrimRight(prefix, "./")] = levels
+		}
+	}
+	return tree, nil
+}
+
+// Converts the levels from a module level rule to the form used by
+// Module.Init(), where a level name means that level and all those more
+// severe.
+func ruleLevels(levels string) string {
+	levels = strings.TrimSpace(levels)
+	for l := lFail; l <= lGuts; l++ {
+		if strings.EqualFold(levNames[l], levels) ||
+			lAcc == l && strings.EqualFold("Acc", levels) {
+			return "FWNAITDOG"[:int(l-lFail)+1]
+		}
+	}
+	return levels
+}
+
+// Splits the value of LAGER_LEVELS into the global levels and any module
+// level rules (the comma-separated parts that contain "=").
+func splitLevels(env string) (levels, rules string) {
+	var global, mods []string
+	for _, part := range strings.Split(env, ",") {
+		if strings.Contains(part, "=") {
+			mods = append(mods, part)
+		} else {
+			global = append(global, part)
+		}
+	}
+	return strings.Join(global, " "), strings.Join(mods, ",")
+}
+
+// Sets the initial module level rules from LAGER_LEVELS and
+// LAGER_MODULE_LEVELS.
+func envModTree(g *globals) {
+	_, rules := splitLevels(os.Getenv("LAGER_LEVELS"))
+	specs := []string{rules, os.Getenv("LAGER_MODULE_LEVELS")}
+	for i, env := range []string{"LAGER_LEVELS", "LAGER_MODULE_LEVELS"} {
+		if _, err := parseModTree(specs[i]); nil != err {
+			initFatal(g, "Invalid "+env, "error", err)
+			return
+		}
+	}
+	g.modTree, _ = parseModTree(strings.Join(specs, ","))
+}
+
+// Returns the levels for the named module from the most specific rule that
+// applies to it (and whether any rule applied).  Other than for glob
+// patterns, this takes one map lookup per segment of the module name.
+func (t *modTree) levels(name string) (string, bool) {
+	if nil == t {
+		return "", false
+	}
+	if levels, ok := t.exact[name]; ok {
+		return levels, true
+	}
+	for _, g := range t.globs {
+		if ok, _ := path.Match(g.pattern, name); ok {
+			return g.levels, true
+		}
+	}
+	for prefix := name; "" != prefix; {
+		if levels, ok := t.subtree[prefix]; ok {
+			return levels, true
+		}
+		dot := strings.LastIndexAny(prefix, "./")
+		if dot < 0 {
+			break
+		}
+		prefix = prefix[:dot]
+	}
+	levels, ok := t.subtree[""]
+	return levels, ok
+}
@@ -2,22 +2,33 @@ package lager
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path"
 	"strconv"
 	"sync"
+	"sync/atomic"
 )
 
 // A named module that allows separate log levels to be en-/disabled.
 type Module struct {
-	name   string
+	name  string
+	state atomic.Value // Holds a *modLevels; replaced by Init().
+	owner *Logger      // The Logger from New() it belongs to (if any).
+}
+
+// The log levels enabled for a Module.
+type modLevels struct {
 	levels string
 	lagers [int(nLevels)]Lager
 }
 
 var modMap sync.Map
 
-func getMod(name string) *Module {
-	x, ok := modMap.Load(name)
+func getMod(name string) *Module { return findMod(&modMap, name) }
+
+func findMod(mods *sync.Map, name string) *Module {
+	x, ok := mods.Load(name)
 	if !ok {
 		return nil // No such module
 	}
@@ -27,12 +38,13 @@ func getMod(name string) *Module {
 	return nil // Invalid module got stored somehow?
 }
 
-func storeMod(name string, mod *Module) *Module {
-	_, _ = modMap.LoadOrStore(name, mod)
-	cur := getMod(name)
+func storeMod(mods *sync.Map, name string, mod *Module) *Module {
+	noteModWidth(name)
+	_, _ = mods.LoadOrStore(name, mod)
+	cur := findMod(mods, name)
 	if nil == cur { // An invalid module got stored somehow:
-		modMap.Store(name, mod) // Overwrite it.
-		cur = getMod(name)
+		mods.Store(name, mod) // Overwrite it.
+		cur = findMod(mods, name)
 		if nil == cur { // Stored module is still invalid:
 			panic("Failed to store module " + name)
 		}
@@ -58,7 +70,7 @@ func GetModuleLevels(name string) string {
 	if nil == mod {
 		return "n/a"
 	}
-	return mod.levels
+	return mod.cur().levels
 }
 
 // Returns a map[string]string where the keys are all of the module names and
@@ -66,17 +78,70 @@ func GetModuleLevels(name string) string {
 func GetModules() map[string]string {
 	m := make(map[string]string)
 	modMap.Range(func(key, value interface{}) bool {
-		m[key.(string)] = value.(*Module).levels
+		m[key.(string)] = value.(*Module).cur().levels
 		return true
 	})
 	return m
 }
 
+// A ModuleRoute sends the log lines of matching modules to an io.Writer.
+// 'Pattern' is matched against module names using path.Match(), so "audit"
+// matches just that module while "billing*" matches modules whose names
+// start with "billing".  See SetModuleRoutes().
+type ModuleRoute struct {
+	Pattern string
+	Output  io.Writer
+}
+
+// SetModuleRoutes() replaces the table used to route log lines from modules
+// to separate outputs.  Each log line from a Module is written to the
+// Output of the first route whose Pattern matches the module name,
+// overriding SetOutput() and SetLevelOutput().  Log lines not from a
+// matching module are not affected.  Calling SetModuleRoutes() with no
+// arguments removes all routes.  An error is returned (and the routes are
+// not changed) if any Pattern is malformed.  For example:
+//
+//      err := lager.SetModuleRoutes(lager.ModuleRoute{"audit", auditFile})
+//
+func SetModuleRoutes(routes ...ModuleRoute) error {
+	for _, r := range routes {
+		if _, err := path.Match(r.Pattern, ""); nil != err {
+			return fmt.Errorf("Invalid module pattern (%s): %w", r.Pattern, err)
+		}
+	}
+	if 0 == len(routes) {
+		routes = nil
+	}
+	updateGlobals(func(g *globals) {
+		g.modRoutes = append([]ModuleRoute(nil), routes...)
+	})
+	return nil
+}
+
+// Returns the output to use for the named module or 'nil' if no route
+// matches (or if 'name' is "", meaning not from a module).
+func (g *globals) moduleOutput(name string) io.Writer {
+	if "" == name {
+		return nil
+	}
+	for _, r := range g.modRoutes {
+		if ok, _ := path.Match(r.Pattern, name); ok {
+			return r.Output
+		}
+	}
+	if nil != g.modOutputs {
+		return g.inheritedOutput(name)
+	}
+	return nil
+}
+
 // Create a new Module with the given name.  Default log levels can also be
 // passed in as an optional second argument.  The initial log levels enabled
 // are taken from the last item in the list that is not "":
 //    The current globally enabled levels.
 //    The (optional) passed-in defaultLevels.
+//    The levels from the rule that applies to the module name, if any
+//    [see SetModuleTreeLevels()].
 //    The value of the LAGER_{module_name}_LEVELS environment variable.
 // If you wish to ignore the LAGER_{module_name}_LEVELS environment varible,
 // then write code similar to:
@@ -93,12 +158,15 @@ func NewModule(name string, defaultLevels ...string) *Module {
 	} else if 0 != len(defaultLevels) {
 		panic("Passed more than one defaultLevel string to lager.NewModule()")
 	}
+	if tree, ok := getGlobals().modTree.levels(name); ok {
+		levels = tree
+	}
 	env := os.Getenv("LAGER_" + name + "_LEVELS")
 	if "" != env {
 		levels = env
 	}
 	mod.Init(levels)
-	return storeMod(name, mod)
+	return storeMod(&modMap, name, mod)
 }
 
 // En-/disables log levels.  Pass in a string of letters from "FWNAITDOG" to
@@ -109,49 +177,72 @@ func NewModule(name string, defaultLevels ...string) *Module {
 // disable all optional logs, you can use Init("-") as any characters not
 // from "FWNAITDOG" are silently ignored.  So you can also call
 // Init("Fail Warn Note Acc Info").
+//
+// Init() can be called at any time (even while other goroutines are logging
+// via the Module) to change which levels are enabled.
 func (m *Module) Init(levels string) *Module {
-	m.levels = ""
+	ml := &modLevels{}
+	ml.lagers[int(lPanic)] = &logger{lev: lPanic, mod: m.name}
+	ml.lagers[int(lExit)] = &logger{lev: lExit, mod: m.name}
 	for l := lFail; l <= lGuts; l++ {
-		m.lagers[int(l)] = noop{}
+		ml.lagers[int(l)] = noop{}
 	}
 	if "" == levels {
-		levels = getGlobals().enabled
+		levels = m.globals().enabled
 	}
 	for _, c := range levels {
 		switch c {
 		case 'F':
-			m.lagers[int(lFail)] = &logger{lev: lFail, mod: m.name}
+			ml.lagers[int(lFail)] = &logger{lev: lFail, mod: m.name}
 		case 'W':
-			m.lagers[int(lWarn)] = &logger{lev: lWarn, mod: m.name}
+			ml.lagers[int(lWarn)] = &logger{lev: lWarn, mod: m.name}
 		case 'N':
-			m.lagers[int(lNote)] = &logger{lev: lNote, mod: m.name}
+			ml.lagers[int(lNote)] = &logger{lev: lNote, mod: m.name}
 		case 'A':
-			m.lagers[int(lAcc)] = &logger{lev: lAcc, mod: m.name}
+			ml.lagers[int(lAcc)] = &logger{lev: lAcc, mod: m.name}
 		case 'I':
-			m.lagers[int(lInfo)] = &logger{lev: lInfo, mod: m.name}
+			ml.lagers[int(lInfo)] = &logger{lev: lInfo, mod: m.name}
 		case 'T':
-			m.lagers[int(lTrace)] = &logger{lev: lTrace, mod: m.name}
+			ml.lagers[int(lTrace)] = &logger{lev: lTrace, mod: m.name}
 		case 'D':
-			m.lagers[int(lDebug)] = &logger{lev: lDebug, mod: m.name}
+			ml.lagers[int(lDebug)] = &logger{lev: lDebug, mod: m.name}
 		case 'O':
-			m.lagers[int(lObj)] = &logger{lev: lObj, mod: m.name}
+			ml.lagers[int(lObj)] = &logger{lev: lObj, mod: m.name}
 		case 'G':
-			m.lagers[int(lGuts)] = &logger{lev: lGuts, mod: m.name}
+			ml.lagers[int(lGuts)] = &logger{lev: lGuts, mod: m.name}
 		default:
 			continue
 		}
-		m.levels += strconv.QuoteRune(c)
+		ml.levels += strconv.QuoteRune(c)
 	}
+	m.state.Store(ml)
 	return m
 }
 
+// Returns the config that the Module's log lines use.
+func (m *Module) globals() *globals {
+	if nil != m.owner {
+		return m.owner.globals()
+	}
+	return getGlobals()
+}
+
+// Returns the Module's current levels.
+func (m *Module) cur() *modLevels {
+	if ml, ok := m.state.Load().(*modLevels); ok {
+		return ml
+	}
+	return &modLevels{} // A Module that was never initialized.
+}
+
 func (m *Module) modLevel(lev level, cs ...Ctx) Lager {
-	l := m.lagers[int(lev)]
+	l := m.globals().promotable(m.cur().lagers[int(lev)], lev, m.name)
 	if pReal, ok := l.(*logger); ok {
-		pReal.g = getGlobals()
+		cp := *pReal
+		cp.g = m.globals()
+		l = &cp
 	}
-	l = l.With(cs...)
-	return l
+	return l.With(cs...)
 }
 
 // Returns a Lager object that calls panic().  The JSON log line is first
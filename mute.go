@@ -0,0 +1,148 @@
+package lager
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// Whether Mute() is in effect.
+var _muted int32
+
+// The least severe level written [see SetLevelFloor()]; 0 for no floor.
+var _floor int32
+
+// How many log lines of each level were suppressed by Mute().
+var _suppressed [int(nLevels)]uint64
+
+// Mute() suppresses all log lines other than those logged at the Panic and
+// Exit levels, no matter what log levels are enabled, until Unmute() is
+// called.  This is meant for command-line tools that embed server libraries
+// and need to run in a quiet mode (such as when used in scripts).  The
+// number of suppressed log lines is still recorded [see Suppressed()].
+// Setting LAGER_MUTE to a non-empty value in the environment has the same
+// effect as calling Mute() when the program starts.
+//
+func Mute() { atomic.StoreInt32(&_muted, 1) }
+
+// Unmute() undoes Mute() so that log lines of enabled levels get written
+// again.
+//
+func Unmute() { atomic.StoreInt32(&_muted, 0) }
+
+// Muted() returns whether Mute() is in effect.
+//
+func Muted() bool { return 0 != atomic.LoadInt32(&_muted) }
+
+// SetLevelFloor() sets a hard minimum severity:  log lines less severe
+// than 'lev' are not written, no matter what log levels are enabled (even
+// for Modules and for Loggers from New()).  This is meant for emergencies,
+// such as when log volume is driving up costs.  'lev' is one letter from
+// "FWNAITDOG".  An 'lev' of 'E' (or 'P') turns off all logging other than
+// Panic and Exit lines, while 0 removes the floor.  Any other value calls
+// panic().  It returns a function that restores the prior floor:
+//
+//      defer lager.SetLevelFloor('W')()
+//
+// Each change is recorded in a single Note line (which is written despite
+// the floor):
+//
+//      ["2021-06-09 13:10:07.0447Z", "NOTE", "Changed log level floor",
+//          {"floor":"WARN", "prior":"none"}]
+//
+// Lines dropped due to the floor are counted [see Suppressed()].  Setting
+// LAGER_LEVEL_FLOOR to one letter in the environment has the same effect
+// as calling SetLevelFloor() when the program starts.
+//
+func SetLevelFloor(lev byte) func() {
+	floor := levelFloor(lev)
+	if floor < 0 {
+		panic(fmt.Sprintf(
+			"SetLevelFloor() needs one char from \"PEFWNAITDOG\" not %q", lev))
+	}
+	prior := atomic.SwapInt32(&_floor, floor)
+	noteFloor(getGlobals(), floor, prior)
+	return func() {
+		was := atomic.SwapInt32(&_floor, prior)
+		noteFloor(getGlobals(), prior, was)
+	}
+}
+
+// Converts a level letter to a value for _floor; -1 if not valid.
+func levelFloor(lev byte) int32 {
+	if 0 == lev {
+		return 0
+	}
+	l, ok := letterLevel(lev)
+	if !ok {
+		return -1
+	} else if l < lFail {
+		l = lExit
+	}
+	return int32(l)
+}
+
+// Returns the name to log for a value of _floor.
+func floorName(floor int32) string {
+	if 0 == floor {
+		return "none"
+	}
+	return level(floor).String()
+}
+
+// Logs a Note line about a change to the level floor.
+func noteFloor(g *globals, floor, prior int32) {
+	if floor == prior {
+		return
+	}
+	(&logger{lev: lNote, g: g, noFloor: true}).MMap(
+		"Changed log level floor",
+		"floor", floorName(floor), "prior", floorName(prior))
+}
+
+// Sets the initial level floor from LAGER_LEVEL_FLOOR.
+func envLevelFloor(g *globals) {
+	env := os.Getenv("LAGER_LEVEL_FLOOR")
+	if "" == env {
+		return
+	}
+	floor := int32(-1)
+	if 1 == len(env) {
+		floor = levelFloor(env[0])
+	}
+	if floor <= 0 {
+		initFatal(g, "Invalid LAGER_LEVEL_FLOOR",
+			"expected", "one letter from PEFWNAITDOG", "got", env)
+		return
+	}
+	atomic.StoreInt32(&_floor, floor)
+	noteFloor(g, floor, 0)
+}
+
+// Suppressed() returns how many log lines of each level have been
+// suppressed because of Mute() or SetLevelFloor().  The keys are level
+// names (like "WARN") and only levels with suppressed lines are included.
+//
+func Suppressed() map[string]uint64 {
+	counts := make(map[string]uint64)
+	for l := lFail; l < nLevels; l++ {
+		if n := atomic.LoadUint64(&_suppressed[int(l)]); 0 < n {
+			counts[l.String()] = n
+		}
+	}
+	return counts
+}
+
+// Returns whether this log line should be suppressed (and counts it).
+func (l *logger) muted() bool {
+	if l.lev < lFail {
+		return false
+	} else if 0 == atomic.LoadInt32(&_muted) {
+		floor := atomic.LoadInt32(&_floor)
+		if 0 == floor || int32(l.lev) <= floor || l.noFloor {
+			return false
+		}
+	}
+	atomic.AddUint64(&_suppressed[int(l.lev)], 1)
+	return true
+}
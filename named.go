@@ -0,0 +1,128 @@
+package lager
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// NamedModule() returns the Module with the given name, creating it if
+// needed, much like NewModule().  But module names are treated as a
+// hierarchy, with segments separated by "." or "/" [as for
+// SetModuleTreeLevels()], and a new module inherits its log levels from
+// its nearest ancestor that already exists.  So large code bases can look
+// up a module for each package or component while configuring just a few
+// of them:
+//
+//      lager.NamedModule("svc.http").InitTree("FWNAID")
+//      client := lager.NamedModule("svc.http.client") // Gets "FWNAID".
+//
+// A module with no ancestor gets the current global levels.  As with
+// NewModule(), a rule from SetModuleTreeLevels() that applies to the
+// module or a LAGER_{module_name}_LEVELS environment variable takes
+// precedence over inherited levels.  The module's log lines are written to
+// the output set for it or its nearest ancestor via SetModuleOutput().
+//
+func NamedModule(name string) *Module {
+	if mod := getMod(name); nil != mod {
+		return mod
+	}
+	levels := ""
+	for p := parentName(name); "" != p; p = parentName(p) {
+		if mod := getMod(p); nil != mod {
+			// Add "-" so "" does not become the global levels:
+			levels = mod.cur().levels + "-"
+			break
+		}
+	}
+	if tree, ok := getGlobals().modTree.levels(name); ok {
+		levels = tree
+	}
+	if env := os.Getenv("LAGER_" + name + "_LEVELS"); "" != env {
+		levels = env
+	}
+	mod := &Module{name: name}
+	mod.Init(levels)
+	return storeMod(&modMap, name, mod)
+}
+
+// InitTree() is like Init() but also sets the levels of every existing
+// module below this one in the hierarchy [see NamedModule()].  Modules
+// created under it later via NamedModule() inherit the levels.
+//
+func (m *Module) InitTree(levels string) *Module {
+	m.Init(levels)
+	levels = m.cur().levels + "-"
+	m.globals().modules().Range(func(key, value interface{}) bool {
+		if isUnder(key.(string), m.name) {
+			value.(*Module).Init(levels)
+		}
+		return true
+	})
+	return m
+}
+
+// SetModuleOutput() sends the log lines of the named module and of every
+// module below it in the hierarchy [see NamedModule()] to 'w', overriding
+// SetOutput() and SetLevelOutput().  When outputs are set for several
+// ancestors of a module, the nearest one is used.  Routes from
+// SetModuleRoutes() take precedence.  Passing a 'nil' 'w' removes the
+// output set for 'name'.  It returns a function that restores the prior
+// output for 'name':
+//
+//      defer lager.SetModuleOutput("svc.audit", auditFile)()
+//
+func SetModuleOutput(name string, w io.Writer) func() {
+	var prior io.Writer
+	updateGlobals(func(g *globals) {
+		prior = g.modOutputs[name]
+		setModuleOutput(name, w)(g)
+	})
+	return func() {
+		updateGlobals(setModuleOutput(name, prior))
+	}
+}
+
+// How globals.modOutputs is updated safely.
+func setModuleOutput(name string, w io.Writer) func(*globals) {
+	return func(g *globals) {
+		outs := make(map[string]io.Writer, len(g.modOutputs)+1)
+		for n, o := range g.modOutputs {
+			outs[n] = o
+		}
+		if nil == w {
+			delete(outs, name)
+		} else {
+			outs[name] = w
+		}
+		if 0 == len(outs) {
+			outs = nil
+		}
+		g.modOutputs = outs
+	}
+}
+
+// Returns the output set via SetModuleOutput() for the named module or its
+// nearest ancestor ('nil' if none).
+func (g *globals) inheritedOutput(name string) io.Writer {
+	for n := name; "" != n; n = parentName(n) {
+		if w, ok := g.modOutputs[n]; ok {
+			return w
+		}
+	}
+	return nil
+}
+
+// Returns the name of the parent of a module in the hierarchy ("" if none).
+func parentName(name string) string {
+	if i := strings.LastIndexAny(name, "./"); 0 <= i {
+		return name[:i]
+	}
+	return ""
+}
+
+// Returns whether module 'name' is below module 'parent' in the hierarchy.
+func isUnder(name, parent string) bool {
+	return len(parent) < len(name) && strings.HasPrefix(name, parent) &&
+		('.' == name[len(parent)] || '/' == name[len(parent)])
+}
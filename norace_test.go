@@ -0,0 +1,5 @@
+// +build !race
+
+package lager_test
+
+const raceEnabled = false
@@ -0,0 +1,368 @@
+/*
+Package otlp provides a Lager output that converts log lines into
+OpenTelemetry LogRecords and exports them via OTLP, so Lager logs can be
+sent straight to an OpenTelemetry Collector (or any OTLP endpoint):
+
+	sink, err := otlp.New(otlp.Config{
+		Endpoint: "http://otel-collector:4318/v1/logs",
+		Resource: map[string]string{"service.name": "billing"},
+	})
+	if nil != err {
+		lager.Exit().MMap("Can't create OTLP sink", "err", err)
+	}
+	defer sink.Close()
+	defer lager.SetOutput(sink)()
+
+Both OTLP/HTTP (protobuf encoded) and OTLP/gRPC are supported.  Each log
+line becomes one LogRecord.  The log message becomes the record's body,
+key/value pairs [including those from the Context] become attributes
+(nested maps and lists are kept as such), and the lager level is mapped to
+an OTel severity number:
+
+	PANIC   FATAL3 (23)     ACCESS  INFO (9)
+	EXIT    FATAL (21)      INFO    INFO (9)
+	FAIL    ERROR (17)      TRACE   TRACE (1)
+	WARN    WARN (13)       DEBUG   DEBUG (5)
+	NOTE    INFO2 (10)      OBJ     DEBUG2 (6)
+	                        GUTS    DEBUG3 (7)
+
+The GCP trace and span pairs [see gcp.ContextAddTrace()] are used to
+set the record's trace and span IDs instead of being added as attributes.
+Lines are sent in batches and failed batches are retried.
+*/
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Unity-Technologies/go-lager-internal"
+	"github.com/Unity-Technologies/go-lager-internal/batch"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The gRPC method that OTLP/gRPC log exports are sent to.
+const exportMethod = "/opentelemetry.proto.collector.logs.v1.LogsService/Export"
+
+// The instrumentation scope name used for exported records.
+const scopeName = "github.com/Unity-Technologies/go-lager-internal"
+
+// Config describes where and how to export log lines.
+type Config struct {
+	// For "http", the URL of the logs endpoint (usually ending in
+	// "/v1/logs").  For "grpc", the "host:port" of the collector.
+	// Required.
+	Endpoint string
+
+	// "http" (OTLP/HTTP with protobuf encoding, the default) or "grpc".
+	Protocol string
+
+	// Extra headers sent with each export (as metadata for "grpc"), such
+	// as for authentication.
+	Headers map[string]string
+
+	// Attributes of the resource producing the logs, like "service.name".
+	Resource map[string]string
+
+	// When lines are logged as JSON maps [see lager.Keys()], the keys that
+	// hold the message and the module name.  The defaults are to check for
+	// "msg" then "message" and for "mod" then "module".  (The timestamp
+	// and level are always the first and second keys.)
+	MessageKey, ModuleKey string
+
+	// For "grpc", whether to connect without TLS.  Ignored if DialOptions
+	// are given.
+	Insecure bool
+
+	// For "grpc", options used when connecting to the collector.
+	DialOptions []grpc.DialOption
+
+	// The http.Client to use for "http" (default is http.DefaultClient).
+	Client *http.Client
+
+	// How long to wait for each export to finish (default 10s).
+	Timeout time.Duration
+
+	// How lines are batched and retried.
+	batch.Options
+}
+
+// Sink is an io.Writer suitable for passing to lager.SetOutput().
+type Sink struct {
+	*batch.Writer
+	conf     Config
+	conn     *grpc.ClientConn
+	resource []byte // The encoded Resource message.
+}
+
+// The OTel severity number for each lager level name.
+var severities = map[string]int{
+	"PANIC": 23, "EXIT": 21, "FAIL": 17, "WARN": 13, "NOTE": 10,
+	"ACCESS": 9, "INFO": 9, "TRACE": 1, "DEBUG": 5, "OBJ": 6, "GUTS": 7,
+}
+
+// New() returns a Sink that exports log lines as configured.  Call Close()
+// on it when done so that any pending lines get sent.
+//
+func New(conf Config) (*Sink, error) {
+	if "" == conf.Endpoint {
+		return nil, fmt.Errorf("otlp.New() requires an Endpoint")
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = 10 * time.Second
+	}
+	if nil == conf.Client {
+		conf.Client = http.DefaultClient
+	}
+	s := &Sink{conf: conf, resource: encodeResource(conf.Resource)}
+	switch conf.Protocol {
+	case "", "http":
+	case "grpc":
+		opts := conf.DialOptions
+		if 0 == len(opts) && conf.Insecure {
+			opts = []grpc.DialOption{
+				grpc.WithTransportCredentials(insecure.NewCredentials())}
+		} else if 0 == len(opts) {
+			opts = []grpc.DialOption{grpc.WithTransportCredentials(
+				credentials.NewTLS(&tls.Config{}))}
+		}
+		conn, err := grpc.Dial(conf.Endpoint, opts...)
+		if nil != err {
+			return nil, err
+		}
+		s.conn = conn
+	default:
+		return nil, fmt.Errorf(
+			"otlp.New() Protocol must be http or grpc not %q", conf.Protocol)
+	}
+	s.Writer = batch.New(s.send, conf.Options)
+	return s, nil
+}
+
+// Close() sends any remaining lines and then closes the connection to the
+// collector (for "grpc").
+func (s *Sink) Close() error {
+	err := s.Writer.Close()
+	if nil != s.conn {
+		if cerr := s.conn.Close(); nil == err {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Exports one batch of lines.
+func (s *Sink) send(lines [][]byte) error {
+	body := s.encodeRequest(lines)
+	ctx, cancel := context.WithTimeout(context.Background(), s.conf.Timeout)
+	defer cancel()
+	if nil != s.conn {
+		return s.sendGrpc(ctx, body)
+	}
+	req, err := http.NewRequest("POST", s.conf.Endpoint, bytes.NewReader(body))
+	if nil != err {
+		return batch.Permanent(err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range s.conf.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.conf.Client.Do(req)
+	if nil != err {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if 200 <= resp.StatusCode && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("OTLP endpoint returned %s: %s",
+		resp.Status, strings.TrimSpace(string(msg)))
+	if 400 <= resp.StatusCode && resp.StatusCode < 500 &&
+		http.StatusTooManyRequests != resp.StatusCode &&
+		http.StatusRequestTimeout != resp.StatusCode {
+		return batch.Permanent(err)
+	}
+	return err
+}
+
+// Exports one encoded request via OTLP/gRPC.
+func (s *Sink) sendGrpc(ctx context.Context, body []byte) error {
+	if 0 < len(s.conf.Headers) {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(s.conf.Headers))
+	}
+	var resp []byte
+	err := s.conn.Invoke(
+		ctx, exportMethod, body, &resp, grpc.ForceCodec(rawCodec{}))
+	switch status.Code(err) {
+	case codes.OK:
+		return nil
+	case codes.Canceled, codes.DeadlineExceeded, codes.ResourceExhausted,
+		codes.Aborted, codes.OutOfRange, codes.Unavailable, codes.DataLoss:
+		return err
+	}
+	return batch.Permanent(err)
+}
+
+// A gRPC codec for requests and responses that are already encoded.
+type rawCodec struct{}
+
+func (rawCodec) Name() string { return "proto" }
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	if b, ok := v.([]byte); ok {
+		return b, nil
+	}
+	return nil, fmt.Errorf("otlp: can't marshal %T", v)
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	if p, ok := v.(*[]byte); ok {
+		*p = append((*p)[:0], data...)
+		return nil
+	}
+	return fmt.Errorf("otlp: can't unmarshal into %T", v)
+}
+
+// A LogRecord decoded from a Lager log line.
+type record struct {
+	when          time.Time
+	lev           string
+	body          interface{}
+	attrs         object
+	trace, spanID []byte
+}
+
+// Converts one Lager log line into a record.  A line that is not valid
+// JSON becomes a record with the line as its body.
+func (s *Sink) parse(line []byte) record {
+	r := record{}
+	r.when, _ = batch.LineTime(line)
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	v, err := decodeValue(dec)
+	if nil != err {
+		r.body = string(line)
+		return r
+	}
+	rest := []interface{}{}
+	switch x := v.(type) {
+	case []interface{}:
+		if 1 < len(x) {
+			r.lev, _ = x[1].(string)
+			x = x[2:]
+		} else {
+			x = nil
+		}
+		if 0 < len(x) {
+			if last, ok := x[len(x)-1].(string); ok &&
+				strings.HasPrefix(last, "mod=") {
+				r.attrs = append(r.attrs, pair{"module", last[4:]})
+				x = x[:len(x)-1]
+			}
+		}
+		for _, elt := range x {
+			if obj, ok := elt.(object); ok {
+				r.attrs = append(r.attrs, obj...)
+			} else {
+				rest = append(rest, elt)
+			}
+		}
+	case object:
+		msgKeys := []string{"msg", "message"}
+		modKeys := []string{"mod", "module"}
+		if "" != s.conf.MessageKey {
+			msgKeys = []string{s.conf.MessageKey}
+		}
+		if "" != s.conf.ModuleKey {
+			modKeys = []string{s.conf.ModuleKey}
+		}
+		for i, p := range x {
+			switch {
+			case 0 == i:
+			case 1 == i:
+				r.lev, _ = p.val.(string)
+			case oneOf(p.key, msgKeys):
+				rest = append(rest, p.val)
+			case oneOf(p.key, modKeys):
+				r.attrs = append(r.attrs, pair{"module", p.val})
+			default:
+				if obj, ok := p.val.(object); ok {
+					r.attrs = append(r.attrs, obj...)
+				} else {
+					r.attrs = append(r.attrs, p)
+				}
+			}
+		}
+	default:
+		rest = append(rest, v)
+	}
+	if 1 == len(rest) {
+		r.body = rest[0]
+	} else if 1 < len(rest) {
+		r.body = rest
+	}
+	r.takeTrace()
+	return r
+}
+
+// Moves the GCP trace and span pairs into the record's trace and span IDs.
+func (r *record) takeTrace() {
+	attrs := r.attrs[:0]
+	for _, p := range r.attrs {
+		str, _ := p.val.(string)
+		switch p.key {
+		case lager.GcpTraceKey:
+			if id, err := hex.DecodeString(
+				str[strings.LastIndex(str, "/")+1:]); nil == err &&
+				16 == len(id) {
+				r.trace = id
+				continue
+			}
+		case lager.GcpSpanKey:
+			if id, err := hex.DecodeString(str); nil == err && 8 == len(id) {
+				r.spanID = id
+				continue
+			}
+		}
+		attrs = append(attrs, p)
+	}
+	r.attrs = attrs
+}
+
+// Returns whether 'key' is one of 'keys'.
+func oneOf(key string, keys []string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the resource attributes, sorted by key.
+func resourceAttrs(res map[string]string) object {
+	attrs := make(object, 0, len(res))
+	for k, v := range res {
+		attrs = append(attrs, pair{k, v})
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return attrs[i].key < attrs[j].key
+	})
+	return attrs
+}
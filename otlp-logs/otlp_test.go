@@ -0,0 +1,239 @@
+package otlp_test
+
+import (
+	"context"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Unity-Technologies/go-lager-internal"
+	"github.com/Unity-Technologies/go-lager-internal/batch"
+	"github.com/Unity-Technologies/go-lager-internal/otlp-logs"
+	"github.com/Unity-Technologies/go-tutl-internal"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The decoded fields of a protobuf message.
+type msg map[protowire.Number][]interface{}
+
+func decode(b []byte) msg {
+	m := msg{}
+	for 0 < len(b) {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return m
+		}
+		b = b[n:]
+		var v interface{}
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		default:
+			return m
+		}
+		if n < 0 {
+			return m
+		}
+		b = b[n:]
+		m[num] = append(m[num], v)
+	}
+	return m
+}
+
+func (m msg) sub(num protowire.Number, i int) msg {
+	if len(m[num]) <= i {
+		return msg{}
+	}
+	b, _ := m[num][i].([]byte)
+	return decode(b)
+}
+
+func (m msg) str(num protowire.Number) string {
+	if 0 == len(m[num]) {
+		return ""
+	}
+	b, _ := m[num][0].([]byte)
+	return string(b)
+}
+
+// Returns the log records from an ExportLogsServiceRequest.
+func records(req []byte) []msg {
+	scope := decode(req).sub(1, 0).sub(2, 0)
+	recs := []msg{}
+	for i := range scope[2] {
+		recs = append(recs, scope.sub(2, i))
+	}
+	return recs
+}
+
+// Returns a record's attributes as a map from key to encoded AnyValue.
+func attrs(rec msg) map[string]msg {
+	m := map[string]msg{}
+	for i := range rec[6] {
+		kv := rec.sub(6, i)
+		m[kv.str(1)] = kv.sub(2, 0)
+	}
+	return m
+}
+
+func TestHttpSink(t *testing.T) {
+	u := tutl.New(t)
+
+	var mu sync.Mutex
+	bodies := [][]byte{}
+	types := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			defer lager.AutoLock(&mu)()
+			b, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, b)
+			types = append(types, r.Header.Get("Content-Type"))
+		}))
+	defer srv.Close()
+
+	_, err := otlp.New(otlp.Config{})
+	u.Like(err, "no Endpoint", "*requires an Endpoint")
+	_, err = otlp.New(otlp.Config{Endpoint: srv.URL, Protocol: "udp"})
+	u.Like(err, "bad Protocol", "*must be http or grpc", `*"udp"`)
+
+	sink, err := otlp.New(otlp.Config{
+		Endpoint: srv.URL, Resource: map[string]string{"service.name": "x"},
+		Options: batch.Options{Interval: time.Hour},
+	})
+	if !u.Is(nil, err, "New") {
+		return
+	}
+	lager.Keys("", "", "", "", "", "")
+	lager.Init("FWI")
+	restore := lager.SetOutput(sink)
+	ctx := lager.AddPairs(context.Background(),
+		lager.GcpTraceKey, "projects/p/traces/0123456789abcdef0123456789abcdef",
+		lager.GcpSpanKey, "00000000000000ff")
+	lager.NewModule("db").Warn(ctx).MMap("two", "n", 2, "f", 1.5,
+		"ok", true, "m", lager.Map("k", "v"), "l", lager.List("a", 1))
+	lager.Fail().List("one", 1)
+	restore()
+	u.Is(nil, sink.Close(), "Close")
+
+	defer lager.AutoLock(&mu)()
+	if !u.Is(1, len(bodies), "one export") {
+		return
+	}
+	u.Is("application/x-protobuf", types[0], "content type")
+	req := decode(bodies[0])
+	res := req.sub(1, 0).sub(1, 0).sub(1, 0)
+	u.Is("service.name", res.str(1), "resource key")
+	u.Is("x", res.sub(2, 0).str(1), "resource value")
+	u.Is("github.com/Unity-Technologies/go-lager-internal",
+		req.sub(1, 0).sub(2, 0).sub(1, 0).str(1), "scope name")
+
+	recs := records(bodies[0])
+	if !u.Is(2, len(recs), "records") {
+		return
+	}
+	rec := recs[0]
+	u.Is(uint64(13), rec[2][0], "WARN severity number")
+	u.Is("WARN", rec.str(3), "severity text")
+	u.Is("two", rec.sub(5, 0).str(1), "body")
+	u.Is(1, len(rec[1]), "has time")
+	u.Is(1, len(rec[11]), "has observed time")
+	u.Is("\x01\x23\x45\x67\x89\xab\xcd\xef\x01\x23\x45\x67\x89\xab\xcd\xef",
+		rec.str(9), "trace ID")
+	u.Is("\x00\x00\x00\x00\x00\x00\x00\xff", rec.str(10), "span ID")
+	a := attrs(rec)
+	u.Is(uint64(2), a["n"][3][0], "int attribute")
+	u.Is(math.Float64bits(1.5), a["f"][4][0], "double attribute")
+	u.Is(uint64(1), a["ok"][2][0], "bool attribute")
+	u.Is("db", a["module"].str(1), "module attribute")
+	kv := a["m"].sub(6, 0).sub(1, 0)
+	u.Is("k", kv.str(1), "nested map key")
+	u.Is("v", kv.sub(2, 0).str(1), "nested map value")
+	u.Is(2, len(a["l"].sub(5, 0)[1]), "list attribute")
+	u.Is(false, nil != a[lager.GcpTraceKey], "no trace attribute")
+
+	rec = recs[1]
+	u.Is(uint64(17), rec[2][0], "FAIL severity number")
+	u.Is(2, len(rec.sub(5, 0).sub(5, 0)[1]), "list body")
+	u.Is(0, len(rec[9]), "no trace ID")
+}
+
+// A gRPC codec that passes encoded messages through unchanged.
+type rawCodec struct{}
+
+func (rawCodec) Name() string { return "proto" }
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	return *v.(*[]byte), nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*[]byte) = append([]byte(nil), data...)
+	return nil
+}
+
+func TestGrpcSink(t *testing.T) {
+	u := tutl.New(t)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if !u.Is(nil, err, "Listen") {
+		return
+	}
+	reqs := make(chan []byte, 4)
+	methods := make(chan string, 4)
+	export := func(_ interface{}, ss grpc.ServerStream) error {
+		method, _ := grpc.MethodFromServerStream(ss)
+		methods <- method
+		var req []byte
+		if err := ss.RecvMsg(&req); nil != err {
+			return err
+		}
+		reqs <- req
+		resp := []byte{}
+		return ss.SendMsg(&resp)
+	}
+	srv := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}),
+		grpc.UnknownServiceHandler(export))
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	sink, err := otlp.New(otlp.Config{
+		Endpoint: lis.Addr().String(), Protocol: "grpc", Insecure: true,
+		Options: batch.Options{Interval: time.Hour, Retries: -1},
+	})
+	if !u.Is(nil, err, "New") {
+		return
+	}
+	lager.Keys("time", "level", "msg", "data", "", "module")
+	defer lager.Keys("", "", "", "", "", "")
+	lager.Init("FWI")
+	restore := lager.SetOutput(sink)
+	lager.NewModule("api").Info().MMap("hello", "id", "x7")
+	restore()
+	u.Is(nil, sink.Close(), "Close")
+
+	select {
+	case req := <-reqs:
+		u.Is("/opentelemetry.proto.collector.logs.v1.LogsService/Export",
+			<-methods, "method")
+		recs := records(req)
+		if u.Is(1, len(recs), "records") {
+			u.Is(uint64(9), recs[0][2][0], "INFO severity number")
+			u.Is("hello", recs[0].sub(5, 0).str(1), "map body")
+			a := attrs(recs[0])
+			u.Is("api", a["module"].str(1), "module attribute")
+			u.Is("x7", a["id"].str(1), "data attribute")
+		}
+	case <-time.After(5 * time.Second):
+		u.Is(true, false, "export received")
+	}
+}
@@ -0,0 +1,198 @@
+package otlp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// A decoded JSON object, keeping its keys in order.
+type object []pair
+
+type pair struct {
+	key string
+	val interface{}
+}
+
+// Decodes the next JSON value, producing an object for each JSON object,
+// []interface{} for arrays, and int64 or float64 for numbers.
+func decodeValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if nil != err {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		if '[' == t {
+			list := []interface{}{}
+			for dec.More() {
+				v, err := decodeValue(dec)
+				if nil != err {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			_, err = dec.Token()
+			return list, err
+		} else if '{' != t {
+			return nil, fmt.Errorf("unexpected %v", t)
+		}
+		obj := object{}
+		for dec.More() {
+			tok, err := dec.Token()
+			if nil != err {
+				return nil, err
+			}
+			key, _ := tok.(string)
+			v, err := decodeValue(dec)
+			if nil != err {
+				return nil, err
+			}
+			obj = append(obj, pair{key, v})
+		}
+		_, err = dec.Token()
+		return obj, err
+	case json.Number:
+		if i, err := t.Int64(); nil == err {
+			return i, nil
+		}
+		return t.Float64()
+	}
+	return tok, nil
+}
+
+// Field numbers from the OTLP protobuf definitions.
+const (
+	fReqResourceLogs = 1 // ExportLogsServiceRequest.resource_logs
+
+	fResResource  = 1 // ResourceLogs.resource
+	fResScopeLogs = 2 // ResourceLogs.scope_logs
+	fResourceAttr = 1 // Resource.attributes
+
+	fScopeScope   = 1 // ScopeLogs.scope
+	fScopeRecords = 2 // ScopeLogs.log_records
+	fScopeName    = 1 // InstrumentationScope.name
+
+	fRecTime     = 1  // LogRecord.time_unix_nano
+	fRecSevNum   = 2  // LogRecord.severity_number
+	fRecSevText  = 3  // LogRecord.severity_text
+	fRecBody     = 5  // LogRecord.body
+	fRecAttrs    = 6  // LogRecord.attributes
+	fRecTraceID  = 9  // LogRecord.trace_id
+	fRecSpanID   = 10 // LogRecord.span_id
+	fRecObserved = 11 // LogRecord.observed_time_unix_nano
+
+	fKvKey   = 1 // KeyValue.key
+	fKvValue = 2 // KeyValue.value
+
+	fAnyString = 1 // AnyValue.string_value
+	fAnyBool   = 2 // AnyValue.bool_value
+	fAnyInt    = 3 // AnyValue.int_value
+	fAnyDouble = 4 // AnyValue.double_value
+	fAnyArray  = 5 // AnyValue.array_value
+	fAnyKvList = 6 // AnyValue.kvlist_value
+
+	fListValues = 1 // ArrayValue.values and KeyValueList.values
+)
+
+// Encodes the ExportLogsServiceRequest for one batch of lines.
+func (s *Sink) encodeRequest(lines [][]byte) []byte {
+	now := uint64(time.Now().UnixNano())
+	scope := appendMessage(nil, fScopeScope,
+		protowire.AppendString(protowire.AppendTag(
+			nil, fScopeName, protowire.BytesType), scopeName))
+	for _, line := range lines {
+		scope = appendMessage(scope, fScopeRecords, s.parse(line).encode(now))
+	}
+	res := appendMessage(nil, fResResource, s.resource)
+	res = appendMessage(res, fResScopeLogs, scope)
+	return appendMessage(nil, fReqResourceLogs, res)
+}
+
+// Encodes the Resource message.
+func encodeResource(res map[string]string) []byte {
+	out := []byte{}
+	for _, p := range resourceAttrs(res) {
+		out = appendMessage(out, fResourceAttr, appendKeyValue(nil, p))
+	}
+	return out
+}
+
+// Encodes a LogRecord message.
+func (r record) encode(observed uint64) []byte {
+	out := []byte{}
+	if !r.when.IsZero() {
+		out = protowire.AppendTag(out, fRecTime, protowire.Fixed64Type)
+		out = protowire.AppendFixed64(out, uint64(r.when.UnixNano()))
+	}
+	if sev, ok := severities[r.lev]; ok {
+		out = protowire.AppendTag(out, fRecSevNum, protowire.VarintType)
+		out = protowire.AppendVarint(out, uint64(sev))
+	}
+	if "" != r.lev {
+		out = protowire.AppendTag(out, fRecSevText, protowire.BytesType)
+		out = protowire.AppendString(out, r.lev)
+	}
+	if nil != r.body {
+		out = appendMessage(out, fRecBody, appendAnyValue(nil, r.body))
+	}
+	for _, p := range r.attrs {
+		out = appendMessage(out, fRecAttrs, appendKeyValue(nil, p))
+	}
+	if nil != r.trace && nil != r.spanID {
+		out = protowire.AppendTag(out, fRecTraceID, protowire.BytesType)
+		out = protowire.AppendBytes(out, r.trace)
+		out = protowire.AppendTag(out, fRecSpanID, protowire.BytesType)
+		out = protowire.AppendBytes(out, r.spanID)
+	}
+	out = protowire.AppendTag(out, fRecObserved, protowire.Fixed64Type)
+	return protowire.AppendFixed64(out, observed)
+}
+
+// Appends an embedded message field.
+func appendMessage(out []byte, num protowire.Number, msg []byte) []byte {
+	out = protowire.AppendTag(out, num, protowire.BytesType)
+	return protowire.AppendBytes(out, msg)
+}
+
+// Appends the fields of a KeyValue message.
+func appendKeyValue(out []byte, p pair) []byte {
+	out = protowire.AppendTag(out, fKvKey, protowire.BytesType)
+	out = protowire.AppendString(out, p.key)
+	return appendMessage(out, fKvValue, appendAnyValue(nil, p.val))
+}
+
+// Appends the fields of an AnyValue message.  A JSON null becomes an empty
+// AnyValue.
+func appendAnyValue(out []byte, v interface{}) []byte {
+	switch x := v.(type) {
+	case string:
+		out = protowire.AppendTag(out, fAnyString, protowire.BytesType)
+		out = protowire.AppendString(out, x)
+	case bool:
+		out = protowire.AppendTag(out, fAnyBool, protowire.VarintType)
+		out = protowire.AppendVarint(out, protowire.EncodeBool(x))
+	case int64:
+		out = protowire.AppendTag(out, fAnyInt, protowire.VarintType)
+		out = protowire.AppendVarint(out, uint64(x))
+	case float64:
+		out = protowire.AppendTag(out, fAnyDouble, protowire.Fixed64Type)
+		out = protowire.AppendFixed64(out, math.Float64bits(x))
+	case []interface{}:
+		list := []byte{}
+		for _, elt := range x {
+			list = appendMessage(list, fListValues, appendAnyValue(nil, elt))
+		}
+		out = appendMessage(out, fAnyArray, list)
+	case object:
+		list := []byte{}
+		for _, p := range x {
+			list = appendMessage(list, fListValues, appendKeyValue(nil, p))
+		}
+		out = appendMessage(out, fAnyKvList, list)
+	}
+	return out
+}
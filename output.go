@@ -0,0 +1,174 @@
+package lager
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// An output that has its own format [see FormatOutput()].
+type formatOutput struct {
+	w io.Writer
+	g *globals // The format settings for this output.
+}
+
+// FormatOutput() returns an io.Writer that writes each log line to 'w'
+// using the named format, no matter what format is used for other outputs.
+// 'format' can be any of the names accepted by the --log-format option [see
+// FlagSet()], such as "console", "map", or "gcp".  This lets you, for
+// example, write pretty lines to the console while also writing JSON to a
+// file:
+//
+//      con, err := lager.FormatOutput(os.Stderr, "console")
+//      if nil != err {
+//          lager.Exit().MMap("Bad format", "err", err)
+//      }
+//      defer lager.SetOutput(lager.TeeOutput(logFile, con))()
+//
+// The returned io.Writer can be passed to SetOutput(), SetLevelOutput(),
+// SetModuleOutput(), or TeeOutput().  The key names and level notation for
+// the output come from the format, as if Keys() or LevelNotation() had
+// been called for just that output.  Other settings (such as colors) are
+// copied from the current global settings when FormatOutput() is called.
+//
+// The logged values are passed to the output's format as each line is
+// composed, so no JSON gets parsed and context pairs are kept apart from
+// the other pairs just as when the format is selected globally.
+//
+// An error is returned if 'format' is not recognized.
+//
+func FormatOutput(w io.Writer, format string) (io.Writer, error) {
+	set, ok := logFormat(format)
+	if !ok {
+		return nil, fmt.Errorf(
+			"lager.FormatOutput() format must be one of %s not %q",
+			formatNames(), format)
+	}
+	g := *getGlobals()
+	set(&g)
+	return &formatOutput{w: w, g: &g}, nil
+}
+
+// Writing directly to a formatOutput just writes to the underlying output.
+func (fo *formatOutput) Write(data []byte) (int, error) {
+	return fo.w.Write(data)
+}
+
+// Passes 'ctx' to the underlying output [see SetWriteTimeout()].
+func (fo *formatOutput) WriteContext(
+	ctx context.Context, data []byte,
+) (int, error) {
+	return writeContext(ctx, fo.w, data)
+}
+
+// Flushes the underlying output.
+func (fo *formatOutput) Flush() error {
+	if f, ok := fo.w.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Returns the io.Writer to use for the log line being composed in 'b' and
+// going to 'w' [see bindOutput()].  The line is not composed as JSON when
+// none of its outputs needs that [see needsJSON()], unless SetDedupWindow()
+// or SetFallbackOutput() is in effect.
+func (l *logger) bind(b *buffer, w io.Writer, async bool) io.Writer {
+	w = l.bindOutput(b, w, async)
+	if 1 < len(b.encs) && !needsJSON(w) &&
+		nil == b.g.dedup && nil == b.g.fallback {
+		b.json.reset(newlineEncoder{}, b.json.out)
+	}
+	return w
+}
+
+// Returns the io.Writer to use for the log line being composed in 'b' and
+// going to 'w', converting the line to the global format or to the format
+// of a FormatOutput() output (but not for a RingBuffer nor StreamHandler()
+// clients, which get lines as JSON).  If 'async' is set, writes to each
+// output are queued when async mode or coalescing applies to it.
+func (l *logger) bindOutput(b *buffer, w io.Writer, async bool) io.Writer {
+	out := func(w io.Writer) io.Writer {
+		if async {
+			return l.queued(b, w)
+		}
+		return w
+	}
+	kept := l.held() || nil != b.g.dedup // Writer used after the line.
+	switch x := w.(type) {
+	case tee:
+		t := make(tee, len(x))
+		for i, w := range x {
+			t[i] = l.bindOutput(b, w, async)
+		}
+		return t
+	case *formatOutput:
+		f := x.g.format
+		if nil != f && nil != f.encoder {
+			return b.encodedWriter(out(x.w), f.encoder, kept)
+		} else if nil != f && nil != f.siem && !f.siem.levels[int(l.lev)] {
+			return io.Discard
+		}
+		return b.encodedWriter(out(x.w), l.formatEncoder(x.g), kept)
+	case *RingBuffer:
+		return &ringWriter{r: x, lev: l.lev, mod: l.mod}
+	case *streamHub:
+		return &streamWriter{lev: l.lev, mod: l.mod}
+	}
+	if nil != l.g.format && nil != l.g.format.encoder {
+		return b.encodedWriter(out(w), l.g.format.encoder, kept)
+	} else if nil != l.g.format {
+		return l.formatWriter(b, out(w), kept)
+	}
+	return out(w)
+}
+
+// Formats a log line as JSON using the output's Keys() and level
+// notation.
+func (fe *formatEncoder) json(tl textLine) []byte {
+	keys := fe.g.keys
+	lev := fe.g.levDesc(fe.lev.String())
+	rest, pairs := fe.args(tl)
+	if nil == keys {
+		line := AList{strings.Replace(tl.when, "T", " ", 1), lev}
+		line = append(line, rest...)
+		for _, kvp := range pairs {
+			if 0 < len(kvp.keys) {
+				line = append(line, kvp)
+			}
+		}
+		if "" != fe.mod {
+			line = append(line, "mod="+fe.mod)
+		}
+		return append(encodeJSON(line), '\n')
+	}
+
+	line := AMap(nil).AddPairs(
+		keys.when, strings.Replace(tl.when, " ", "T", 1), keys.lev, lev)
+	if fe.g.inEcs {
+		line = line.AddPairs("ecs.version", EcsVersion)
+	}
+	if "" != keys.msg && 0 < len(rest) {
+		if s, ok := rest[0].(string); ok {
+			line = line.AddPairs(keys.msg, s)
+			rest = rest[1:]
+		}
+	}
+	if 0 < len(rest) {
+		line = line.AddPairs(keys.args, AList(rest))
+	}
+	for _, kvp := range pairs {
+		if kvp == tl.ctx && "" != keys.ctx {
+			line = line.AddPairs(keys.ctx, kvp)
+		} else {
+			for i, k := range kvp.keys {
+				line = line.AddPairs(k, kvp.vals[i])
+			}
+		}
+	}
+	if "" != fe.mod {
+		line = line.AddPairs(keys.mod, fe.mod)
+	}
+	return append(encodeJSON(line), '\n')
+}
@@ -0,0 +1,7 @@
+// +build race
+
+package lager_test
+
+// The race detector makes sync.Pool drop items at random, which skews
+// allocation counts.
+const raceEnabled = true
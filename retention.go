@@ -0,0 +1,118 @@
+package lager
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Retention() returns a context.Context that adds a "retention" pair to
+// each log line so that downstream log routers can send the line to a
+// storage bucket that keeps it for the given time.  'class' is "forever"
+// or a number followed by "d" (days), "w" (weeks), "m" (months), or "y"
+// (years), like "30d" or "1y"; anything else causes a panic():
+//
+//      ctx = lager.Retention(ctx, "1y")
+//      lager.Note(ctx).MMap("Changed role", "user", id, "role", role)
+//
+//      ["2021-06-09 13:10:07.0447Z", "NOTE", "Changed role",
+//          {"user":"bob", "role":"admin"}, {"retention":"1y"}]
+//
+// This takes precedence over the default retention for the line's level
+// [see SetLevelRetention()].
+//
+func Retention(ctx Ctx, class string) Ctx {
+	if err := checkRetention(class); nil != err {
+		panic(err.Error())
+	}
+	return AddPairs(ctx, "retention", class)
+}
+
+// SetLevelRetention() sets the default retention class [see Retention()]
+// for log lines of the given levels (letters from "PEFWNAITDOG").  Lines of
+// those levels get a "retention" pair unless they already have one (from
+// their context).  A 'class' of "" removes the default for those levels.
+// It returns a function that restores the prior defaults:
+//
+//      defer lager.SetLevelRetention("DOG", "7d")()
+//
+// A 'class' that is not valid causes a panic().  Setting
+// LAGER_LEVEL_RETENTION in the environment to a comma-separated list of
+// {levels}={class} pairs, like "PEFW=1y,DOG=7d", has the same effect as
+// calling SetLevelRetention() when the program starts.
+//
+func SetLevelRetention(levels, class string) func() {
+	if "" != class {
+		if err := checkRetention(class); nil != err {
+			panic(err.Error())
+		}
+	}
+	var prior [int(nLevels)]string
+	updateGlobals(func(g *globals) {
+		prior = g.retention
+		setLevelRetention(levels, class)(g)
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.retention = prior
+		})
+	}
+}
+
+// How default retention classes are updated safely.
+func setLevelRetention(levels, class string) func(*globals) {
+	return func(g *globals) {
+		for _, c := range []byte(levels) {
+			if lev, ok := letterLevel(c); ok {
+				g.retention[int(lev)] = class
+			}
+		}
+	}
+}
+
+// Returns an error if 'class' is not a valid retention class.
+func checkRetention(class string) error {
+	if "forever" == class {
+		return nil
+	}
+	n := len(class)
+	if 2 <= n && strings.ContainsRune("dwmy", rune(class[n-1])) &&
+		'0' < class[0] && "" == strings.Trim(class[:n-1], "0123456789") {
+		return nil
+	}
+	return fmt.Errorf("retention class must be like \"30d\", \"1y\", or"+
+		" \"forever\" not %q", class)
+}
+
+// Sets the initial default retention classes from LAGER_LEVEL_RETENTION.
+func envLevelRetention(g *globals) {
+	env := os.Getenv("LAGER_LEVEL_RETENTION")
+	if "" == env {
+		return
+	}
+	for _, part := range strings.Split(env, ",") {
+		eq := strings.Index(part, "=")
+		err := fmt.Errorf("expected {levels}={class} not %q", part)
+		if 0 < eq {
+			err = checkRetention(strings.TrimSpace(part[eq+1:]))
+		}
+		if nil != err {
+			initFatal(g,
+				"Invalid LAGER_LEVEL_RETENTION", "error", err, "got", env)
+			return
+		}
+		setLevelRetention(strings.TrimSpace(part[:eq]),
+			strings.TrimSpace(part[eq+1:]))(g)
+	}
+}
+
+// Returns a copy of the logger with the "retention" pair added for the
+// default retention of its level (unless it already has one).
+func (l *logger) withRetention() *logger {
+	if nil != l.ctxPairs().Get("retention") {
+		return l
+	}
+	cp := *l
+	cp.kvp = cp.kvp.AddPairs("retention", l.g.retention[int(l.lev)])
+	return &cp
+}
@@ -0,0 +1,139 @@
+package lager
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// RingBuffer is an output that keeps the most recent log lines in memory
+// [see NewRingBuffer()].  Those lines can then be viewed via ViewerHandler()
+// even when the usual outputs are not accessible.
+//
+type RingBuffer struct {
+	mu      sync.Mutex
+	lines   []ringLine // Oldest line is at 'next' once the buffer is full.
+	next    int
+	partial []byte // Data written via Write() not yet ending in a newline.
+}
+
+// A log line held in a RingBuffer.
+type ringLine struct {
+	when time.Time // When the line was written.
+	lev  level     // nLevels if not known.
+	mod  string
+	line []byte // Without the trailing newline.
+}
+
+// NewRingBuffer() returns an output that holds the most recent 'size' log
+// lines in memory (1000 lines if 'size' is not positive).  Add it as an
+// extra output via TeeOutput() (or SetLevelOutput(), etc.):
+//
+//      ring := lager.NewRingBuffer(5000)
+//      lager.SetOutput(lager.TeeOutput(os.Stdout, ring))
+//      lager.Init("FWNAITD")
+//
+// Lines are kept as JSON, as composed (ignoring any global format such as
+// UseConsoleFormat()), along with their level and module.
+//
+func NewRingBuffer(size int) *RingBuffer {
+	if size < 1 {
+		size = 1000
+	}
+	return &RingBuffer{lines: make([]ringLine, 0, size)}
+}
+
+// Write() adds log lines to the buffer.  Lines written directly rather than
+// via a Lager are kept without knowing their level nor module.
+//
+func (r *RingBuffer) Write(data []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.partial = append(r.partial, data...)
+	for {
+		i := bytes.IndexByte(r.partial, '\n')
+		if i < 0 {
+			break
+		}
+		r.add(nLevels, "", r.partial[:i])
+		r.partial = r.partial[i+1:]
+	}
+	if 0 == len(r.partial) {
+		r.partial = nil
+	}
+	return len(data), nil
+}
+
+// Lines() returns the log lines held, oldest first, each ending in a
+// newline.
+//
+func (r *RingBuffer) Lines() [][]byte {
+	lines := r.recent("", "", 0)
+	out := make([][]byte, len(lines))
+	for i, rl := range lines {
+		out[i] = append(append([]byte(nil), rl.line...), '\n')
+	}
+	return out
+}
+
+// Adds a line (copying it).  Must be called with 'mu' locked.
+func (r *RingBuffer) add(lev level, mod string, line []byte) {
+	rl := ringLine{
+		when: time.Now(), lev: lev, mod: mod,
+		line: append([]byte(nil), line...),
+	}
+	if len(r.lines) < cap(r.lines) {
+		r.lines = append(r.lines, rl)
+		return
+	}
+	r.lines[r.next] = rl
+	r.next = (r.next + 1) % len(r.lines)
+}
+
+// Returns up to 'max' (0 for all) of the most recent lines, oldest first,
+// that have one of the 'levels' (if not "") and are from module 'mod' (if
+// not "").
+func (r *RingBuffer) recent(levels, mod string, max int) []ringLine {
+	var want [int(nLevels) + 1]bool
+	for _, c := range []byte(levels) {
+		if lev, ok := letterLevel(c); ok {
+			want[int(lev)] = true
+		}
+	}
+	r.mu.Lock()
+	all := make([]ringLine, 0, len(r.lines))
+	all = append(append(all, r.lines[r.next:]...), r.lines[:r.next]...)
+	r.mu.Unlock()
+	kept := all[:0]
+	for _, rl := range all {
+		if ("" == levels || want[int(rl.lev)]) && ("" == mod || mod == rl.mod) {
+			kept = append(kept, rl)
+		}
+	}
+	if 0 < max && max < len(kept) {
+		kept = kept[len(kept)-max:]
+	}
+	return kept
+}
+
+// The io.Writer used for a log line going to a RingBuffer, so the line's
+// level and module are kept.
+type ringWriter struct {
+	r   *RingBuffer
+	lev level
+	mod string
+	buf []byte
+}
+
+// Collects the log line and adds it to the RingBuffer once it is complete.
+func (rw *ringWriter) Write(data []byte) (int, error) {
+	rw.buf = append(rw.buf, data...)
+	if 0 == len(rw.buf) || '\n' != rw.buf[len(rw.buf)-1] {
+		return len(data), nil
+	}
+	rw.r.mu.Lock()
+	rw.r.add(rw.lev, rw.mod, rw.buf[:len(rw.buf)-1])
+	rw.r.mu.Unlock()
+	rw.buf = rw.buf[:0]
+	return len(data), nil
+}
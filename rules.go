@@ -0,0 +1,246 @@
+package lager
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// LevelRule describes log lines that should be logged at a different log
+// level than the one chosen in the code.  This lets operators tune noisy
+// (or too quiet) logs without code changes.  See SetLevelRules().
+//
+type LevelRule struct {
+	// The levels the rule applies to, as letters from "FWNAITDOG".  An
+	// empty string means all of those levels, but only while they are
+	// enabled; lines from a disabled level are only promoted by rules that
+	// list that level.  Panic and Exit lines are never changed.
+	From string
+
+	// The level to log matching lines at, one letter from "FWNAITDOG".
+	To byte
+
+	// If not "", only lines logged via the Module of this name match.
+	Module string
+
+	// Lines match if this matches the message or the text of any logged
+	// value of type 'error'.
+	Match *regexp.Regexp
+}
+
+// A LevelRule after validation.
+type levelRule struct {
+	from    [int(nLevels)]bool
+	anyFrom bool // 'From' was "" so lines from disabled levels are ignored.
+	to      level
+	mod     string
+	match   *regexp.Regexp
+}
+
+// SetLevelRules() replaces the rules used to change the level of log lines
+// based on their content.  The first rule that matches a line decides the
+// level used.  If that level is not enabled, the line is not logged.
+// Calling SetLevelRules() with no arguments removes all rules.
+//
+// Rules are checked before whether the original level is enabled, so a
+// rule that lists a disabled level in its 'From' can promote lines from
+// that level (such as Debug lines that mention "deadlock" to Warn).  While
+// such a rule exists, the Lager for that disabled level reports Enabled()
+// as 'true' (since it might log) and is no longer free to use, since each
+// of its lines must be checked against the rules.  So a rule with an empty
+// 'From' never applies to disabled levels.
+//
+// For example, to log cancelled requests as Info rather than as Fail:
+//
+//      lager.SetLevelRules(lager.LevelRule{
+//          From: "F", To: 'I', Match: regexp.MustCompile("context canceled"),
+//      })
+//
+// If the environment variable LAGER_LEVEL_RULES is set, then it is parsed
+// via ParseLevelRules() to provide the initial rules.
+//
+func SetLevelRules(rules ...LevelRule) error {
+	compiled, err := compileLevelRules(rules)
+	if nil != err {
+		return err
+	}
+	updateGlobals(func(g *globals) {
+		g.levRules = compiled
+	})
+	return nil
+}
+
+// ParseLevelRules() converts a string into a list of LevelRules.  Rules
+// are separated by ";" and each has the form:
+//
+//      {from}>{to}[@{module}]:{regexp}
+//
+// For example, "F>I:context canceled;FW>D@cache:miss" gives 2 rules.  Use
+// `\x3B` in a regexp to match a literal ";".
+//
+func ParseLevelRules(spec string) ([]LevelRule, error) {
+	rules := make([]LevelRule, 0)
+	for _, r := range strings.Split(spec, ";") {
+		if "" == strings.TrimSpace(r) {
+			continue
+		}
+		colon := strings.Index(r, ":")
+		gt := strings.Index(r, ">")
+		if colon < 0 || gt < 0 || colon < gt {
+			return nil, fmt.Errorf(
+				"Level rule (%s) not in form {from}>{to}[@{mod}]:{regexp}", r)
+		}
+		to, mod := r[gt+1:colon], ""
+		if at := strings.Index(to, "@"); 0 <= at {
+			to, mod = to[:at], to[at+1:]
+		}
+		if 1 != len(to) {
+			return nil, fmt.Errorf(
+				"Level rule (%s) must have 1 letter after '>' not %q", r, to)
+		}
+		re, err := regexp.Compile(r[colon+1:])
+		if nil != err {
+			return nil, fmt.Errorf("Level rule (%s) has bad regexp: %w", r, err)
+		}
+		rules = append(rules, LevelRule{
+			From: strings.TrimSpace(r[:gt]), To: to[0], Module: mod, Match: re,
+		})
+	}
+	return rules, nil
+}
+
+// Validates LevelRules and converts them to the internal form.
+func compileLevelRules(rules []LevelRule) ([]levelRule, error) {
+	if 0 == len(rules) {
+		return nil, nil
+	}
+	compiled := make([]levelRule, len(rules))
+	for i, r := range rules {
+		c := &compiled[i]
+		to, ok := letterLevel(r.To)
+		if !ok || to < lFail {
+			return nil, fmt.Errorf(
+				"LevelRule.To must be one letter from \"FWNAITDOG\" not %q", r.To)
+		}
+		c.to, c.mod, c.match = to, r.Module, r.Match
+		if nil == c.match {
+			return nil, fmt.Errorf("LevelRule.Match must not be nil")
+		}
+		if "" == r.From {
+			c.anyFrom = true
+			for l := lFail; l <= lGuts; l++ {
+				c.from[int(l)] = true
+			}
+		}
+		for _, ch := range []byte(r.From) {
+			if from, ok := letterLevel(ch); ok && lFail <= from {
+				c.from[int(from)] = true
+			}
+		}
+	}
+	return compiled, nil
+}
+
+// Sets the initial level rules from LAGER_LEVEL_RULES.
+func envLevelRules(g *globals) {
+	spec := os.Getenv("LAGER_LEVEL_RULES")
+	if "" == spec {
+		return
+	}
+	rules, err := ParseLevelRules(spec)
+	if nil == err {
+		g.levRules, err = compileLevelRules(rules)
+	}
+	if nil != err {
+		initFatal(g, "Invalid LAGER_LEVEL_RULES", "error", err)
+	}
+}
+
+// Returns whether a rule matches the message or logged errors.
+func (r *levelRule) matches(msg string, args []interface{}) bool {
+	if "" != msg && r.match.MatchString(msg) {
+		return true
+	}
+	for _, arg := range args {
+		if f, ok := arg.(Field); ok && fieldErr == f.kind {
+			arg = f.val
+		}
+		if err, ok := arg.(error); ok && r.match.MatchString(err.Error()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns whether a rule applies to lines logged at 'lev' via 'mod'.
+func (r *levelRule) appliesTo(lev level, mod string) bool {
+	return r.from[int(lev)] && ("" == r.mod || r.mod == mod)
+}
+
+// Returns whether any level rule applies to lines logged at 'lev' via
+// 'mod', so their message is needed to decide their level.
+func (g *globals) rulesApply(lev level, mod string) bool {
+	for i := range g.levRules {
+		if g.levRules[i].appliesTo(lev, mod) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns 'l', the Lager for a log level, unless that level is disabled
+// (so 'l' is a noop) but a level rule might promote its lines.  Then it
+// returns a pending logger, whose lines are only written if promoted.
+func (g *globals) promotable(l Lager, lev level, mod string) Lager {
+	if _, ok := l.(noop); !ok {
+		return l
+	}
+	for i := range g.levRules {
+		r := &g.levRules[i]
+		if !r.anyFrom && r.appliesTo(lev, mod) {
+			return &logger{lev: lev, mod: mod, g: g, pending: true}
+		}
+	}
+	return l
+}
+
+// Applies any level rules to a log line about to be written.  Returns the
+// logger to use, which is 'nil' if the line should not be logged (its new
+// level is not enabled or its level is not enabled and was not changed).
+func (l *logger) relevel(msg string, args []interface{}) *logger {
+	for i := range l.g.levRules {
+		r := &l.g.levRules[i]
+		if l.pending && r.anyFrom || !r.appliesTo(l.lev, l.mod) ||
+			!r.matches(msg, args) {
+			continue
+		}
+		if r.to == l.lev {
+			break
+		}
+		if !l.levelEnabled(r.to) {
+			return nil
+		}
+		cp := *l
+		cp.lev, cp.pending = r.to, false
+		return &cp
+	}
+	if l.pending {
+		return nil
+	}
+	return l
+}
+
+// Returns whether the logger's module (or the global config) has the
+// given log level enabled.
+func (l *logger) levelEnabled(lev level) bool {
+	lagers := &l.g.lagers
+	if "" != l.mod {
+		mod := findMod(l.g.modules(), l.mod)
+		if nil == mod {
+			return false
+		}
+		lagers = &mod.cur().lagers
+	}
+	return lagers[int(lev)].Enabled()
+}
@@ -0,0 +1,126 @@
+package lager_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/Unity-Technologies/go-lager-internal"
+	"github.com/Unity-Technologies/go-tutl-internal"
+)
+
+func TestLevelRules(t *testing.T) {
+	u := tutl.New(t)
+	log := bytes.NewBuffer(nil)
+	defer lager.SetOutput(log)()
+	defer lager.SetLevelRules()
+	lager.Keys("", "", "", "", "", "")
+
+	rules, err := lager.ParseLevelRules(
+		"F>I:context canceled; FW>N@rules:^noisy$")
+	u.Is(nil, err, "parse rules")
+	u.Is(2, len(rules), "parse rules len")
+	if 2 == len(rules) {
+		u.Is("F", rules[0].From, "rule 0 from")
+		u.Is('I', rules[0].To, "rule 0 to")
+		u.Is("", rules[0].Module, "rule 0 module")
+		u.Is("FW", rules[1].From, "rule 1 from")
+		u.Is('N', rules[1].To, "rule 1 to")
+		u.Is("rules", rules[1].Module, "rule 1 module")
+	}
+	_, err = lager.ParseLevelRules("F:I>oops")
+	u.Like(err, "parse bad form", "*not in form")
+	_, err = lager.ParseLevelRules("F>IN:oops")
+	u.Like(err, "parse bad to", "*must have 1 letter")
+	_, err = lager.ParseLevelRules("F>I:(")
+	u.Like(err, "parse bad regexp", "*bad regexp")
+	u.Like(lager.SetLevelRules(lager.LevelRule{To: 'P', Match: rules[0].Match}),
+		"set bad to", "*LevelRule.To must be")
+	u.Like(lager.SetLevelRules(lager.LevelRule{To: 'I'}),
+		"set nil match", "*Match must not be nil")
+
+	u.Is(nil, lager.SetLevelRules(rules...), "set rules")
+	lager.Init("FWNAI")
+	defer lager.Init("FWNA")
+
+	ctx := context.Background()
+	lager.Fail().MMap("Request failed", "err", context.Canceled)
+	u.Like(log.Bytes(), "canceled err downgraded", `^\[[^,]*, "INFO"`)
+	log.Reset()
+
+	lager.Fail().MList("context canceled")
+	u.Like(log.Bytes(), "canceled msg downgraded", `^\[[^,]*, "INFO"`)
+	log.Reset()
+
+	lager.Warn().Map("err", errors.New("context canceled"))
+	u.Like(log.Bytes(), "warn not matched", `^\[[^,]*, "WARN"`)
+	log.Reset()
+
+	lager.Fail(ctx).List("noisy")
+	u.Like(log.Bytes(), "module-only rule", `^\[[^,]*, "FAIL"`)
+	log.Reset()
+
+	mod := lager.NewModule("rules", "FW")
+	mod.Warn().List("noisy")
+	u.Is("", log.String(), "downgraded to disabled level not logged")
+	mod.Fail().List("noisy", "not")
+	u.Like(log.Bytes(), "only whole message matches", `^\[[^,]*, "FAIL"`)
+	log.Reset()
+
+	u.Is(nil, lager.SetLevelRules(lager.LevelRule{
+		From: "I", To: 'W', Match: regexp.MustCompile("disk full"),
+	}), "set upgrade rule")
+	lager.Info().MMap("disk full", "dev", "sda1")
+	u.Like(log.Bytes(), "info upgraded", `^\[[^,]*, "WARN"`)
+	log.Reset()
+}
+
+func TestPromoteDisabledLevel(t *testing.T) {
+	u := tutl.New(t)
+	log := bytes.NewBuffer(nil)
+	defer lager.SetOutput(log)()
+	lager.Keys("", "", "", "", "", "")
+	t.Cleanup(lager.SetLevels("FWN"))
+	t.Cleanup(func() { lager.SetLevelRules() })
+
+	u.Is(nil, lager.SetLevelRules(lager.LevelRule{
+		From: "D", To: 'W', Match: regexp.MustCompile("deadlock"),
+	}), "set promote rule")
+	u.Is(true, lager.Debug().Enabled(), "debug might log")
+	lager.Debug().MMap("Possible deadlock", "waiters", 3)
+	u.Like(log.Bytes(), "debug promoted",
+		`^\[[^,]*, "WARN", "Possible deadlock"`)
+	log.Reset()
+
+	lager.Debug().MMap("Lock acquired", "waiters", 0)
+	lager.Info().MMap("Possible deadlock")
+	u.Is("", log.String(), "unmatched or other level not logged")
+
+	u.Is(nil, lager.SetLevelRules(lager.LevelRule{
+		From: "D", To: 'I', Match: regexp.MustCompile("deadlock"),
+	}), "set rule to disabled level")
+	lager.Debug().MMap("Possible deadlock")
+	u.Is("", log.String(), "promoted to disabled level not logged")
+
+	u.Is(nil, lager.SetLevelRules(lager.LevelRule{
+		From: "D", To: 'W', Module: "locks",
+		Match: regexp.MustCompile("deadlock"),
+	}), "set module rule")
+	lager.NewModule("locks", "FW").Debug().MMap("Possible deadlock")
+	u.Like(log.Bytes(), "module debug promoted", `^\[[^,]*, "WARN"`)
+	log.Reset()
+	lager.Debug().MMap("Possible deadlock")
+	u.Is("", log.String(), "rule only for module")
+
+	u.Is(nil, lager.SetLevelRules(lager.LevelRule{
+		To: 'W', Match: regexp.MustCompile("deadlock"),
+	}), "set rule for any level")
+	u.Is(false, lager.Debug().Enabled(), "any level leaves debug disabled")
+	lager.Debug().MMap("Possible deadlock")
+	u.Is("", log.String(), "any level does not promote disabled lines")
+	lager.Note().MMap("Possible deadlock")
+	u.Like(log.Bytes(), "any level changes enabled lines",
+		`^\[[^,]*, "WARN", "Possible deadlock"`)
+}
@@ -0,0 +1,118 @@
+package lager
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// How many log lines of each level were not written due to sampling.
+var _sampledOut [int(nLevels)]uint64
+
+// SetSampling() keeps only about 1 in 'n' log lines of the given levels,
+// chosen at random, to control log volume in services that log a lot of
+// detail.  'levels' is a string of letters from "WNAITDOG" (Panic, Exit,
+// and Fail lines are never sampled, so "F" and other characters are
+// ignored).  An 'n' of 1 (or less) stops sampling those levels.
+//
+// Each line that is kept (for a sampled level) gets the pairs
+// "sampled":true and "sample_rate":{n}, so that counts can be scaled back
+// up.  Whether a line is kept is decided after any level rules [see
+// SetLevelRules()] have been applied.  Call it once for each rate:
+//
+//      lager.SetSampling("TD", 100)
+//      lager.SetSampling("I", 10)
+//
+// It returns a function that restores the prior rates for 'levels'.
+//
+// If the environment variable LAGER_SAMPLING is set, then it is used as a
+// comma-separated list of "{levels}={n}" settings, like "TD=100,I=10".
+//
+func SetSampling(levels string, n int) func() {
+	var prior [int(nLevels)]uint32
+	updateGlobals(func(g *globals) {
+		prior = g.sample
+		setSampling(levels, n)(g)
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			for _, c := range []byte(levels) {
+				if lev, ok := letterLevel(c); ok {
+					g.sample[int(lev)] = prior[int(lev)]
+				}
+			}
+		})
+	}
+}
+
+// How sampling rates are updated safely.
+func setSampling(levels string, n int) func(*globals) {
+	rate := uint32(1)
+	if 1 < n {
+		rate = uint32(n)
+	}
+	return func(g *globals) {
+		for _, c := range []byte(levels) {
+			if lev, ok := letterLevel(c); ok && lFail < lev {
+				g.sample[int(lev)] = rate
+			}
+		}
+	}
+}
+
+// SampledOut() returns how many log lines of each level were not written
+// due to sampling [see SetSampling()].  The keys are level names (like
+// "DEBUG") and only levels with such lines are included.
+//
+func SampledOut() map[string]uint64 {
+	counts := make(map[string]uint64)
+	for l := lWarn; l < nLevels; l++ {
+		if n := atomic.LoadUint64(&_sampledOut[int(l)]); 0 < n {
+			counts[l.String()] = n
+		}
+	}
+	return counts
+}
+
+// Sets the initial sampling rates from LAGER_SAMPLING.
+func envSampling(g *globals) {
+	spec := os.Getenv("LAGER_SAMPLING")
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if "" == part {
+			continue
+		}
+		eq := strings.Index(part, "=")
+		n := 0
+		err := fmt.Errorf("expected {levels}={n} not %q", part)
+		if 0 < eq {
+			n, err = strconv.Atoi(part[eq+1:])
+		}
+		if nil != err {
+			initFatal(g, "Invalid LAGER_SAMPLING", "error", err)
+			return
+		}
+		setSampling(part[:eq], n)(g)
+	}
+}
+
+// Decides whether a log line is kept when its level is sampled.  Returns
+// the logger to use, which is 'nil' if the line is not to be written.
+func (l *logger) sample() *logger {
+	if nil == l {
+		return nil
+	}
+	rate := l.g.sample[int(l.lev)]
+	if rate <= 1 {
+		return l
+	} else if 0 != rand.Int63n(int64(rate)) {
+		atomic.AddUint64(&_sampledOut[int(l.lev)], 1)
+		return nil
+	}
+	cp := *l
+	cp.kvp = cp.kvp.AddPairs("sampled", true, "sample_rate", rate)
+	return &cp
+}
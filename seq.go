@@ -0,0 +1,100 @@
+package lager
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// The name of the environment variable used to pass sequencing to a child.
+const seqEnv = "LAGER_SEQ"
+
+// Numbers the log lines of this process [see EnableSequencing()].
+type sequencer struct {
+	proc     string // Like "0" for the first process, "0.2" for its 2nd child.
+	next     uint64 // The number for the next log line.
+	children uint32 // How many child processes have been given a number.
+}
+
+// EnableSequencing() adds "seq" and "seq_proc" pairs to each log line so
+// that the logs of a process and of the worker processes that it starts
+// can be put in a total order when they are reassembled.  "seq_proc"
+// identifies the process, like "0" for the first process, "0.1" and "0.2"
+// for the first two workers that it started, and "0.1.1" for the first
+// worker started by "0.1".  "seq" counts the log lines written by the
+// process.
+//
+// Use ChildSequenceEnv() to pass sequencing to each worker process.  Each
+// worker's "seq" starts where its parent's "seq" was when the worker was
+// started, so sorting by "seq" and then by "seq_proc" puts each worker's
+// lines after the lines its parent logged before starting it.
+//
+// A process started with LAGER_SEQ set [by ChildSequenceEnv()] acts as if
+// EnableSequencing() had been called when the program starts.  Calling
+// EnableSequencing() when sequencing is already enabled does not change
+// the numbering.  It returns a function that restores the prior setting:
+//
+//      defer lager.EnableSequencing()()
+//
+func EnableSequencing() func() {
+	var prior *sequencer
+	updateGlobals(func(g *globals) {
+		prior = g.seq
+		if nil == g.seq {
+			g.seq = &sequencer{proc: "0"}
+		}
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.seq = prior
+		})
+	}
+}
+
+// ChildSequenceEnv() returns a "LAGER_SEQ={proc}:{seq}" setting to add to
+// the environment of a worker process so that its log lines continue this
+// process's sequencing [see EnableSequencing(), which it calls if needed].
+// Call it once per worker, since each call assigns a new "seq_proc":
+//
+//      cmd := exec.Command(worker)
+//      cmd.Env = append(os.Environ(), lager.ChildSequenceEnv())
+//
+func ChildSequenceEnv() string {
+	seq := getGlobals().seq
+	if nil == seq {
+		EnableSequencing()
+		seq = getGlobals().seq
+	}
+	child := atomic.AddUint32(&seq.children, 1)
+	return fmt.Sprintf("%s=%s.%d:%d",
+		seqEnv, seq.proc, child, atomic.LoadUint64(&seq.next))
+}
+
+// Sets up sequencing from LAGER_SEQ (if set).
+func envSequence(g *globals) {
+	env := os.Getenv(seqEnv)
+	if "" == env {
+		return
+	}
+	colon := strings.LastIndex(env, ":")
+	var start uint64
+	err := fmt.Errorf("expected {proc}:{seq}")
+	if 0 < colon {
+		start, err = strconv.ParseUint(env[colon+1:], 10, 64)
+	}
+	if nil != err {
+		initFatal(g, "Invalid "+seqEnv, "error", err, "got", env)
+		return
+	}
+	g.seq = &sequencer{proc: env[:colon], next: start}
+}
+
+// Returns a copy of the logger with the "seq" and "seq_proc" pairs added.
+func (l *logger) withSeq() *logger {
+	n := atomic.AddUint64(&l.g.seq.next, 1) - 1
+	cp := *l
+	cp.kvp = cp.kvp.AddPairs("seq", n, "seq_proc", l.g.seq.proc)
+	return &cp
+}
@@ -0,0 +1,182 @@
+package lager
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LogSession is a long-lived logging scope for something like a WebSocket
+// connection or a gRPC stream that outlives any one request.  It counts
+// messages and errors and logs summaries of them.  Use lager.Session() to
+// get a LogSession.
+//
+type LogSession struct {
+	ctx   Ctx
+	start time.Time
+	mu    sync.Mutex
+	keys  []string // Counter names in the order they were first used.
+	total map[string]int64
+	stop  chan struct{}
+	ended bool
+}
+
+// How often a LogSession logs unless SetInterval() is used.
+const defaultSessionInterval = time.Minute
+
+// Session() starts a LogSession, adding the pair 'key' and 'id' (such as
+// "conn" and a connection ID) to 'ctx' for use in all of the session's log
+// lines.  An Info "Session started" line is logged.  Then, once per minute
+// [see SetInterval()], an Info "Session active" line is logged with the
+// counts so far, and a Note "Session ended" line with the final counts is
+// logged when End() is called:
+//
+//      sess := lager.Session(ctx, "conn", connID)
+//      defer sess.End(nil)
+//      for {
+//          msg, err := ws.Read(sess.Ctx())
+//          if nil != err {
+//              sess.End(err)
+//              return
+//          }
+//          sess.In()
+//          // ...
+//      }
+//
+// could log:
+//
+//      ["2019-12-31 23:59:59.1234Z", "NOTE", "Session ended",
+//          {"duration":"321.500s", "msgsIn":1802, "msgsOut":1799,
+//          "errors":2, "error":"EOF"}, {"conn":"c7"}]
+//
+// Always call End() (or the periodic logging goroutine is never stopped).
+//
+func Session(ctx Ctx, key string, id interface{}) *LogSession {
+	if nil == ctx {
+		ctx = context.Background()
+	}
+	s := &LogSession{
+		ctx:   AddPairs(ctx, key, id),
+		start: time.Now(),
+		total: make(map[string]int64),
+	}
+	Info(s.ctx).MMap("Session started")
+	s.SetInterval(defaultSessionInterval)
+	return s
+}
+
+// Ctx() returns the Context for the session, which includes the session's
+// pair, for logging things that happen during the session.
+//
+func (s *LogSession) Ctx() Ctx { return s.ctx }
+
+// In() counts messages received (1 or the sum of the passed-in values) as
+// "msgsIn".
+//
+func (s *LogSession) In(n ...int64) { s.Count("msgsIn", n...) }
+
+// Out() counts messages sent (1 or the sum of the passed-in values) as
+// "msgsOut".
+//
+func (s *LogSession) Out(n ...int64) { s.Count("msgsOut", n...) }
+
+// Error() counts an error as "errors" and logs it as a Warn line (unless
+// 'err' is 'nil', in which case nothing is done).
+//
+func (s *LogSession) Error(err error) {
+	if nil == err {
+		return
+	}
+	s.Count("errors")
+	Warn(s.ctx).MMap("Session error", "error", err)
+}
+
+// Count() adds 1 (or the sum of the passed-in values) to the session's
+// counter for 'key'.  Counters are logged in the order they were first
+// used.
+//
+func (s *LogSession) Count(key string, n ...int64) {
+	add := int64(1)
+	if 0 < len(n) {
+		add = 0
+		for _, i := range n {
+			add += i
+		}
+	}
+	defer AutoLock(&s.mu)()
+	if _, ok := s.total[key]; !ok {
+		s.keys = append(s.keys, key)
+	}
+	s.total[key] += add
+}
+
+// SetInterval() changes how often a "Session active" line is logged.  An
+// 'every' of 0 (or less) turns off these periodic lines.  Returns the
+// LogSession so calls can be chained.
+//
+func (s *LogSession) SetInterval(every time.Duration) *LogSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if nil != s.stop {
+		close(s.stop)
+		s.stop = nil
+	}
+	if every <= 0 || s.ended {
+		return s
+	}
+	stop := make(chan struct{})
+	s.stop = stop
+	go func() {
+		tick := time.NewTicker(every)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				Info(s.ctx).MMap("Session active", s.pairs(nil)...)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// End() stops the periodic logging and logs a Note "Session ended" line
+// with the session's duration and counts.  If 'err' is not 'nil', it is
+// included as "error" (and the line is logged at the Warn level).  Only the
+// first call to End() logs anything.
+//
+func (s *LogSession) End(err error) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	if nil != s.stop {
+		close(s.stop)
+		s.stop = nil
+	}
+	s.mu.Unlock()
+	lg := Note(s.ctx)
+	if nil != err {
+		lg = Warn(s.ctx)
+	}
+	lg.MMap("Session ended", s.pairs(err)...)
+}
+
+// Returns the pairs to log for the session so far.
+func (s *LogSession) pairs(err error) []interface{} {
+	defer AutoLock(&s.mu)()
+	pairs := make([]interface{}, 0, 4+2*len(s.keys))
+	pairs = append(pairs, "duration",
+		fmt.Sprintf("%.3fs", time.Since(s.start).Seconds()))
+	for _, k := range s.keys {
+		pairs = append(pairs, k, s.total[k])
+	}
+	if nil != err {
+		pairs = append(pairs, "error", err)
+	}
+	return pairs
+}
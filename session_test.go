@@ -0,0 +1,53 @@
+package lager_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Unity-Technologies/go-lager-internal"
+	"github.com/Unity-Technologies/go-tutl-internal"
+)
+
+func TestSession(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	defer lager.Init("FWNA")
+	lager.Init("FWNAI")
+	log := new(syncBuffer)
+	defer lager.SetOutput(log)()
+
+	ctx := lager.AddPairs(context.Background(), "svc", "chat")
+	s := lager.Session(ctx, "conn", "c7").SetInterval(0)
+	u.Like(lager.MarshalPairs(s.Ctx()), "ctx", `*{"svc":"chat", "conn":"c7"}`)
+	s.In()
+	s.In(2, 3)
+	s.Out()
+	s.Error(nil)
+	s.Error(errors.New("bad frame"))
+	s.End(errors.New("EOF"))
+	s.End(nil)
+
+	lines := strings.Split(log.String(), "\n")
+	if u.Is(4, len(lines), "lines") {
+		u.Like(lines[0], "start", `"INFO", "Session started", `+
+			`{"svc":"chat", "conn":"c7"}`)
+		u.Like(lines[1], "error", `"WARN", "Session error", `+
+			`{"error":"bad frame"}`)
+		u.Like(lines[2], "end", `"WARN", "Session ended", {"duration":"0[.]`,
+			`*s", "msgsIn":6, "msgsOut":1, "errors":1, "error":"EOF"}`)
+	}
+	log.Reset()
+
+	s = lager.Session(nil, "stream", 3).SetInterval(5 * time.Millisecond)
+	s.Count("frames")
+	for i := 0; i < 100 && !strings.Contains(log.String(), "active"); i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	s.End(nil)
+	u.Like(log.String(), "periodic",
+		`*"INFO", "Session active", {"duration":"`, `"frames":1}`,
+		`*"NOTE", "Session ended", {"duration":"`)
+}
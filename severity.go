@@ -0,0 +1,137 @@
+package lager
+
+import (
+	"os"
+	"strings"
+)
+
+// SetSeverityNumbers() causes each log line to include the level as an
+// integer so that downstream filters can do numeric comparisons (such as
+// "severity <= 4").  'mapper' takes a Lager level name (like "WARN") and
+// returns its number, such as SyslogSeverity() or OtelSeverity().
+//
+// If 'key' is "", then the number is logged instead of the textual level
+// [see SetLevelNotation()].  Otherwise, the textual level is still logged
+// and the number is added after it under 'key', but only when logging a
+// JSON map [see Keys()]:
+//
+//      lager.Keys("time", "level", "msg", "data", "", "module")
+//      lager.SetSeverityNumbers(lager.SyslogSeverity, "severity")
+//      // {"time":"...", "level":"WARN", "severity":4, "msg":"Slow", ...}
+//
+// Passing in 'nil' for 'mapper' goes back to only logging the textual
+// level.  Note that log sinks that get the level from each line [like
+// otlp-logs] need the textual level.  It returns a function that restores
+// the prior settings:
+//
+//      defer lager.SetSeverityNumbers(lager.OtelSeverity, "")()
+//
+// Setting LAGER_SEVERITY_NUMBERS in the environment to "syslog" or "otel"
+// (optionally followed by a comma and the key, like "otel,severity") has
+// the same effect as calling SetSeverityNumbers() when the program starts.
+//
+func SetSeverityNumbers(mapper func(string) int, key string) func() {
+	var prior func(string) int
+	var priorKey string
+	updateGlobals(func(g *globals) {
+		prior, priorKey = g.sevNum, g.sevKey
+		g.sevNum, g.sevKey = mapper, key
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.sevNum, g.sevKey = prior, priorKey
+		})
+	}
+}
+
+// Reads LAGER_SEVERITY_NUMBERS.
+func envSeverityNumbers(g *globals) {
+	val := os.Getenv("LAGER_SEVERITY_NUMBERS")
+	if "" == val {
+		return
+	}
+	parts := strings.SplitN(val, ",", 2)
+	switch parts[0] {
+	case "syslog":
+		g.sevNum = SyslogSeverity
+	case "otel":
+		g.sevNum = OtelSeverity
+	default:
+		initFatal(g,
+			"LAGER_SEVERITY_NUMBERS must be syslog or otel", "not", val)
+		return
+	}
+	if 2 == len(parts) {
+		g.sevKey = parts[1]
+	}
+}
+
+// SyslogSeverity takes a Lager level name (only the first letter matters
+// and it must be upper case) and returns the corresponding syslog severity
+// (RFC 5424), where smaller numbers are more severe.  Levels are mapped as:
+//      Not used: Emergency (0) and Alert (1)
+//      Panic, Exit - Critical (2)
+//      Fail - Error (3)
+//      Warn - Warning (4)
+//      Note - Notice (5)
+//      Access, Info - Informational (6)
+//      Trace, Debug, Obj, Guts - Debug (7)
+//      If an invalid level name is passed: Debug (7)
+//
+func SyslogSeverity(lev string) int {
+	switch lev[0] {
+	case 'P', 'E':
+		return 2
+	case 'F':
+		return 3
+	case 'W':
+		return 4
+	case 'N':
+		return 5
+	case 'A', 'I':
+		return 6
+	}
+	return 7
+}
+
+// OtelSeverity takes a Lager level name (only the first letter matters and
+// it must be upper case) and returns the corresponding OpenTelemetry
+// severity number, where larger numbers are more severe.  Levels are
+// mapped as:
+//      Panic - FATAL3 (23)
+//      Exit - FATAL (21)
+//      Fail - ERROR (17)
+//      Warn - WARN (13)
+//      Note - INFO2 (10)
+//      Access, Info - INFO (9)
+//      Debug - DEBUG (5)
+//      Obj - DEBUG2 (6)
+//      Guts - DEBUG3 (7)
+//      Trace - TRACE (1)
+//      If an invalid level name is passed: UNSPECIFIED (0)
+//
+func OtelSeverity(lev string) int {
+	switch lev[0] {
+	case 'P':
+		return 23
+	case 'E':
+		return 21
+	case 'F':
+		return 17
+	case 'W':
+		return 13
+	case 'N':
+		return 10
+	case 'A', 'I':
+		return 9
+	case 'D':
+		return 5
+	case 'O':
+		return 6
+	case 'G':
+		return 7
+	case 'T':
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,83 @@
+// +build !windows
+
+package lager
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// EnableSignalLevelToggle() lets the verbosity of a running process be
+// changed by sending it signals, for debugging a production incident
+// without restarting.  After a SIGUSR1, the levels in 'levels' are enabled
+// in addition to the ones already enabled.  After a SIGUSR2, the levels
+// that were enabled before the SIGUSR1 are restored.  If 'levels' is "",
+// then "ITD" (Info, Trace, and Debug) is used.  For example:
+//
+//      defer lager.EnableSignalLevelToggle("")()
+//
+// then, from a shell:
+//
+//      kill -USR1 $pid     # Turn on Info, Trace, and Debug logs.
+//      kill -USR2 $pid     # Go back to the prior levels.
+//
+// A Note line is logged for each change.  Additional SIGUSR1 signals are
+// ignored until a SIGUSR2 is received.  This only changes the global
+// levels [see SetLevels()], not the levels of any Module.
+//
+// It returns a function that stops handling the signals (and restores the
+// prior levels if verbosity was still raised).  On Windows, which has no
+// SIGUSR1 nor SIGUSR2, it does nothing.
+//
+func EnableSignalLevelToggle(levels string) func() {
+	if "" == levels {
+		levels = "ITD"
+	}
+	sigs := make(chan os.Signal, 8)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	var (
+		mu      sync.Mutex
+		restore func()
+	)
+	toggle := func(sig os.Signal) {
+		defer AutoLock(&mu)()
+		if syscall.SIGUSR1 == sig {
+			if nil != restore {
+				return
+			}
+			restore = SetLevels(GetLevels() + levels)
+			Note().MMap("Raised log levels due to signal",
+				"signal", sig.String(), "levels", GetLevels())
+		} else if nil != restore {
+			restore()
+			restore = nil
+			Note().MMap("Restored log levels due to signal",
+				"signal", sig.String(), "levels", GetLevels())
+		}
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case sig := <-sigs:
+				toggle(sig)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(stop)
+		<-done
+		defer AutoLock(&mu)()
+		if nil != restore {
+			restore()
+			restore = nil
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// +build !windows
+
+package lager_test
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Unity-Technologies/go-lager-internal"
+	"github.com/Unity-Technologies/go-tutl-internal"
+)
+
+// Waits up to 2 seconds for the global levels to become 'want'.
+func waitLevels(want string) string {
+	for i := 0; i < 200 && want != lager.GetLevels(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return lager.GetLevels()
+}
+
+func TestSignalLevelToggle(t *testing.T) {
+	u := tutl.New(t)
+	lager.Keys("", "", "", "", "", "")
+	defer lager.Init("FWNA")
+	lager.Init("FWNA")
+	log := new(syncBuffer)
+	defer lager.SetOutput(log)()
+
+	stop := lager.EnableSignalLevelToggle("")
+	kill := func(sig syscall.Signal) {
+		u.Is(nil, syscall.Kill(syscall.Getpid(), sig), "kill")
+	}
+	kill(syscall.SIGUSR1)
+	u.Is("FWNAITD", waitLevels("FWNAITD"), "raised")
+	kill(syscall.SIGUSR1)
+	// Pending signals are not delivered in order, so give it time:
+	time.Sleep(50 * time.Millisecond)
+	kill(syscall.SIGUSR2)
+	u.Is("FWNA", waitLevels("FWNA"), "restored")
+	kill(syscall.SIGUSR1)
+	u.Is("FWNAITD", waitLevels("FWNAITD"), "raised again")
+	stop()
+	u.Is("FWNA", lager.GetLevels(), "stop restores")
+
+	lines := strings.Split(log.String(), "\n")
+	if u.Is(4, len(lines), "lines") {
+		u.Like(lines[0], "raise", `"NOTE", "Raised log levels due to signal",`,
+			`*{"signal":"user defined signal 1", "levels":"FWNAITD"}`)
+		u.Like(lines[1], "restore", `"Restored log levels due to signal",`,
+			`*{"signal":"user defined signal 2", "levels":"FWNA"}`)
+		u.Like(lines[2], "again", `"Raised log levels due to signal",`)
+		u.Is("", lines[3], "last")
+	}
+}
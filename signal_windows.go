@@ -0,0 +1,8 @@
+package lager
+
+// EnableSignalLevelToggle() does nothing on Windows, which has no SIGUSR1
+// nor SIGUSR2.  See the documentation for other platforms.
+//
+func EnableSignalLevelToggle(levels string) func() {
+	return func() {}
+}
@@ -0,0 +1,241 @@
+package lager
+
+import (
+	"os"
+	"reflect"
+	"strconv"
+)
+
+// How many bytes to assume for a value whose size is not known without
+// encoding it (like a number or a Stringer).
+const guessSize = 16
+
+// How deeply EstimateSize() looks into nested values.
+const maxSizeDepth = 10
+
+// EstimateSize() returns a rough estimate of how many bytes the passed-in
+// values will take up when logged as JSON (not counting the timestamp,
+// level, nor context pairs).  It is much cheaper than encoding the values,
+// since it looks at the lengths of strings, lists, and maps (including
+// nested ones) but does not escape strings nor format numbers.  Values
+// whose size cannot be cheaply determined (such as from String() methods
+// or json.Marshal()) are assumed to be small.
+//
+func EstimateSize(pairs ...interface{}) int {
+	return estimateSize(pairs, 0)
+}
+
+// Returns the estimated size of 'vals', stopping early once it is over
+// 'limit' (if 'limit' is positive).
+func estimateSize(vals []interface{}, limit int) int {
+	e := sizer{limit: limit}
+	for _, v := range vals {
+		if e.add(v, 0) {
+			break
+		}
+		e.n += 2 // ", "
+	}
+	return e.n
+}
+
+// Totals the estimated sizes of values.
+type sizer struct {
+	n, limit int
+}
+
+// Adds the estimated size of 'v'.  Returns 'true' once over the limit.
+func (e *sizer) add(v interface{}, depth int) bool {
+	switch x := v.(type) {
+	case nil:
+		e.n += 4
+	case string:
+		e.n += 2 + len(x)
+	case []byte:
+		e.n += 2 + len(x)
+	case bool:
+		e.n += 5
+	case []string:
+		e.n += 2
+		for _, s := range x {
+			e.n += 4 + len(s)
+		}
+	case AList:
+		e.n += 2
+		for _, elt := range x {
+			if e.nested(elt, depth) {
+				return true
+			}
+		}
+	case RawMap:
+		e.n += 2
+		for _, elt := range x {
+			if e.nested(elt, depth) {
+				return true
+			}
+		}
+	case AMap:
+		e.n += 2
+		if nil != x {
+			for i, k := range x.keys {
+				e.n += 4 + len(k)
+				if e.nested(x.vals[i], depth) {
+					return true
+				}
+			}
+		}
+	case map[string]interface{}:
+		e.n += 2
+		for k, elt := range x {
+			e.n += 4 + len(k)
+			if e.nested(elt, depth) {
+				return true
+			}
+		}
+	case Field:
+		e.n += 4 + len(x.key)
+		switch x.kind {
+		case fieldStr:
+			e.n += 2 + len(x.str)
+		case fieldErr, fieldAny, fieldGroup:
+			return e.add(x.val, depth)
+		default:
+			e.n += guessSize
+		}
+	case error:
+		e.n += 2 + len(x.Error())
+	case Stringer, Valuer, func() interface{}:
+		e.n += guessSize
+	default:
+		e.reflected(reflect.ValueOf(v), depth)
+	}
+	return 0 < e.limit && e.limit < e.n
+}
+
+// Adds the estimated size of an element of a list or map.
+func (e *sizer) nested(v interface{}, depth int) bool {
+	e.n += 2
+	if maxSizeDepth <= depth {
+		e.n += guessSize
+		return 0 < e.limit && e.limit < e.n
+	}
+	return e.add(v, depth+1)
+}
+
+// Adds the estimated size of a value of a type not handled by add().
+func (e *sizer) reflected(v reflect.Value, depth int) {
+	if maxSizeDepth <= depth {
+		e.n += guessSize
+		return
+	}
+	switch v.Kind() {
+	case reflect.String:
+		e.n += 2 + v.Len()
+	case reflect.Slice, reflect.Array:
+		e.n += 2
+		for i := 0; i < v.Len(); i++ {
+			if e.nested(v.Index(i).Interface(), depth) {
+				return
+			}
+		}
+	case reflect.Map:
+		e.n += 2
+		iter := v.MapRange()
+		for iter.Next() {
+			e.n += guessSize
+			if e.nested(iter.Value().Interface(), depth) {
+				return
+			}
+		}
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			e.reflected(v.Elem(), depth+1)
+		} else {
+			e.n += 4
+		}
+	case reflect.Struct:
+		e.n += 2
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if "" != t.Field(i).PkgPath {
+				continue // Not exported so not logged.
+			}
+			e.n += 4 + len(t.Field(i).Name)
+			if e.nested(v.Field(i).Interface(), depth) {
+				return
+			}
+		}
+	default:
+		e.n += guessSize
+	}
+}
+
+// SetMaxLineSize() sets a hard cap on the size of log lines.  Before a log
+// line is composed, its message, values, and context pairs are measured
+// via EstimateSize() and, if the estimate is over 'max' bytes, the line is
+// not written.  Instead, a Warn line reporting the rejection is written
+// (from the same Module and with the same context pairs, but without the
+// other values):
+//
+//      ["2021-06-09 13:10:07.0447Z", "WARN", "Log line too large",
+//          {"msg":"Got response", "level":"DEBUG", "size":248153,
+//          "max":65536}]
+//
+// This protects against the slow path taken for lines too large for the
+// 16KiB buffer used to compose each log line, such as when a whole
+// response body is accidentally logged.  A 'max' of 0 (the default)
+// removes the cap.  It returns a function that restores the prior cap:
+//
+//      defer lager.SetMaxLineSize(64*1024)()
+//
+// Setting LAGER_MAX_LINE_SIZE in the environment (to a number of bytes)
+// has the same effect as calling SetMaxLineSize() when the program starts.
+//
+func SetMaxLineSize(max int) func() {
+	var prior int
+	updateGlobals(func(g *globals) {
+		prior = g.maxSize
+		g.maxSize = max
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.maxSize = prior
+		})
+	}
+}
+
+// Sets the initial cap on log line size from LAGER_MAX_LINE_SIZE.
+func envMaxLineSize(g *globals) {
+	env := os.Getenv("LAGER_MAX_LINE_SIZE")
+	if "" == env {
+		return
+	}
+	max, err := strconv.Atoi(env)
+	if nil != err || max < 0 {
+		initFatal(g, "Invalid LAGER_MAX_LINE_SIZE",
+			"expected", "a number of bytes", "got", env)
+		return
+	}
+	g.maxSize = max
+}
+
+// Returns whether a log line is over the cap on log line size, in which
+// case the rejection is logged instead.
+func (l *logger) oversized(msg string, args []interface{}) bool {
+	if l.g.maxSize <= 0 {
+		return false
+	}
+	size := 2 + len(msg) + estimateSize(args, l.g.maxSize)
+	if kvp := l.ctxPairs(); nil != kvp && size <= l.g.maxSize {
+		size += estimateSize(AList{kvp}, l.g.maxSize)
+	}
+	if size <= l.g.maxSize {
+		return false
+	}
+	g := *l.g
+	g.maxSize = 0 // So the report itself is never rejected.
+	rej := *l
+	rej.lev, rej.g = lWarn, &g
+	rej.MMap("Log line too large", "msg", msg, "level", l.lev.String(),
+		"size", size, "max", l.g.maxSize)
+	return true
+}
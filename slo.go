@@ -0,0 +1,129 @@
+package lager
+
+import (
+	"net/url"
+	"path"
+	"time"
+)
+
+// SLO gives the latency objective for the requests to a route.  See
+// SetSLOs().
+//
+type SLO struct {
+	// A pattern, as for path.Match(), for the path of request URLs, like
+	// "/api/v1/users/*".  An empty string matches every request.
+	Route string
+
+	// Requests to the route that take longer than this violate the SLO.
+	Latency time.Duration
+}
+
+// SetSLOs() sets per-route latency objectives that are checked for each
+// access log line [see Acc()] that includes a "httpRequest" value from
+// GcpHttp() with a latency [such as those from GcpLogAccess()], whether it
+// is passed to Map() or MMap() or is among the context pairs.  The path
+// from the "requestUrl" is compared against the Route of each SLO, in
+// order, and the first one that matches is used.  If the latency is over
+// that SLO's Latency, then the line is annotated so that SLO breaches are
+// easy to query for in any log backend:
+//
+//      defer lager.SetSLOs(
+//          lager.SLO{Route: "/api/v1/search", Latency: time.Second},
+//          lager.SLO{Latency: 250*time.Millisecond},
+//      )()
+//
+//      ["2021-06-09 13:10:07.0447Z", "ACCESS", "Response sent",
+//          {"User":"bob"},
+//          {"httpRequest":{..., "latency":"0.3129s", ...},
+//          "slo.violated":true, "slo.threshold":"250ms"}]
+//
+// Lines that do not violate an SLO are not changed.  Calling SetSLOs()
+// replaces any prior SLOs; calling it with no SLOs removes them.  It
+// returns a function that restores the prior SLOs.
+//
+func SetSLOs(slos ...SLO) func() {
+	if 0 == len(slos) {
+		slos = nil
+	} else {
+		slos = append([]SLO(nil), slos...)
+	}
+	var prior []SLO
+	updateGlobals(func(g *globals) {
+		prior = g.slos
+		g.slos = slos
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			g.slos = prior
+		})
+	}
+}
+
+// Annotates an access log line that violates an SLO [see SetSLOs()].
+// 'pairs' is the list of key/value pairs passed to Map() or MMap() ('nil'
+// for List() and MList()).  Returns the logger to use.
+func (l *logger) slo(pairs []interface{}) *logger {
+	if nil == l || nil == l.g.slos || lAcc != l.lev {
+		return l
+	}
+	req, _ := pairValue(pairs, "httpRequest")
+	if nil == req {
+		req = l.ctxPairs().Get("httpRequest")
+	}
+	lag, uri := reqField(req, "latency"), reqField(req, "requestUrl")
+	var latency time.Duration
+	switch x := lag.(type) {
+	case time.Duration:
+		latency = x
+	case string:
+		var err error
+		if latency, err = time.ParseDuration(x); nil != err {
+			return l
+		}
+	case RawMap, AMap: // From SetGcpLatencyFormat("object").
+		secs, ok := reqField(x, "seconds").(int64)
+		nanos, ok2 := reqField(x, "nanos").(int64)
+		if !ok || !ok2 {
+			return l
+		}
+		latency = time.Duration(secs)*time.Second + time.Duration(nanos)
+	default:
+		return l
+	}
+	route := ""
+	if s, ok := uri.(string); ok {
+		if u, err := url.Parse(s); nil == err {
+			route = u.Path
+		}
+	}
+	for _, s := range l.g.slos {
+		if "" != s.Route {
+			if ok, _ := path.Match(s.Route, route); !ok {
+				continue
+			}
+		}
+		if latency <= s.Latency {
+			return l
+		}
+		cp := *l
+		cp.kvp = cp.kvp.AddPairs(
+			"slo.violated", true, "slo.threshold", s.Latency.String())
+		return &cp
+	}
+	return l
+}
+
+// Returns the value for 'key' in a "httpRequest" value (or 'nil').
+func reqField(req interface{}, key string) interface{} {
+	switch x := req.(type) {
+	case RawMap:
+		for i := 0; i+1 < len(x); i += 2 {
+			if key == x[i] {
+				return x[i+1]
+			}
+		}
+	case AMap:
+		return x.Get(key)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+/*
+Package hec provides a Lager output that posts log lines to a Splunk HTTP
+Event Collector (HEC).  Each Lager log line becomes the "event" of one HEC
+event and the Lager timestamp is used for the HEC "time" field:
+
+	sink, err := hec.New(hec.Config{
+		URL:   "https://splunk.example.com:8088/services/collector/event",
+		Token: os.Getenv("SPLUNK_HEC_TOKEN"),
+	})
+	if nil != err {
+		lager.Exit().MMap("Can't create Splunk HEC sink", "err", err)
+	}
+	defer sink.Close()
+	defer lager.SetOutput(sink)()
+
+Lines are sent in batches and failed batches are retried.
+*/
+package hec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Unity-Technologies/go-lager-internal/batch"
+)
+
+// Config describes where and how to send log lines.
+type Config struct {
+	// The URL of the HEC endpoint (usually ending in
+	// "/services/collector/event").  Required.
+	URL string
+
+	// The HEC token to authenticate with.  Required.
+	Token string
+
+	// Optional values for the "index", "source", "sourcetype", and "host"
+	// fields of each event.
+	Index, Source, SourceType, Host string
+
+	// The http.Client to use (default is http.DefaultClient).
+	Client *http.Client
+
+	// How lines are batched and retried.
+	batch.Options
+}
+
+// Sink is an io.Writer suitable for passing to lager.SetOutput().
+type Sink struct {
+	*batch.Writer
+	conf Config
+	meta []byte // Extra fields to add to each event.
+}
+
+// New() returns a Sink that sends log lines as configured.  Call Close()
+// on it when done so that any pending lines get sent.
+//
+func New(conf Config) (*Sink, error) {
+	if "" == conf.URL || "" == conf.Token {
+		return nil, fmt.Errorf("hec.New() requires both a URL and a Token")
+	}
+	if nil == conf.Client {
+		conf.Client = http.DefaultClient
+	}
+	s := &Sink{conf: conf}
+	for _, f := range []struct{ key, val string }{
+		{"host", conf.Host}, {"source", conf.Source},
+		{"sourcetype", conf.SourceType}, {"index", conf.Index},
+	} {
+		if "" != f.val {
+			val, _ := json.Marshal(f.val)
+			s.meta = append(s.meta, `,"`+f.key+`":`...)
+			s.meta = append(s.meta, val...)
+		}
+	}
+	s.Writer = batch.New(s.send, conf.Options)
+	return s, nil
+}
+
+// Posts one batch of lines to the HEC endpoint.
+func (s *Sink) send(lines [][]byte) error {
+	body := new(bytes.Buffer)
+	for _, line := range lines {
+		s.encode(body, line)
+	}
+	req, err := http.NewRequest("POST", s.conf.URL, body)
+	if nil != err {
+		return batch.Permanent(err)
+	}
+	req.Header.Set("Authorization", "Splunk "+s.conf.Token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.conf.Client.Do(req)
+	if nil != err {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if 200 <= resp.StatusCode && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("Splunk HEC returned %s: %s",
+		resp.Status, strings.TrimSpace(string(msg)))
+	if 400 <= resp.StatusCode && resp.StatusCode < 500 &&
+		http.StatusTooManyRequests != resp.StatusCode &&
+		http.StatusRequestTimeout != resp.StatusCode {
+		return batch.Permanent(err)
+	}
+	return err
+}
+
+// Appends one HEC event to the request body.
+func (s *Sink) encode(body *bytes.Buffer, line []byte) {
+	body.WriteString(`{`)
+	if t, ok := batch.LineTime(line); ok {
+		ms := t.UnixNano() / int64(time.Millisecond)
+		body.WriteString(`"time":`)
+		body.WriteString(strconv.FormatInt(ms/1000, 10))
+		body.WriteString(fmt.Sprintf(".%03d,", ms%1000))
+	}
+	body.WriteString(`"event":`)
+	if json.Valid(line) {
+		body.Write(line)
+	} else {
+		val, _ := json.Marshal(string(line))
+		body.Write(val)
+	}
+	body.Write(s.meta)
+	body.WriteString("}\n")
+}
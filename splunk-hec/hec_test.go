@@ -0,0 +1,154 @@
+package hec_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Unity-Technologies/go-lager-internal"
+	"github.com/Unity-Technologies/go-lager-internal/batch"
+	"github.com/Unity-Technologies/go-lager-internal/splunk-hec"
+	"github.com/Unity-Technologies/go-tutl-internal"
+)
+
+type event struct {
+	Time  float64
+	Event []interface{}
+	Host  string
+	Index string
+}
+
+func TestSink(t *testing.T) {
+	u := tutl.New(t)
+
+	var mu sync.Mutex
+	events := make([]event, 0)
+	auths := make([]string, 0)
+	fails := 1
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			defer lager.AutoLock(&mu)()
+			auths = append(auths, r.Header.Get("Authorization"))
+			if 0 < fails {
+				fails--
+				w.WriteHeader(503)
+				return
+			}
+			scan := bufio.NewScanner(r.Body)
+			for scan.Scan() {
+				var ev event
+				if u.Is(nil, json.Unmarshal(scan.Bytes(), &ev), "valid event") {
+					events = append(events, ev)
+				}
+			}
+		}))
+	defer srv.Close()
+
+	_, err := hec.New(hec.Config{URL: srv.URL})
+	u.Like(err, "no token", "*requires both")
+
+	sink, err := hec.New(hec.Config{
+		URL: srv.URL, Token: "secret", Host: "box", Index: "main",
+		Options: batch.Options{Backoff: time.Millisecond},
+	})
+	if !u.Is(nil, err, "New") {
+		return
+	}
+	defer lager.Keys(lager.GetKeys())
+	lager.Keys("", "", "", "", "", "")
+	restore := lager.SetOutput(sink)
+	lager.Fail().List("one")
+	lager.Warn().MMap("two", "n", 2)
+	restore()
+	u.Is(nil, sink.Flush(), "Flush")
+	u.Is(nil, sink.Close(), "Close")
+	u.Is(batch.ErrClosed, sink.Close(), "second Close")
+
+	defer lager.AutoLock(&mu)()
+	u.Is(2, len(auths), "one retry")
+	u.Is("Splunk secret", auths[0], "token auth")
+	if u.Is(2, len(events), "events") {
+		u.Is("FAIL", events[0].Event[1], "event 0 level")
+		u.Is("two", events[1].Event[2], "event 1 msg")
+		u.Is("box", events[0].Host, "host")
+		u.Is("main", events[0].Index, "index")
+		ts, _ := time.Parse("2006-01-02 15:04:05.9999Z",
+			events[0].Event[0].(string))
+		u.Circa(6, float64(ts.UnixNano()/1e6)/1e3, events[0].Time, "time")
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	u := tutl.New(t)
+	tries := 0
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			tries++
+			w.WriteHeader(403)
+			w.Write([]byte("Invalid token\n"))
+		}))
+	defer srv.Close()
+
+	var dropped error
+	sink, _ := hec.New(hec.Config{
+		URL: srv.URL, Token: "bad",
+		Options: batch.Options{OnError: func(err error) { dropped = err }},
+	})
+	sink.Write([]byte(`["2019-12-31 23:59:59.1234Z", "FAIL", "partial`))
+	u.Is(nil, sink.Flush(), "nothing complete to flush")
+	sink.Write([]byte("\"]\n"))
+	u.Like(sink.Flush(), "403 error", "*403 Forbidden: Invalid token")
+	u.Is(1, tries, "403 not retried")
+	u.Like(dropped, "OnError", "^1 lines: ", "*403")
+	sink.Close()
+	_, err := sink.Write([]byte("late\n"))
+	u.Is(batch.ErrClosed, err, "write after close")
+}
+
+func TestEpochLines(t *testing.T) {
+	u := tutl.New(t)
+	var mu sync.Mutex
+	events := make([]map[string]interface{}, 0)
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			defer lager.AutoLock(&mu)()
+			scan := bufio.NewScanner(r.Body)
+			for scan.Scan() {
+				var ev map[string]interface{}
+				if u.Is(nil, json.Unmarshal(scan.Bytes(), &ev), "valid event") {
+					events = append(events, ev)
+				}
+			}
+		}))
+	defer srv.Close()
+
+	sink, _ := hec.New(hec.Config{URL: srv.URL, Token: "secret"})
+	defer lager.Keys(lager.GetKeys())
+	lager.Keys("time", "severity", "msg", "data", "", "module")
+	restore := lager.SetOutput(sink)
+	undo := lager.SetEpochTimestamp("", false)
+	lager.Warn().MMap("both")
+	undo()
+	undo = lager.SetEpochTimestamp("", true)
+	lager.Warn().MMap("only")
+	undo()
+	restore()
+	u.Is(nil, sink.Close(), "Close")
+
+	defer lager.AutoLock(&mu)()
+	if !u.Is(2, len(events), "events") {
+		return
+	}
+	both, _ := events[0]["event"].(map[string]interface{})
+	only, _ := events[1]["event"].(map[string]interface{})
+	when, _ := time.Parse(time.RFC3339Nano, both["time"].(string))
+	u.Is(float64(when.UnixNano()/1e6)/1e3, events[0]["time"],
+		"string time with epoch pair")
+	u.Is("WARN", both["severity"], "level")
+	ms, _ := only["time"].(float64)
+	u.Is(ms/1e3, events[1]["time"], "epoch time")
+}
@@ -18,6 +18,14 @@ func caller(depth, pathparts int) (file string, line int, funcname string) {
 	if 0 == frame.PC {
 		return
 	}
+	return trimFrame(frame, pathparts)
+}
+
+// Returns the file (keeping only the last 'pathparts' parts of its path, if
+// positive), line, and function name (without its package) of a frame.
+func trimFrame(
+	frame runtime.Frame, pathparts int,
+) (file string, line int, funcname string) {
 	file, line, funcname = frame.File, frame.Line, frame.Function
 
 	if fnparts := strings.Split(funcname, "."); 0 < len(fnparts) {
@@ -85,3 +93,13 @@ func (l *logger) CMap(args ...interface{}) {
 func (l *logger) CMMap(message string, args ...interface{}) {
 	l.WithCaller(1).MMap(message, args...)
 }
+
+// See the Lager interface for documentation.
+func (l *logger) CMFields(message string, fields ...Field) {
+	l.WithCaller(1).MFields(message, fields...)
+}
+
+// See the Lager interface for documentation.
+func (l *logger) CMFmt(format string, args ...interface{}) {
+	l.WithCaller(1).MFmt(format, args...)
+}
@@ -0,0 +1,180 @@
+package lager
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The number of clients connected via StreamHandler().  Log lines are only
+// copied to the stream hub when this is not 0.
+var _streamClients int32
+
+// The output that copies log lines to StreamHandler() clients.
+var _streams = &streamHub{subs: map[*streamSub]bool{}}
+
+// Sends log lines to StreamHandler() clients.
+type streamHub struct {
+	mu   sync.Mutex
+	subs map[*streamSub]bool
+}
+
+// One StreamHandler() client.
+type streamSub struct {
+	levels [int(nLevels)]bool
+	mod    string
+	lines  chan []byte
+}
+
+// How many log lines can be waiting to be sent to a StreamHandler() client
+// before more lines are dropped.
+const streamBacklog = 256
+
+// How often a StreamHandler() sends a comment to keep an idle connection
+// from being closed.
+const streamKeepAlive = 15 * time.Second
+
+// StreamHandler() returns an http.Handler that streams log lines as they
+// are written, using Server-Sent Events, so that internal dashboards (or
+// "curl -N") can tail a service live without access to the node:
+//
+//      http.Handle("/debug/log-stream", lager.StreamHandler(token))
+//
+// Each log line is sent as the data of one event (as JSON, as composed).
+// Secrets are first removed via RedactSecrets().  These query parameters
+// select which lines are sent:
+//
+//      levels  Only send lines of these levels, like "FWN" [default: all
+//              levels that are enabled].
+//      mod     Only send lines logged via the Module of this name.
+//
+// Only lines of levels that are enabled are logged, so use SetLevels() (or
+// LevelHandler()) to stream more detail.  If a client cannot keep up, log
+// lines are dropped for that client rather than slowing down logging.
+//
+// If 'token' is not "", requests must include it in an "Authorization:
+// Bearer {token}" header; other requests get a 401 error.  The token is
+// not accepted as a query parameter, since URLs end up in access logs.
+//
+func StreamHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if "" != token && !streamAuthorized(r, token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming not supported",
+				http.StatusInternalServerError)
+			return
+		}
+		q := r.URL.Query()
+		sub := &streamSub{
+			mod: q.Get("mod"), lines: make(chan []byte, streamBacklog),
+		}
+		levels := q.Get("levels")
+		for l := lPanic; l < nLevels; l++ {
+			sub.levels[int(l)] = "" == levels
+		}
+		for _, c := range []byte(levels) {
+			if lev, ok := letterLevel(c); ok {
+				sub.levels[int(lev)] = true
+			}
+		}
+		_streams.add(sub)
+		defer _streams.remove(sub)
+
+		h := w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-store")
+		h.Set("X-Accel-Buffering", "no") // Don't let nginx buffer events.
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, ": lager log stream\n\n")
+		flusher.Flush()
+		tick := time.NewTicker(streamKeepAlive)
+		defer tick.Stop()
+		for {
+			select {
+			case line := <-sub.lines:
+				_, err := fmt.Fprintf(w, "data: %s\n\n", RedactSecrets(
+					strings.TrimSuffix(string(line), "\n")))
+				if nil != err {
+					return
+				}
+			case <-tick.C:
+				_, err := fmt.Fprint(w, ": keep-alive\n\n")
+				if nil != err {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+			flusher.Flush()
+		}
+	})
+}
+
+// Returns whether the request includes the StreamHandler() token.
+func streamAuthorized(r *http.Request, token string) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	got := strings.TrimPrefix(auth, "Bearer ")
+	return 1 == subtle.ConstantTimeCompare([]byte(got), []byte(token))
+}
+
+func (h *streamHub) add(sub *streamSub) {
+	defer AutoLock(&h.mu)()
+	h.subs[sub] = true
+	atomic.AddInt32(&_streamClients, 1)
+}
+
+func (h *streamHub) remove(sub *streamSub) {
+	defer AutoLock(&h.mu)()
+	delete(h.subs, sub)
+	atomic.AddInt32(&_streamClients, -1)
+}
+
+// Write() is only used when a line reaches the hub without its level being
+// known, which should not happen, so it is ignored.
+func (h *streamHub) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+// Queues a complete log line for each client that wants it (unless that
+// client is too far behind).
+func (h *streamHub) send(lev level, mod string, line []byte) {
+	defer AutoLock(&h.mu)()
+	for sub := range h.subs {
+		if !sub.levels[int(lev)] || "" != sub.mod && mod != sub.mod {
+			continue
+		}
+		select {
+		case sub.lines <- line:
+		default: // Client is behind; drop the line.
+		}
+	}
+}
+
+// The io.Writer used for a log line going to StreamHandler() clients.
+type streamWriter struct {
+	lev level
+	mod string
+	buf []byte
+}
+
+// Collects the log line and sends it to the clients once it is complete.
+func (sw *streamWriter) Write(data []byte) (int, error) {
+	sw.buf = append(sw.buf, data...)
+	if 0 < len(sw.buf) && '\n' == sw.buf[len(sw.buf)-1] {
+		_streams.send(sw.lev, sw.mod, sw.buf)
+		sw.buf = nil
+	}
+	return len(data), nil
+}
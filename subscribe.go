@@ -0,0 +1,140 @@
+package lager
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How many entries can be waiting to be received by a subscriber before
+// more entries are dropped.
+const subscribeBacklog = 256
+
+// Entry describes a log line that was written.  See Subscribe().
+//
+type Entry struct {
+	Time    time.Time
+	Level   string // Like "FAIL".
+	Module  string // "" if not logged via a Module.
+	Message string // "" if the line has no message.
+
+	// The host, global, and context pairs [see AddHostPairs() and
+	// SetGlobalPairs()] plus the pairs passed to Map() or MMap().  Must
+	// not be modified.
+	Pairs AMap
+}
+
+// One channel from Subscribe().
+type subscriber struct {
+	keep    func(Entry) bool
+	ch      chan Entry
+	dropped uint64
+	mu      sync.RWMutex // Held (for writing) when closing 'ch'.
+	closed  bool
+}
+
+// Subscribe() returns a channel that receives an Entry for each log line
+// that is written, so other parts of the application can react to log
+// events.  For example, to mark a service as degraded once a critical
+// module logs a Fail line:
+//
+//      fails := lager.Subscribe(func(e lager.Entry) bool {
+//          return "FAIL" == e.Level && "payments" == e.Module
+//      })
+//      go func() {
+//          for range fails {
+//              health.SetDegraded()
+//          }
+//      }()
+//
+// If 'filter' is not 'nil', then only entries for which it returns 'true'
+// are sent.  It is called for each log line as it is written, so it should
+// be fast.  Only lines that are actually written are sent (not lines for
+// disabled levels).
+//
+// The channel can hold 256 entries.  If the subscriber falls that far
+// behind, further entries are dropped (rather than slowing down logging);
+// SubscriptionDrops() tells how many.  Call Unsubscribe() to stop the
+// entries and close the channel.
+//
+func Subscribe(filter func(Entry) bool) <-chan Entry {
+	s := &subscriber{keep: filter, ch: make(chan Entry, subscribeBacklog)}
+	updateGlobals(func(g *globals) {
+		g.subs = append(append([]*subscriber(nil), g.subs...), s)
+	})
+	return s.ch
+}
+
+// Unsubscribe() stops sending entries to a channel from Subscribe() and
+// closes it.
+//
+func Unsubscribe(ch <-chan Entry) {
+	var s *subscriber
+	updateGlobals(func(g *globals) {
+		kept := make([]*subscriber, 0, len(g.subs))
+		for _, o := range g.subs {
+			if o.ch == ch {
+				s = o
+			} else {
+				kept = append(kept, o)
+			}
+		}
+		if 0 == len(kept) {
+			kept = nil
+		}
+		g.subs = kept
+	})
+	if nil != s {
+		s.mu.Lock()
+		s.closed = true
+		close(s.ch)
+		s.mu.Unlock()
+	}
+}
+
+// SubscriptionDrops() returns how many entries were not sent to a channel
+// from Subscribe() because it was full.
+//
+func SubscriptionDrops(ch <-chan Entry) uint64 {
+	for _, s := range getGlobals().subs {
+		if s.ch == ch {
+			return atomic.LoadUint64(&s.dropped)
+		}
+	}
+	return 0
+}
+
+// Returns a copy of the logger that remembers the pairs passed to Map() or
+// MMap() if there are any subscribers [see Subscribe()].
+func (l *logger) forSubscribers(pairs []interface{}) *logger {
+	if nil == l || nil == l.g.subs {
+		return l
+	}
+	cp := *l
+	cp.pairs = pairs
+	return &cp
+}
+
+// Sends the Entry for a log line that was written to each subscriber that
+// wants it.
+func (l *logger) publish(b *buffer) {
+	e := Entry{
+		Time: b.now, Level: l.lev.String(), Module: l.mod, Message: b.msg,
+		Pairs: l.g.basePairs().Merge(l.ctxPairs()).Merge(
+			flatPairs(nil, l.pairs)),
+	}
+	for _, s := range l.g.subs {
+		if nil != s.keep && !s.keep(e) {
+			continue
+		}
+		s.mu.RLock()
+		if !s.closed {
+			select {
+			case s.ch <- e:
+			default: // Subscriber is behind; drop the entry.
+				atomic.AddUint64(&s.dropped, 1)
+			}
+		}
+		s.mu.RUnlock()
+	}
+}
@@ -0,0 +1,264 @@
+package lager
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Summarizer accumulates counters and distributions of observed values
+// and logs them as a single line once per interval.  This gives visibility
+// into subsystems where logging each event would be far too expensive.
+// Use lager.Summary() to get a Summarizer.
+//
+type Summarizer struct {
+	name  string
+	mu    sync.Mutex
+	ctx   Ctx
+	lev   byte
+	every time.Duration
+	since time.Time
+	stop  chan bool
+
+	cKeys  []string
+	counts map[string]int64
+	oKeys  []string
+	obs    map[string]*observed
+}
+
+// The distribution of values passed to Summarizer.Observe() for one key.
+type observed struct {
+	n        int64
+	sum      float64
+	min, max float64
+	samples  []float64
+}
+
+// The most samples kept per key for computing percentiles.
+const summarySamples = 1024
+
+// How often a Summarizer logs unless SetInterval() is used.
+const defaultSummaryInterval = time.Minute
+
+var summaries sync.Map
+
+// Summary() returns the Summarizer having the given name, creating it if
+// needed.  The name is logged as the value of the "summary" key and the
+// pairs from 'ctx' are included in each summary line.  Once a Summarizer
+// with a given name exists, 'ctx' is ignored in subsequent calls [until
+// its Close() is called].
+//
+// A new Summarizer logs at the Info level once per minute; see SetLevel()
+// and SetInterval().  No line is logged for an interval during which
+// nothing was recorded.  For example:
+//
+//      cache := lager.Summary(ctx, "cache")
+//      // ...
+//      if hit {
+//          cache.Count("hits")
+//      } else {
+//          cache.Count("misses")
+//      }
+//      cache.Observe("fetch_ms", float64(elapsed)/1e6)
+//
+// could log (once per minute):
+//
+//      ["2019-12-31 23:59:59.1234Z", "INFO", "Summary", {"summary":"cache",
+//          "interval":"60.000s", "hits":1802, "misses":44, "fetch_ms":
+//          {"count":44, "min":1.2, "avg":8.1, "p50":6.5, "p95":21.7,
+//          "p99":35.2, "max":40.9}}]
+//
+func Summary(ctx Ctx, name string) *Summarizer {
+	if x, ok := summaries.Load(name); ok {
+		return x.(*Summarizer)
+	}
+	s := &Summarizer{
+		name:   name,
+		ctx:    ctx,
+		lev:    'I',
+		every:  defaultSummaryInterval,
+		since:  time.Now(),
+		counts: make(map[string]int64),
+		obs:    make(map[string]*observed),
+	}
+	if x, loaded := summaries.LoadOrStore(name, s); loaded {
+		return x.(*Summarizer)
+	}
+	s.start()
+	return s
+}
+
+// Count() adds 1 (or the sum of the passed-in values) to the counter for
+// 'key'.  Counters are logged in the order they were first used.
+//
+func (s *Summarizer) Count(key string, n ...int64) {
+	add := int64(1)
+	if 0 < len(n) {
+		add = 0
+		for _, i := range n {
+			add += i
+		}
+	}
+	defer AutoLock(&s.mu)()
+	if _, ok := s.counts[key]; !ok {
+		s.cKeys = append(s.cKeys, key)
+	}
+	s.counts[key] += add
+}
+
+// Observe() records one value (such as a latency) for 'key'.  The count,
+// minimum, average, maximum, and 50th, 95th, and 99th percentiles of the
+// values observed during the interval are logged.  Percentiles are computed
+// from a uniform sample of at most 1024 values per key.
+//
+func (s *Summarizer) Observe(key string, val float64) {
+	defer AutoLock(&s.mu)()
+	o := s.obs[key]
+	if nil == o {
+		o = &observed{min: val, max: val}
+		s.obs[key] = o
+		s.oKeys = append(s.oKeys, key)
+	}
+	o.n++
+	o.sum += val
+	if val < o.min {
+		o.min = val
+	}
+	if o.max < val {
+		o.max = val
+	}
+	if len(o.samples) < summarySamples {
+		o.samples = append(o.samples, val)
+	} else if i := rand.Int63n(o.n); i < summarySamples {
+		o.samples[i] = val
+	}
+}
+
+// SetLevel() sets the log level used for summary lines.  Pass in one
+// character from "FWNAITDOG" [see lager.Level()].  Returns the Summarizer
+// so calls can be chained.
+//
+func (s *Summarizer) SetLevel(lev byte) *Summarizer {
+	Level(lev) // Panics if 'lev' is not valid.
+	defer AutoLock(&s.mu)()
+	s.lev = lev
+	return s
+}
+
+// SetInterval() changes how often a summary line is logged.  An 'every'
+// of 0 (or less) means summaries are only logged when Flush() is called.
+// Any pending summary is logged first [see Stop()].  Returns the Summarizer
+// so calls can be chained.
+//
+func (s *Summarizer) SetInterval(every time.Duration) *Summarizer {
+	s.Stop()
+	s.mu.Lock()
+	s.every = every
+	s.mu.Unlock()
+	s.start()
+	return s
+}
+
+// Stop() logs any pending summary and stops the periodic logging.  The
+// Summarizer can still be used and then Flush() must be called for its
+// data to be logged.
+//
+func (s *Summarizer) Stop() {
+	s.mu.Lock()
+	stop := s.stop
+	s.stop = nil
+	s.mu.Unlock()
+	if nil != stop {
+		close(stop)
+	}
+	s.Flush()
+}
+
+// Close() is like Stop() but also forgets the Summarizer, so that a later
+// call to lager.Summary() with the same name gets a new Summarizer.
+//
+func (s *Summarizer) Close() {
+	s.Stop()
+	if x, ok := summaries.Load(s.name); ok && x == s {
+		summaries.Delete(s.name)
+	}
+}
+
+// Flush() immediately logs a summary line of everything recorded since the
+// prior summary (unless nothing was recorded) and resets the accumulated
+// values.
+//
+func (s *Summarizer) Flush() {
+	s.mu.Lock()
+	now := time.Now()
+	since, cKeys, counts := s.since, s.cKeys, s.counts
+	oKeys, obs, lev, ctx := s.oKeys, s.obs, s.lev, s.ctx
+	s.since = now
+	if 0 < len(cKeys) || 0 < len(oKeys) {
+		s.cKeys, s.counts = nil, make(map[string]int64)
+		s.oKeys, s.obs = nil, make(map[string]*observed)
+	}
+	s.mu.Unlock()
+
+	if 0 == len(cKeys) && 0 == len(oKeys) {
+		return
+	}
+	pairs := make([]interface{}, 0, 4+2*len(cKeys)+2*len(oKeys))
+	pairs = append(pairs, "summary", s.name,
+		"interval", fmt.Sprintf("%.3fs", now.Sub(since).Seconds()))
+	for _, k := range cKeys {
+		pairs = append(pairs, k, counts[k])
+	}
+	for _, k := range oKeys {
+		pairs = append(pairs, k, obs[k].stats())
+	}
+	Level(lev, ctx).MMap("Summary", pairs...)
+}
+
+// Starts the goroutine that logs the summary each interval.
+func (s *Summarizer) start() {
+	defer AutoLock(&s.mu)()
+	if s.every <= 0 || nil != s.stop {
+		return
+	}
+	stop := make(chan bool)
+	s.stop = stop
+	go func(every time.Duration) {
+		tick := time.NewTicker(every)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				s.Flush()
+			case <-stop:
+				return
+			}
+		}
+	}(s.every)
+}
+
+// Computes the values to be logged for one observed key.
+func (o *observed) stats() RawMap {
+	sort.Float64s(o.samples)
+	return Map(
+		"count", o.n,
+		"min", o.min,
+		"avg", o.sum/float64(o.n),
+		"p50", percentile(o.samples, 0.50),
+		"p95", percentile(o.samples, 0.95),
+		"p99", percentile(o.samples, 0.99),
+		"max", o.max,
+	)
+}
+
+// Returns the 'p' percentile (0.0 < p <= 1.0) from sorted 'vals'.
+func percentile(vals []float64, p float64) float64 {
+	i := int(math.Ceil(p*float64(len(vals)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return vals[i]
+}
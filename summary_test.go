@@ -0,0 +1,73 @@
+package lager_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Unity-Technologies/go-lager-internal"
+	"github.com/Unity-Technologies/go-tutl-internal"
+)
+
+func TestSummary(t *testing.T) {
+	u := tutl.New(t)
+	log := bytes.NewBuffer(nil)
+	defer lager.SetOutput(log)()
+	lager.Keys("t", "l", "msg", "data", "", "mod")
+	defer lager.Keys("", "", "", "", "", "")
+
+	ctx := lager.AddPairs(context.Background(), "region", "us")
+	s := lager.Summary(ctx, "cache").SetInterval(0).SetLevel('N')
+	u.Is(true, s == lager.Summary(nil, "cache"), "Summary() reuses name")
+	defer s.Close()
+
+	s.Flush()
+	u.Is("", log.String(), "nothing recorded, nothing logged")
+
+	s.Count("hits")
+	s.Count("misses", 2, 3)
+	s.Count("hits")
+	for i := 1; i <= 100; i++ {
+		s.Observe("ms", float64(i))
+	}
+	s.Flush()
+	hash := make(map[string]interface{})
+	if validJson("summary", log.Bytes(), &hash, u) {
+		u.Is("Summary", hash["msg"], "summary msg")
+		u.Is("NOTE", hash["l"], "summary level")
+		u.Is("cache", hash["summary"], "summary name")
+		u.Is("us", hash["region"], "summary ctx pair")
+		u.Is(2, hash["hits"], "summary hits")
+		u.Is(5, hash["misses"], "summary misses")
+		u.Like(hash["interval"], "summary interval", `^[0-9]+[.][0-9]{3}s$`)
+		if u.HasType("map[string]interface {}", hash["ms"], "ms type") {
+			ms := hash["ms"].(map[string]interface{})
+			u.Is(100, ms["count"], "ms count")
+			u.Is(1, ms["min"], "ms min")
+			u.Is(50.5, ms["avg"], "ms avg")
+			u.Is(50, ms["p50"], "ms p50")
+			u.Is(95, ms["p95"], "ms p95")
+			u.Is(99, ms["p99"], "ms p99")
+			u.Is(100, ms["max"], "ms max")
+		}
+	}
+	u.Like(log.Bytes(), "summary order", `"hits":.*"misses":.*"ms":`)
+	log.Reset()
+
+	s.Flush()
+	u.Is("", log.String(), "counts reset after flush")
+
+	s.SetInterval(10 * time.Millisecond)
+	s.Count("hits")
+	time.Sleep(50 * time.Millisecond)
+	s.Stop()
+	u.Like(log.Bytes(), "periodic summary", `"hits":1[,}]`)
+	log.Reset()
+
+	s.Count("hits")
+	s.Close()
+	u.Like(log.Bytes(), "close logs pending", `"hits":1[,}]`)
+	u.Is(false, s == lager.Summary(nil, "cache"), "closed one forgotten")
+	lager.Summary(nil, "cache").Close()
+}
@@ -0,0 +1,66 @@
+package lager
+
+import (
+	"context"
+	"io"
+)
+
+// The io.Writer returned by TeeOutput().
+type tee []io.Writer
+
+// TeeOutput() returns an io.Writer that writes each log line to every one
+// of the passed-in io.Writers (in order).  Pass it to SetOutput() to send
+// logs to multiple destinations:
+//
+//      defer lager.SetOutput(lager.TeeOutput(os.Stdout, logFile))()
+//
+// A failure writing to one destination does not prevent the line from
+// being written to the remaining destinations.  An error is only returned
+// if every destination fails (the error from the first one).  The returned
+// io.Writer also has a Flush() method that flushes each destination that
+// supports it [see FlushOn()].  Any 'nil' writers are ignored.
+//
+func TeeOutput(writers ...io.Writer) io.Writer {
+	t := make(tee, 0, len(writers))
+	for _, w := range writers {
+		if nil != w {
+			t = append(t, w)
+		}
+	}
+	return t
+}
+
+func (t tee) Write(buf []byte) (int, error) {
+	return t.WriteContext(context.Background(), buf)
+}
+
+// WriteContext() writes to each destination, passing along 'ctx' [see
+// SetWriteTimeout()].
+func (t tee) WriteContext(ctx context.Context, buf []byte) (int, error) {
+	var first error
+	fails := 0
+	for _, w := range t {
+		if _, err := writeContext(ctx, w, buf); nil != err {
+			if 0 == fails {
+				first = err
+			}
+			fails++
+		}
+	}
+	if 0 < fails && len(t) == fails {
+		return 0, first
+	}
+	return len(buf), nil
+}
+
+func (t tee) Flush() error {
+	var first error
+	for _, w := range t {
+		if f, ok := w.(flusher); ok {
+			if err := f.Flush(); nil != err && nil == first {
+				first = err
+			}
+		}
+	}
+	return first
+}
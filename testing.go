@@ -0,0 +1,71 @@
+package lager
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+// TestLogger covers the methods of a '*testing.T' (or '*testing.B') that
+// CaptureTestLogs() uses.  A 'tutl.TUTL' does not have a Cleanup() method,
+// so pass the '*testing.T' that it wraps.  Using this small interface,
+// rather than taking a 'tutl.TUTL', keeps the lager package from depending
+// on the go-tutl test helper.
+//
+type TestLogger interface {
+	Helper()
+	Log(args ...interface{})
+	Failed() bool
+	Cleanup(func())
+}
+
+// Collects log lines during a test.
+type testCapture struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (tc *testCapture) Write(buf []byte) (int, error) {
+	defer tc.mu.Unlock()
+	tc.mu.Lock()
+	return tc.buf.Write(buf)
+}
+
+// CaptureTestLogs() captures the log lines written during a test and only
+// shows them (via t.Log()) if the test fails, keeping 'go test' output
+// clean while preserving the full logs for failures.  It is meant to be
+// called right next to 'tutl.New(t)':
+//
+//      func TestThing(t *testing.T) {
+//          u := tutl.New(t)
+//          lager.CaptureTestLogs(t, "FWNAITD")
+//
+// If 'levels' is given, then those log levels [see Init()] are enabled for
+// the duration of the test.  The prior output and levels are restored when
+// the test finishes [via t.Cleanup()].  Since this changes the global
+// output, it should not be used with parallel tests [t.Parallel()].
+//
+func CaptureTestLogs(t TestLogger, levels ...string) {
+	t.Helper()
+	tc := new(testCapture)
+	restore := SetOutput(tc)
+	prior := getGlobals().enabled
+	for _, lev := range levels {
+		Init(lev)
+	}
+	t.Cleanup(func() {
+		restore()
+		if 0 < len(levels) {
+			Init(prior)
+		}
+		if !t.Failed() {
+			return
+		}
+		defer tc.mu.Unlock()
+		tc.mu.Lock()
+		lines := strings.TrimRight(tc.buf.String(), "\n")
+		if "" != lines {
+			t.Log("Log lines written during the test:\n" + lines)
+		}
+	})
+}
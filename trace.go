@@ -0,0 +1,85 @@
+package lager
+
+import (
+	"fmt"
+)
+
+// TraceSource is the small part of a tracing system that Lager needs in
+// order to correlate log lines with traces.  Any spans.Factory is a
+// TraceSource, and a tracing system like AWS X-Ray or OpenTelemetry can be
+// made into one with a tiny adapter [or via TraceIDs()], without needing to
+// import the GCP spans package.
+//
+type TraceSource interface {
+	// GetTraceID() returns the ID of the current trace, as the tracing
+	// system formats it (such as 32 hex digits), or "" if there is none.
+	GetTraceID() string
+
+	// GetSpanID() returns the ID of the current span or 0 if there is none.
+	GetSpanID() uint64
+}
+
+// A TraceSource holding fixed IDs.
+type traceIDs struct {
+	trace string
+	span  uint64
+}
+
+func (t traceIDs) GetTraceID() string { return t.trace }
+func (t traceIDs) GetSpanID() uint64  { return t.span }
+
+// TraceIDs() returns a TraceSource for the given trace and span IDs.  For
+// example, with OpenTelemetry:
+//
+//      sc := trace.SpanContextFromContext(ctx)
+//      id := sc.SpanID()
+//      ctx = lager.ContextAddTrace(ctx, lager.TraceIDs(
+//          sc.TraceID().String(), binary.BigEndian.Uint64(id[:])))
+//
+func TraceIDs(traceID string, spanID uint64) TraceSource {
+	return traceIDs{trace: traceID, span: spanID}
+}
+
+// ContextAddTrace() takes a Context and returns one that has the trace and
+// span from 'src' added as 2 pairs that will be logged when that Context
+// is passed to lager.Warn() or similar methods.  If 'src' is 'nil' or has
+// no trace or span, then the original 'ctx' is just returned.
+//
+// The keys used depend on the log format in effect.  When RunningInGcp()
+// is in effect, the pairs are the same as those added by
+// gcp.ContextAddTrace() (using the project ID from 'src', if it has a
+// 'GetProjectID() string' method, else from GcpProjectID()).  When
+// RunningInEcs() is in effect, "trace.id" and "span.id" are used.  When
+// RunningInAws() is in effect, "xray_trace_id" and "xray_span_id" are used.
+// When RunningInAzure() is in effect, "operation_Id" and
+// "operation_ParentId" are used.  Otherwise, "trace_id" and "span_id" are
+// used (the names from the OpenTelemetry log data model).  The span ID is
+// logged as 16 hex digits.
+//
+func ContextAddTrace(ctx Ctx, src TraceSource) Ctx {
+	if nil == src || 0 == src.GetSpanID() || "" == src.GetTraceID() {
+		return ctx
+	}
+	trace, span := src.GetTraceID(), fmt.Sprintf("%016x", src.GetSpanID())
+	g := getGlobals()
+	switch {
+	case g.inGcp:
+		proj := ""
+		if p, ok := src.(interface{ GetProjectID() string }); ok {
+			proj = p.GetProjectID()
+		}
+		if "" == proj {
+			proj, _ = GcpProjectID(ctx)
+		}
+		return AddPairs(ctx,
+			GcpTraceKey, "projects/"+proj+"/traces/"+trace, GcpSpanKey, span)
+	case g.inEcs:
+		return AddPairs(ctx, "trace.id", trace, "span.id", span)
+	case g.inAws:
+		return AddPairs(ctx, "xray_trace_id", trace, "xray_span_id", span)
+	case g.inAzure:
+		return AddPairs(ctx,
+			"operation_Id", trace, "operation_ParentId", span)
+	}
+	return AddPairs(ctx, "trace_id", trace, "span_id", span)
+}
@@ -0,0 +1,159 @@
+package lager
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Trigger describes a pattern of log lines that should cause a callback to
+// be invoked, such as to trip a circuit breaker or disable a feature when
+// errors are being logged at a high rate.  See AddTrigger().
+//
+type Trigger struct {
+	// The levels of log lines counted, as letters from "PEFWNAITDOG".  An
+	// empty string means "PEF" (Panic, Exit, and Fail).
+	Levels string
+
+	// If not "", only lines logged via the Module of this name count.
+	Module string
+
+	// The callback is invoked once Count lines have been logged within the
+	// time span of Within.
+	Count  int
+	Within time.Duration
+
+	// The callback.  It is passed the Trigger and the number of lines
+	// counted.  It is called from a new goroutine so it can take its time
+	// and can log without causing problems.
+	Func func(t Trigger, lines int)
+}
+
+// A Trigger after validation along with the times of recent matching lines.
+type trigger struct {
+	Trigger
+	levels [int(nLevels)]bool
+	mu     sync.Mutex
+	times  []time.Time // Ring buffer of the last 'Count' line times.
+	next   int         // Where the next time goes in 'times'.
+	closed bool        // Set once the trigger is removed.
+	copies []*trigger  // Copies made for each Logger from New().
+}
+
+// AddTrigger() arranges for t.Func to be called whenever t.Count log lines
+// of the given levels (and module) are written within t.Within.  For
+// example, to turn off a feature when over 20 Fail lines are logged from
+// the "payments" module within 10 seconds:
+//
+//      remove, err := lager.AddTrigger(lager.Trigger{
+//          Levels: "F", Module: "payments", Count: 20,
+//          Within: 10*time.Second,
+//          Func: func(lager.Trigger, int) { payments.Disable() },
+//      })
+//
+// Only lines that are actually written count (not lines for disabled
+// levels).  After the callback is invoked, counting starts over, so it will
+// not be called again until another t.Count lines are logged within
+// t.Within.  The returned function removes the trigger.  An error is
+// returned if t.Count is not positive, if t.Within is not positive, or if
+// t.Func is 'nil'.
+//
+func AddTrigger(t Trigger) (func(), error) {
+	if t.Count < 1 || t.Within <= 0 || nil == t.Func {
+		return nil, fmt.Errorf("lager.Trigger needs positive Count and" +
+			" Within and a non-nil Func")
+	}
+	tr := &trigger{Trigger: t, times: make([]time.Time, 0, t.Count)}
+	levels := t.Levels
+	if "" == levels {
+		levels = "PEF"
+	}
+	for _, c := range []byte(levels) {
+		if lev, ok := letterLevel(c); ok {
+			tr.levels[int(lev)] = true
+		}
+	}
+	updateGlobals(func(g *globals) {
+		g.triggers = append(append([]*trigger(nil), g.triggers...), tr)
+	})
+	return func() {
+		updateGlobals(func(g *globals) {
+			kept := make([]*trigger, 0, len(g.triggers))
+			for _, t := range g.triggers {
+				if t != tr {
+					kept = append(kept, t)
+				}
+			}
+			if 0 == len(kept) {
+				kept = nil
+			}
+			g.triggers = kept
+		})
+		tr.close()
+	}, nil
+}
+
+// Returns a copy of the trigger that counts lines separately [for a Logger
+// from New()].  Closing 't' also closes the copy.
+func (t *trigger) copy() *trigger {
+	c := &trigger{
+		Trigger: t.Trigger, levels: t.levels,
+		times: make([]time.Time, 0, t.Count),
+	}
+	t.mu.Lock()
+	c.closed = t.closed
+	t.copies = append(t.copies, c)
+	t.mu.Unlock()
+	return c
+}
+
+// Stops the trigger (and its copies) from invoking the callback.
+func (t *trigger) close() {
+	t.mu.Lock()
+	t.closed = true
+	copies := t.copies
+	t.mu.Unlock()
+	for _, c := range copies {
+		c.close()
+	}
+}
+
+// Counts a log line against any triggers (and escalation policies) that
+// it matches.
+func (g *globals) noteTriggers(lev level, mod string) {
+	if nil == g.triggers && nil == g.escalations {
+		return
+	}
+	now := time.Now()
+	for _, t := range g.triggers {
+		if t.levels[int(lev)] && ("" == t.Module || t.Module == mod) {
+			t.note(now)
+		}
+	}
+	g.noteEscalations(lev, mod, now)
+}
+
+// Records a matching line and invokes the callback if it is time.
+func (t *trigger) note(now time.Time) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return
+	}
+	if len(t.times) < t.Count {
+		t.times = append(t.times, now)
+	} else {
+		t.times[t.next] = now
+	}
+	t.next = (t.next + 1) % t.Count
+	// Once full, 'next' is also the position of the oldest time:
+	fire := len(t.times) == t.Count &&
+		now.Sub(t.times[t.next]) <= t.Within
+	if fire {
+		t.times, t.next = t.times[:0], 0
+	}
+	t.mu.Unlock()
+	if fire {
+		go t.Func(t.Trigger, t.Count)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Package socket provides a Lager output that writes log lines to a Unix
+domain socket, so node-local log agents can consume Lager output without
+touching stdout:
+
+	sink, err := socket.New(socket.Config{Path: "/var/run/agent.sock"})
+	if nil != err {
+		lager.Exit().MMap("Can't connect to log agent", "err", err)
+	}
+	defer sink.Close()
+	defer lager.SetOutput(sink)()
+
+Both stream ("unix") and datagram ("unixgram") sockets are supported.  If
+writing fails, the socket is reconnected and the write is retried once.  If
+that also fails, the error is returned to Lager [which can then write the
+line elsewhere, see lager.SetFallbackOutput()].
+*/
+package socket
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Config describes which socket to write to.
+type Config struct {
+	// The file system path of the socket.  Required.
+	Path string
+
+	// "unix" (stream, the default), "unixgram" (datagram), or "unixpacket".
+	// For datagram and packet sockets, each log line is sent as one message
+	// without the trailing newline.
+	Network string
+
+	// How long to wait for connecting (default 1s) or for one write to
+	// finish (default 5s).
+	DialTimeout, WriteTimeout time.Duration
+}
+
+// Sink is an io.Writer suitable for passing to lager.SetOutput().
+type Sink struct {
+	conf    Config
+	mu      sync.Mutex
+	conn    net.Conn
+	partial []byte // Start of a log line not yet completely written to us.
+	closed  bool
+}
+
+// ErrClosed is returned when writing to a Sink after calling Close().
+var ErrClosed = errors.New("unix socket log sink already closed")
+
+// New() connects to the socket and returns a Sink that writes to it.
+//
+func New(conf Config) (*Sink, error) {
+	if "" == conf.Path {
+		return nil, fmt.Errorf("socket.New() requires a Path")
+	}
+	switch conf.Network {
+	case "":
+		conf.Network = "unix"
+	case "unix", "unixgram", "unixpacket":
+	default:
+		return nil, fmt.Errorf(
+			"socket.New() Network must be unix, unixgram, or unixpacket not %q",
+			conf.Network)
+	}
+	if conf.DialTimeout <= 0 {
+		conf.DialTimeout = time.Second
+	}
+	if conf.WriteTimeout <= 0 {
+		conf.WriteTimeout = 5 * time.Second
+	}
+	s := &Sink{conf: conf}
+	if err := s.connect(); nil != err {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Write() writes data to the socket.  For datagram and packet sockets, it
+// sends each complete log line and holds on to any partial line until the
+// rest of it is written.
+func (s *Sink) Write(buf []byte) (int, error) {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+	if s.closed {
+		return 0, ErrClosed
+	}
+	if !s.stream() {
+		s.partial = append(s.partial, buf...)
+		for {
+			nl := bytes.IndexByte(s.partial, '\n')
+			if nl < 0 {
+				return len(buf), nil
+			}
+			line := s.partial[:nl]
+			s.partial = s.partial[nl+1:]
+			if err := s.send(line); nil != err {
+				return 0, err
+			}
+		}
+	}
+	if err := s.send(buf); nil != err {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+// Close() closes the connection to the socket.
+func (s *Sink) Close() error {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+	if s.closed {
+		return ErrClosed
+	}
+	s.closed = true
+	if nil == s.conn {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// Whether we are writing to a stream socket.
+func (s *Sink) stream() bool {
+	return "unix" == s.conf.Network
+}
+
+// Connects (or reconnects) to the socket.
+func (s *Sink) connect() error {
+	if nil != s.conn {
+		s.conn.Close()
+		s.conn = nil
+	}
+	conn, err := net.DialTimeout(s.conf.Network, s.conf.Path, s.conf.DialTimeout)
+	if nil != err {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+// Writes data to the socket, reconnecting and retrying once on failure.
+func (s *Sink) send(data []byte) error {
+	var err error
+	for try := 0; try < 2; try++ {
+		if nil == s.conn || 0 < try {
+			if err = s.connect(); nil != err {
+				continue
+			}
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(s.conf.WriteTimeout))
+		if _, err = s.conn.Write(data); nil == err {
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package socket_test
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Unity-Technologies/go-lager-internal"
+	"github.com/Unity-Technologies/go-lager-internal/unix-socket"
+	"github.com/Unity-Technologies/go-tutl-internal"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "lager-sock")
+	if nil != err {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestStream(t *testing.T) {
+	u := tutl.New(t)
+	path := filepath.Join(tempDir(t), "s")
+	_, err := socket.New(socket.Config{Path: path})
+	u.Like(err, "no listener", "*connect")
+	_, err = socket.New(socket.Config{Path: path, Network: "tcp"})
+	u.Like(err, "bad network", `*not "tcp"`)
+
+	ln, err := net.Listen("unix", path)
+	if !u.Is(nil, err, "listen") {
+		return
+	}
+	defer ln.Close()
+	lines := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if nil != err {
+				return
+			}
+			go func() {
+				scan := bufio.NewScanner(conn)
+				for scan.Scan() {
+					lines <- scan.Text()
+				}
+			}()
+		}
+	}()
+
+	sink, err := socket.New(socket.Config{Path: path})
+	if !u.Is(nil, err, "New") {
+		return
+	}
+	lager.Keys("", "", "", "", "", "")
+	restore := lager.SetOutput(sink)
+	lager.Fail().List("one")
+	lager.Warn().MMap("two", "n", 2)
+	restore()
+	u.Like(<-lines, "line 1", `"FAIL", "one"\]$`)
+	u.Like(<-lines, "line 2", `"WARN", "two", {"n":2}\]$`)
+	u.Is(nil, sink.Close(), "Close")
+	u.Is(socket.ErrClosed, sink.Close(), "Close again")
+	_, err = sink.Write([]byte("late\n"))
+	u.Is(socket.ErrClosed, err, "write after Close")
+}
+
+func TestDatagram(t *testing.T) {
+	u := tutl.New(t)
+	path := filepath.Join(tempDir(t), "d")
+	conn, err := net.ListenUnixgram("unixgram",
+		&net.UnixAddr{Name: path, Net: "unixgram"})
+	if !u.Is(nil, err, "listen") {
+		return
+	}
+	defer conn.Close()
+
+	sink, err := socket.New(socket.Config{Path: path, Network: "unixgram"})
+	if !u.Is(nil, err, "New") {
+		return
+	}
+	defer sink.Close()
+	n, err := sink.Write([]byte(`["first"]` + "\n" + `["sec`))
+	u.Is(15, n, "write count")
+	u.Is(nil, err, "write")
+	sink.Write([]byte(`ond"]` + "\n"))
+
+	buf := make([]byte, 1024)
+	n, _ = conn.Read(buf)
+	u.Is(`["first"]`, string(buf[:n]), "datagram 1")
+	n, _ = conn.Read(buf)
+	u.Is(`["second"]`, string(buf[:n]), "datagram 2")
+}
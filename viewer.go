@@ -0,0 +1,152 @@
+package lager
+
+import (
+	"html/template"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ViewerHandler() returns an http.Handler that serves a small HTML page
+// showing the most recent log lines held in 'ring', which is useful for
+// debugging on machines where you cannot install log tooling:
+//
+//      ring := lager.NewRingBuffer(5000)
+//      lager.SetOutput(lager.TeeOutput(os.Stdout, ring))
+//      http.Handle("/debug/logs", lager.ViewerHandler(ring))
+//
+// The page has a form for filtering the lines shown, which uses these
+// query parameters:
+//
+//      levels  Only show lines of these levels, like "FWN" [default: all].
+//      mod     Only show lines logged via the Module of this name.
+//      n       Show at most this many of the most recent lines [200].
+//
+// The page also has a link to download the matching lines as a file [see
+// SnapshotHandler()], which is done when 'download' is set to any value.
+//
+// The log lines can contain sensitive data, so only serve this on a port
+// that is just for internal use.
+//
+func ViewerHandler(ring *RingBuffer) http.Handler {
+	snapshot := SnapshotHandler(ring)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if "" != q.Get("download") {
+			snapshot.ServeHTTP(w, r)
+			return
+		}
+		levels, mod := q.Get("levels"), q.Get("mod")
+		n, err := strconv.Atoi(q.Get("n"))
+		if nil != err || n < 1 {
+			n = 200
+		}
+		page := viewerPage{Levels: levels, Mod: mod, N: n}
+		mods := make(map[string]bool)
+		for _, rl := range ring.recent("", "", 0) {
+			if "" != rl.mod && !mods[rl.mod] {
+				mods[rl.mod] = true
+				page.Mods = append(page.Mods, rl.mod)
+			}
+		}
+		sort.Strings(page.Mods)
+		for _, rl := range ring.recent(levels, mod, n) {
+			lev := ""
+			if rl.lev < nLevels {
+				lev = rl.lev.String()
+			}
+			page.Lines = append(page.Lines, viewerLine{
+				When: rl.when.UTC().Format("2006-01-02 15:04:05.0000Z"),
+				Lev:  lev, Mod: rl.mod, Line: string(rl.line),
+			})
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := viewerTemplate.Execute(w, page); nil != err {
+			Fail().MMap("Could not render log viewer page", "error", err)
+		}
+	})
+}
+
+// SnapshotHandler() returns an http.Handler that downloads the log lines
+// held in 'ring' as a file of newline-delimited JSON (NDJSON), oldest
+// first, such as to attach recent Debug history to an incident ticket:
+//
+//      http.Handle("/debug/logs.ndjson", lager.SnapshotHandler(ring))
+//
+// It takes the same "levels" and "mod" query parameters as ViewerHandler()
+// (and "n", though by default all lines are included).  Secrets are
+// removed from each line via RedactSecrets() since the file is meant to be
+// shared.  The file is named like "logs-{hostname}-{time}.ndjson".
+//
+func SnapshotHandler(ring *RingBuffer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		n, _ := strconv.Atoi(q.Get("n"))
+		lines := ring.recent(q.Get("levels"), q.Get("mod"), n)
+		host, _ := os.Hostname()
+		if "" == host {
+			host = "host"
+		}
+		name := "logs-" + host + "-" +
+			time.Now().UTC().Format("20060102T150405Z") + ".ndjson"
+		h := w.Header()
+		h.Set("Content-Type", "application/x-ndjson")
+		h.Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		h.Set("Cache-Control", "no-store")
+		for _, rl := range lines {
+			line := RedactSecrets(string(rl.line)) + "\n"
+			if _, err := w.Write([]byte(line)); nil != err {
+				return
+			}
+		}
+	})
+}
+
+// The data used to render the log viewer page.
+type viewerPage struct {
+	Levels, Mod string
+	N           int
+	Mods        []string
+	Lines       []viewerLine
+}
+
+// One log line shown on the log viewer page.
+type viewerLine struct {
+	When, Lev, Mod, Line string
+}
+
+var viewerTemplate = template.Must(template.New("viewer").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Recent log lines</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+table { border-collapse: collapse; font-size: 90%; }
+td { padding: 2px 6px; vertical-align: top; border-bottom: 1px solid #ddd; }
+td.line { font-family: monospace; white-space: pre-wrap;
+  word-break: break-all; }
+.PANIC, .EXIT, .FAIL { color: #b00; } .WARN { color: #a60; }
+.NOTE { color: #068; } .ACCESS { color: #070; }
+.TRACE, .DEBUG, .OBJ, .GUTS { color: #777; }
+</style></head><body>
+<form method="get">
+Levels: <input name="levels" size="12" value="{{.Levels}}"
+ placeholder="all, or like FWN">
+Module: <select name="mod"><option value="">(all)</option>
+{{- range .Mods}}
+<option{{if eq . $.Mod}} selected{{end}}>{{.}}</option>
+{{- end}}
+</select>
+Lines: <input name="n" size="5" value="{{.N}}">
+<input type="submit" value="Show">
+<a href="?levels={{.Levels}}&amp;mod={{.Mod}}&amp;download=1">Download</a>
+</form>
+<p>{{len .Lines}} lines shown (oldest first).</p>
+<table>
+{{- range .Lines}}
+<tr class="{{.Lev}}"><td>{{.When}}</td><td>{{.Lev}}</td><td>{{.Mod}}</td>
+<td class="line">{{.Line}}</td></tr>
+{{- end}}
+</table></body></html>
+`))